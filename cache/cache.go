@@ -0,0 +1,733 @@
+// Package cache is an in-memory LRU cache of string keys and values with
+// per-entry TTLs. Besides reads and writes it offers conditional updates,
+// a loader for misses, per-namespace quotas, change events, hooks and
+// counters, for programs that embed it as well as for the cache server.
+//
+// It opens no listeners and needs none of the server's configuration: a
+// program that only wants the data structure makes a cache with NewCache
+// and its options, watches it with hooks or Subscribe, reads its counters
+// with Stats, and calls Close when done. NewEngine makes the other
+// engines, LFU and sharded, behind the Cache interface.
+package cache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("myproject/cache")
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+type entry struct {
+	key       string
+	value     string
+	storedAt  time.Time
+	expiresAt time.Time
+	version   uint64
+	flags     uint32
+	// compression is how value is encoded. A value over the chunk size
+	// is held in chunks, and value is empty.
+	compression Compression
+	// shared is set when the value is in the cache's value pool.
+	shared bool
+	chunks []string
+	hits   uint64
+	// id is the entry's own place in the slabs, and prev and next its
+	// neighbours' on the LRU list.
+	id, prev, next entryID
+}
+
+// Item is a copy of a cache entry handed out to callers.
+type Item struct {
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	StoredAt  time.Time `json:"stored_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Version increases with every write to the cache, so it changes
+	// whenever the entry does.
+	Version uint64 `json:"version"`
+	// Flags is opaque client metadata, such as the memcached flags word.
+	Flags uint32 `json:"flags,omitempty"`
+}
+
+// LRUCache is safe for concurrent use. Make one with NewCache.
+type LRUCache struct {
+	capacity   int
+	cache      map[string]entryID
+	entries    entrySlabs
+	head, tail entryID
+	mutex      sync.Mutex
+	// expiration is the default TTL, in nanoseconds; it can change while
+	// the cache is in use.
+	expiration atomic.Int64
+	stop       chan struct{}
+	stopOnce   sync.Once
+	stats      Counters
+	events     eventBus
+	version    uint64
+	loader     Loader
+	loads      loadGroup
+	namespaces map[string]*namespaceCounters
+	// reservations counts the namespaces with reserved bytes.
+	reservations int
+	memory       memoryUsage
+	keys         keyArena
+	sinks        []MutationSink
+	overflow     Overflow
+	hotKeys      *hotKeyTracker
+	removals     *removalLog
+	latency      opLatencies
+	audit        AuditSink
+	hooks        []*HookRegistration
+	slowOps      *slowOpLog
+	efficiency   *efficiencyTracker
+	timing       atomic.Pointer[TimingObserver]
+	clock        func() time.Time
+	log          *slog.Logger
+
+	// sweepInterval is in nanoseconds; sweepChanged wakes the sweep to
+	// take up a new one.
+	sweepInterval atomic.Int64
+	sweepChanged  chan struct{}
+	sweepObserver func(d time.Duration, expired int)
+	// highWatermark and lowWatermark are fractions of the capacity, for
+	// the sweep's evictions; zero is off.
+	highWatermark, lowWatermark float64
+	// memoryLimit and memoryPressure are SetMemoryPressure's;
+	// pressureCycle is the collection count at its last evictions.
+	memoryLimit    int64
+	memoryPressure float64
+	pressureCycle  uint64
+	// chunkSize is the length past which values are held in chunks;
+	// zero is never.
+	chunkSize int
+	dedup     valuePool
+}
+
+// NewCache makes a cache with the given options, on top of a capacity of 1000
+// entries, a default TTL of 5 seconds and a sweep every second, and
+// starts its expiry sweep.
+// Close stops the sweep.
+func NewCache(opts ...Option) (*LRUCache, error) {
+	o := cacheOptions{capacity: 1000, ttl: 5 * time.Second, sweepInterval: time.Second, clock: time.Now, logger: slog.Default(), shards: 1}
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+	if err := o.single(EvictLRU); err != nil {
+		return nil, err
+	}
+	cache := &LRUCache{
+		capacity:   o.capacity,
+		cache:      make(map[string]entryID),
+		namespaces: make(map[string]*namespaceCounters),
+		stop:       make(chan struct{}),
+		latency:    newOpLatencies(),
+
+		sweepChanged: make(chan struct{}, 1),
+		efficiency:   newEfficiencyTracker(o.clock()),
+		clock:        o.clock,
+		log:          o.logger,
+		loader:       o.loader,
+	}
+	cache.expiration.Store(int64(o.ttl))
+	cache.sweepInterval.Store(int64(o.sweepInterval))
+	cache.highWatermark, cache.lowWatermark = o.highWatermark, o.lowWatermark
+	cache.memoryLimit, cache.memoryPressure = o.memoryLimit, o.memoryPressure
+	cache.chunkSize = o.chunkSize
+	cache.dedup = newValuePool(o.dedupAbove)
+	for name, quota := range o.quotas {
+		cache.namespace(name).quota = quota
+	}
+	cache.countReservations()
+	for _, h := range o.hooks {
+		cache.AddHooks(h.hooks, h.opts)
+	}
+	if o.callbacks.OnEvict != nil || o.callbacks.OnExpire != nil {
+		cache.AddHooks(o.callbacks.hooks(), HookOptions{})
+	}
+	go cache.startEvictionRoutine()
+	return cache, nil
+}
+
+// now is the time by the cache's clock, against which entries expire.
+func (this *LRUCache) now() time.Time {
+	return this.clock()
+}
+
+// DefaultTTL is how long entries written without a TTL are kept.
+func (this *LRUCache) DefaultTTL() time.Duration {
+	return time.Duration(this.expiration.Load())
+}
+
+// SetDefaultTTL changes the default TTL for later writes.
+func (this *LRUCache) SetDefaultTTL(ttl time.Duration) {
+	this.expiration.Store(int64(ttl))
+}
+
+func (this *LRUCache) Get(key string) (string, bool) {
+	item, ok := this.GetItem(key)
+	return item.Value, ok
+}
+
+func (this *LRUCache) GetItem(key string) (Item, bool) {
+	var item Item
+	ok := this.get(key, func(e *entry) (ok bool) {
+		item, ok = this.read(e)
+		return ok
+	})
+	return item, ok
+}
+
+// get counts a read of key, and calls hit with its entry, if it has one
+// that has not expired, under the lock; hit returns false for an entry
+// it found corrupt and evicted, which counts as a miss.
+func (this *LRUCache) get(key string, hit func(e *entry) bool) bool {
+	defer this.since(opGet, key, time.Now())
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	now := this.now()
+	if this.hotKeys != nil {
+		this.hotKeys.record(key, now)
+	}
+	e, ok := this.lookup(key)
+	ns := this.namespaces[NamespaceOf(key)]
+	if ok {
+		if now.After(e.expiresAt) {
+			this.evict(key)
+			this.stats.Expirations++
+			this.publish(EventExpire, key, "ttl", eventOrigin{})
+			this.stats.Misses++
+			this.efficiency.read(false, now)
+			if ns != nil {
+				ns.misses++
+			}
+			if len(this.hooks) > 0 {
+				this.runHooks(hookMiss, HookEvent{Key: key, Time: now}, nil)
+			}
+			return false
+		}
+		if hit(e) {
+			e.hits++
+			this.stats.Hits++
+			this.efficiency.read(true, now)
+			ns.hits++
+			this.moveToFront(e)
+			if len(this.hooks) > 0 {
+				// hit has read the value, so it decodes.
+				this.runHooks(hookGet, HookEvent{Key: key, Time: now}, func() Item { item, _ := e.item(); return item })
+			}
+			return true
+		}
+		// read evicted the entry, which may have been the namespace's
+		// last.
+		ns = this.namespaces[NamespaceOf(key)]
+	}
+	this.stats.Misses++
+	this.efficiency.read(false, now)
+	if ns != nil {
+		ns.misses++
+	}
+	if len(this.hooks) > 0 {
+		this.runHooks(hookMiss, HookEvent{Key: key, Time: now}, nil)
+	}
+	return false
+}
+
+// Peek returns the entry for key without counting a hit or miss or
+// changing its position in the LRU order.
+func (this *LRUCache) Peek(key string) (Item, bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	e, ok := this.lookup(key)
+	if !ok || this.now().After(e.expiresAt) {
+		return Item{}, false
+	}
+	return this.read(e)
+}
+
+// Write is one entry for Store and SetMany.
+type Write struct {
+	Key   string
+	Value string
+	TTL   time.Duration
+	Flags uint32
+	// Version, if not zero, is the entry's version instead of the next
+	// one, for writes whose version is decided elsewhere, as by the Raft
+	// log. Later writes are numbered after it.
+	Version uint64
+	// StoredAt, if not zero, is when the entry was written, for writes
+	// made first elsewhere, as in another region.
+	StoredAt time.Time
+}
+
+// SetMany stores all writes under a single acquisition of the cache lock.
+func (this *LRUCache) SetMany(ctx context.Context, writes []Write) {
+	_, span := tracer.Start(ctx, "cache.SetMany", trace.WithAttributes(attribute.Int("cache.writes", len(writes))))
+	defer span.End()
+	origin := originFrom(ctx)
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for _, w := range writes {
+		this.set(w, "", origin)
+	}
+}
+
+// Set stores value under key for ttl, or for the cache's default
+// expiration when ttl is zero.
+func (this *LRUCache) Set(ctx context.Context, key, value string, ttl time.Duration) Item {
+	return this.Store(ctx, Write{Key: key, Value: value, TTL: ttl})
+}
+
+// Store is Set for callers that also need to set Flags.
+func (this *LRUCache) Store(ctx context.Context, w Write) Item {
+	_, span := tracer.Start(ctx, "cache.Store")
+	defer span.End()
+	defer this.since(opSet, w.Key, time.Now())
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.set(w, "", originFrom(ctx))
+}
+
+// SetIf stores value only if cond, called with the current entry (ok is
+// false when the key is absent or expired), returns true. It reports the
+// entry as stored, or as it was when cond refused.
+func (this *LRUCache) SetIf(ctx context.Context, key, value string, ttl time.Duration, cond func(current Item, ok bool) bool) (Item, bool) {
+	return this.Update(ctx, key, func(current Item, ok bool) (Write, bool) {
+		return Write{Key: key, Value: value, TTL: ttl}, cond(current, ok)
+	})
+}
+
+// Update is the general form of SetIf: fn sees the current entry and
+// returns the write to make, if any, in its place. The write's Key is
+// forced to key.
+func (this *LRUCache) Update(ctx context.Context, key string, fn func(current Item, ok bool) (Write, bool)) (Item, bool) {
+	_, span := tracer.Start(ctx, "cache.Update")
+	defer span.End()
+	defer this.since(opSet, key, time.Now())
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	var current Item
+	e, ok := this.lookup(key)
+	if ok && this.now().After(e.expiresAt) {
+		ok = false
+	}
+	if ok {
+		current, ok = this.read(e)
+	}
+	w, store := fn(current, ok)
+	if !store {
+		return current, false
+	}
+	w.Key = key
+	return this.set(w, "", originFrom(ctx)), true
+}
+
+var (
+	// ErrNotInteger is returned by Incr when the stored value is not a
+	// base-10 int64.
+	ErrNotInteger = errors.New("value is not an integer")
+	ErrOverflow   = errors.New("increment would overflow")
+)
+
+// Incr adds delta to the integer stored under key. A missing key counts
+// from zero and gets the default TTL; an existing one keeps its expiry.
+func (this *LRUCache) Incr(ctx context.Context, key string, delta int64) (Item, error) {
+	_, span := tracer.Start(ctx, "cache.Incr")
+	defer span.End()
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	var n int64
+	w := Write{Key: key}
+	if e, ok := this.lookup(key); ok && !this.now().After(e.expiresAt) {
+		if item, ok := this.read(e); ok {
+			v, err := strconv.ParseInt(item.Value, 10, 64)
+			if err != nil {
+				return Item{}, ErrNotInteger
+			}
+			n, w.TTL, w.Flags = v, e.expiresAt.Sub(this.now()), e.flags
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return Item{}, ErrOverflow
+	}
+	w.Value = strconv.FormatInt(n+delta, 10)
+	return this.set(w, "", originFrom(ctx)), nil
+}
+
+// Expire gives an existing entry a new TTL without changing its value or
+// version. It reports false if the key is absent or already expired. The
+// change is published as a set with reason "touch".
+func (this *LRUCache) Expire(ctx context.Context, key string, ttl time.Duration) bool {
+	_, span := tracer.Start(ctx, "cache.Expire")
+	defer span.End()
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	e, ok := this.lookup(key)
+	if !ok || this.now().After(e.expiresAt) {
+		return false
+	}
+	if _, ok := this.read(e); !ok {
+		return false
+	}
+	e.expiresAt = this.now().Add(ttl)
+	this.publish(EventSet, key, "touch", originFrom(ctx))
+	return true
+}
+
+// set stores w and publishes it with reason, which is empty for writes
+// and "load" for values from the loader.
+func (this *LRUCache) set(w Write, reason string, origin eventOrigin) Item {
+	e := this.store(w, origin)
+	this.namespace(NamespaceOf(w.Key)).writes++
+	this.publish(EventSet, w.Key, reason, origin)
+	return e.itemOf(w.Value)
+}
+
+// store is set without the event, for entries that come back from the
+// overflow tier unchanged.
+func (this *LRUCache) store(w Write, origin eventOrigin) *entry {
+	key, value, ttl := w.Key, w.Value, w.TTL
+	if ttl <= 0 {
+		ttl = this.namespaceTTL(key)
+	}
+	now := this.now()
+	expiresAt := now.Add(ttl)
+	storedAt := now
+	if !w.StoredAt.IsZero() {
+		storedAt = w.StoredAt
+	}
+	version := w.Version
+	if version == 0 {
+		this.version++
+		version = this.version
+	} else {
+		this.version = max(this.version, version)
+	}
+
+	e, ok := this.find(key)
+	if !ok {
+		if this.overflow != nil {
+			// A copy demoted earlier would shadow a later delete.
+			this.overflow.Remove(key)
+		}
+		// Evict before taking the namespace counters, which go with the
+		// last entry of a namespace.
+		if len(this.cache) >= this.capacity {
+			this.evictLRU("capacity", origin)
+		}
+	}
+
+	if !ok {
+		if this.keys.wasteful(key) {
+			this.compactKeys()
+		}
+		key = this.keys.intern(key)
+	}
+	name := NamespaceOf(key)
+	ns := this.namespace(name)
+	value, compression := ns.quota.compress(value)
+	if ok {
+		before := e.size()
+		this.dropValue(e)
+		this.holdValue(e, value, compression)
+		ns.bytes += e.size() - before
+		e.storedAt = storedAt
+		e.expiresAt = expiresAt
+		e.version = version
+		e.flags = w.Flags
+		this.moveToFront(e)
+	} else {
+		e = this.entries.alloc()
+		e.key = key
+		this.holdValue(e, value, compression)
+		e.storedAt, e.expiresAt, e.version, e.flags = storedAt, expiresAt, version, w.Flags
+		this.cache[key] = e.id
+		this.addToFront(e)
+		ns.entries++
+		ns.bytes += e.size()
+		this.memory.add(key, 0, 1)
+	}
+	this.enforceQuota(ns, name, e, origin)
+	return e
+}
+
+func (this *LRUCache) Delete(ctx context.Context, key string) bool {
+	_, span := tracer.Start(ctx, "cache.Delete")
+	defer span.End()
+	defer this.since(opDelete, key, time.Now())
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if _, ok := this.lookup(key); !ok {
+		return false
+	}
+	this.evict(key)
+	this.publish(EventDelete, key, "explicit", originFrom(ctx))
+	return true
+}
+
+// DeleteIf deletes key only if cond, called with the current entry,
+// returns true. found is false when the key is absent or expired.
+func (this *LRUCache) DeleteIf(ctx context.Context, key string, cond func(current Item) bool) (found, deleted bool) {
+	_, span := tracer.Start(ctx, "cache.DeleteIf")
+	defer span.End()
+	defer this.since(opDelete, key, time.Now())
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	e, ok := this.lookup(key)
+	if !ok || this.now().After(e.expiresAt) {
+		return false, false
+	}
+	current, ok := this.read(e)
+	if !ok {
+		return false, false
+	}
+	if !cond(current) {
+		return true, false
+	}
+	this.evict(key)
+	this.publish(EventDelete, key, "explicit", originFrom(ctx))
+	return true, true
+}
+
+func (this *LRUCache) Flush(ctx context.Context) {
+	_, span := tracer.Start(ctx, "cache.Flush")
+	defer span.End()
+	origin := originFrom(ctx)
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for key := range this.cache {
+		this.publish(EventDelete, key, "flush", origin)
+	}
+	this.cache = make(map[string]entryID)
+	this.entries.reset()
+	this.keys.reset()
+	this.head, this.tail = 0, 0
+	this.memory = memoryUsage{}
+	this.dedup.reset()
+	if this.overflow != nil {
+		// Demoted entries go without events, as if already evicted.
+		this.overflow.Clear()
+	}
+	for name, ns := range this.namespaces {
+		ns.entries, ns.bytes = 0, 0
+		if ns.quota == (Quota{}) {
+			delete(this.namespaces, name)
+		}
+	}
+}
+
+// Resize changes the capacity, evicting least recently used entries until
+// the cache fits, and returns how many were evicted.
+func (this *LRUCache) Resize(ctx context.Context, capacity int) int {
+	_, span := tracer.Start(ctx, "cache.Resize")
+	defer span.End()
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.capacity = capacity
+	evicted := 0
+	for len(this.cache) > capacity {
+		this.evictLRU("capacity", originFrom(ctx))
+		evicted++
+	}
+	return evicted
+}
+
+func (this *LRUCache) evict(key string) {
+	if elem, ok := this.find(key); ok {
+		delete(this.cache, key)
+		this.remove(elem)
+		this.efficiency.removed(elem.storedAt, this.now())
+		this.releaseNamespace(NamespaceOf(key), elem.size())
+		this.memory.add(key, 0, -1)
+		this.dropValue(elem)
+		this.keys.release(elem.key)
+		this.entries.release(elem)
+	}
+}
+
+// evictLRU evicts the least recently used entry that evictions from the
+// whole cache may take, demoting it to the overflow tier, and returns
+// the memory it held, or zero if the cache is empty. The caller holds
+// the lock.
+func (this *LRUCache) evictLRU(reason string, origin eventOrigin) int64 {
+	victim := this.victim()
+	if victim == nil {
+		return 0
+	}
+	key, size := victim.key, victim.size()+entryOverhead
+	this.demote(victim)
+	this.evict(key)
+	this.stats.Evictions++
+	this.publish(EventEvict, key, reason, origin)
+	return size
+}
+
+// find returns the in-memory entry for key. The caller holds the lock.
+func (this *LRUCache) find(key string) (*entry, bool) {
+	id, ok := this.cache[key]
+	return this.entries.at(id), ok
+}
+
+func (e *entry) item() (Item, error) {
+	value, err := decompress(e.stored(), e.compression)
+	if err != nil {
+		return Item{}, err
+	}
+	return e.itemOf(value), nil
+}
+
+// itemOf is the entry as an item holding value, which is its value as
+// written.
+func (e *entry) itemOf(value string) Item {
+	return Item{Key: e.key, Value: value, StoredAt: e.storedAt, ExpiresAt: e.expiresAt, Version: e.version, Flags: e.flags}
+}
+
+// read returns the entry as an item. An entry whose value cannot be
+// decompressed is corrupt: it is evicted, so that the caller can take it
+// for a miss. The caller holds the lock.
+func (this *LRUCache) read(e *entry) (Item, bool) {
+	item, err := e.item()
+	if err != nil {
+		key := e.key
+		this.log.Error("Evicted a corrupt entry", "key", key, "err", err)
+		this.evict(key)
+		this.stats.Evictions++
+		this.publish(EventEvict, key, "corrupt", eventOrigin{})
+		return Item{}, false
+	}
+	return item, true
+}
+
+func (this *LRUCache) moveToFront(entry *entry) {
+	this.remove(entry)
+	this.addToFront(entry)
+}
+
+func (this *LRUCache) addToFront(entry *entry) {
+	entry.prev = 0
+	entry.next = this.head
+	if head := this.entries.at(this.head); head != nil {
+		head.prev = entry.id
+	}
+	this.head = entry.id
+	if this.tail == 0 {
+		this.tail = entry.id
+	}
+}
+
+func (this *LRUCache) remove(entry *entry) {
+	if prev := this.entries.at(entry.prev); prev != nil {
+		prev.next = entry.next
+	} else {
+		this.head = entry.next
+	}
+	if next := this.entries.at(entry.next); next != nil {
+		next.prev = entry.prev
+	} else {
+		this.tail = entry.prev
+	}
+}
+
+func (this *LRUCache) Len() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return len(this.cache)
+}
+
+// Close stops the background eviction routine and removes the hooks,
+// waiting for the asynchronous ones to run the calls already queued.
+func (this *LRUCache) Close() {
+	this.stopOnce.Do(func() { close(this.stop) })
+	this.mutex.Lock()
+	hooks := slices.Clone(this.hooks)
+	this.mutex.Unlock()
+	for _, r := range hooks {
+		r.Remove()
+	}
+}
+
+func (this *LRUCache) startEvictionRoutine() {
+	ticker := time.NewTicker(this.SweepInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-this.stop:
+			return
+		case <-this.sweepChanged:
+			ticker.Reset(this.SweepInterval())
+			continue
+		case <-ticker.C:
+		}
+		start, now := time.Now(), this.now()
+		expired := 0
+		heap := readHeap()
+		this.mutex.Lock()
+		for key, id := range this.cache {
+			if now.After(this.entries.at(id).expiresAt) {
+				this.evict(key)
+				this.stats.Expirations++
+				this.publish(EventExpire, key, "ttl", eventOrigin{})
+				expired++
+			}
+		}
+		evicted := this.enforceWatermarks()
+		relieved := this.relieveMemoryPressure(heap)
+		limit := this.memoryLimit
+		observe := this.sweepObserver
+		this.mutex.Unlock()
+		took := time.Since(start)
+		this.observe(opSweep, "", took, 3)
+		if observe != nil {
+			observe(took, expired)
+		}
+		if expired > 0 {
+			this.log.Debug("Expired entries", "expired", expired, "took", took)
+		}
+		if evicted > 0 {
+			this.log.Debug("Evicted entries above the high watermark", "evicted", evicted)
+		}
+		if relieved > 0 {
+			this.log.Warn("Evicted entries under memory pressure", "evicted", relieved, "heap_goal", heap.goal, "limit", limit)
+		}
+	}
+}
+
+// SetSweepObserver registers fn to be told how long each expiration sweep
+// took and how many entries it removed.
+func (this *LRUCache) SetSweepObserver(fn func(d time.Duration, expired int)) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.sweepObserver = fn
+}
@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestCache makes a cache with opts that is closed when the test ends.
+func newTestCache(t *testing.T, opts ...Option) *LRUCache {
+	t.Helper()
+	c, err := NewCache(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// fakeClock is a clock for WithClock that moves only when told to.
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// wantKeys fails the test unless exactly the keys in present are cached,
+// of those in all.
+func wantKeys(t *testing.T, c *LRUCache, all []string, present ...string) {
+	t.Helper()
+	in := make(map[string]bool, len(present))
+	for _, key := range present {
+		in[key] = true
+	}
+	for _, key := range all {
+		if _, ok := c.Peek(key); ok != in[key] {
+			t.Errorf("key %q cached = %v, want %v", key, ok, in[key])
+		}
+	}
+}
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newTestCache(t, WithCapacity(3))
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "c"} {
+		c.Set(ctx, key, "v-"+key, 0)
+	}
+	// Reading a makes b the least recently used.
+	if v, ok := c.Get("a"); !ok || v != "v-a" {
+		t.Fatalf("Get(a) = %q, %v", v, ok)
+	}
+	c.Set(ctx, "d", "v-d", 0)
+	wantKeys(t, c, []string{"a", "b", "c", "d"}, "a", "c", "d")
+
+	// Overwriting c makes it recent too, leaving a the oldest.
+	c.Set(ctx, "c", "v-c2", 0)
+	c.Set(ctx, "e", "v-e", 0)
+	wantKeys(t, c, []string{"a", "c", "d", "e"}, "c", "d", "e")
+
+	stats := c.Stats(0)
+	if stats.Entries != 3 || stats.Evictions != 2 {
+		t.Errorf("entries = %d, evictions = %d; want 3 and 2", stats.Entries, stats.Evictions)
+	}
+}
+
+func TestPeekLeavesOrderAlone(t *testing.T) {
+	c := newTestCache(t, WithCapacity(2))
+	ctx := context.Background()
+	c.Set(ctx, "a", "1", 0)
+	c.Set(ctx, "b", "2", 0)
+	if _, ok := c.Peek("a"); !ok {
+		t.Fatal("Peek(a) missed")
+	}
+	c.Set(ctx, "c", "3", 0)
+	wantKeys(t, c, []string{"a", "b", "c"}, "b", "c")
+}
+
+func TestResizeEvictsOldest(t *testing.T) {
+	c := newTestCache(t, WithCapacity(4))
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		c.Set(ctx, key, key, 0)
+	}
+	if evicted := c.Resize(ctx, 2); evicted != 2 {
+		t.Errorf("Resize evicted %d, want 2", evicted)
+	}
+	wantKeys(t, c, []string{"a", "b", "c", "d"}, "c", "d")
+}
+
+func TestEntriesExpire(t *testing.T) {
+	clock := newFakeClock()
+	c := newTestCache(t, WithClock(clock.Now), WithTTL(time.Minute))
+	ctx := context.Background()
+	c.Set(ctx, "short", "1", 10*time.Second)
+	c.Set(ctx, "default", "2", 0)
+
+	clock.Advance(10 * time.Second)
+	if _, ok := c.Get("short"); !ok {
+		t.Fatal("entry gone at its expiry; it lasts until then")
+	}
+	clock.Advance(time.Second)
+	if _, ok := c.Get("short"); ok {
+		t.Fatal("entry outlived its TTL")
+	}
+	if item, ok := c.GetItem("default"); !ok || !item.ExpiresAt.Equal(item.StoredAt.Add(time.Minute)) {
+		t.Fatalf("GetItem(default) = %+v, %v; want the default TTL", item, ok)
+	}
+	clock.Advance(time.Minute)
+	if _, ok := c.Get("default"); ok {
+		t.Fatal("entry outlived the default TTL")
+	}
+
+	stats := c.Stats(0)
+	if stats.Expirations != 2 || stats.Entries != 0 {
+		t.Errorf("expirations = %d, entries = %d; want 2 and 0", stats.Expirations, stats.Entries)
+	}
+	if stats.Hits != 2 || stats.Misses != 2 {
+		t.Errorf("hits = %d, misses = %d; want 2 and 2", stats.Hits, stats.Misses)
+	}
+}
+
+func TestUpdateSeesCurrentEntry(t *testing.T) {
+	c := newTestCache(t)
+	ctx := context.Background()
+	first := c.Set(ctx, "k", "1", 0)
+
+	stale := func(current Item, ok bool) bool { return ok && current.Version == first.Version-1 }
+	if _, stored := c.SetIf(ctx, "k", "2", 0, stale); stored {
+		t.Fatal("SetIf stored against a stale version")
+	}
+	match := func(current Item, ok bool) bool { return ok && current.Version == first.Version }
+	item, stored := c.SetIf(ctx, "k", "2", 0, match)
+	if !stored || item.Value != "2" || item.Version <= first.Version {
+		t.Fatalf("SetIf = %+v, %v", item, stored)
+	}
+}
+
+func TestExpireExtendsTTL(t *testing.T) {
+	clock := newFakeClock()
+	c := newTestCache(t, WithClock(clock.Now))
+	ctx := context.Background()
+	item := c.Set(ctx, "k", "v", time.Second)
+	if !c.Expire(ctx, "k", time.Hour) {
+		t.Fatal("Expire of a live key failed")
+	}
+	clock.Advance(time.Minute)
+	got, ok := c.GetItem("k")
+	if !ok || got.Value != "v" || got.Version != item.Version {
+		t.Fatalf("GetItem = %+v, %v; want the same value and version", got, ok)
+	}
+	clock.Advance(time.Hour)
+	if c.Expire(ctx, "k", time.Hour) {
+		t.Error("Expire revived an expired key")
+	}
+}
+
+func TestIncr(t *testing.T) {
+	c := newTestCache(t)
+	ctx := context.Background()
+	if item, err := c.Incr(ctx, "n", 5); err != nil || item.Value != "5" {
+		t.Fatalf("Incr of a missing key = %+v, %v", item, err)
+	}
+	if item, err := c.Incr(ctx, "n", -7); err != nil || item.Value != "-2" {
+		t.Fatalf("Incr = %+v, %v", item, err)
+	}
+	c.Set(ctx, "s", "text", 0)
+	if _, err := c.Incr(ctx, "s", 1); err != ErrNotInteger {
+		t.Errorf("Incr of text = %v, want ErrNotInteger", err)
+	}
+}
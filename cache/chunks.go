@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"io"
+	"strings"
+)
+
+// splitValue returns value as an entry holds it: whole if size is zero
+// or value is no longer, or else copied into chunks of size bytes. A
+// chunked value takes no single allocation of its full length, and
+// keeps no caller's string, such as a request body, in memory.
+func splitValue(value string, size int) (string, []string) {
+	if size <= 0 || len(value) <= size {
+		return value, nil
+	}
+	chunks := make([]string, 0, (len(value)+size-1)/size)
+	for len(value) > 0 {
+		n := min(size, len(value))
+		chunks = append(chunks, strings.Clone(value[:n]))
+		value = value[n:]
+	}
+	return "", chunks
+}
+
+// stored is the entry's value as stored, compressed or not, with its
+// chunks joined. Reads that copy the value out use reader instead.
+func (e *entry) stored() string {
+	if e.chunks != nil {
+		return strings.Join(e.chunks, "")
+	}
+	return e.value
+}
+
+// reader yields the entry's value as written, reading a value held in
+// chunks from the chunks, which are never written to again, so that the
+// reader stays good after the lock is released. A compressed value is
+// decompressed whole, which needs it whole anyway. The caller holds the
+// lock.
+func (this *LRUCache) reader(e *entry) (io.Reader, bool) {
+	if e.chunks == nil || e.compression != CompressNone {
+		item, ok := this.read(e)
+		return strings.NewReader(item.Value), ok
+	}
+	chunks := make([]io.Reader, len(e.chunks))
+	for i, c := range e.chunks {
+		chunks[i] = strings.NewReader(c)
+	}
+	return io.MultiReader(chunks...), true
+}
+
+// valueLen is the length of the entry's value as stored.
+func (e *entry) valueLen() int {
+	n := len(e.value)
+	for _, c := range e.chunks {
+		n += len(c)
+	}
+	return n
+}
+
+// size approximates the memory the entry pins: its key and its value as
+// stored.
+func (e *entry) size() int64 {
+	return int64(len(e.key) + e.valueLen())
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitValue(t *testing.T) {
+	tests := []struct {
+		value  string
+		size   int
+		whole  string
+		chunks []string
+	}{
+		{"abcdefghij", 0, "abcdefghij", nil},
+		{"abcdefghij", 10, "abcdefghij", nil},
+		{"abcdefghij", 4, "", []string{"abcd", "efgh", "ij"}},
+		{"abcdefgh", 4, "", []string{"abcd", "efgh"}},
+	}
+	for _, tt := range tests {
+		whole, chunks := splitValue(tt.value, tt.size)
+		if whole != tt.whole || !slices.Equal(chunks, tt.chunks) {
+			t.Errorf("splitValue(%q, %d) = %q, %q; want %q, %q", tt.value, tt.size, whole, chunks, tt.whole, tt.chunks)
+		}
+	}
+}
+
+func TestChunkedValuesReadWhole(t *testing.T) {
+	c := newTestCache(t, WithChunkSize(8))
+	ctx := context.Background()
+	value := strings.Repeat("0123456789", 10)
+	c.Set(ctx, "k", value, 0)
+
+	c.mutex.Lock()
+	e, _ := c.find("k")
+	chunks := len(e.chunks)
+	c.mutex.Unlock()
+	if chunks != 13 {
+		t.Errorf("value held in %d chunks, want 13", chunks)
+	}
+	if v, ok := c.Get("k"); !ok || v != value {
+		t.Fatalf("Get = %q, %v", v, ok)
+	}
+	if mem := c.Stats(0).Memory; mem.Values != 100 {
+		t.Errorf("memory counts %d bytes of values, want 100", mem.Values)
+	}
+	if items := c.Entries(); len(items) != 1 || items[0].Value != value {
+		t.Errorf("Entries = %+v", items)
+	}
+}
+
+func TestOpenOrLoadStreamsChunks(t *testing.T) {
+	c := newTestCache(t, WithChunkSize(8))
+	ctx := context.Background()
+	value := strings.Repeat("0123456789", 10)
+	stored := c.Set(ctx, "k", value, 0)
+
+	item, r, found, err := c.OpenOrLoad(ctx, "k")
+	if err != nil || !found {
+		t.Fatalf("OpenOrLoad = %v, %v", found, err)
+	}
+	if item.Value != "" || item.Version != stored.Version {
+		t.Errorf("item = %+v; want the entry without its value", item)
+	}
+	// The chunks are never written again, so the reader outlives a
+	// change to the entry.
+	c.Set(ctx, "k", "new", 0)
+	if got, _ := io.ReadAll(r); string(got) != value {
+		t.Errorf("read %q, want %q", got, value)
+	}
+	if stats := c.Stats(0); stats.Hits != 1 {
+		t.Errorf("hits = %d, want 1", stats.Hits)
+	}
+}
+
+func TestOpenOrLoadFillsMiss(t *testing.T) {
+	loader := func(ctx context.Context, key string) (string, time.Duration, error) {
+		if key == "absent" {
+			return "", 0, ErrNotFound
+		}
+		return "loaded " + key, 0, nil
+	}
+	c := newTestCache(t, WithLoader(loader))
+	ctx := context.Background()
+	item, r, found, err := c.OpenOrLoad(ctx, "k")
+	if err != nil || !found || item.Value != "" {
+		t.Fatalf("OpenOrLoad = %+v, %v, %v", item, found, err)
+	}
+	if got, _ := io.ReadAll(r); string(got) != "loaded k" {
+		t.Errorf("read %q, want the loaded value", got)
+	}
+	if _, _, found, err := c.OpenOrLoad(ctx, "absent"); found || err != nil {
+		t.Errorf("OpenOrLoad of a key the loader lacks = %v, %v", found, err)
+	}
+}
+
+func TestOpenOrLoadDecompresses(t *testing.T) {
+	c := newTestCache(t, WithChunkSize(8), WithQuota("z", Quota{Compression: CompressZstd, CompressAbove: 10}))
+	ctx := context.Background()
+	value := strings.Repeat("compressible ", 100)
+	c.Set(ctx, "z:k", value, 0)
+	_, r, found, err := c.OpenOrLoad(ctx, "z:k")
+	if err != nil || !found {
+		t.Fatalf("OpenOrLoad = %v, %v", found, err)
+	}
+	if got, _ := io.ReadAll(r); string(got) != value {
+		t.Errorf("read %d bytes, want the %d written", len(got), len(value))
+	}
+}
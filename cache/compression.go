@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is how a namespace's large values are stored. Values are
+// compressed as they are written and decompressed as they are read, so
+// callers only ever see them as written; quotas and memory estimates
+// count the compressed bytes.
+type Compression uint8
+
+const (
+	// CompressNone stores values as they are.
+	CompressNone Compression = iota
+	// CompressSnappy is fast and compresses modestly.
+	CompressSnappy
+	// CompressZstd compresses further at a higher cost in CPU.
+	CompressZstd
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressSnappy:
+		return "snappy"
+	case CompressZstd:
+		return "zstd"
+	}
+	return "none"
+}
+
+// ParseCompression reads "none", "snappy" or "zstd".
+func ParseCompression(s string) (Compression, error) {
+	switch strings.ToLower(s) {
+	case "", "none":
+		return CompressNone, nil
+	case "snappy":
+		return CompressSnappy, nil
+	case "zstd":
+		return CompressZstd, nil
+	}
+	return 0, fmt.Errorf("unknown compression %q; want none, snappy or zstd", s)
+}
+
+// The zstd coders are safe for concurrent use through EncodeAll and
+// DecodeAll, and costly to make, so they are made once.
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+func zstdCoders() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	})
+	return zstdEncoder, zstdDecoder
+}
+
+// compress encodes value by q's compression if it is longer than
+// q.CompressAbove, and returns it with the compression used: none when
+// compressing would not make it smaller.
+func (q Quota) compress(value string) (string, Compression) {
+	if q.Compression == CompressNone || len(value) <= q.CompressAbove {
+		return value, CompressNone
+	}
+	var out []byte
+	switch q.Compression {
+	case CompressSnappy:
+		out = s2.EncodeSnappy(nil, []byte(value))
+	case CompressZstd:
+		enc, _ := zstdCoders()
+		out = enc.EncodeAll([]byte(value), nil)
+	}
+	if len(out) >= len(value) {
+		return value, CompressNone
+	}
+	return string(out), q.Compression
+}
+
+// decompress reverses compress. The cache only decodes what it encoded
+// itself, so an error means the memory holding value was corrupted.
+func decompress(value string, c Compression) (string, error) {
+	var out []byte
+	var err error
+	switch c {
+	case CompressNone:
+		return value, nil
+	case CompressSnappy:
+		out, err = s2.Decode(nil, []byte(value))
+	case CompressZstd:
+		_, dec := zstdCoders()
+		out, err = dec.DecodeAll([]byte(value), nil)
+	}
+	if err != nil {
+		return "", fmt.Errorf("corrupt %s value: %w", c, err)
+	}
+	return string(out), nil
+}
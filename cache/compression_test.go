@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	for _, compression := range []Compression{CompressSnappy, CompressZstd} {
+		t.Run(compression.String(), func(t *testing.T) {
+			c := newTestCache(t, WithQuota("z", Quota{Compression: compression, CompressAbove: 10}))
+			ctx := context.Background()
+			long := strings.Repeat("compressible ", 100)
+			c.Set(ctx, "z:long", long, 0)
+			c.Set(ctx, "z:short", "tiny", 0)
+
+			c.mutex.Lock()
+			long0, _ := c.find("z:long")
+			short0, _ := c.find("z:short")
+			got, stored := long0.compression, long0.valueLen()
+			gotShort := short0.compression
+			c.mutex.Unlock()
+			if got != compression || stored >= len(long) {
+				t.Errorf("long value stored %s in %d bytes", got, stored)
+			}
+			if gotShort != CompressNone {
+				t.Errorf("short value stored %s", gotShort)
+			}
+			if v, _ := c.Get("z:long"); v != long {
+				t.Error("long value read back changed")
+			}
+			if v, _ := c.Get("z:short"); v != "tiny" {
+				t.Errorf("short value read back as %q", v)
+			}
+		})
+	}
+}
+
+func TestDecompressRejectsCorruptInput(t *testing.T) {
+	for _, compression := range []Compression{CompressSnappy, CompressZstd} {
+		if _, err := decompress("not compressed", compression); err == nil {
+			t.Errorf("decompress of garbage as %s succeeded", compression)
+		}
+	}
+}
+
+func TestCorruptEntryIsEvicted(t *testing.T) {
+	c := newTestCache(t, WithQuota("z", Quota{Compression: CompressZstd, CompressAbove: 10}))
+	ctx := context.Background()
+	c.Set(ctx, "z:k", strings.Repeat("x", 1000), 0)
+	c.mutex.Lock()
+	e, _ := c.find("z:k")
+	e.value = e.value[:len(e.value)/2]
+	c.mutex.Unlock()
+
+	if _, ok := c.GetItem("z:k"); ok {
+		t.Fatal("corrupt entry read as a hit")
+	}
+	if _, ok := c.Peek("z:k"); ok {
+		t.Fatal("corrupt entry still cached")
+	}
+	stats := c.Stats(0)
+	if stats.Misses != 1 || stats.Evictions != 1 || stats.Entries != 0 {
+		t.Errorf("misses = %d, evictions = %d, entries = %d; want 1, 1 and 0", stats.Misses, stats.Evictions, stats.Entries)
+	}
+}
@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"context"
+	"net"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID tags the changes made with ctx with id, which their
+// events carry.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFrom returns the request ID carried by ctx, or "".
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Client is who is behind a request, as far as an audit needs to know:
+// the remote address and, once authenticated, a principal naming the
+// credential without revealing it.
+type Client struct {
+	Addr      string
+	Principal string
+}
+
+type clientKey struct{}
+
+// WithClient starts a client identity for requests from addr; the
+// authentication that follows fills in the principal with SetPrincipal.
+func WithClient(ctx context.Context, addr string) context.Context {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return context.WithValue(ctx, clientKey{}, &Client{Addr: addr})
+}
+
+// ClientFrom returns the client identity carried by ctx, or nil.
+func ClientFrom(ctx context.Context) *Client {
+	c, _ := ctx.Value(clientKey{}).(*Client)
+	return c
+}
+
+// SetPrincipal records who the client of ctx authenticated as.
+func SetPrincipal(ctx context.Context, principal string) {
+	if c := ClientFrom(ctx); c != nil {
+		c.Principal = principal
+	}
+}
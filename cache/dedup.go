@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"hash/maphash"
+	"strings"
+)
+
+// valuePool shares the storage of identical values among the entries
+// that hold them. Values are found by a hash of their content as stored;
+// of two different values with the same hash, the later is held apart.
+type valuePool struct {
+	// above is the length past which values are shared; zero is never.
+	above  int
+	seed   maphash.Seed
+	values map[uint64]*sharedValue
+}
+
+// sharedValue is a value as stored, and the number of entries holding it.
+type sharedValue struct {
+	value       string
+	chunks      []string
+	compression Compression
+	refs        int
+}
+
+func newValuePool(above int) valuePool {
+	return valuePool{above: above, seed: maphash.MakeSeed(), values: make(map[uint64]*sharedValue)}
+}
+
+// hash is the hash of the pieces joined, stored with compression.
+func (p *valuePool) hash(compression Compression, pieces ...string) uint64 {
+	var h maphash.Hash
+	h.SetSeed(p.seed)
+	h.WriteByte(byte(compression))
+	for _, s := range pieces {
+		h.WriteString(s)
+	}
+	return h.Sum64()
+}
+
+// entryHash is the hash of e's value.
+func (p *valuePool) entryHash(e *entry) uint64 {
+	if e.chunks != nil {
+		return p.hash(e.compression, e.chunks...)
+	}
+	return p.hash(e.compression, e.value)
+}
+
+// equal reports whether s holds value.
+func (s *sharedValue) equal(value string, compression Compression) bool {
+	if s.compression != compression {
+		return false
+	}
+	if s.chunks == nil {
+		return s.value == value
+	}
+	for _, c := range s.chunks {
+		if !strings.HasPrefix(value, c) {
+			return false
+		}
+		value = value[len(c):]
+	}
+	return value == ""
+}
+
+func (p *valuePool) reset() {
+	clear(p.values)
+}
+
+// holdValue gives e value, stored with compression: held in chunks if it
+// is long, and sharing the storage of an equal value other entries hold
+// if it is long enough to be pooled. The memory counts a shared value
+// once. The caller holds the lock.
+func (this *LRUCache) holdValue(e *entry, value string, compression Compression) {
+	e.compression = compression
+	pool := &this.dedup
+	if pool.above == 0 || len(value) <= pool.above {
+		e.value, e.chunks = splitValue(value, this.chunkSize)
+		this.memory.add("", e.valueLen(), 1)
+		return
+	}
+	h := pool.hash(compression, value)
+	if s, ok := pool.values[h]; ok {
+		if s.equal(value, compression) {
+			s.refs++
+			e.value, e.chunks, e.shared = s.value, s.chunks, true
+			this.memory.deduplicated += int64(len(value))
+			return
+		}
+		e.value, e.chunks = splitValue(value, this.chunkSize)
+		this.memory.add("", e.valueLen(), 1)
+		return
+	}
+	e.value, e.chunks = splitValue(value, this.chunkSize)
+	e.shared = true
+	pool.values[h] = &sharedValue{value: e.value, chunks: e.chunks, compression: compression, refs: 1}
+	this.memory.add("", len(value), 1)
+}
+
+// dropValue takes e's value away, releasing its share of a pooled one.
+// The caller holds the lock.
+func (this *LRUCache) dropValue(e *entry) {
+	n := int64(e.valueLen())
+	if e.shared {
+		h := this.dedup.entryHash(e)
+		if s := this.dedup.values[h]; s.refs > 1 {
+			s.refs--
+			this.memory.deduplicated -= n
+			n = 0
+		} else {
+			delete(this.dedup.values, h)
+		}
+	}
+	this.memory.add("", int(n), -1)
+	e.value, e.chunks, e.shared = "", nil, false
+}
@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// wantPool fails the test unless c's value pool holds values distinct
+// values, referenced refs times in all, and the memory estimate counts
+// stored bytes of values and deduplicated bytes shared.
+func wantPool(t *testing.T, c *LRUCache, values, refs int, stored, deduplicated int64) {
+	t.Helper()
+	c.mutex.Lock()
+	n := 0
+	for _, s := range c.dedup.values {
+		n += s.refs
+	}
+	got := len(c.dedup.values)
+	c.mutex.Unlock()
+	if got != values || n != refs {
+		t.Errorf("pool holds %d values with %d references, want %d with %d", got, n, values, refs)
+	}
+	mem := c.Stats(0).Memory
+	if mem.Values != stored || mem.Deduplicated != deduplicated {
+		t.Errorf("memory counts %d bytes of values and %d deduplicated, want %d and %d", mem.Values, mem.Deduplicated, stored, deduplicated)
+	}
+}
+
+func TestDedupSharesEqualValues(t *testing.T) {
+	c := newTestCache(t, WithDedup(10))
+	ctx := context.Background()
+	payload := strings.Repeat("p", 100)
+	for _, key := range []string{"a", "b", "c"} {
+		c.Set(ctx, key, payload, 0)
+	}
+	wantPool(t, c, 1, 3, 100, 200)
+	for _, key := range []string{"a", "b", "c"} {
+		if v, _ := c.Get(key); v != payload {
+			t.Fatalf("Get(%q) = %q", key, v)
+		}
+	}
+
+	// A short value is held apart, outside the pool.
+	c.Set(ctx, "short", "s", 0)
+	wantPool(t, c, 1, 3, 101, 200)
+
+	// Overwriting one releases its reference.
+	other := strings.Repeat("o", 50)
+	c.Set(ctx, "b", other, 0)
+	wantPool(t, c, 2, 3, 151, 100)
+
+	c.Delete(ctx, "a")
+	wantPool(t, c, 2, 2, 151, 0)
+	// The last holder releases the value itself.
+	c.Delete(ctx, "c")
+	wantPool(t, c, 1, 1, 51, 0)
+	c.Delete(ctx, "b")
+	wantPool(t, c, 0, 0, 1, 0)
+}
+
+func TestDedupReleasesOnEviction(t *testing.T) {
+	c := newTestCache(t, WithCapacity(2), WithDedup(10))
+	ctx := context.Background()
+	payload := strings.Repeat("p", 100)
+	c.Set(ctx, "a", payload, 0)
+	c.Set(ctx, "b", payload, 0)
+	c.Set(ctx, "c", "x", 0)
+	wantPool(t, c, 1, 1, 101, 0)
+
+	c.Flush(ctx)
+	wantPool(t, c, 0, 0, 0, 0)
+}
+
+func TestDedupOfChunkedValues(t *testing.T) {
+	c := newTestCache(t, WithDedup(10), WithChunkSize(16))
+	ctx := context.Background()
+	payload := strings.Repeat("0123456789", 10)
+	c.Set(ctx, "a", payload, 0)
+	c.Set(ctx, "b", payload, 0)
+	wantPool(t, c, 1, 2, 100, 100)
+
+	// Equal chunks but a different tail make a different value.
+	c.Set(ctx, "c", payload+"!", 0)
+	wantPool(t, c, 2, 3, 201, 100)
+	for key, want := range map[string]string{"a": payload, "b": payload, "c": payload + "!"} {
+		if v, _ := c.Get(key); v != want {
+			t.Errorf("Get(%q) = %q, want %q", key, v, want)
+		}
+	}
+}
+
+func TestSharedValueEqual(t *testing.T) {
+	s := &sharedValue{chunks: []string{"abc", "def"}}
+	for value, want := range map[string]bool{
+		"abcdef":  true,
+		"abcde":   false,
+		"abcdefg": false,
+		"abcxef":  false,
+	} {
+		if got := s.equal(value, CompressNone); got != want {
+			t.Errorf("equal(%q) = %v, want %v", value, got, want)
+		}
+	}
+	if s.equal("abcdef", CompressSnappy) {
+		t.Error("equal ignores the compression")
+	}
+}
@@ -0,0 +1,167 @@
+package cache
+
+import "time"
+
+// efficiencyStep is the resolution of the efficiency windows, and
+// efficiencySteps how many steps are kept: an hour's worth.
+const (
+	efficiencyStep  = 10 * time.Second
+	efficiencySteps = 360
+)
+
+// efficiencyWindows are the windows /v1/stats/efficiency reports.
+var efficiencyWindows = []struct {
+	name string
+	d    time.Duration
+}{{"1m", time.Minute}, {"5m", 5 * time.Minute}, {"1h", time.Hour}}
+
+// efficiencyCounts is what happened during one step.
+type efficiencyCounts struct {
+	// step numbers the step, counting from the Unix epoch.
+	step        int64
+	hits        uint64
+	misses      uint64
+	sets        uint64
+	evictions   uint64
+	expirations uint64
+	removals    uint64
+	// lifetime is the total time the removed entries had been cached.
+	lifetime time.Duration
+}
+
+// EfficiencyReport is how well the cache is sized over sliding windows.
+type EfficiencyReport struct {
+	Entries  int `json:"entries" msgpack:"entries"`
+	Capacity int `json:"capacity" msgpack:"capacity"`
+	// The last minute, 5 minutes and hour.
+	Windows []EfficiencyWindow `json:"windows" msgpack:"windows"`
+}
+
+type EfficiencyWindow struct {
+	Window string `json:"window" msgpack:"window"`
+	// The time the counts cover, which is shorter than the window
+	// until the server has been up that long.
+	Seconds  float64 `json:"seconds" msgpack:"seconds"`
+	Hits     uint64  `json:"hits" msgpack:"hits"`
+	Misses   uint64  `json:"misses" msgpack:"misses"`
+	HitRatio float64 `json:"hit_ratio" msgpack:"hit_ratio"`
+	Sets     uint64  `json:"sets" msgpack:"sets"`
+	// Entries evicted for capacity or a namespace quota.
+	Evictions   uint64 `json:"evictions" msgpack:"evictions"`
+	Expirations uint64 `json:"expirations" msgpack:"expirations"`
+	// Entries removed for any reason but a flush, deletes included.
+	Removals uint64 `json:"removals" msgpack:"removals"`
+	// How long the removed entries had been cached since they were
+	// last written, on average. Entries evicted well before their
+	// TTL point to too little capacity; entries expiring unread, to
+	// too long a TTL.
+	AvgLifetimeSeconds float64 `json:"avg_lifetime_seconds" msgpack:"avg_lifetime_seconds"`
+	// Removals per second.
+	ChurnPerSecond float64 `json:"churn_per_second" msgpack:"churn_per_second"`
+}
+
+// efficiencyTracker counts reads, writes and removals in a ring of steps
+// so that they can be summed over sliding windows. The cache lock
+// protects it.
+type efficiencyTracker struct {
+	started time.Time
+	steps   [efficiencySteps]efficiencyCounts
+}
+
+func newEfficiencyTracker(now time.Time) *efficiencyTracker {
+	return &efficiencyTracker{started: now}
+}
+
+// at returns the counts of the step now falls in, clearing what is left
+// in its slot from an hour or more ago.
+func (t *efficiencyTracker) at(now time.Time) *efficiencyCounts {
+	step := now.UnixNano() / int64(efficiencyStep)
+	c := &t.steps[step%efficiencySteps]
+	if c.step != step {
+		*c = efficiencyCounts{step: step}
+	}
+	return c
+}
+
+func (t *efficiencyTracker) read(hit bool, now time.Time) {
+	if hit {
+		t.at(now).hits++
+	} else {
+		t.at(now).misses++
+	}
+}
+
+// event counts what publish reports.
+func (t *efficiencyTracker) event(eventType string, now time.Time) {
+	switch eventType {
+	case EventSet:
+		t.at(now).sets++
+	case EventEvict:
+		t.at(now).evictions++
+	case EventExpire:
+		t.at(now).expirations++
+	}
+}
+
+// removed counts an entry, last written at storedAt, leaving the cache.
+func (t *efficiencyTracker) removed(storedAt, now time.Time) {
+	c := t.at(now)
+	c.removals++
+	c.lifetime += now.Sub(storedAt)
+}
+
+// window sums the steps within d of now, the current one included.
+func (t *efficiencyTracker) window(name string, d time.Duration, now time.Time) EfficiencyWindow {
+	step := now.UnixNano() / int64(efficiencyStep)
+	n := int64(d / efficiencyStep)
+	var sum efficiencyCounts
+	for i := range n {
+		c := &t.steps[(step-i)%efficiencySteps]
+		if c.step != step-i {
+			continue
+		}
+		sum.hits += c.hits
+		sum.misses += c.misses
+		sum.sets += c.sets
+		sum.evictions += c.evictions
+		sum.expirations += c.expirations
+		sum.removals += c.removals
+		sum.lifetime += c.lifetime
+	}
+	// The window starts n-1 whole steps before the current one.
+	covered := now.Sub(time.Unix(0, (step-n+1)*int64(efficiencyStep)))
+	covered = min(covered, now.Sub(t.started))
+	w := EfficiencyWindow{
+		Window:      name,
+		Seconds:     covered.Seconds(),
+		Hits:        sum.hits,
+		Misses:      sum.misses,
+		Sets:        sum.sets,
+		Evictions:   sum.evictions,
+		Expirations: sum.expirations,
+		Removals:    sum.removals,
+	}
+	if total := sum.hits + sum.misses; total > 0 {
+		w.HitRatio = float64(sum.hits) / float64(total)
+	}
+	if sum.removals > 0 {
+		w.AvgLifetimeSeconds = (sum.lifetime / time.Duration(sum.removals)).Seconds()
+	}
+	if covered > 0 {
+		w.ChurnPerSecond = float64(sum.removals) / covered.Seconds()
+	}
+	return w
+}
+
+// Efficiency reports how well the cache is sized over the last minute, 5
+// minutes and hour.
+func (this *LRUCache) Efficiency() *EfficiencyReport {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	now := this.now()
+	report := &EfficiencyReport{Entries: len(this.cache), Capacity: this.capacity}
+	for _, w := range efficiencyWindows {
+		report.Windows = append(report.Windows, this.efficiency.window(w.name, w.d, now))
+	}
+	return report
+}
@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// The types of Event.
+const (
+	EventSet    = "set"
+	EventDelete = "delete"
+	EventEvict  = "evict"
+	EventExpire = "expire"
+)
+
+// Event is a change to the cache.
+type Event struct {
+	Type string `json:"type" msgpack:"type"`
+	Key  string `json:"key" msgpack:"key"`
+	// Why the key changed, e.g. capacity, quota, ttl, touch, load, explicit or flush.
+	Reason string `json:"reason,omitempty" msgpack:"reason,omitempty"`
+	// X-Request-ID of the request that caused the change, if any.
+	RequestID string    `json:"request_id,omitempty" msgpack:"request_id,omitempty"`
+	Time      time.Time `json:"time" msgpack:"time"`
+}
+
+// eventBus fans cache events out to subscribers. Publishing never blocks:
+// a subscriber whose buffer is full misses the event.
+type eventBus struct {
+	mutex       sync.RWMutex
+	subscribers map[chan Event]struct{}
+}
+
+func (b *eventBus) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	b.mutex.Lock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan Event]struct{})
+	}
+	b.subscribers[ch] = struct{}{}
+	b.mutex.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			delete(b.subscribers, ch)
+			b.mutex.Unlock()
+		})
+	}
+}
+
+func (b *eventBus) Publish(e Event) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of cache events and a function that ends the
+// subscription.
+func (this *LRUCache) Subscribe(buffer int) (<-chan Event, func()) {
+	return this.events.Subscribe(buffer)
+}
+
+// MutationSink receives every event with the cache lock held, in the order
+// the changes happened. For sets, item is the entry as stored; otherwise
+// it is zero. It must be quick and must not call back into the cache.
+type MutationSink func(e Event, item Item)
+
+// AddMutationSink registers sink after any already registered.
+func (this *LRUCache) AddMutationSink(sink MutationSink) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.sinks = append(this.sinks, sink)
+}
+
+// ClearMutationSinks unregisters every sink, so that they can be closed.
+func (this *LRUCache) ClearMutationSinks() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.sinks = nil
+}
+
+// eventOrigin is what publish knows of the request that caused a change.
+// The client only goes to the audit sink, not to subscribers.
+type eventOrigin struct {
+	requestID string
+	client    Client
+}
+
+func originFrom(ctx context.Context) eventOrigin {
+	origin := eventOrigin{requestID: RequestIDFrom(ctx)}
+	if c := ClientFrom(ctx); c != nil {
+		origin.client = *c
+	}
+	return origin
+}
+
+// AuditSink receives every set and delete with the client that made it,
+// with the cache lock held. Like a MutationSink it must be quick.
+type AuditSink func(e Event, client Client)
+
+// SetAuditSink sends every set and delete to sink from now on; nil stops
+// it.
+func (this *LRUCache) SetAuditSink(sink AuditSink) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.audit = sink
+}
+
+// publish sends an event; origin is the request that caused it, if any.
+// The caller holds the cache lock.
+func (this *LRUCache) publish(eventType, key, reason string, origin eventOrigin) {
+	e := Event{Type: eventType, Key: key, Reason: reason, RequestID: origin.requestID, Time: time.Now()}
+	this.events.Publish(e)
+	this.efficiency.event(eventType, e.Time)
+	if this.removals != nil && eventType != EventSet {
+		this.removals.record(e)
+	}
+	if this.audit != nil && (eventType == EventSet || eventType == EventDelete) {
+		this.audit(e, origin.client)
+	}
+	if len(this.hooks) > 0 {
+		var item func() Item
+		if eventType == EventSet {
+			e, _ := this.find(key)
+			// A set event follows a write or a read of the entry, so
+			// its value decodes.
+			item = func() Item { item, _ := e.item(); return item }
+		}
+		this.runHooks(eventHooks[eventType], HookEvent{Key: key, Reason: reason, RequestID: origin.requestID, Time: e.Time}, item)
+	}
+	if len(this.sinks) > 0 {
+		var item Item
+		if en, ok := this.find(key); ok && eventType == EventSet {
+			item, _ = en.item()
+		}
+		for _, sink := range this.sinks {
+			sink(e, item)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Hooks are callbacks on cache activity, for observers such as metrics,
+// webhooks and replication. Any may be nil. Unlike Subscribe, hooks also
+// see reads.
+type Hooks struct {
+	OnSet    func(HookEvent)
+	OnGet    func(HookEvent)
+	OnMiss   func(HookEvent)
+	OnDelete func(HookEvent)
+	OnEvict  func(HookEvent)
+	OnExpire func(HookEvent)
+}
+
+// HookEvent describes what a hook is called for. Item is the entry for
+// OnSet and OnGet, and zero otherwise. Reads carry no request ID.
+type HookEvent struct {
+	Key       string
+	Item      Item
+	Reason    string
+	RequestID string
+	Time      time.Time
+}
+
+// HookOptions chooses how hooks are dispatched. Synchronous hooks run
+// with the cache lock held, in the order things happen, and must be
+// quick and must not call back into the cache. Asynchronous ones run in
+// order on a goroutine of their own, behind a queue of Buffer calls; when
+// it is full, calls are dropped and counted rather than slowing the cache.
+type HookOptions struct {
+	Async  bool
+	Buffer int
+}
+
+// HookRegistration is a set of hooks added to the cache.
+type HookRegistration struct {
+	cache   *LRUCache
+	hooks   Hooks
+	queue   chan hookCall
+	dropped atomic.Uint64
+	once    sync.Once
+	done    sync.WaitGroup
+}
+
+type hookCall struct {
+	fn    func(HookEvent)
+	event HookEvent
+}
+
+// AddHooks registers hooks after any already registered.
+func (this *LRUCache) AddHooks(hooks Hooks, opts HookOptions) *HookRegistration {
+	r := &HookRegistration{cache: this, hooks: hooks}
+	if opts.Async {
+		r.queue = make(chan hookCall, opts.Buffer)
+		r.done.Add(1)
+		go r.run()
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.hooks = append(this.hooks, r)
+	return r
+}
+
+// Remove unregisters the hooks. For asynchronous ones it waits until the
+// calls already queued have run.
+func (r *HookRegistration) Remove() {
+	r.once.Do(func() {
+		c := r.cache
+		c.mutex.Lock()
+		for i, h := range c.hooks {
+			if h == r {
+				c.hooks = append(c.hooks[:i:i], c.hooks[i+1:]...)
+				break
+			}
+		}
+		c.mutex.Unlock()
+		if r.queue != nil {
+			close(r.queue)
+			r.done.Wait()
+		}
+	})
+}
+
+// Dropped is the number of asynchronous calls lost to a full queue.
+func (r *HookRegistration) Dropped() uint64 {
+	return r.dropped.Load()
+}
+
+func (r *HookRegistration) run() {
+	defer r.done.Done()
+	for call := range r.queue {
+		call.fn(call.event)
+	}
+}
+
+func (r *HookRegistration) call(fn func(HookEvent), e HookEvent) {
+	if r.queue == nil {
+		fn(e)
+		return
+	}
+	select {
+	case r.queue <- hookCall{fn, e}:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+type hookKind int
+
+const (
+	hookSet hookKind = iota
+	hookGet
+	hookMiss
+	hookDelete
+	hookEvict
+	hookExpire
+)
+
+func (h *Hooks) hook(kind hookKind) func(HookEvent) {
+	switch kind {
+	case hookSet:
+		return h.OnSet
+	case hookGet:
+		return h.OnGet
+	case hookMiss:
+		return h.OnMiss
+	case hookDelete:
+		return h.OnDelete
+	case hookEvict:
+		return h.OnEvict
+	case hookExpire:
+		return h.OnExpire
+	}
+	return nil
+}
+
+// eventHooks maps publish's event types to their hooks.
+var eventHooks = map[string]hookKind{
+	EventSet:    hookSet,
+	EventDelete: hookDelete,
+	EventEvict:  hookEvict,
+	EventExpire: hookExpire,
+}
+
+// runHooks calls the hook of kind in every registration that has one.
+// item, if not nil, is called for the entry once some hook wants it. The
+// caller holds the cache lock.
+func (this *LRUCache) runHooks(kind hookKind, e HookEvent, item func() Item) {
+	for _, r := range this.hooks {
+		fn := r.hooks.hook(kind)
+		if fn == nil {
+			continue
+		}
+		if item != nil {
+			e.Item, item = item(), nil
+		}
+		r.call(fn, e)
+	}
+}
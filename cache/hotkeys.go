@@ -0,0 +1,202 @@
+package cache
+
+import (
+	"cmp"
+	"container/heap"
+	"hash/maphash"
+	"slices"
+	"time"
+)
+
+// hotKeyTracker finds the most-read keys in fixed windows, in memory that
+// does not grow with the keyspace: a count-min sketch estimates each
+// key's reads in the current window, and a min-heap keeps the topK
+// estimates. Unlike Stats.HotKeys it counts misses as well as hits and
+// forgets keys that have cooled off. The cache lock protects it.
+type hotKeyTracker struct {
+	topK   int
+	window time.Duration
+	seed   maphash.Seed
+	// counts is sketchDepth rows of width counters.
+	counts []uint32
+	width  uint64
+	start  time.Time
+	top    hotKeyHeap
+	// last is the previous window's top keys, hottest first, and lastEnd
+	// when it ended.
+	last    []HotKeyRate
+	lastEnd time.Time
+}
+
+const sketchDepth = 4
+
+func newHotKeyTracker(topK int, window time.Duration, sketchWidth int, now time.Time) *hotKeyTracker {
+	return &hotKeyTracker{
+		topK:   topK,
+		window: window,
+		seed:   maphash.MakeSeed(),
+		counts: make([]uint32, sketchDepth*sketchWidth),
+		width:  uint64(sketchWidth),
+		start:  now,
+		top:    hotKeyHeap{index: make(map[string]int)},
+	}
+}
+
+// record counts one read of key.
+func (t *hotKeyTracker) record(key string, now time.Time) {
+	t.rotate(now)
+	count := t.add(key)
+	if i, ok := t.top.index[key]; ok {
+		t.top.keys[i].count = count
+		heap.Fix(&t.top, i)
+		return
+	}
+	switch {
+	case len(t.top.keys) < t.topK:
+		heap.Push(&t.top, hotKeyCount{key: key, count: count})
+	case count > t.top.keys[0].count:
+		delete(t.top.index, t.top.keys[0].key)
+		t.top.keys[0] = hotKeyCount{key: key, count: count}
+		t.top.index[key] = 0
+		heap.Fix(&t.top, 0)
+	}
+}
+
+// add increments key's counters and returns its estimate. Only the
+// counters at the current minimum are raised (the conservative update),
+// which keeps collisions from inflating estimates as much.
+func (t *hotKeyTracker) add(key string) uint32 {
+	h := maphash.String(t.seed, key)
+	h1, h2 := h&0xffffffff, h>>32|1
+	var cells [sketchDepth]*uint32
+	estimate := ^uint32(0)
+	for row := range cells {
+		cells[row] = &t.counts[uint64(row)*t.width+(h1+uint64(row)*h2)%t.width]
+		estimate = min(estimate, *cells[row])
+	}
+	if estimate == ^uint32(0) {
+		return estimate
+	}
+	for _, c := range cells {
+		if *c == estimate {
+			*c++
+		}
+	}
+	return estimate + 1
+}
+
+// rotate ends the current window if it is over, keeping its top keys as
+// the last window's. After an idle window there is no last window.
+func (t *hotKeyTracker) rotate(now time.Time) {
+	elapsed := now.Sub(t.start)
+	if elapsed < t.window {
+		return
+	}
+	t.last = nil
+	if elapsed < 2*t.window {
+		t.last = t.top.rates(t.window)
+	}
+	clear(t.counts)
+	clear(t.top.index)
+	t.top.keys = t.top.keys[:0]
+	t.start = now.Add(-elapsed % t.window)
+	t.lastEnd = t.start
+}
+
+// report returns up to n keys from the last complete window or, before
+// one has completed, from the current window so far.
+func (t *hotKeyTracker) report(n int, now time.Time) *HotKeyReport {
+	t.rotate(now)
+	report := &HotKeyReport{WindowSeconds: t.window.Seconds(), WindowEnd: t.lastEnd, Keys: t.last}
+	if t.lastEnd.IsZero() {
+		elapsed := now.Sub(t.start)
+		report.WindowSeconds, report.WindowEnd, report.Keys = elapsed.Seconds(), now, t.top.rates(elapsed)
+	}
+	if report.Keys == nil {
+		report.Keys = []HotKeyRate{}
+	}
+	if len(report.Keys) > n {
+		report.Keys = report.Keys[:n]
+	}
+	return report
+}
+
+// HotKeyReport is the most-read keys of a window.
+type HotKeyReport struct {
+	WindowSeconds float64 `json:"window_seconds" msgpack:"window_seconds"`
+	// When the window ended. Until the first window completes, the
+	// report covers the current window so far and this is now.
+	WindowEnd time.Time `json:"window_end" msgpack:"window_end"`
+	// Hottest first.
+	Keys []HotKeyRate `json:"keys" msgpack:"keys"`
+}
+
+type HotKeyRate struct {
+	Key string `json:"key" msgpack:"key"`
+	// Estimated reads, hits and misses, in the window.
+	Reads     uint64  `json:"reads" msgpack:"reads"`
+	PerSecond float64 `json:"per_second" msgpack:"per_second"`
+}
+
+type hotKeyCount struct {
+	key   string
+	count uint32
+}
+
+// hotKeyHeap is a min-heap on count, with index locating each key.
+type hotKeyHeap struct {
+	keys  []hotKeyCount
+	index map[string]int
+}
+
+func (h *hotKeyHeap) Len() int           { return len(h.keys) }
+func (h *hotKeyHeap) Less(i, j int) bool { return h.keys[i].count < h.keys[j].count }
+func (h *hotKeyHeap) Swap(i, j int) {
+	h.keys[i], h.keys[j] = h.keys[j], h.keys[i]
+	h.index[h.keys[i].key], h.index[h.keys[j].key] = i, j
+}
+func (h *hotKeyHeap) Push(x any) {
+	k := x.(hotKeyCount)
+	h.index[k.key] = len(h.keys)
+	h.keys = append(h.keys, k)
+}
+func (h *hotKeyHeap) Pop() any {
+	k := h.keys[len(h.keys)-1]
+	h.keys = h.keys[:len(h.keys)-1]
+	delete(h.index, k.key)
+	return k
+}
+
+// rates returns the keys hottest first, with their reads over d.
+func (h *hotKeyHeap) rates(d time.Duration) []HotKeyRate {
+	rates := make([]HotKeyRate, 0, len(h.keys))
+	for _, k := range h.keys {
+		rate := HotKeyRate{Key: k.key, Reads: uint64(k.count)}
+		if d > 0 {
+			rate.PerSecond = float64(k.count) / d.Seconds()
+		}
+		rates = append(rates, rate)
+	}
+	slices.SortFunc(rates, func(a, b HotKeyRate) int { return cmp.Compare(b.Reads, a.Reads) })
+	return rates
+}
+
+// SetHotKeyTracking starts tracking the topK most-read keys in windows of
+// the given length, with a sketch sketchWidth counters wide. It must be
+// called before the cache is in use.
+func (this *LRUCache) SetHotKeyTracking(topK int, window time.Duration, sketchWidth int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.hotKeys = newHotKeyTracker(topK, window, sketchWidth, this.now())
+}
+
+// HotKeys reports up to n of the most-read keys, or nil if tracking is
+// off.
+func (this *LRUCache) HotKeys(n int) *HotKeyReport {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.hotKeys == nil {
+		return nil
+	}
+	return this.hotKeys.report(n, this.now())
+}
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// Cache is what the storage engines have in common: LRUCache, LFUCache,
+// and Sharded and Tiered, which are built from others. Loaders, events
+// and hooks are LRUCache's alone.
+type Cache interface {
+	Get(key string) (string, bool)
+	GetItem(key string) (Item, bool)
+	// Set stores value under key for ttl, or for the cache's default TTL
+	// when ttl is zero.
+	Set(ctx context.Context, key, value string, ttl time.Duration) Item
+	Delete(ctx context.Context, key string) bool
+	Len() int
+	// DefaultTTL is how long entries written without a TTL are kept.
+	DefaultTTL() time.Duration
+	// Stats reports the counters along with the n most read keys.
+	Stats(n int) *Stats
+	// Close stops any background work.
+	Close()
+}
+
+// ConditionalCache is a Cache that can write conditionally, as LRUCache
+// and LFUCache can.
+type ConditionalCache interface {
+	Cache
+	// SetIf stores value only if cond, called with the current entry (ok
+	// is false when the key is absent or expired), returns true. It
+	// reports the entry as stored, or as it was when cond refused.
+	SetIf(ctx context.Context, key, value string, ttl time.Duration, cond func(current Item, ok bool) bool) (Item, bool)
+}
+
+var (
+	_ ConditionalCache = (*LRUCache)(nil)
+	_ ConditionalCache = (*LFUCache)(nil)
+	_ Cache            = (*Sharded)(nil)
+	_ Cache            = (*Tiered)(nil)
+)
+
+// NewEngine makes a cache of the engine the options choose: an LRUCache,
+// an LFUCache with WithPolicy(EvictLFU), and with WithShards a Sharded of
+// such caches that split the capacity between them, and each quota's
+// limits, rounding up. Every other option applies to each shard as it
+// is given.
+func NewEngine(opts ...Option) (Cache, error) {
+	o := cacheOptions{capacity: 1000, shards: 1}
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+	// one returns nil, not a nil *LRUCache or *LFUCache, on failure.
+	one := func(opts ...Option) (Cache, error) {
+		if o.policy == EvictLFU {
+			c, err := NewLFU(opts...)
+			if err != nil {
+				return nil, err
+			}
+			return c, nil
+		}
+		c, err := NewCache(opts...)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	if o.shards == 1 {
+		return one(opts...)
+	}
+	shardOpts := append(slices.Clip(opts), WithCapacity(max(divideUp(o.capacity, o.shards), 1)), WithShards(1))
+	for namespace, quota := range o.quotas {
+		shardOpts = append(shardOpts, WithQuota(namespace, quota.split(o.shards)))
+	}
+	shards := make([]Cache, 0, o.shards)
+	for range o.shards {
+		shard, err := one(shardOpts...)
+		if err != nil {
+			for _, s := range shards {
+				s.Close()
+			}
+			return nil, err
+		}
+		shards = append(shards, shard)
+	}
+	return NewSharded(shards...)
+}
+
+// split returns the share of q each of n shards enforces: its entry,
+// byte and reserved byte limits divided between them, rounding up.
+func (q Quota) split(n int) Quota {
+	q.MaxEntries = divideUp(q.MaxEntries, n)
+	q.MaxBytes = divideUp(q.MaxBytes, int64(n))
+	q.ReservedBytes = divideUp(q.ReservedBytes, int64(n))
+	return q
+}
+
+func divideUp[T int | int64](a, b T) T {
+	return (a + b - 1) / b
+}
@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewLFURejectsLRUOptions(t *testing.T) {
+	_, err := NewLFU(WithQuota("a", Quota{MaxEntries: 1}), WithChunkSize(16))
+	if err == nil || !strings.Contains(err.Error(), "WithQuota, WithChunkSize") {
+		t.Errorf("NewLFU with LRU options = %v", err)
+	}
+	c, err := NewLFU(WithCapacity(10), WithSweepInterval(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+func TestShardsSplitQuotas(t *testing.T) {
+	c, err := NewEngine(WithCapacity(100), WithShards(4), WithQuota("a", Quota{MaxEntries: 8, MaxBytes: 1000}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, shard := range c.(*Sharded).shards {
+		ns := namespaceStats(t, shard.(*LRUCache), "a")
+		if ns.MaxEntries != 2 || ns.MaxBytes != 250 {
+			t.Fatalf("shard's share of the quota = %d entries and %d bytes, want 2 and 250", ns.MaxEntries, ns.MaxBytes)
+		}
+	}
+	var total NamespaceStats
+	for _, ns := range c.Stats(0).Namespaces {
+		if ns.Name == "a" {
+			total = ns
+		}
+	}
+	if total.MaxEntries != 8 || total.MaxBytes != 1000 {
+		t.Errorf("sharded quota = %d entries and %d bytes, want 8 and 1000", total.MaxEntries, total.MaxBytes)
+	}
+}
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"unsafe"
+)
+
+const (
+	// keyChunkSize is the size of the chunks keys are copied into.
+	keyChunkSize = 4 << 10
+	// maxArenaKey is the longest key copied into a chunk; longer ones
+	// get an allocation of their own.
+	maxArenaKey = 256
+)
+
+// keyArena stores the keys of new entries. The map and the entry share
+// one copy of a key; the arena makes that copy compact. Keys are packed
+// end to end in shared chunks, so that each takes its exact length
+// rather than an allocation of its own rounded up to a size class. And a
+// key is never a substring of the caller's larger string, such as a
+// request path or a protocol frame, which would stay in memory with it.
+//
+// Chunks are never written to again where keys have been copied, so the
+// strings are immutable, and the garbage collector frees a chunk once
+// none of its keys is referenced, by the cache or by an Item handed out.
+// A chunk that keeps a few long-lived keys among many deleted ones stays
+// whole, so the arena counts how much of what it has copied is still in
+// use: when more than half is dead, the cache copies the keys it holds
+// into new chunks before starting another, and the old ones are freed.
+// The caller holds the cache lock.
+type keyArena struct {
+	chunk []byte
+	// copied is the length of the keys copied into chunks since the
+	// arena was last reset, and live the part of it still in use.
+	copied, live int
+}
+
+// inArena reports whether intern copies key into a chunk.
+func inArena(key string) bool {
+	return len(key) > 0 && len(key) <= maxArenaKey
+}
+
+func (a *keyArena) intern(key string) string {
+	if !inArena(key) {
+		return strings.Clone(key)
+	}
+	if cap(a.chunk)-len(a.chunk) < len(key) {
+		a.chunk = make([]byte, 0, keyChunkSize)
+	}
+	start := len(a.chunk)
+	a.chunk = append(a.chunk, key...)
+	a.copied += len(key)
+	a.live += len(key)
+	return unsafe.String(&a.chunk[start], len(key))
+}
+
+// release accounts for a key intern returned going out of use.
+func (a *keyArena) release(key string) {
+	if inArena(key) {
+		a.live -= len(key)
+	}
+}
+
+// wasteful reports whether interning key would start a chunk while more
+// than half of what the chunks hold is dead: the time to compact.
+func (a *keyArena) wasteful(key string) bool {
+	return inArena(key) && cap(a.chunk)-len(a.chunk) < len(key) &&
+		a.copied >= 2*keyChunkSize && a.live < a.copied/2
+}
+
+// reset forgets the chunks. The cache interns again the keys it keeps,
+// and stops using the old copies, which frees the old chunks.
+func (a *keyArena) reset() {
+	*a = keyArena{}
+}
+
+// compactKeys copies the keys of the entries into new chunks. The caller
+// holds the lock.
+func (this *LRUCache) compactKeys() {
+	this.keys.reset()
+	for e := this.entries.at(this.head); e != nil; e = this.entries.at(e.next) {
+		// An assignment keeps the old key of an equal one in the map.
+		delete(this.cache, e.key)
+		e.key = this.keys.intern(e.key)
+		this.cache[e.key] = e.id
+	}
+}
+
+// compactKeys copies the keys of the entries into new chunks. The caller
+// holds the lock.
+func (c *LFUCache) compactKeys() {
+	entries := slices.Collect(maps.Values(c.entries))
+	c.keys.reset()
+	for _, e := range entries {
+		delete(c.entries, e.key)
+		e.key = c.keys.intern(e.key)
+		c.entries[e.key] = e
+	}
+}
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// fillAndThin writes n keys through set, then deletes all but every
+// hundredth through del, so that each chunk keeps a key or two alive.
+func fillAndThin(n int, set func(key string), del func(key string)) {
+	for i := range n {
+		set(fmt.Sprintf("key-%015d", i))
+	}
+	for i := range n {
+		if i%100 != 0 {
+			del(fmt.Sprintf("key-%015d", i))
+		}
+	}
+}
+
+func TestKeyArenaCompactsAfterDeletes(t *testing.T) {
+	const n = 200000
+	c := newTestCache(t, WithCapacity(n))
+	ctx := context.Background()
+	fillAndThin(n,
+		func(key string) { c.Set(ctx, key, "v", 0) },
+		func(key string) { c.Delete(ctx, key) })
+
+	c.mutex.Lock()
+	copied, live := c.keys.copied, c.keys.live
+	c.mutex.Unlock()
+	if want := n / 100 * len("key-000000000000000"); live != want {
+		t.Fatalf("live = %d bytes, want %d", live, want)
+	}
+
+	// Writes go on until one needs a new chunk and compacts the arena.
+	written := 0
+	for i := 0; ; i++ {
+		c.Set(ctx, fmt.Sprintf("new-%015d", i), "v", 0)
+		c.mutex.Lock()
+		compacted := c.keys.copied < copied
+		c.mutex.Unlock()
+		if compacted {
+			written = i + 1
+			break
+		}
+		if i > keyChunkSize {
+			t.Fatal("no compaction after a chunk of writes")
+		}
+	}
+
+	// Compaction copies only the live keys, so nothing in the new arena
+	// is dead.
+	c.mutex.Lock()
+	copied, live = c.keys.copied, c.keys.live
+	c.mutex.Unlock()
+	if want := (n/100 + written) * len("key-000000000000000"); copied != want || live != want {
+		t.Errorf("after compaction the arena holds %d bytes, %d live; want %d of each", copied, live, want)
+	}
+	for i := 0; i < n; i += 100 {
+		key := fmt.Sprintf("key-%015d", i)
+		if v, ok := c.Get(key); !ok || v != "v" {
+			t.Fatalf("Get(%q) = %q, %v after compaction", key, v, ok)
+		}
+	}
+	if _, ok := c.Get("key-000000000000001"); ok {
+		t.Error("deleted key found after compaction")
+	}
+}
+
+func TestLFUKeyArenaCompactsAfterDeletes(t *testing.T) {
+	const n = 20000
+	c, err := NewLFU(WithCapacity(n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+	fillAndThin(n,
+		func(key string) { c.Set(ctx, key, "v", 0) },
+		func(key string) { c.Delete(ctx, key) })
+
+	c.mutex.Lock()
+	copied := c.keys.copied
+	c.mutex.Unlock()
+	for i := 0; i < keyChunkSize; i++ {
+		c.Set(ctx, fmt.Sprintf("new-%015d", i), "v", 0)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.keys.copied >= copied {
+		t.Fatalf("arena holds %d bytes, %d before; not compacted", c.keys.copied, copied)
+	}
+	if c.keys.live != c.keys.copied {
+		t.Errorf("after compaction %d of %d bytes live", c.keys.live, c.keys.copied)
+	}
+	if len(c.entries) != n/100+keyChunkSize {
+		t.Fatalf("%d entries after compaction, want %d", len(c.entries), n/100+keyChunkSize)
+	}
+	for key, e := range c.entries {
+		if e.key != key {
+			t.Fatalf("entry %q under key %q", e.key, key)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// The operations timed by the cache. Get is GetItem and everything built
+// on it; set is Store and Update; delete is Delete and DeleteIf; load is
+// the loader call on a miss; sweep is one pass of the expiration janitor.
+// Cache operations include the wait for the lock.
+const (
+	opGet    = "get"
+	opSet    = "set"
+	opDelete = "delete"
+	opLoad   = "load"
+	opSweep  = "sweep"
+)
+
+var latencyOps = []string{opGet, opSet, opDelete, opLoad, opSweep}
+
+// LatencyBuckets is the number of histogram buckets. Bucket i counts
+// durations below 2^i microseconds, and the last one everything longer,
+// so the histogram resolves 1µs to about 4 minutes.
+const LatencyBuckets = 30
+
+// latencyHistogram counts durations in power-of-two buckets without
+// locking, so recording costs a few atomic adds on the hot path.
+type latencyHistogram struct {
+	buckets [LatencyBuckets]atomic.Uint64
+	sumNS   atomic.Uint64
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	us := uint64(max(d.Microseconds(), 0))
+	h.buckets[min(bits.Len64(us), LatencyBuckets-1)].Add(1)
+	h.sumNS.Add(uint64(max(d, 0)))
+}
+
+// LatencyBucketBound is the upper bound of bucket i.
+func LatencyBucketBound(i int) time.Duration {
+	return time.Duration(1<<i) * time.Microsecond
+}
+
+// snapshot copies the counts, which may be mid-update: the total is
+// recomputed from the buckets so that the quantiles are consistent.
+func (h *latencyHistogram) snapshot() (counts [LatencyBuckets]uint64, total uint64, sum time.Duration) {
+	for i := range h.buckets {
+		counts[i] = h.buckets[i].Load()
+		total += counts[i]
+	}
+	return counts, total, time.Duration(h.sumNS.Load())
+}
+
+// quantile estimates the q-quantile from counts, interpolating linearly
+// within the bucket it falls in.
+func quantile(counts [LatencyBuckets]uint64, total uint64, q float64) time.Duration {
+	if total == 0 {
+		return 0
+	}
+	rank := q * float64(total)
+	var seen uint64
+	for i, n := range counts {
+		if n == 0 || float64(seen+n) < rank {
+			seen += n
+			continue
+		}
+		var lower time.Duration
+		if i > 0 {
+			lower = LatencyBucketBound(i - 1)
+		}
+		upper := LatencyBucketBound(i)
+		return lower + time.Duration((rank-float64(seen))/float64(n)*float64(upper-lower))
+	}
+	return LatencyBucketBound(LatencyBuckets - 1)
+}
+
+// OperationLatency summarizes the latency of one operation.
+type OperationLatency struct {
+	Op    string  `json:"op" msgpack:"op"`
+	Count uint64  `json:"count" msgpack:"count"`
+	P50Ms float64 `json:"p50_ms" msgpack:"p50_ms"`
+	P95Ms float64 `json:"p95_ms" msgpack:"p95_ms"`
+	P99Ms float64 `json:"p99_ms" msgpack:"p99_ms"`
+}
+
+// opLatencies holds a histogram for each of latencyOps.
+type opLatencies map[string]*latencyHistogram
+
+func newOpLatencies() opLatencies {
+	l := make(opLatencies, len(latencyOps))
+	for _, op := range latencyOps {
+		l[op] = new(latencyHistogram)
+	}
+	return l
+}
+
+// summary reports the count and p50/p95/p99 of each operation, in
+// milliseconds.
+func (l opLatencies) summary() []OperationLatency {
+	summary := make([]OperationLatency, 0, len(latencyOps))
+	for _, op := range latencyOps {
+		counts, total, _ := l[op].snapshot()
+		ms := func(q float64) float64 { return float64(quantile(counts, total, q)) / float64(time.Millisecond) }
+		summary = append(summary, OperationLatency{Op: op, Count: total, P50Ms: ms(.5), P95Ms: ms(.95), P99Ms: ms(.99)})
+	}
+	return summary
+}
+
+// LatencyHistogram is a copy of an operation's latency histogram: Counts
+// holds the durations in each of the buckets LatencyBucketBound bounds,
+// the last being unbounded.
+type LatencyHistogram struct {
+	Counts [LatencyBuckets]uint64
+	Total  uint64
+	Sum    time.Duration
+}
+
+// Latencies copies the latency histogram of each operation.
+func (this *LRUCache) Latencies() map[string]LatencyHistogram {
+	histograms := make(map[string]LatencyHistogram, len(this.latency))
+	for op, h := range this.latency {
+		var c LatencyHistogram
+		c.Counts, c.Total, c.Sum = h.snapshot()
+		histograms[op] = c
+	}
+	return histograms
+}
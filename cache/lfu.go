@@ -0,0 +1,338 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LFUCache evicts the least frequently read entry when full, and of
+// those the least recently used, which suits workloads whose popular keys
+// stay popular better than LRU does: a scan cannot flush them. Each
+// frequency has a list of its entries, and the lists are kept in order
+// of frequency, so reads, writes and evictions are O(1).
+type LFUCache struct {
+	capacity  int
+	ttl       time.Duration
+	clock     func() time.Time
+	callbacks Callbacks
+
+	mutex   sync.Mutex
+	entries map[string]*lfuEntry
+	// lowest is the list of the lowest frequency with entries, where
+	// evictions start.
+	lowest   *lfuList
+	version  uint64
+	stats    Counters
+	memory   memoryUsage
+	keys     keyArena
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type lfuEntry struct {
+	key       string
+	value     string
+	storedAt  time.Time
+	expiresAt time.Time
+	version   uint64
+	// freq counts the entry's reads, plus one for the write that made it.
+	freq       uint64
+	list       *lfuList
+	prev, next *lfuEntry
+}
+
+// lfuList is the entries of one frequency, most recently used first,
+// linked to the lists of the next lower and higher frequencies that have
+// entries.
+type lfuList struct {
+	freq          uint64
+	head, tail    *lfuEntry
+	lower, higher *lfuList
+}
+
+// NewLFU makes an LFU cache. Of the options it takes the capacity, the
+// default TTL, the clock, the sweep interval and the callbacks, and
+// fails on the others, which are LRUCache's alone.
+func NewLFU(opts ...Option) (*LFUCache, error) {
+	o := cacheOptions{capacity: 1000, ttl: 5 * time.Second, clock: time.Now, policy: EvictLFU, shards: 1, sweepInterval: time.Second}
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+	if err := o.single(EvictLFU); err != nil {
+		return nil, err
+	}
+	if names := o.lruOnly(); len(names) > 0 {
+		return nil, fmt.Errorf("LFU caches do not support %s", strings.Join(names, ", "))
+	}
+	c := &LFUCache{
+		capacity:  o.capacity,
+		ttl:       o.ttl,
+		clock:     o.clock,
+		callbacks: o.callbacks,
+		entries:   make(map[string]*lfuEntry),
+		stop:      make(chan struct{}),
+	}
+	go c.sweep(o.sweepInterval)
+	return c, nil
+}
+
+func (c *LFUCache) Get(key string) (string, bool) {
+	item, ok := c.GetItem(key)
+	return item.Value, ok
+}
+
+func (c *LFUCache) GetItem(key string) (Item, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.entries[key]
+	if ok && c.clock().After(e.expiresAt) {
+		c.expire(e)
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses++
+		return Item{}, false
+	}
+	c.stats.Hits++
+	c.touch(e)
+	return e.item(), true
+}
+
+func (c *LFUCache) Set(_ context.Context, key, value string, ttl time.Duration) Item {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.set(key, value, ttl)
+}
+
+// SetIf stores value only if cond, called with the current entry (ok is
+// false when the key is absent or expired), returns true. It reports the
+// entry as stored, or as it was when cond refused. Neither counts as a
+// read of the entry.
+func (c *LFUCache) SetIf(_ context.Context, key, value string, ttl time.Duration, cond func(current Item, ok bool) bool) (Item, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var current Item
+	e, ok := c.entries[key]
+	if ok && c.clock().After(e.expiresAt) {
+		ok = false
+	}
+	if ok {
+		current = e.item()
+	}
+	if !cond(current, ok) {
+		return current, false
+	}
+	return c.set(key, value, ttl), true
+}
+
+// set stores value under key. The caller holds the lock.
+func (c *LFUCache) set(key, value string, ttl time.Duration) Item {
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
+	now := c.clock()
+	c.version++
+	if e, ok := c.entries[key]; ok {
+		c.memory.add("", len(e.value), -1)
+		c.memory.add("", len(value), 1)
+		e.value, e.storedAt, e.expiresAt, e.version = value, now, now.Add(ttl), c.version
+		return e.item()
+	}
+	if len(c.entries) >= c.capacity {
+		victim := c.lowest.tail
+		c.remove(victim)
+		c.stats.Evictions++
+		if c.callbacks.OnEvict != nil {
+			c.callbacks.OnEvict(victim.key)
+		}
+	}
+	if c.keys.wasteful(key) {
+		c.compactKeys()
+	}
+	key = c.keys.intern(key)
+	e := &lfuEntry{key: key, value: value, storedAt: now, expiresAt: now.Add(ttl), version: c.version, freq: 1}
+	c.entries[key] = e
+	c.memory.add(key, len(value), 1)
+	if c.lowest == nil || c.lowest.freq != 1 {
+		c.lowest = c.insertAfter(nil, 1)
+	}
+	c.lowest.pushFront(e)
+	return e.item()
+}
+
+func (c *LFUCache) Delete(_ context.Context, key string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.entries[key]
+	if ok {
+		c.remove(e)
+	}
+	return ok
+}
+
+// DefaultTTL is how long entries written without a TTL are kept.
+func (c *LFUCache) DefaultTTL() time.Duration {
+	return c.ttl
+}
+
+// Len is the number of entries held, expired ones not yet swept included.
+func (c *LFUCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.entries)
+}
+
+// Stats reports the counters along with the n most frequently read keys.
+func (c *LFUCache) Stats(n int) *Stats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats := &Stats{
+		Entries:     len(c.entries),
+		Capacity:    c.capacity,
+		Hits:        c.stats.Hits,
+		Misses:      c.stats.Misses,
+		Evictions:   c.stats.Evictions,
+		Expirations: c.stats.Expirations,
+		HotKeys:     []HotKey{},
+		Memory:      c.memory.report(len(c.entries), lfuEntryOverhead),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	for _, e := range c.entries {
+		if e.freq > 1 {
+			stats.HotKeys = append(stats.HotKeys, HotKey{Key: e.key, Hits: e.freq - 1})
+		}
+	}
+	sort.Slice(stats.HotKeys, func(i, j int) bool { return stats.HotKeys[i].Hits > stats.HotKeys[j].Hits })
+	if len(stats.HotKeys) > n {
+		stats.HotKeys = stats.HotKeys[:n]
+	}
+	return stats
+}
+
+// Close stops the expiry sweep.
+func (c *LFUCache) Close() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+func (c *LFUCache) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+		c.mutex.Lock()
+		now := c.clock()
+		for _, e := range c.entries {
+			if now.After(e.expiresAt) {
+				c.expire(e)
+			}
+		}
+		c.mutex.Unlock()
+	}
+}
+
+// touch moves e up to the next frequency. The caller holds the lock.
+func (c *LFUCache) touch(e *lfuEntry) {
+	l := e.list
+	next := l.higher
+	if next == nil || next.freq != l.freq+1 {
+		next = c.insertAfter(l, l.freq+1)
+	}
+	c.unlink(e)
+	e.freq++
+	next.pushFront(e)
+}
+
+// remove drops e. The caller holds the lock.
+func (c *LFUCache) remove(e *lfuEntry) {
+	c.unlink(e)
+	delete(c.entries, e.key)
+	c.memory.add(e.key, len(e.value), -1)
+	c.keys.release(e.key)
+}
+
+// expire drops e, which has expired. The caller holds the lock.
+func (c *LFUCache) expire(e *lfuEntry) {
+	c.remove(e)
+	c.stats.Expirations++
+	if c.callbacks.OnExpire != nil {
+		c.callbacks.OnExpire(e.key)
+	}
+}
+
+// unlink takes e off its frequency's list, dropping the list when it
+// empties.
+func (c *LFUCache) unlink(e *lfuEntry) {
+	l := e.list
+	l.remove(e)
+	if l.head != nil {
+		return
+	}
+	if l.lower != nil {
+		l.lower.higher = l.higher
+	} else {
+		c.lowest = l.higher
+	}
+	if l.higher != nil {
+		l.higher.lower = l.lower
+	}
+}
+
+// insertAfter adds an empty list for freq after lower, or first when
+// lower is nil, and returns it.
+func (c *LFUCache) insertAfter(lower *lfuList, freq uint64) *lfuList {
+	l := &lfuList{freq: freq, lower: lower}
+	if lower != nil {
+		l.higher, lower.higher = lower.higher, l
+	} else {
+		l.higher, c.lowest = c.lowest, l
+	}
+	if l.higher != nil {
+		l.higher.lower = l
+	}
+	return l
+}
+
+func (e *lfuEntry) item() Item {
+	return Item{Key: e.key, Value: e.value, StoredAt: e.storedAt, ExpiresAt: e.expiresAt, Version: e.version}
+}
+
+func (l *lfuList) pushFront(e *lfuEntry) {
+	e.list, e.prev, e.next = l, nil, l.head
+	if l.head != nil {
+		l.head.prev = e
+	}
+	l.head = e
+	if l.tail == nil {
+		l.tail = e
+	}
+}
+
+func (l *lfuList) remove(e *lfuEntry) {
+	if e.prev != nil {
+		e.prev.next = e.next
+	} else {
+		l.head = e.next
+	}
+	if e.next != nil {
+		e.next.prev = e.prev
+	} else {
+		l.tail = e.prev
+	}
+	e.prev, e.next = nil, nil
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"context"
+	"testing"
+)
+
+// newTestLFU makes an LFU cache with opts that is closed when the test
+// ends.
+func newTestLFU(t *testing.T, opts ...Option) *LFUCache {
+	t.Helper()
+	c, err := NewLFU(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// entry reports whether key is cached in c, and its frequency.
+func (c *LFUCache) entry(key string) (uint64, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return 0, false
+	}
+	return e.freq, true
+}
+
+func TestLFUEvictsLeastRead(t *testing.T) {
+	c := newTestLFU(t, WithCapacity(3))
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "c"} {
+		c.Set(ctx, key, key, 0)
+	}
+	c.Get("a")
+	c.Get("a")
+	c.Get("c")
+	// b is least read, and then d, which is new.
+	c.Set(ctx, "d", "d", 0)
+	c.Set(ctx, "e", "e", 0)
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true, "d": false, "e": true} {
+		if _, ok := c.entry(key); ok != want {
+			t.Errorf("key %q cached = %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestLFUSetLeavesFrequencyAlone(t *testing.T) {
+	c := newTestLFU(t, WithCapacity(2))
+	ctx := context.Background()
+	c.Set(ctx, "a", "1", 0)
+	c.Set(ctx, "b", "1", 0)
+	c.Get("b")
+	// Rewriting a is no read of it, so it stays the least read.
+	c.Set(ctx, "a", "2", 0)
+	c.Set(ctx, "a", "3", 0)
+	c.Set(ctx, "c", "1", 0)
+	if _, ok := c.entry("a"); ok {
+		t.Error("rewritten key outranked a read one")
+	}
+	if freq, ok := c.entry("b"); !ok || freq != 2 {
+		t.Errorf("b cached = %v with frequency %d, want frequency 2", ok, freq)
+	}
+}
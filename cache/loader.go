@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrNotFound is returned by a Loader when the origin has no value for
+// the key.
+var ErrNotFound = errors.New("not found")
+
+// Loader fetches the value for a key that missed the cache. A ttl of zero
+// uses the cache's default expiration.
+type Loader func(ctx context.Context, key string) (value string, ttl time.Duration, err error)
+
+type loadCall struct {
+	done chan struct{}
+	item Item
+	err  error
+}
+
+type loadGroup struct {
+	mutex sync.Mutex
+	calls map[string]*loadCall
+}
+
+func (this *LRUCache) SetLoader(loader Loader) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.loader = loader
+}
+
+// GetOrLoad returns the cached entry for key, calling the loader on a miss.
+// Concurrent misses for the same key share one loader call. ctx bounds both
+// the loader call and the wait for someone else's; found is false when
+// there is no loader or the loader returns ErrNotFound.
+func (this *LRUCache) GetOrLoad(ctx context.Context, key string) (item Item, found bool, err error) {
+	ctx, span := tracer.Start(ctx, "cache.GetOrLoad")
+	defer func() { endSpan(span, err) }()
+	item, ok := this.GetItem(key)
+	span.SetAttributes(attribute.Bool("cache.hit", ok))
+	if ok {
+		return item, true, nil
+	}
+	return this.load(ctx, key)
+}
+
+// OpenOrLoad is GetOrLoad for a value to be copied out rather than held:
+// the item comes without its Value, which value yields instead. A cached
+// value held in chunks is read from them, so that no string of its full
+// length is made.
+func (this *LRUCache) OpenOrLoad(ctx context.Context, key string) (item Item, value io.Reader, found bool, err error) {
+	ctx, span := tracer.Start(ctx, "cache.OpenOrLoad")
+	defer func() { endSpan(span, err) }()
+	ok := this.get(key, func(e *entry) (ok bool) {
+		item = e.itemOf("")
+		value, ok = this.reader(e)
+		return ok
+	})
+	span.SetAttributes(attribute.Bool("cache.hit", ok))
+	if ok {
+		return item, value, true, nil
+	}
+	item, found, err = this.load(ctx, key)
+	value = strings.NewReader(item.Value)
+	item.Value = ""
+	return item, value, found, err
+}
+
+// load calls the loader for key, which has missed.
+func (this *LRUCache) load(ctx context.Context, key string) (Item, bool, error) {
+	this.mutex.Lock()
+	loader := this.loader
+	this.mutex.Unlock()
+	if loader == nil {
+		return Item{}, false, nil
+	}
+
+	g := &this.loads
+	g.mutex.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*loadCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mutex.Unlock()
+		select {
+		case <-c.done:
+			return loadResult(c)
+		case <-ctx.Done():
+			return Item{}, false, ctx.Err()
+		}
+	}
+	c := &loadCall{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mutex.Unlock()
+
+	loadCtx, span := tracer.Start(ctx, "cache.load")
+	start := time.Now()
+	value, ttl, err := loader(loadCtx, key)
+	this.since(opLoad, key, start)
+	if errors.Is(err, ErrNotFound) {
+		endSpan(span, nil)
+	} else {
+		endSpan(span, err)
+	}
+	if err == nil {
+		c.item = this.storeLoaded(ctx, key, value, ttl)
+	}
+	c.err = err
+
+	g.mutex.Lock()
+	delete(g.calls, key)
+	g.mutex.Unlock()
+	close(c.done)
+
+	return loadResult(c)
+}
+
+// storeLoaded is Set for a value from the loader, which is published
+// with reason "load" so that it is not taken for a write.
+func (this *LRUCache) storeLoaded(ctx context.Context, key, value string, ttl time.Duration) Item {
+	defer this.since(opSet, key, time.Now())
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.set(Write{Key: key, Value: value, TTL: ttl}, "load", originFrom(ctx))
+}
+
+func loadResult(c *loadCall) (Item, bool, error) {
+	if errors.Is(c.err, ErrNotFound) {
+		return Item{}, false, nil
+	}
+	if c.err != nil {
+		return Item{}, false, c.err
+	}
+	return c.item, true, nil
+}
@@ -0,0 +1,80 @@
+package cache
+
+import "unsafe"
+
+// mapSlotSize approximates what a map spends on each entry: the key's
+// string header, the pointer to the entry and a control byte, at the
+// load a Go map usually runs at.
+const mapSlotSize = 32
+
+var (
+	// entryOverhead and lfuEntryOverhead are what an entry costs beyond
+	// the bytes of its key and value.
+	entryOverhead    = int64(unsafe.Sizeof(entry{})) + mapSlotSize
+	lfuEntryOverhead = int64(unsafe.Sizeof(lfuEntry{})) + mapSlotSize
+)
+
+// Memory estimates the memory the cache's entries hold. It leaves out the
+// cache's fixed costs and what the allocator rounds sizes up by, so the
+// process uses somewhat more.
+type Memory struct {
+	// Bytes of the keys and of the values.
+	Keys   int64 `json:"keys" msgpack:"keys"`
+	Values int64 `json:"values" msgpack:"values"`
+	// The entries' bookkeeping: timestamps, list links and map slots.
+	Overhead int64 `json:"overhead" msgpack:"overhead"`
+	Total    int64 `json:"total" msgpack:"total"`
+	// Bytes of values that keys share rather than each hold, which
+	// Values leaves out; absent without deduplication.
+	Deduplicated int64 `json:"deduplicated,omitempty" msgpack:"deduplicated,omitempty"`
+}
+
+// memoryUsage counts the bytes of the keys and values held, as entries
+// are stored and removed.
+type memoryUsage struct {
+	keys, values int64
+	// deduplicated is the bytes of values that entries share rather
+	// than hold.
+	deduplicated int64
+}
+
+// add accounts for an entry's key and a value of valueLen bytes
+// arriving, or with a sign of -1, leaving.
+func (m *memoryUsage) add(key string, valueLen int, sign int64) {
+	m.keys += sign * int64(len(key))
+	m.values += sign * int64(valueLen)
+}
+
+// report is m for entries that each cost overhead more.
+func (m memoryUsage) report(entries int, overhead int64) Memory {
+	mem := Memory{Keys: m.keys, Values: m.values, Overhead: int64(entries) * overhead, Deduplicated: m.deduplicated}
+	mem.Total = mem.Keys + mem.Values + mem.Overhead
+	return mem
+}
+
+// add sums the estimates of several caches.
+func (m Memory) add(other Memory) Memory {
+	return Memory{
+		Keys:     m.Keys + other.Keys,
+		Values:   m.Values + other.Values,
+		Overhead: m.Overhead + other.Overhead,
+		Total:    m.Total + other.Total,
+
+		Deduplicated: m.Deduplicated + other.Deduplicated,
+	}
+}
+
+// CapacityFor is the number of entries of entryBytes of key and value
+// that fit in budget bytes of memory, by the estimate Memory makes; at
+// least one.
+func CapacityFor(budget int64, entryBytes int) int {
+	return int(max(1, budget/(entryOverhead+int64(entryBytes))))
+}
+
+// Memory estimates the memory the entries hold, without the work of
+// Stats.
+func (this *LRUCache) Memory() Memory {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.memory.report(len(this.cache), entryOverhead)
+}
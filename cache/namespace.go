@@ -0,0 +1,272 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// namespaceSep splits a key into namespace and name: "user:42" lives in
+// namespace "user". Keys without it belong to the unnamed namespace "".
+const namespaceSep = ":"
+
+// NamespaceOf returns the namespace key belongs to.
+func NamespaceOf(key string) string {
+	if i := strings.Index(key, namespaceSep); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+// Quota bounds a namespace and sets its defaults. Zero fields are
+// unlimited, or the cache's own.
+type Quota struct {
+	MaxEntries int
+	MaxBytes   int64
+	// TTL is the namespace's default, for writes that do not give one.
+	TTL time.Duration
+	// Eviction picks the entries to evict when the namespace is over
+	// its quota. Evictions to make room in the whole cache are always
+	// LRU.
+	Eviction EvictionPolicy
+	// Compression stores the namespace's values of more than
+	// CompressAbove bytes compressed. A change applies to later writes;
+	// entries already stored stay as they are.
+	Compression   Compression
+	CompressAbove int
+	// ReservedBytes is held for the namespace however full the cache
+	// gets: evictions from the whole cache, for its capacity, its
+	// watermarks or memory pressure, pass over the namespace's entries
+	// while it holds no more than this, unless every entry is in such a
+	// namespace. It keeps one tenant's writes from evicting everyone
+	// else's. Each such eviction walks past the reserved entries, so
+	// reservations suit a modest share of the cache.
+	ReservedBytes int64
+}
+
+// EvictionPolicy is how a namespace over its quota picks entries to evict.
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the least recently used entries.
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU evicts the least frequently read entries, and of those
+	// the least recently used. Each eviction walks the LRU list, so it
+	// suits namespaces with small quotas.
+	EvictLFU
+)
+
+func (p EvictionPolicy) String() string {
+	if p == EvictLFU {
+		return "lfu"
+	}
+	return "lru"
+}
+
+// ParseEvictionPolicy reads "lru" or "lfu".
+func ParseEvictionPolicy(s string) (EvictionPolicy, error) {
+	switch strings.ToLower(s) {
+	case "", "lru":
+		return EvictLRU, nil
+	case "lfu":
+		return EvictLFU, nil
+	}
+	return 0, fmt.Errorf("unknown eviction policy %q; want lru or lfu", s)
+}
+
+type namespaceCounters struct {
+	entries int
+	bytes   int64
+	hits    uint64
+	misses  uint64
+	writes  uint64
+	quota   Quota
+}
+
+// reserved reports whether ns is within its reservation.
+func (q Quota) reserved(ns *namespaceCounters) bool {
+	return q.ReservedBytes > 0 && ns.bytes <= q.ReservedBytes
+}
+
+func (q Quota) exceeded(ns *namespaceCounters) bool {
+	return (q.MaxEntries > 0 && ns.entries > q.MaxEntries) ||
+		(q.MaxBytes > 0 && ns.bytes > q.MaxBytes)
+}
+
+// SetQuota limits the entries and bytes held under namespace, and sets
+// its default TTL. Writes that push it over evict that namespace's
+// entries, by its eviction policy, never another namespace's.
+func (this *LRUCache) SetQuota(namespace string, quota Quota) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	ns := this.namespace(namespace)
+	ns.quota = quota
+	this.countReservations()
+	this.enforceQuota(ns, namespace, nil, eventOrigin{})
+}
+
+// countReservations counts the namespaces with reserved bytes, so that
+// victim knows whether to look for them. The caller holds the lock.
+func (this *LRUCache) countReservations() {
+	this.reservations = 0
+	for _, ns := range this.namespaces {
+		if ns.quota.ReservedBytes > 0 {
+			this.reservations++
+		}
+	}
+}
+
+// victim is the least recently used entry outside every namespace's
+// reservation, or the least recently used of all if there is none. The
+// caller holds the lock.
+func (this *LRUCache) victim() *entry {
+	tail := this.entries.at(this.tail)
+	if this.reservations == 0 {
+		return tail
+	}
+	for e := tail; e != nil; e = this.entries.at(e.prev) {
+		if ns := this.namespaces[NamespaceOf(e.key)]; ns == nil || !ns.quota.reserved(ns) {
+			return e
+		}
+	}
+	return tail
+}
+
+// namespace returns the counters for name, creating them on first use.
+// The caller holds the lock.
+func (this *LRUCache) namespace(name string) *namespaceCounters {
+	ns, ok := this.namespaces[name]
+	if !ok {
+		ns = &namespaceCounters{}
+		// A copy, so that the table does not keep the key the name is
+		// part of, or its chunk of the key arena, in memory.
+		this.namespaces[strings.Clone(name)] = ns
+	}
+	return ns
+}
+
+// releaseNamespace accounts for an entry of size bytes leaving name. The
+// counters of a namespace without a quota go with its last entry, so keys
+// with arbitrary prefixes cannot grow the table without bound.
+func (this *LRUCache) releaseNamespace(name string, size int64) {
+	ns := this.namespaces[name]
+	ns.entries--
+	ns.bytes -= size
+	if ns.entries == 0 && ns.quota == (Quota{}) {
+		delete(this.namespaces, name)
+	}
+}
+
+// enforceQuota evicts from the tail of the LRU list until ns is within its
+// quota, skipping keep so that a write never evicts itself.
+func (this *LRUCache) enforceQuota(ns *namespaceCounters, name string, keep *entry, origin eventOrigin) {
+	if ns.quota.Eviction == EvictLFU {
+		this.enforceQuotaLFU(ns, name, keep, origin)
+		return
+	}
+	for e := this.entries.at(this.tail); e != nil && ns.quota.exceeded(ns); {
+		prev := this.entries.at(e.prev)
+		if key := e.key; e != keep && NamespaceOf(key) == name {
+			// evict clears e's slot.
+			this.evict(key)
+			this.stats.Evictions++
+			this.publish(EventEvict, key, "quota", origin)
+		}
+		e = prev
+	}
+}
+
+// enforceQuotaLFU evicts the namespace's least read entries, oldest
+// first among equals, until ns is within its quota.
+func (this *LRUCache) enforceQuotaLFU(ns *namespaceCounters, name string, keep *entry, origin eventOrigin) {
+	for ns.quota.exceeded(ns) {
+		var victim *entry
+		for e := this.entries.at(this.tail); e != nil; e = this.entries.at(e.prev) {
+			if e != keep && NamespaceOf(e.key) == name && (victim == nil || e.hits < victim.hits) {
+				victim = e
+			}
+		}
+		if victim == nil {
+			return
+		}
+		key := victim.key
+		this.evict(key)
+		this.stats.Evictions++
+		this.publish(EventEvict, key, "quota", origin)
+	}
+}
+
+// TTLFor is how long key is kept when written without a TTL: its
+// namespace's default TTL, if it has one, or the cache's.
+func (this *LRUCache) TTLFor(key string) time.Duration {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.namespaceTTL(key)
+}
+
+// namespaceTTL is the default TTL for key: its namespace's, if set, or
+// the cache's. The caller holds the lock.
+func (this *LRUCache) namespaceTTL(key string) time.Duration {
+	if ns, ok := this.namespaces[NamespaceOf(key)]; ok && ns.quota.TTL > 0 {
+		return ns.quota.TTL
+	}
+	return this.DefaultTTL()
+}
+
+// NamespaceStats is the counters of a namespace.
+type NamespaceStats struct {
+	Name    string `json:"name" msgpack:"name"`
+	Entries int    `json:"entries" msgpack:"entries"`
+	// Total size of keys and values.
+	Bytes   int64   `json:"bytes" msgpack:"bytes"`
+	Hits    uint64  `json:"hits" msgpack:"hits"`
+	Misses  uint64  `json:"misses" msgpack:"misses"`
+	HitRate float64 `json:"hit_rate" msgpack:"hit_rate"`
+	Writes  uint64  `json:"writes" msgpack:"writes"`
+	// Entry quota; absent if unlimited.
+	MaxEntries int `json:"max_entries,omitempty" msgpack:"max_entries,omitempty"`
+	// Byte quota; absent if unlimited.
+	MaxBytes int64 `json:"max_bytes,omitempty" msgpack:"max_bytes,omitempty"`
+	// Default TTL in seconds; absent if the cache's.
+	TTL int `json:"ttl,omitempty" msgpack:"ttl,omitempty"`
+	// How entries are evicted when over quota, lru or lfu.
+	Eviction string `json:"eviction" msgpack:"eviction"`
+	// How values of more than CompressAbove bytes are compressed;
+	// absent if they are not.
+	Compression   string `json:"compression,omitempty" msgpack:"compression,omitempty"`
+	CompressAbove int    `json:"compress_above,omitempty" msgpack:"compress_above,omitempty"`
+	// Bytes kept from evictions for the whole cache; absent if none.
+	ReservedBytes int64 `json:"reserved_bytes,omitempty" msgpack:"reserved_bytes,omitempty"`
+}
+
+// namespaceStats lists the namespaces that hold entries or have a quota.
+// The caller holds the lock.
+func (this *LRUCache) namespaceStats() []NamespaceStats {
+	list := make([]NamespaceStats, 0, len(this.namespaces))
+	for name, ns := range this.namespaces {
+		s := NamespaceStats{
+			Name:       name,
+			Entries:    ns.entries,
+			Bytes:      ns.bytes,
+			Hits:       ns.hits,
+			Misses:     ns.misses,
+			Writes:     ns.writes,
+			MaxEntries: ns.quota.MaxEntries,
+			MaxBytes:   ns.quota.MaxBytes,
+			TTL:        int(ns.quota.TTL / time.Second),
+			Eviction:   ns.quota.Eviction.String(),
+		}
+		s.ReservedBytes = ns.quota.ReservedBytes
+		if ns.quota.Compression != CompressNone {
+			s.Compression, s.CompressAbove = ns.quota.Compression.String(), ns.quota.CompressAbove
+		}
+		if total := ns.hits + ns.misses; total > 0 {
+			s.HitRate = float64(ns.hits) / float64(total)
+		}
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNamespaceOf(t *testing.T) {
+	for key, want := range map[string]string{
+		"user:42":   "user",
+		"user:42:a": "user",
+		":x":        "",
+		"plain":     "",
+	} {
+		if got := NamespaceOf(key); got != want {
+			t.Errorf("NamespaceOf(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+// namespaceStats returns the stats of the namespace name.
+func namespaceStats(t *testing.T, c *LRUCache, name string) NamespaceStats {
+	t.Helper()
+	for _, ns := range c.Stats(0).Namespaces {
+		if ns.Name == name {
+			return ns
+		}
+	}
+	t.Fatalf("no stats for namespace %q", name)
+	return NamespaceStats{}
+}
+
+func TestQuotaEvictsOnlyItsNamespace(t *testing.T) {
+	c := newTestCache(t)
+	ctx := context.Background()
+	c.SetQuota("a", Quota{MaxEntries: 2})
+	c.Set(ctx, "a:1", "x", 0)
+	c.Set(ctx, "b:1", "x", 0)
+	c.Set(ctx, "a:2", "x", 0)
+	c.Set(ctx, "b:2", "x", 0)
+	c.Set(ctx, "a:3", "x", 0)
+	wantKeys(t, c, []string{"a:1", "a:2", "a:3", "b:1", "b:2"}, "a:2", "a:3", "b:1", "b:2")
+
+	ns := namespaceStats(t, c, "a")
+	if ns.Entries != 2 || ns.Writes != 3 || ns.MaxEntries != 2 {
+		t.Errorf("namespace a: %+v", ns)
+	}
+}
+
+func TestByteQuota(t *testing.T) {
+	c := newTestCache(t)
+	ctx := context.Background()
+	// Each entry takes its key and value: 3 + 7 bytes.
+	c.SetQuota("a", Quota{MaxBytes: 25})
+	c.Set(ctx, "a:1", "1234567", 0)
+	c.Set(ctx, "a:2", "1234567", 0)
+	c.Set(ctx, "a:3", "1234567", 0)
+	wantKeys(t, c, []string{"a:1", "a:2", "a:3"}, "a:2", "a:3")
+	if ns := namespaceStats(t, c, "a"); ns.Bytes != 20 {
+		t.Errorf("namespace a holds %d bytes, want 20", ns.Bytes)
+	}
+
+	// A larger value for an existing key counts its growth.
+	c.Set(ctx, "a:3", strings.Repeat("x", 15), 0)
+	wantKeys(t, c, []string{"a:2", "a:3"}, "a:3")
+}
+
+func TestLFUQuotaEvictsLeastRead(t *testing.T) {
+	c := newTestCache(t)
+	ctx := context.Background()
+	c.SetQuota("a", Quota{MaxEntries: 2, Eviction: EvictLFU})
+	c.Set(ctx, "a:1", "x", 0)
+	c.Set(ctx, "a:2", "x", 0)
+	c.Get("a:1")
+	c.Get("a:1")
+	c.Get("a:2")
+	c.Get("a:2")
+	c.Get("a:1")
+	c.Set(ctx, "a:3", "x", 0)
+	wantKeys(t, c, []string{"a:1", "a:2", "a:3"}, "a:1", "a:3")
+}
+
+func TestNamespaceTTL(t *testing.T) {
+	clock := newFakeClock()
+	c := newTestCache(t, WithClock(clock.Now), WithTTL(time.Hour))
+	ctx := context.Background()
+	c.SetQuota("s", Quota{TTL: time.Second})
+	c.Set(ctx, "s:1", "x", 0)
+	c.Set(ctx, "s:2", "x", time.Minute)
+	c.Set(ctx, "o:1", "x", 0)
+	if ttl := c.TTLFor("s:9"); ttl != time.Second {
+		t.Errorf("TTLFor(s:9) = %v, want the namespace's 1s", ttl)
+	}
+	clock.Advance(2 * time.Second)
+	wantKeys(t, c, []string{"s:1", "s:2", "o:1"}, "s:2", "o:1")
+}
+
+func TestReservationSurvivesCacheEvictions(t *testing.T) {
+	c := newTestCache(t, WithCapacity(4), WithQuota("r", Quota{ReservedBytes: 20}))
+	ctx := context.Background()
+	c.Set(ctx, "r:1", "x", 0)
+	c.Set(ctx, "r:2", "x", 0)
+	for _, key := range []string{"o:1", "o:2", "o:3", "o:4"} {
+		c.Set(ctx, key, "x", 0)
+	}
+	// The reserved entries are the oldest, but the others go first.
+	wantKeys(t, c, []string{"r:1", "r:2", "o:1", "o:2", "o:3", "o:4"}, "r:1", "r:2", "o:3", "o:4")
+}
+
+func TestReservationOverrunIsEvictable(t *testing.T) {
+	c := newTestCache(t, WithCapacity(3), WithQuota("r", Quota{ReservedBytes: 10}))
+	ctx := context.Background()
+	// 3 + 10 bytes: past the reservation, so not held.
+	c.Set(ctx, "r:1", strings.Repeat("x", 10), 0)
+	c.Set(ctx, "o:1", "x", 0)
+	c.Set(ctx, "o:2", "x", 0)
+	c.Set(ctx, "o:3", "x", 0)
+	wantKeys(t, c, []string{"r:1", "o:1", "o:2", "o:3"}, "o:1", "o:2", "o:3")
+}
+
+func TestEveryEntryReservedEvictsOldest(t *testing.T) {
+	c := newTestCache(t, WithCapacity(2), WithQuota("r", Quota{ReservedBytes: 100}))
+	ctx := context.Background()
+	c.Set(ctx, "r:1", "x", 0)
+	c.Set(ctx, "r:2", "x", 0)
+	c.Set(ctx, "r:3", "x", 0)
+	wantKeys(t, c, []string{"r:1", "r:2", "r:3"}, "r:2", "r:3")
+}
+
+func TestNamespaceCountersGoWithLastEntry(t *testing.T) {
+	c := newTestCache(t)
+	ctx := context.Background()
+	c.Set(ctx, "tmp:1", "x", 0)
+	c.Delete(ctx, "tmp:1")
+	for _, ns := range c.Stats(0).Namespaces {
+		if ns.Name == "tmp" {
+			t.Fatalf("namespace without entries or quota still listed: %+v", ns)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Option configures a cache made by NewCache, NewLFU or NewEngine.
+type Option func(*cacheOptions) error
+
+type cacheOptions struct {
+	capacity int
+	ttl      time.Duration
+	clock    func() time.Time
+	policy   EvictionPolicy
+	shards   int
+
+	sweepInterval time.Duration
+	loader        Loader
+	hooks         []hookOption
+	callbacks     Callbacks
+	logger        *slog.Logger
+
+	quotas                      map[string]Quota
+	highWatermark, lowWatermark float64
+	memoryLimit                 int64
+	memoryPressure              float64
+	chunkSize                   int
+	dedupAbove                  int
+}
+
+// single checks that o is for one cache with policy, as NewCache and
+// NewLFU make.
+func (o *cacheOptions) single(policy EvictionPolicy) error {
+	if o.policy != policy {
+		constructor := "NewCache"
+		if o.policy == EvictLFU {
+			constructor = "NewLFU"
+		}
+		return fmt.Errorf("%s policy needs NewEngine or %s", o.policy, constructor)
+	}
+	if o.shards > 1 {
+		return errors.New("shards need NewEngine")
+	}
+	return nil
+}
+
+// lruOnly names the options o sets that only NewCache takes.
+func (o *cacheOptions) lruOnly() []string {
+	var names []string
+	for _, opt := range []struct {
+		name string
+		set  bool
+	}{
+		{"WithLoader", o.loader != nil},
+		{"WithHooks", len(o.hooks) > 0},
+		{"WithLogger", o.logger != nil},
+		{"WithQuota", len(o.quotas) > 0},
+		{"WithWatermarks", o.highWatermark > 0},
+		{"WithMemoryPressure", o.memoryLimit > 0},
+		{"WithChunkSize", o.chunkSize > 0},
+		{"WithDedup", o.dedupAbove > 0},
+	} {
+		if opt.set {
+			names = append(names, opt.name)
+		}
+	}
+	return names
+}
+
+type hookOption struct {
+	hooks Hooks
+	opts  HookOptions
+}
+
+// WithCapacity sets how many entries the cache holds before it evicts the
+// least recently used.
+func WithCapacity(capacity int) Option {
+	return func(o *cacheOptions) error {
+		if capacity < 1 {
+			return errors.New("capacity must be at least 1")
+		}
+		o.capacity = capacity
+		return nil
+	}
+}
+
+// WithTTL sets the default TTL, for writes that do not give one.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *cacheOptions) error {
+		if ttl <= 0 {
+			return errors.New("default TTL must be positive")
+		}
+		o.ttl = ttl
+		return nil
+	}
+}
+
+// WithPolicy sets how the cache picks entries to evict when full. NewCache
+// makes LRU caches only and NewLFU LFU ones; NewEngine makes either.
+func WithPolicy(policy EvictionPolicy) Option {
+	return func(o *cacheOptions) error {
+		if policy != EvictLRU && policy != EvictLFU {
+			return fmt.Errorf("unknown eviction policy %d", policy)
+		}
+		o.policy = policy
+		return nil
+	}
+}
+
+// WithShards has NewEngine spread keys over n caches, each with its share
+// of the capacity, so that operations on different keys mostly take
+// different locks. NewCache and NewLFU make single caches, of one shard.
+func WithShards(n int) Option {
+	return func(o *cacheOptions) error {
+		if n < 1 {
+			return errors.New("shards must be at least 1")
+		}
+		o.shards = n
+		return nil
+	}
+}
+
+// WithSweepInterval sets how often expired entries are swept.
+func WithSweepInterval(interval time.Duration) Option {
+	return func(o *cacheOptions) error {
+		if interval <= 0 {
+			return errors.New("sweep interval must be positive")
+		}
+		o.sweepInterval = interval
+		return nil
+	}
+}
+
+// WithWatermarks has the sweep evict down to low once the cache is
+// fuller than high, as SetWatermarks does.
+func WithWatermarks(high, low float64) Option {
+	return func(o *cacheOptions) error {
+		if err := checkWatermarks(high, low); err != nil {
+			return err
+		}
+		o.highWatermark, o.lowWatermark = high, low
+		return nil
+	}
+}
+
+// WithMemoryPressure has the sweep evict once the heap is set to grow
+// past fraction of limit bytes, as SetMemoryPressure does.
+func WithMemoryPressure(limit int64, fraction float64) Option {
+	return func(o *cacheOptions) error {
+		if err := checkMemoryPressure(limit, fraction); err != nil {
+			return err
+		}
+		o.memoryLimit, o.memoryPressure = limit, fraction
+		return nil
+	}
+}
+
+// WithChunkSize has the cache hold values longer than size bytes, after
+// any compression, in chunks of size, so that a large value takes no
+// single allocation of its full length while it is stored. Reads return
+// it whole, but for OpenOrLoad, which reads it from the chunks.
+func WithChunkSize(size int) Option {
+	return func(o *cacheOptions) error {
+		if size < 0 {
+			return errors.New("chunk size must not be negative")
+		}
+		o.chunkSize = size
+		return nil
+	}
+}
+
+// WithDedup has the cache keep one copy of each value longer than above
+// bytes, as stored, however many keys hold it, for workloads where many
+// keys map to the same payload. Each such write hashes the value, and
+// shorter values would save less than the sharing costs.
+func WithDedup(above int) Option {
+	return func(o *cacheOptions) error {
+		if above < 0 {
+			return errors.New("dedup threshold must not be negative")
+		}
+		o.dedupAbove = above
+		return nil
+	}
+}
+
+// WithQuota sets the quota of namespace, as SetQuota does. It can be
+// given once for each namespace.
+func WithQuota(namespace string, quota Quota) Option {
+	return func(o *cacheOptions) error {
+		if quota.MaxEntries < 0 || quota.MaxBytes < 0 || quota.TTL < 0 || quota.CompressAbove < 0 || quota.ReservedBytes < 0 {
+			return fmt.Errorf("quota of namespace %q must not be negative", namespace)
+		}
+		if quota.MaxBytes > 0 && quota.ReservedBytes > quota.MaxBytes {
+			return fmt.Errorf("reservation of namespace %q exceeds its byte quota", namespace)
+		}
+		if o.quotas == nil {
+			o.quotas = make(map[string]Quota)
+		}
+		o.quotas[namespace] = quota
+		return nil
+	}
+}
+
+// WithClock sets the clock entries expire by, in place of time.Now, as
+// for tests that move time on by hand. The expiry sweep still runs every
+// sweep interval of real time.
+func WithClock(clock func() time.Time) Option {
+	return func(o *cacheOptions) error {
+		if clock == nil {
+			return errors.New("clock must not be nil")
+		}
+		o.clock = clock
+		return nil
+	}
+}
+
+// WithLoader sets the loader that fills misses, as SetLoader does.
+func WithLoader(loader Loader) Option {
+	return func(o *cacheOptions) error {
+		o.loader = loader
+		return nil
+	}
+}
+
+// Callbacks are told of the entries a cache drops by itself. Unlike
+// hooks, every engine takes them. They run with the cache lock held, so
+// must be quick and must not call back into the cache.
+type Callbacks struct {
+	OnEvict  func(key string)
+	OnExpire func(key string)
+}
+
+// WithCallbacks sets the callbacks, in place of any given before.
+func WithCallbacks(callbacks Callbacks) Option {
+	return func(o *cacheOptions) error {
+		o.callbacks = callbacks
+		return nil
+	}
+}
+
+// hooks are the synchronous hooks that call c.
+func (c Callbacks) hooks() Hooks {
+	var h Hooks
+	if c.OnEvict != nil {
+		h.OnEvict = func(e HookEvent) { c.OnEvict(e.Key) }
+	}
+	if c.OnExpire != nil {
+		h.OnExpire = func(e HookEvent) { c.OnExpire(e.Key) }
+	}
+	return h
+}
+
+// WithHooks registers hooks, as AddHooks does, before the cache is in
+// use. It can be given more than once; the hooks run in the order given.
+func WithHooks(hooks Hooks, opts HookOptions) Option {
+	return func(o *cacheOptions) error {
+		o.hooks = append(o.hooks, hookOption{hooks, opts})
+		return nil
+	}
+}
+
+// WithLogger sets where the cache logs its expiry sweeps, in place of
+// slog's default logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *cacheOptions) error {
+		if logger == nil {
+			return errors.New("logger must not be nil")
+		}
+		o.logger = logger
+		return nil
+	}
+}
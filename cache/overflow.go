@@ -0,0 +1,55 @@
+package cache
+
+// Overflow is a second tier for entries evicted for capacity, which are
+// demoted to it instead of being dropped; a miss in memory promotes them
+// back. Put, Remove and Clear are called with the cache lock held, and
+// must not wait on slow storage.
+type Overflow interface {
+	Put(item Item)
+	// Take removes key and returns its entry, if it has one that has not
+	// expired.
+	Take(key string) (Item, bool)
+	// Remove drops any copy of key.
+	Remove(key string)
+	Clear()
+}
+
+// lookup returns the in-memory entry for key, promoting it from the
+// overflow tier if it was demoted there. The caller holds the lock.
+func (this *LRUCache) lookup(key string) (*entry, bool) {
+	if e, ok := this.find(key); ok || this.overflow == nil {
+		return e, ok
+	}
+	item, ok := this.overflow.Take(key)
+	if !ok {
+		return nil, false
+	}
+	ttl := item.ExpiresAt.Sub(this.now())
+	if ttl <= 0 {
+		return nil, false
+	}
+	e := this.store(Write{Key: key, Value: item.Value, TTL: ttl, Flags: item.Flags}, eventOrigin{})
+	// The entry is unchanged, so it keeps its version and ETag.
+	e.storedAt, e.version = item.StoredAt, item.Version
+	return e, true
+}
+
+// demote copies an entry that is about to be evicted for capacity to the
+// overflow tier. The caller holds the lock.
+func (this *LRUCache) demote(e *entry) {
+	if this.overflow == nil || !this.now().Before(e.expiresAt) {
+		return
+	}
+	// A corrupt entry is evicted without a copy.
+	if item, err := e.item(); err == nil {
+		this.overflow.Put(item)
+	}
+}
+
+// SetOverflow attaches the overflow tier. It must be called before the
+// cache is in use.
+func (this *LRUCache) SetOverflow(store Overflow) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.overflow = store
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"errors"
+	"math"
+	"os"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+)
+
+// pressureMargin is how far below the threshold an eviction for memory
+// pressure aims, so that it is not needed again at once.
+const pressureMargin = 0.9
+
+// The cgroup files that hold the container's memory limit, under v2 and
+// v1.
+const (
+	cgroupV2MemoryMax = "/sys/fs/cgroup/memory.max"
+	cgroupV1MemoryMax = "/sys/fs/cgroup/memory/memory.limit_in_bytes"
+)
+
+// SetMemoryPressure has each sweep evict least recently used entries
+// once the process's heap is set to grow past fraction of limit bytes,
+// until their estimated memory covers the excess, so that a container
+// is not killed for running out of memory. What the heap is set to grow
+// to is the runtime's goal for the next collection. The sweep evicts
+// once per collection at most, since entries only give their memory
+// back when the collector runs. A fraction of zero turns this off.
+func (this *LRUCache) SetMemoryPressure(limit int64, fraction float64) error {
+	if err := checkMemoryPressure(limit, fraction); err != nil {
+		return err
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.memoryLimit, this.memoryPressure = limit, fraction
+	return nil
+}
+
+func checkMemoryPressure(limit int64, fraction float64) error {
+	if fraction != 0 && (limit <= 0 || fraction < 0 || fraction > 1) {
+		return errors.New("memory pressure needs a positive limit and a fraction in (0, 1]")
+	}
+	return nil
+}
+
+// DetectMemoryLimit returns the most memory the process may use: the
+// lowest of its cgroup's limit and GOMEMLIMIT, or zero if neither is
+// set.
+func DetectMemoryLimit() int64 {
+	var limit int64
+	lower := func(n int64) {
+		if n > 0 && (limit == 0 || n < limit) {
+			limit = n
+		}
+	}
+	for _, path := range []string{cgroupV2MemoryMax, cgroupV1MemoryMax} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// v2 says "max" when unlimited, v1 a number near 2^63.
+		n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err == nil && n < math.MaxInt64/2 {
+			lower(n)
+		}
+	}
+	if n := debug.SetMemoryLimit(-1); n < math.MaxInt64 {
+		lower(n)
+	}
+	return limit
+}
+
+// heapMetrics are the runtime metrics the sweep reads for memory
+// pressure.
+var heapMetrics = []metrics.Sample{
+	{Name: "/gc/heap/goal:bytes"},
+	{Name: "/gc/heap/live:bytes"},
+	{Name: "/gc/cycles/total:gc-cycles"},
+}
+
+// heapState is the heap as of the last collection.
+type heapState struct {
+	// goal is the size the heap may grow to before the next collection,
+	// and live what the last one found in use.
+	goal, live uint64
+	cycles     uint64
+}
+
+func readHeap() heapState {
+	samples := make([]metrics.Sample, len(heapMetrics))
+	copy(samples, heapMetrics)
+	metrics.Read(samples)
+	return heapState{goal: samples[0].Value.Uint64(), live: samples[1].Value.Uint64(), cycles: samples[2].Value.Uint64()}
+}
+
+// relieveMemoryPressure evicts for memory pressure and returns how many
+// entries it evicted. The caller holds the lock.
+func (this *LRUCache) relieveMemoryPressure(heap heapState) int {
+	threshold := this.memoryPressure * float64(this.memoryLimit)
+	if threshold == 0 || float64(heap.goal) <= threshold || heap.cycles == this.pressureCycle || heap.live == 0 {
+		return 0
+	}
+	this.pressureCycle = heap.cycles
+	// The goal grows by more than a byte for each live byte, as GOGC
+	// sets, so the live bytes to free are the excess scaled down.
+	excess := int64((float64(heap.goal) - pressureMargin*threshold) * float64(heap.live) / float64(heap.goal))
+	evicted := 0
+	for excess > 0 && this.tail != 0 {
+		excess -= this.evictLRU("memory", eventOrigin{})
+		evicted++
+	}
+	return evicted
+}
@@ -0,0 +1,78 @@
+package cache
+
+// removalLog counts entries leaving the cache by reason (capacity, quota,
+// ttl, explicit, flush) and keeps the most recent removals in a ring, to
+// answer "why did my key disappear". The cache lock protects it.
+type removalLog struct {
+	reasons map[string]uint64
+	recent  []Event
+	// next is where the next removal goes, wrapping once recent is full.
+	next int
+}
+
+func newRemovalLog(size int) *removalLog {
+	return &removalLog{reasons: make(map[string]uint64), recent: make([]Event, 0, size)}
+}
+
+func (l *removalLog) record(e Event) {
+	l.reasons[e.Reason]++
+	if cap(l.recent) == 0 {
+		return
+	}
+	if len(l.recent) < cap(l.recent) {
+		l.recent = append(l.recent, e)
+	} else {
+		l.recent[l.next] = e
+	}
+	l.next = (l.next + 1) % cap(l.recent)
+}
+
+// report returns the counts and up to limit recent removals of key, or of
+// any key if key is empty, newest first.
+func (l *removalLog) report(key string, limit int) *EvictionLog {
+	report := &EvictionLog{Reasons: make(map[string]uint64, len(l.reasons)), Recent: []Event{}}
+	for reason, n := range l.reasons {
+		report.Reasons[reason] = n
+	}
+	for i := range l.recent {
+		if len(report.Recent) == limit {
+			break
+		}
+		// Walk back from the newest, which is just before next.
+		j := l.next - 1 - i
+		if j < 0 {
+			j += len(l.recent)
+		}
+		if e := l.recent[j]; key == "" || e.Key == key {
+			report.Recent = append(report.Recent, e)
+		}
+	}
+	return report
+}
+
+// EvictionLog is why entries have left the cache.
+type EvictionLog struct {
+	// Entries removed since startup by reason: capacity, quota, ttl,
+	// explicit or flush.
+	Reasons map[string]uint64 `json:"reasons" msgpack:"reasons"`
+	// The latest removals, newest first.
+	Recent []Event `json:"recent" msgpack:"recent"`
+}
+
+// SetRemovalLog starts recording removals, keeping the last size of
+// them. It must be called before the cache is in use.
+func (this *LRUCache) SetRemovalLog(size int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.removals = newRemovalLog(size)
+}
+
+// Removals reports why entries have left the cache; see removalLog.report.
+func (this *LRUCache) Removals(key string, limit int) *EvictionLog {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.removals == nil {
+		return &EvictionLog{Reasons: map[string]uint64{}, Recent: []Event{}}
+	}
+	return this.removals.report(key, limit)
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"iter"
+	"strings"
+)
+
+const scanChunk = 256
+
+// Entries returns a copy of the cache contents, most recently used first.
+func (this *LRUCache) Entries() []Item {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	entries := make([]Item, 0, len(this.cache))
+	for e := this.entries.at(this.head); e != nil; {
+		// read may evict e, and with it the link to the next.
+		next := this.entries.at(e.next)
+		if item, ok := this.read(e); ok {
+			entries = append(entries, item)
+		}
+		e = next
+	}
+	return entries
+}
+
+// Scan yields the entries whose key starts with prefix. The key set is
+// fixed when iteration starts; values are read in chunks under the lock, so
+// writers are never blocked for the whole walk. Keys deleted or expired
+// since the start are skipped.
+func (this *LRUCache) Scan(prefix string) iter.Seq[Item] {
+	return func(yield func(Item) bool) {
+		this.mutex.Lock()
+		keys := make([]string, 0, len(this.cache))
+		for e := this.entries.at(this.head); e != nil; e = this.entries.at(e.next) {
+			if strings.HasPrefix(e.key, prefix) {
+				keys = append(keys, e.key)
+			}
+		}
+		this.mutex.Unlock()
+
+		chunk := make([]Item, 0, scanChunk)
+		for len(keys) > 0 {
+			n := min(scanChunk, len(keys))
+			chunk = chunk[:0]
+			now := this.now()
+			this.mutex.Lock()
+			for _, key := range keys[:n] {
+				if e, ok := this.find(key); ok && !now.After(e.expiresAt) {
+					if item, ok := this.read(e); ok {
+						chunk = append(chunk, item)
+					}
+				}
+			}
+			this.mutex.Unlock()
+			keys = keys[n:]
+
+			for _, item := range chunk {
+				if !yield(item) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"sort"
+	"time"
+)
+
+// Sharded spreads keys over several caches by hash, so that operations on
+// different keys mostly take different locks. The shards can be any
+// Cache, and need not be of one kind.
+type Sharded struct {
+	shards []Cache
+	seed   maphash.Seed
+}
+
+// NewSharded makes a cache of shards, which it owns from then on: Close
+// closes them.
+func NewSharded(shards ...Cache) (*Sharded, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("a sharded cache needs at least one shard")
+	}
+	return &Sharded{shards: shards, seed: maphash.MakeSeed()}, nil
+}
+
+func (s *Sharded) shard(key string) Cache {
+	return s.shards[maphash.String(s.seed, key)%uint64(len(s.shards))]
+}
+
+func (s *Sharded) Get(key string) (string, bool) {
+	return s.shard(key).Get(key)
+}
+
+func (s *Sharded) GetItem(key string) (Item, bool) {
+	return s.shard(key).GetItem(key)
+}
+
+func (s *Sharded) Set(ctx context.Context, key, value string, ttl time.Duration) Item {
+	return s.shard(key).Set(ctx, key, value, ttl)
+}
+
+func (s *Sharded) Delete(ctx context.Context, key string) bool {
+	return s.shard(key).Delete(ctx, key)
+}
+
+// DefaultTTL is the first shard's; shards made by NewEngine share one.
+func (s *Sharded) DefaultTTL() time.Duration {
+	return s.shards[0].DefaultTTL()
+}
+
+func (s *Sharded) Len() int {
+	n := 0
+	for _, shard := range s.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+// Stats adds up the shards' counters, capacities, namespaces and
+// memory, and picks the n most read keys among theirs. A namespace's
+// limits are the sum of the shards' shares of its quota. Latencies are
+// per shard and left out.
+func (s *Sharded) Stats(n int) *Stats {
+	stats := &Stats{HotKeys: []HotKey{}}
+	namespaces := make(map[string]*NamespaceStats)
+	for _, shard := range s.shards {
+		st := shard.Stats(n)
+		stats.Entries += st.Entries
+		stats.Capacity += st.Capacity
+		stats.Hits += st.Hits
+		stats.Misses += st.Misses
+		stats.Evictions += st.Evictions
+		stats.Expirations += st.Expirations
+		stats.HotKeys = append(stats.HotKeys, st.HotKeys...)
+		stats.Memory = stats.Memory.add(st.Memory)
+		for _, ns := range st.Namespaces {
+			total, ok := namespaces[ns.Name]
+			if !ok {
+				total = &NamespaceStats{Name: ns.Name}
+				namespaces[ns.Name] = total
+			}
+			total.Entries += ns.Entries
+			total.Bytes += ns.Bytes
+			total.Hits += ns.Hits
+			total.Misses += ns.Misses
+			total.Writes += ns.Writes
+			total.MaxEntries += ns.MaxEntries
+			total.MaxBytes += ns.MaxBytes
+		}
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	sort.Slice(stats.HotKeys, func(i, j int) bool { return stats.HotKeys[i].Hits > stats.HotKeys[j].Hits })
+	if len(stats.HotKeys) > n {
+		stats.HotKeys = stats.HotKeys[:n]
+	}
+	for _, ns := range namespaces {
+		if total := ns.Hits + ns.Misses; total > 0 {
+			ns.HitRate = float64(ns.Hits) / float64(total)
+		}
+		stats.Namespaces = append(stats.Namespaces, *ns)
+	}
+	sort.Slice(stats.Namespaces, func(i, j int) bool { return stats.Namespaces[i].Name < stats.Namespaces[j].Name })
+	return stats
+}
+
+func (s *Sharded) Close() {
+	for _, shard := range s.shards {
+		shard.Close()
+	}
+}
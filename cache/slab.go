@@ -0,0 +1,62 @@
+package cache
+
+// entryID names an entry by its place in the slabs; zero is none.
+type entryID uint32
+
+// slabSize is the number of entries in a slab.
+const slabSize = 1024
+
+// entrySlabs holds the entries in slabs of slabSize, in place of an
+// allocation each, and names them by ID. The key map and the LRU list
+// hold IDs rather than pointers, so that the collector marks a few large
+// arrays instead of following millions of small objects linked to each
+// other. A removed entry's slot is cleared, so that its key and value can
+// be collected, and reused by the next entry stored. Slabs are only
+// given back by a flush.
+//
+// Pointers to entries stay valid, since slabs never move, but only until
+// the entry is removed; they must not be kept once the lock is released.
+type entrySlabs struct {
+	slabs [][]entry
+	free  []entryID
+	// used is how many IDs have ever been handed out.
+	used entryID
+}
+
+// at returns the entry id names, or nil for zero.
+func (s *entrySlabs) at(id entryID) *entry {
+	if id == 0 {
+		return nil
+	}
+	i := int(id - 1)
+	return &s.slabs[i/slabSize][i%slabSize]
+}
+
+// alloc returns a cleared slot for a new entry, with its ID set.
+func (s *entrySlabs) alloc() *entry {
+	var id entryID
+	if n := len(s.free); n > 0 {
+		id, s.free = s.free[n-1], s.free[:n-1]
+	} else {
+		if int(s.used) == len(s.slabs)*slabSize {
+			s.slabs = append(s.slabs, make([]entry, slabSize))
+		}
+		s.used++
+		id = s.used
+	}
+	e := s.at(id)
+	e.id = id
+	return e
+}
+
+// release clears e's slot for reuse.
+func (s *entrySlabs) release(e *entry) {
+	id := e.id
+	*e = entry{}
+	s.free = append(s.free, id)
+}
+
+// reset drops every slab.
+func (s *entrySlabs) reset() {
+	*s = entrySlabs{}
+}
@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// slowOpLog keeps the most recent operations that took at least
+// threshold in a ring, with where they were called from, and logs each
+// one. It is recorded after the operation has released the cache lock,
+// so it has a lock of its own.
+type slowOpLog struct {
+	threshold time.Duration
+	// logger logs slow operations, and sweepLogger slow sweeps.
+	logger      *slog.Logger
+	sweepLogger *slog.Logger
+	mutex       sync.Mutex
+	total       uint64
+	recent      []SlowOperation
+	// next is where the next operation goes, wrapping once recent is full.
+	next int
+}
+
+// slowOpFrames is how much of the stack each slow operation keeps.
+const slowOpFrames = 16
+
+func newSlowOpLog(threshold time.Duration, recent int, logger, sweepLogger *slog.Logger) *slowOpLog {
+	return &slowOpLog{threshold: threshold, logger: logger, sweepLogger: sweepLogger, recent: make([]SlowOperation, 0, recent)}
+}
+
+// record adds an operation that took d. skip is the number of frames
+// between runtime.Callers and the operation, which comes first in the
+// stack.
+func (l *slowOpLog) record(op, key string, d time.Duration, skip int) {
+	var pcs [slowOpFrames]uintptr
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(skip, pcs[:])])
+	stack := make([]string, 0, slowOpFrames)
+	for {
+		f, more := frames.Next()
+		if f.Function != "runtime.goexit" {
+			stack = append(stack, fmt.Sprintf("%s %s:%d", f.Function, f.File, f.Line))
+		}
+		if !more {
+			break
+		}
+	}
+	logger := l.logger
+	if op == opSweep {
+		logger = l.sweepLogger
+	}
+	args := []any{"op", op, "took", d}
+	if key != "" {
+		args = append(args, "key", key)
+	}
+	if len(stack) > 1 {
+		args = append(args, "caller", stack[1])
+	}
+	logger.Warn("Slow operation", args...)
+
+	e := SlowOperation{Time: time.Now(), Op: op, Key: key, DurationMs: float64(d) / float64(time.Millisecond), Stack: stack}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.total++
+	if cap(l.recent) == 0 {
+		return
+	}
+	if len(l.recent) < cap(l.recent) {
+		l.recent = append(l.recent, e)
+	} else {
+		l.recent[l.next] = e
+	}
+	l.next = (l.next + 1) % cap(l.recent)
+}
+
+// report returns the total and up to limit recent operations, newest
+// first.
+func (l *slowOpLog) report(limit int) *SlowOperationLog {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	report := &SlowOperationLog{
+		ThresholdMs: float64(l.threshold) / float64(time.Millisecond),
+		Total:       l.total,
+		Recent:      make([]SlowOperation, 0, min(limit, len(l.recent))),
+	}
+	for i := range min(limit, len(l.recent)) {
+		j := l.next - 1 - i
+		if j < 0 {
+			j += len(l.recent)
+		}
+		report.Recent = append(report.Recent, l.recent[j])
+	}
+	return report
+}
+
+// SlowOperationLog is the operations that took longest.
+type SlowOperationLog struct {
+	// Operations taking at least this long are logged.
+	ThresholdMs float64 `json:"threshold_ms" msgpack:"threshold_ms"`
+	// Slow operations since startup.
+	Total uint64 `json:"total" msgpack:"total"`
+	// The latest slow operations, newest first.
+	Recent []SlowOperation `json:"recent" msgpack:"recent"`
+}
+
+type SlowOperation struct {
+	// When the operation finished.
+	Time time.Time `json:"time" msgpack:"time"`
+	Op   string    `json:"op" msgpack:"op"`
+	// The key operated on; sweeps have none.
+	Key        string  `json:"key,omitempty" msgpack:"key,omitempty"`
+	DurationMs float64 `json:"duration_ms" msgpack:"duration_ms"`
+	// Where the operation was called from, innermost first, as
+	// "function file:line".
+	Stack []string `json:"stack" msgpack:"stack"`
+}
+
+// SetSlowOpLog starts logging, to logger, operations that take at least
+// threshold, and keeping the last recent of them. Slow sweeps go to the
+// cache's own logger. It must be called before the cache is in use.
+func (this *LRUCache) SetSlowOpLog(threshold time.Duration, recent int, logger *slog.Logger) {
+	this.slowOps = newSlowOpLog(threshold, recent, logger, this.log)
+}
+
+// SlowOps reports the slowest recent operations, or nil if the slow
+// operation log is off.
+func (this *LRUCache) SlowOps(limit int) *SlowOperationLog {
+	if this.slowOps == nil {
+		return nil
+	}
+	return this.slowOps.report(limit)
+}
+
+// since records the time from start to now of op on key.
+func (this *LRUCache) since(op, key string, start time.Time) {
+	this.observe(op, key, time.Since(start), 4)
+}
+
+// TimingObserver is told how long each operation took, as the latency
+// histograms are.
+type TimingObserver func(op string, d time.Duration)
+
+// SetTimingObserver sends the operation timings to fn as well as to the
+// latency histograms; nil stops them.
+func (this *LRUCache) SetTimingObserver(fn TimingObserver) {
+	if fn == nil {
+		this.timing.Store(nil)
+		return
+	}
+	this.timing.Store(&fn)
+}
+
+// observe records that op on key took d, in the latency histograms, any
+// timing observer and, if it was slow, the slow operation log. skip is as
+// for slowOpLog.record.
+func (this *LRUCache) observe(op, key string, d time.Duration, skip int) {
+	this.latency[op].observe(d)
+	if fn := this.timing.Load(); fn != nil {
+		(*fn)(op, d)
+	}
+	if l := this.slowOps; l != nil && d >= l.threshold {
+		l.record(op, key, d, skip)
+	}
+}
@@ -0,0 +1,88 @@
+package cache
+
+import "sort"
+
+// Counters count reads and removals since the cache was made.
+type Counters struct {
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64
+	Expirations uint64
+}
+
+// Stats is the cache's counters and most-read keys.
+type Stats struct {
+	Entries  int     `json:"entries" msgpack:"entries"`
+	Capacity int     `json:"capacity" msgpack:"capacity"`
+	Hits     uint64  `json:"hits" msgpack:"hits"`
+	Misses   uint64  `json:"misses" msgpack:"misses"`
+	HitRate  float64 `json:"hit_rate" msgpack:"hit_rate"`
+	// Entries dropped to make room for new ones.
+	Evictions   uint64 `json:"evictions" msgpack:"evictions"`
+	Expirations uint64 `json:"expirations" msgpack:"expirations"`
+	// The most frequently read keys currently cached.
+	HotKeys []HotKey `json:"hot_keys" msgpack:"hot_keys"`
+	// Per-namespace counters. A key's namespace is the part before
+	// its first ":"; keys without one are in the namespace "". A
+	// namespace is listed while it holds entries or has a quota.
+	Namespaces []NamespaceStats `json:"namespaces,omitempty" msgpack:"namespaces,omitempty"`
+	// Latency of get, set, delete, loader calls and expiration
+	// sweeps since startup. Cache operations include waiting for the
+	// lock.
+	Latency []OperationLatency `json:"latency,omitempty" msgpack:"latency,omitempty"`
+	// Estimated memory held by the entries.
+	Memory Memory `json:"memory" msgpack:"memory"`
+	// The settings in effect, which PATCH /v1/admin/cache changes.
+	Settings Settings `json:"settings" msgpack:"settings"`
+}
+
+type HotKey struct {
+	Key  string `json:"key" msgpack:"key"`
+	Hits uint64 `json:"hits" msgpack:"hits"`
+}
+
+// Stats reports the cache counters along with the n most frequently read
+// keys still in the cache.
+func (this *LRUCache) Stats(n int) *Stats {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	stats := &Stats{
+		Entries:     len(this.cache),
+		Capacity:    this.capacity,
+		Hits:        this.stats.Hits,
+		Misses:      this.stats.Misses,
+		Evictions:   this.stats.Evictions,
+		Expirations: this.stats.Expirations,
+		HotKeys:     []HotKey{},
+		Namespaces:  this.namespaceStats(),
+		Latency:     this.latency.summary(),
+		Memory:      this.memory.report(len(this.cache), entryOverhead),
+		Settings:    this.settings(),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+
+	for e := this.entries.at(this.head); e != nil; e = this.entries.at(e.next) {
+		if e.hits > 0 {
+			stats.HotKeys = append(stats.HotKeys, HotKey{Key: e.key, Hits: e.hits})
+		}
+	}
+	sort.Slice(stats.HotKeys, func(i, j int) bool { return stats.HotKeys[i].Hits > stats.HotKeys[j].Hits })
+	if len(stats.HotKeys) > n {
+		stats.HotKeys = stats.HotKeys[:n]
+	}
+	return stats
+}
+
+// Counters returns the cache counters, the number of entries and the
+// bytes they hold as entry.size estimates them, without the work of Stats.
+func (this *LRUCache) Counters() (counters Counters, entries int, bytes int64) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for _, ns := range this.namespaces {
+		bytes += ns.bytes
+	}
+	return this.stats, len(this.cache), bytes
+}
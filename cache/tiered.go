@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+// Tiered puts a small, fast cache in front of a larger one. Reads try l1
+// and then l2, copying what l2 has into l1 for the rest of its TTL;
+// writes go to l2 and then l1, and deletes to both. l2 holds everything,
+// so its entries are the tier's: l1 keeps each value with l2's version,
+// times and flags, which a read from either level reports, and takes a
+// copy only if it is newer than the one it holds, so that a read's copy
+// cannot overwrite a later write.
+type Tiered struct {
+	l1     ConditionalCache
+	l2     Cache
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// NewTiered makes a cache of two levels, which it owns from then on:
+// Close closes both.
+func NewTiered(l1 ConditionalCache, l2 Cache) *Tiered {
+	return &Tiered{l1: l1, l2: l2}
+}
+
+func (t *Tiered) Get(key string) (string, bool) {
+	item, ok := t.GetItem(key)
+	return item.Value, ok
+}
+
+func (t *Tiered) GetItem(key string) (Item, bool) {
+	if copied, ok := t.l1.GetItem(key); ok {
+		if item, ok := decodeTierItem(copied); ok {
+			t.hits.Add(1)
+			return item, true
+		}
+	}
+	item, ok := t.l2.GetItem(key)
+	if !ok {
+		t.misses.Add(1)
+		return Item{}, false
+	}
+	t.hits.Add(1)
+	t.copyToL1(context.Background(), item)
+	return item, true
+}
+
+// Set returns l2's item, whose version is the one that counts.
+func (t *Tiered) Set(ctx context.Context, key, value string, ttl time.Duration) Item {
+	item := t.l2.Set(ctx, key, value, ttl)
+	t.copyToL1(ctx, item)
+	return item
+}
+
+// copyToL1 stores item, as l2 holds it, in l1 for the rest of its TTL,
+// unless l1 holds the same or a later version.
+func (t *Tiered) copyToL1(ctx context.Context, item Item) {
+	ttl := time.Until(item.ExpiresAt)
+	if ttl <= 0 {
+		return
+	}
+	t.l1.SetIf(ctx, item.Key, encodeTierItem(item), ttl, func(current Item, ok bool) bool {
+		held, decoded := decodeTierItem(current)
+		return !ok || !decoded || held.Version < item.Version
+	})
+}
+
+func (t *Tiered) Delete(ctx context.Context, key string) bool {
+	inL1 := t.l1.Delete(ctx, key)
+	return t.l2.Delete(ctx, key) || inL1
+}
+
+// DefaultTTL is l2's, which writes without a TTL get.
+func (t *Tiered) DefaultTTL() time.Duration {
+	return t.l2.DefaultTTL()
+}
+
+func (t *Tiered) Len() int {
+	return t.l2.Len()
+}
+
+// Stats is l2's, with the tier's own hits and misses, counting a read
+// once whichever level answers it, and l1's hot keys, since l1 serves
+// the hottest reads. Memory is both levels'.
+func (t *Tiered) Stats(n int) *Stats {
+	stats := t.l2.Stats(n)
+	stats.Hits, stats.Misses = t.hits.Load(), t.misses.Load()
+	stats.HitRate = 0
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	l1 := t.l1.Stats(n)
+	stats.HotKeys = l1.HotKeys
+	stats.Memory = stats.Memory.add(l1.Memory)
+	return stats
+}
+
+func (t *Tiered) Close() {
+	t.l1.Close()
+	t.l2.Close()
+}
+
+// tierHeaderSize is the length of the header encodeTierItem puts before
+// the value: the version, the two times in Unix nanoseconds and the
+// flags.
+const tierHeaderSize = 8 + 8 + 8 + 4
+
+// encodeTierItem returns item's value with its header, as l1 holds it.
+func encodeTierItem(item Item) string {
+	b := make([]byte, tierHeaderSize, tierHeaderSize+len(item.Value))
+	binary.BigEndian.PutUint64(b, item.Version)
+	binary.BigEndian.PutUint64(b[8:], uint64(item.StoredAt.UnixNano()))
+	binary.BigEndian.PutUint64(b[16:], uint64(item.ExpiresAt.UnixNano()))
+	binary.BigEndian.PutUint32(b[24:], item.Flags)
+	return string(append(b, item.Value...))
+}
+
+// decodeTierItem returns the item l1 holds in copied, or false if its
+// value has no header.
+func decodeTierItem(copied Item) (Item, bool) {
+	if len(copied.Value) < tierHeaderSize {
+		return Item{}, false
+	}
+	b := []byte(copied.Value[:tierHeaderSize])
+	return Item{
+		Key:       copied.Key,
+		Value:     copied.Value[tierHeaderSize:],
+		Version:   binary.BigEndian.Uint64(b),
+		StoredAt:  time.Unix(0, int64(binary.BigEndian.Uint64(b[8:]))),
+		ExpiresAt: time.Unix(0, int64(binary.BigEndian.Uint64(b[16:]))),
+		Flags:     binary.BigEndian.Uint32(b[24:]),
+	}, true
+}
@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTieredReportsL2Entries(t *testing.T) {
+	tiered := NewTiered(newTestLFU(t), newTestCache(t))
+	ctx := context.Background()
+	set := tiered.Set(ctx, "k", "v", 0)
+	// l1 answers, but with l2's entry.
+	got, ok := tiered.GetItem("k")
+	if !ok || got.Value != "v" || got.Version != set.Version || !got.StoredAt.Equal(set.StoredAt) || !got.ExpiresAt.Equal(set.ExpiresAt) {
+		t.Fatalf("GetItem = %+v, %v; want %+v", got, ok, set)
+	}
+	if tiered.l1.Len() != 1 {
+		t.Error("Set left l1 without the entry")
+	}
+}
+
+func TestTieredKeepsLaterWriteInL1(t *testing.T) {
+	tiered := NewTiered(newTestLFU(t), newTestCache(t))
+	ctx := context.Background()
+	old := tiered.Set(ctx, "k", "old", 0)
+	tiered.Set(ctx, "k", "new", 0)
+	// A read's copy of the old entry lands after the write.
+	tiered.copyToL1(ctx, old)
+	if v, _ := tiered.Get("k"); v != "new" {
+		t.Errorf("Get = %q after a stale copy, want the later write", v)
+	}
+}
+
+func TestTieredCopiesL2ReadsToL1(t *testing.T) {
+	l2 := newTestCache(t)
+	tiered := NewTiered(newTestLFU(t), l2)
+	item := l2.Set(context.Background(), "k", "v", 0)
+	for range 2 {
+		if got, ok := tiered.GetItem("k"); !ok || got.Version != item.Version {
+			t.Fatalf("GetItem = %+v, %v; want version %d", got, ok, item.Version)
+		}
+	}
+	if l2.Stats(0).Hits != 1 {
+		t.Error("second read went to l2, not the copy in l1")
+	}
+}
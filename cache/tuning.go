@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// Settings is what can be tuned while the cache is in use, as Stats
+// reports it.
+type Settings struct {
+	// Default TTL in seconds.
+	DefaultTTL int `json:"default_ttl" msgpack:"default_ttl"`
+	// How often expired entries are swept, in milliseconds.
+	SweepIntervalMs int64 `json:"sweep_interval_ms" msgpack:"sweep_interval_ms"`
+	// Fractions of the capacity: once a sweep finds the cache fuller than
+	// the high watermark, it evicts least recently used entries down to
+	// the low one. Zero when off.
+	HighWatermark float64 `json:"high_watermark" msgpack:"high_watermark"`
+	LowWatermark  float64 `json:"low_watermark" msgpack:"low_watermark"`
+	// Bytes the process may use, and the fraction of them past which
+	// the sweep evicts for memory pressure. Zero when off.
+	MemoryLimit    int64   `json:"memory_limit" msgpack:"memory_limit"`
+	MemoryPressure float64 `json:"memory_pressure" msgpack:"memory_pressure"`
+}
+
+// Settings reports the current settings.
+func (this *LRUCache) Settings() Settings {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.settings()
+}
+
+// settings is Settings for a caller that holds the lock.
+func (this *LRUCache) settings() Settings {
+	return Settings{
+		DefaultTTL:      int(this.DefaultTTL() / time.Second),
+		SweepIntervalMs: this.SweepInterval().Milliseconds(),
+		HighWatermark:   this.highWatermark,
+		LowWatermark:    this.lowWatermark,
+		MemoryLimit:     this.memoryLimit,
+		MemoryPressure:  this.memoryPressure,
+	}
+}
+
+// SweepInterval is how often expired entries are swept.
+func (this *LRUCache) SweepInterval() time.Duration {
+	return time.Duration(this.sweepInterval.Load())
+}
+
+// SetSweepInterval changes how often expired entries are swept. The next
+// sweep is an interval from now.
+func (this *LRUCache) SetSweepInterval(interval time.Duration) {
+	this.sweepInterval.Store(int64(interval))
+	select {
+	case this.sweepChanged <- struct{}{}:
+	default:
+	}
+}
+
+// SetWatermarks has each sweep evict least recently used entries, once
+// the cache holds more than high times its capacity, until it holds low
+// times it, so that writes seldom have to evict. A high of zero turns
+// this off.
+func (this *LRUCache) SetWatermarks(high, low float64) error {
+	if err := checkWatermarks(high, low); err != nil {
+		return err
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.highWatermark, this.lowWatermark = high, low
+	return nil
+}
+
+func checkWatermarks(high, low float64) error {
+	if high != 0 && (high > 1 || low <= 0 || low > high) {
+		return errors.New("watermarks must satisfy 0 < low <= high <= 1")
+	}
+	return nil
+}
+
+// enforceWatermarks evicts down to the low watermark if the cache is
+// above the high one, and returns how many entries it evicted. The caller
+// holds the lock.
+func (this *LRUCache) enforceWatermarks() int {
+	if this.highWatermark == 0 || float64(len(this.cache)) <= this.highWatermark*float64(this.capacity) {
+		return 0
+	}
+	target := int(this.lowWatermark * float64(this.capacity))
+	evicted := 0
+	for len(this.cache) > target && this.tail != 0 {
+		this.evictLRU("watermark", eventOrigin{})
+		evicted++
+	}
+	return evicted
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+type Stats struct {
+	Entries     int              `json:"entries"`
+	Capacity    int              `json:"capacity"`
+	Hits        uint64           `json:"hits"`
+	Misses      uint64           `json:"misses"`
+	HitRate     float64          `json:"hit_rate"`
+	Evictions   uint64           `json:"evictions"`
+	Expirations uint64           `json:"expirations"`
+	HotKeys     []HotKey         `json:"hot_keys"`
+	Namespaces  []NamespaceStats `json:"namespaces,omitempty"`
+	Memory      Memory           `json:"memory"`
+}
+
+type Memory struct {
+	Keys     int64 `json:"keys"`
+	Values   int64 `json:"values"`
+	Overhead int64 `json:"overhead"`
+	Total    int64 `json:"total"`
+	// Bytes of values that keys share rather than each hold.
+	Deduplicated int64 `json:"deduplicated,omitempty"`
+}
+
+type HotKey struct {
+	Key  string `json:"key"`
+	Hits uint64 `json:"hits"`
+}
+
+type NamespaceStats struct {
+	Name       string  `json:"name"`
+	Entries    int     `json:"entries"`
+	Bytes      int64   `json:"bytes"`
+	Hits       uint64  `json:"hits"`
+	Misses     uint64  `json:"misses"`
+	HitRate    float64 `json:"hit_rate"`
+	Writes     uint64  `json:"writes"`
+	MaxEntries int     `json:"max_entries,omitempty"`
+	MaxBytes   int64   `json:"max_bytes,omitempty"`
+}
+
+type ImportReport struct {
+	Imported        int           `json:"imported"`
+	Failed          int           `json:"failed"`
+	Errors          []ImportError `json:"errors"`
+	ErrorsTruncated bool          `json:"errors_truncated,omitempty"`
+	Aborted         string        `json:"aborted,omitempty"`
+}
+
+type ImportError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+func (c *CacheClient) Stats(ctx context.Context) (*Stats, error) {
+	var stats Stats
+	if err := c.do(ctx, http.MethodGet, "/v1/stats", nil, nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Export streams the entries whose keys start with prefix, as "ndjson" or
+// "csv" (empty means ndjson). It needs the admin permission and is not
+// retried; the caller closes the returned body.
+func (c *CacheClient) Export(ctx context.Context, prefix, format string) (io.ReadCloser, error) {
+	query := url.Values{}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if format != "" {
+		query.Set("format", format)
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, "/v1/export", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Del("Accept")
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, adminError(decodeResponse(resp, nil))
+	}
+	return resp.Body, nil
+}
+
+// Import loads rows from r in the given format ("ndjson" or "csv"). It
+// needs the admin permission and, since r is read once, is not retried.
+func (c *CacheClient) Import(ctx context.Context, r io.Reader, format string) (*ImportReport, error) {
+	query := url.Values{}
+	if format != "" {
+		query.Set("format", format)
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, "/v1/import", query, r)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	var report ImportReport
+	if err := decodeResponse(resp, &report); err != nil {
+		return nil, adminError(err)
+	}
+	return &report, nil
+}
+
+// adminError explains a 404 from an admin route: a server with a separate
+// admin listener does not serve them on its public address.
+func adminError(err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return &Error{StatusCode: http.StatusNotFound, Message: "not found; is this the server's admin address?"}
+	}
+	return err
+}
@@ -0,0 +1,244 @@
+package client
+
+// The binary protocol, served on the server's BinaryAddr, for clients that
+// cannot afford HTTP. Every frame is a big-endian uint32 length followed
+// by that many bytes:
+//
+//	request:  op u8 | opaque u32 | body
+//	response: status u8 | opaque u32 | body
+//
+// The opaque value is echoed back unchanged. Request bodies:
+//
+//	Noop    (empty)
+//	Get     key
+//	Set     ttl-seconds u32 | key-length u16 | key | value
+//	Delete  key
+//	Auth    API key or JWT
+//
+// A ttl of 0 means the server default. Response bodies on StatusOK:
+// Get returns ttl-remaining u32 | version u64 | value, Set returns
+// version u64, and the rest are empty. Other statuses may carry an error
+// message. Requests can be pipelined: responses arrive in request order.
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+type Op byte
+
+const (
+	OpNoop   Op = 0x00
+	OpGet    Op = 0x01
+	OpSet    Op = 0x02
+	OpDelete Op = 0x03
+	OpAuth   Op = 0x04
+)
+
+type Status byte
+
+const (
+	StatusOK           Status = 0x00
+	StatusNotFound     Status = 0x01
+	StatusBadRequest   Status = 0x02
+	StatusUnauthorized Status = 0x03
+	StatusForbidden    Status = 0x04
+	StatusServerError  Status = 0x05
+)
+
+// BinaryRequest is one request frame. Value and TTL are used by OpSet;
+// Key carries the credential for OpAuth.
+type BinaryRequest struct {
+	Op     Op
+	Opaque uint32
+	Key    string
+	Value  string
+	TTL    time.Duration
+}
+
+// AppendTo appends the encoded frame to dst.
+func (r *BinaryRequest) AppendTo(dst []byte) ([]byte, error) {
+	size := 5 + len(r.Key)
+	if r.Op == OpSet {
+		if len(r.Key) > math.MaxUint16 {
+			return dst, errors.New("cache: key longer than 65535 bytes")
+		}
+		size += 6 + len(r.Value)
+	}
+	if uint64(size) > math.MaxUint32 {
+		return dst, errors.New("cache: frame too large")
+	}
+	dst = binary.BigEndian.AppendUint32(dst, uint32(size))
+	dst = append(dst, byte(r.Op))
+	dst = binary.BigEndian.AppendUint32(dst, r.Opaque)
+	if r.Op == OpSet {
+		dst = binary.BigEndian.AppendUint32(dst, uint32(ttlSeconds(r.TTL)))
+		dst = binary.BigEndian.AppendUint16(dst, uint16(len(r.Key)))
+		dst = append(dst, r.Key...)
+		return append(dst, r.Value...), nil
+	}
+	return append(dst, r.Key...), nil
+}
+
+type BinaryResponse struct {
+	Status Status
+	Opaque uint32
+	// Value, TTL and Version are set for a successful OpGet; Version
+	// also for OpSet.
+	Value   string
+	TTL     time.Duration
+	Version uint64
+	// Message is the error text for other statuses, if any.
+	Message string
+}
+
+// ReadBinaryResponse decodes one response frame for a request of type op.
+func ReadBinaryResponse(r io.Reader, op Op) (*BinaryResponse, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	frame := make([]byte, binary.BigEndian.Uint32(prefix[:]))
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	if len(frame) < 5 {
+		return nil, errors.New("cache: short response frame")
+	}
+	resp := &BinaryResponse{Status: Status(frame[0]), Opaque: binary.BigEndian.Uint32(frame[1:5])}
+	body := frame[5:]
+	switch {
+	case resp.Status != StatusOK:
+		resp.Message = string(body)
+	case op == OpGet:
+		if len(body) < 12 {
+			return nil, errors.New("cache: short get response")
+		}
+		resp.TTL = time.Duration(binary.BigEndian.Uint32(body[0:4])) * time.Second
+		resp.Version = binary.BigEndian.Uint64(body[4:12])
+		resp.Value = string(body[12:])
+	case op == OpSet:
+		if len(body) < 8 {
+			return nil, errors.New("cache: short set response")
+		}
+		resp.Version = binary.BigEndian.Uint64(body)
+	}
+	return resp, nil
+}
+
+// BinaryConn is one binary-protocol connection. Its methods may be called
+// concurrently; each holds the connection for its whole round trip.
+type BinaryConn struct {
+	mutex  sync.Mutex
+	conn   net.Conn
+	r      *bufio.Reader
+	buf    []byte
+	opaque uint32
+}
+
+func DialBinary(ctx context.Context, addr string) (*BinaryConn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &BinaryConn{conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+func (c *BinaryConn) Close() error {
+	return c.conn.Close()
+}
+
+// Pipeline sends all requests in one write and reads their responses.
+// Requests with a zero Opaque are numbered by the connection.
+func (c *BinaryConn) Pipeline(ctx context.Context, reqs []BinaryRequest) ([]BinaryResponse, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetDeadline(deadline)
+		defer c.conn.SetDeadline(time.Time{})
+	}
+
+	c.buf = c.buf[:0]
+	for i := range reqs {
+		if reqs[i].Opaque == 0 {
+			c.opaque++
+			reqs[i].Opaque = c.opaque
+		}
+		var err error
+		if c.buf, err = reqs[i].AppendTo(c.buf); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := c.conn.Write(c.buf); err != nil {
+		return nil, err
+	}
+
+	resps := make([]BinaryResponse, len(reqs))
+	for i := range reqs {
+		resp, err := ReadBinaryResponse(c.r, reqs[i].Op)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Opaque != reqs[i].Opaque {
+			return nil, fmt.Errorf("cache: response opaque %d, want %d", resp.Opaque, reqs[i].Opaque)
+		}
+		resps[i] = *resp
+	}
+	return resps, nil
+}
+
+func (c *BinaryConn) do(ctx context.Context, req BinaryRequest) (*BinaryResponse, error) {
+	resps, err := c.Pipeline(ctx, []BinaryRequest{req})
+	if err != nil {
+		return nil, err
+	}
+	resp := &resps[0]
+	switch resp.Status {
+	case StatusOK:
+		return resp, nil
+	case StatusNotFound:
+		return nil, ErrNotFound
+	}
+	return nil, fmt.Errorf("cache: status %d: %s", resp.Status, resp.Message)
+}
+
+func (c *BinaryConn) Auth(ctx context.Context, secret string) error {
+	_, err := c.do(ctx, BinaryRequest{Op: OpAuth, Key: secret})
+	return err
+}
+
+// Get returns the entry for key, or ErrNotFound.
+func (c *BinaryConn) Get(ctx context.Context, key string) (Entry, error) {
+	resp, err := c.do(ctx, BinaryRequest{Op: OpGet, Key: key})
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{Key: key, Value: resp.Value, ExpiresAt: time.Now().Add(resp.TTL), Version: resp.Version}, nil
+}
+
+func (c *BinaryConn) Set(ctx context.Context, key, value string, ttl time.Duration) (uint64, error) {
+	resp, err := c.do(ctx, BinaryRequest{Op: OpSet, Key: key, Value: value, TTL: ttl})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Version, nil
+}
+
+// Delete removes key and reports whether it was present.
+func (c *BinaryConn) Delete(ctx context.Context, key string) (bool, error) {
+	_, err := c.do(ctx, BinaryRequest{Op: OpDelete, Key: key})
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,387 @@
+// Package client is a Go client for the cache server's /v1 JSON API.
+//
+//	c, err := client.New("http://cache:8080", client.Options{APIKey: key})
+//	...
+//	entry, err := c.Get(ctx, "user:42")
+//	if errors.Is(err, client.ErrNotFound) { ... }
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by Get for a key the cache does not hold.
+var ErrNotFound = errors.New("cache: key not found")
+
+// Error is a non-2xx response from the server.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("cache: %d %s", e.StatusCode, e.Message)
+}
+
+type Entry struct {
+	Key   string
+	Value string
+	// ExpiresAt is when the server will drop the entry.
+	ExpiresAt time.Time
+	// Version changes on every write to the entry.
+	Version uint64
+}
+
+type Options struct {
+	// HTTPClient sends the requests. If nil, one is built with a
+	// connection pool of MaxIdleConns per host.
+	HTTPClient   *http.Client
+	MaxIdleConns int
+	// Timeout bounds each attempt when HTTPClient is nil. Export and
+	// Import are bounded only by their context.
+	Timeout time.Duration
+
+	// APIKey is sent as X-API-Key; BearerToken, a JWT, as Authorization.
+	APIKey      string
+	BearerToken string
+
+	// Retries is how many times a request is retried after a network
+	// error, 429, 502, 503 or 504. Set and MGet are safe to retry; a
+	// retried Delete whose first attempt succeeded reports false.
+	Retries int
+	// RetryBackoff is the first delay between attempts; it doubles each
+	// time. A Retry-After header on a 429 or 503 takes precedence.
+	RetryBackoff time.Duration
+
+	// NearCacheSize, if positive, keeps up to this many entries read or
+	// written through this client in process for up to NearCacheTTL, so
+	// hot keys skip the network. Writes by other clients are not seen
+	// until the local copy expires.
+	NearCacheSize int
+	NearCacheTTL  time.Duration
+	// NearCacheInvalidate has the near cache follow the server's event
+	// stream, which needs the read permission, and drop each key as soon
+	// as it changes, by any client, so NearCacheTTL can be minutes rather
+	// than seconds. It still bounds how long a change the server fails to
+	// send, to a slow subscriber, goes unseen. While the stream is down
+	// the near cache is bypassed. Call Close to end the subscription.
+	NearCacheInvalidate bool
+}
+
+const (
+	defaultMaxIdleConns = 64
+	defaultTimeout      = 10 * time.Second
+	defaultRetries      = 2
+	defaultRetryBackoff = 50 * time.Millisecond
+	defaultNearCacheTTL = time.Second
+	maxRetryAfter       = 5 * time.Second
+)
+
+// CacheClient is safe for concurrent use.
+type CacheClient struct {
+	base *url.URL
+	http *http.Client
+	// stream has no Timeout, for exports and imports of any length.
+	stream  *http.Client
+	options Options
+	near    *nearCache
+
+	unsubscribe context.CancelFunc
+	subscribed  sync.WaitGroup
+}
+
+// New returns a client for the server at baseURL, e.g.
+// "http://localhost:8080". Zero Options fields get defaults; Retries < 0
+// disables retrying.
+func New(baseURL string, options Options) (*CacheClient, error) {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("cache: invalid base URL: %w", err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("cache: base URL %q must be http or https", baseURL)
+	}
+
+	if options.MaxIdleConns <= 0 {
+		options.MaxIdleConns = defaultMaxIdleConns
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = defaultTimeout
+	}
+	switch {
+	case options.Retries == 0:
+		options.Retries = defaultRetries
+	case options.Retries < 0:
+		options.Retries = 0
+	}
+	if options.RetryBackoff <= 0 {
+		options.RetryBackoff = defaultRetryBackoff
+	}
+	if options.NearCacheTTL <= 0 {
+		options.NearCacheTTL = defaultNearCacheTTL
+	}
+
+	c := &CacheClient{base: base, http: options.HTTPClient, stream: options.HTTPClient, options: options}
+	if c.http == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConns = options.MaxIdleConns
+		transport.MaxIdleConnsPerHost = options.MaxIdleConns
+		c.http = &http.Client{Transport: transport, Timeout: options.Timeout}
+		c.stream = &http.Client{Transport: transport}
+	}
+	if options.NearCacheSize > 0 {
+		c.near = newNearCache(options.NearCacheSize, options.NearCacheTTL, options.NearCacheInvalidate)
+		if options.NearCacheInvalidate {
+			ctx, cancel := context.WithCancel(context.Background())
+			c.unsubscribe = cancel
+			c.subscribed.Add(1)
+			go func() {
+				defer c.subscribed.Done()
+				c.subscribe(ctx)
+			}()
+		}
+	}
+	return c, nil
+}
+
+type wireEntry struct {
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Version   uint64    `json:"version"`
+}
+
+func (e *wireEntry) entry() Entry {
+	return Entry{Key: e.Key, Value: e.Value, ExpiresAt: e.ExpiresAt, Version: e.Version}
+}
+
+type wirePut struct {
+	Value string `json:"value"`
+	TTL   int64  `json:"ttl,omitempty"`
+}
+
+type wireEntryList struct {
+	Entries []wireEntry `json:"entries"`
+}
+
+// Get returns the entry for key, or ErrNotFound.
+func (c *CacheClient) Get(ctx context.Context, key string) (Entry, error) {
+	if e, ok := c.near.get(key); ok {
+		return e, nil
+	}
+	since := c.near.begin(key)
+	defer c.near.end(key)
+	var resp wireEntry
+	if err := c.do(ctx, http.MethodGet, keyPath(key), nil, nil, &resp); err != nil {
+		return Entry{}, err
+	}
+	e := resp.entry()
+	c.near.put(e, since)
+	return e, nil
+}
+
+// Set stores value under key for ttl, or the server default when ttl is 0.
+// TTLs are sent in whole seconds.
+func (c *CacheClient) Set(ctx context.Context, key, value string, ttl time.Duration) (Entry, error) {
+	body, err := json.Marshal(&wirePut{Value: value, TTL: ttlSeconds(ttl)})
+	if err != nil {
+		return Entry{}, err
+	}
+	since := c.near.begin(key)
+	defer c.near.end(key)
+	var resp wireEntry
+	if err := c.do(ctx, http.MethodPut, keyPath(key), body, nil, &resp); err != nil {
+		c.near.remove(key)
+		return Entry{}, err
+	}
+	e := resp.entry()
+	c.near.put(e, since)
+	return e, nil
+}
+
+// Delete removes key and reports whether it was present.
+func (c *CacheClient) Delete(ctx context.Context, key string) (bool, error) {
+	c.near.remove(key)
+	err := c.do(ctx, http.MethodDelete, keyPath(key), nil, nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// MGet returns the entries found for keys; missing keys are absent from
+// the map.
+func (c *CacheClient) MGet(ctx context.Context, keys ...string) (map[string]Entry, error) {
+	found := make(map[string]Entry, len(keys))
+	query := url.Values{}
+	for _, key := range keys {
+		if e, ok := c.near.get(key); ok {
+			found[key] = e
+		} else {
+			query.Add("key", key)
+		}
+	}
+	if len(query) == 0 {
+		return found, nil
+	}
+
+	since := c.near.begin(query["key"]...)
+	defer c.near.end(query["key"]...)
+	var resp wireEntryList
+	if err := c.do(ctx, http.MethodGet, "/v1/keys", nil, query, &resp); err != nil {
+		return nil, err
+	}
+	for i := range resp.Entries {
+		e := resp.Entries[i].entry()
+		found[e.Key] = e
+		c.near.put(e, since)
+	}
+	return found, nil
+}
+
+func keyPath(key string) string {
+	return "/v1/keys/" + url.PathEscape(key)
+}
+
+func ttlSeconds(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	// Round up so a sub-second TTL does not become the server default.
+	return int64((ttl + time.Second - 1) / time.Second)
+}
+
+// do sends a request, retrying transient failures, and decodes a JSON
+// response into out. 404 becomes ErrNotFound.
+func (c *CacheClient) do(ctx context.Context, method, path string, body []byte, query url.Values, out any) error {
+	// One key for all attempts, so the server replays rather than
+	// re-applies a write whose response was lost.
+	var idempotencyKey string
+	if method == http.MethodPut || method == http.MethodPost {
+		idempotencyKey = newIdempotencyKey()
+	}
+
+	backoff := c.options.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		var reqBody io.Reader
+		if body != nil {
+			reqBody = bytes.NewReader(body)
+		}
+		req, err := c.newRequest(ctx, method, path, query, reqBody)
+		if err != nil {
+			return err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if idempotencyKey != "" {
+			req.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+
+		resp, err := c.http.Do(req)
+		var wait time.Duration
+		if err == nil {
+			// 409 means an earlier attempt with the same key is still
+			// being processed.
+			conflict := resp.StatusCode == http.StatusConflict && idempotencyKey != ""
+			if resp.StatusCode < 300 || !(retryable(resp.StatusCode) || conflict) || attempt == c.options.Retries {
+				return decodeResponse(resp, out)
+			}
+			wait = retryAfter(resp)
+			resp.Body.Close()
+		} else if ctx.Err() != nil || attempt == c.options.Retries {
+			return err
+		}
+
+		if wait == 0 {
+			wait = backoff
+			backoff *= 2
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *CacheClient) newRequest(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	target := c.base.String() + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.options.APIKey != "" {
+		req.Header.Set("X-API-Key", c.options.APIKey)
+	}
+	if c.options.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.options.BearerToken)
+	}
+	return req, nil
+}
+
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return min(time.Duration(seconds)*time.Second, maxRetryAfter)
+}
+
+func decodeResponse(resp *http.Response, out any) error {
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		io.Copy(io.Discard, resp.Body)
+		return ErrNotFound
+	case resp.StatusCode >= 300:
+		var e struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		if json.Unmarshal(data, &e) != nil || e.Error == "" {
+			e.Error = strings.TrimSpace(string(data))
+		}
+		return &Error{StatusCode: resp.StatusCode, Message: e.Error}
+	case out == nil:
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("cache: decode response: %w", err)
+	}
+	return nil
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
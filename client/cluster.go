@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"myproject/internal/hashring"
+)
+
+// ClusterClient sends each key straight to the server that owns it on
+// the cluster's consistent-hash ring, sparing the servers a proxy hop. It
+// is safe for concurrent use.
+type ClusterClient struct {
+	ring  *hashring.Ring
+	nodes map[string]*CacheClient
+}
+
+// NewCluster returns a client for the servers at nodes, which must be
+// their base URLs exactly as in the servers' cluster configuration.
+func NewCluster(nodes []string, options Options) (*ClusterClient, error) {
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("cache: no cluster nodes")
+	}
+	c := &ClusterClient{nodes: make(map[string]*CacheClient, len(nodes))}
+	trimmed := make([]string, len(nodes))
+	for i, node := range nodes {
+		trimmed[i] = strings.TrimSuffix(node, "/")
+		if _, ok := c.nodes[trimmed[i]]; ok {
+			continue
+		}
+		node, err := New(trimmed[i], options)
+		if err != nil {
+			return nil, err
+		}
+		c.nodes[trimmed[i]] = node
+	}
+	c.ring = hashring.New(trimmed)
+	return c, nil
+}
+
+type wireClusterInfo struct {
+	Nodes []string `json:"nodes"`
+}
+
+// DiscoverCluster asks the server at seed for the cluster's nodes and
+// returns a client for them.
+func DiscoverCluster(ctx context.Context, seed string, options Options) (*ClusterClient, error) {
+	c, err := New(seed, options)
+	if err != nil {
+		return nil, err
+	}
+	var info wireClusterInfo
+	if err := c.do(ctx, http.MethodGet, "/v1/cluster", nil, nil, &info); err != nil {
+		return nil, fmt.Errorf("cache: discover cluster: %w", err)
+	}
+	return NewCluster(info.Nodes, options)
+}
+
+// Node returns the client for the server that owns key.
+func (c *ClusterClient) Node(key string) *CacheClient {
+	return c.nodes[c.ring.Owner(key)]
+}
+
+// Close ends every node client's near-cache subscription.
+func (c *ClusterClient) Close() error {
+	for _, node := range c.nodes {
+		node.Close()
+	}
+	return nil
+}
+
+// Get returns the entry for key, or ErrNotFound.
+func (c *ClusterClient) Get(ctx context.Context, key string) (Entry, error) {
+	return c.Node(key).Get(ctx, key)
+}
+
+// Set stores value under key as CacheClient.Set does.
+func (c *ClusterClient) Set(ctx context.Context, key, value string, ttl time.Duration) (Entry, error) {
+	return c.Node(key).Set(ctx, key, value, ttl)
+}
+
+// Delete removes key and reports whether it was present.
+func (c *ClusterClient) Delete(ctx context.Context, key string) (bool, error) {
+	return c.Node(key).Delete(ctx, key)
+}
+
+// MGet asks each owning server for its keys, in parallel, and merges the
+// entries found. It fails if any server does.
+func (c *ClusterClient) MGet(ctx context.Context, keys ...string) (map[string]Entry, error) {
+	byNode := make(map[string][]string)
+	for _, key := range keys {
+		owner := c.ring.Owner(key)
+		byNode[owner] = append(byNode[owner], key)
+	}
+
+	var (
+		mutex    sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	found := make(map[string]Entry, len(keys))
+	for node, keys := range byNode {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			entries, err := c.nodes[node].MGet(ctx, keys...)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("cache: %s: %w", node, err)
+				}
+				return
+			}
+			for key, e := range entries {
+				found[key] = e
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return found, nil
+}
@@ -0,0 +1,253 @@
+package client
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// nearStreamIdle is how long the event stream may stay silent before it
+// is taken for dead. The server sends a heartbeat every 15 seconds.
+const nearStreamIdle = 45 * time.Second
+
+// nearCache is a small in-process LRU in front of the server. A nil
+// *nearCache is disabled: every method is a no-op.
+type nearCache struct {
+	mutex    sync.Mutex
+	capacity int
+	ttl      time.Duration
+	order    *list.List
+	entries  map[string]*list.Element
+
+	// subscribed is set when the cache follows the server's event stream,
+	// and live while the stream is up; a subscribed cache that is not
+	// live holds nothing.
+	subscribed bool
+	live       bool
+	// removals counts calls to remove. fetching holds the keys being read
+	// or written, so that a response that raced a change is not kept.
+	removals uint64
+	fetching map[string]*nearFetch
+}
+
+type nearEntry struct {
+	entry   Entry
+	expires time.Time
+}
+
+type nearFetch struct {
+	count int
+	// removed is the removals count at the key's last removal.
+	removed uint64
+}
+
+func newNearCache(capacity int, ttl time.Duration, subscribed bool) *nearCache {
+	return &nearCache{
+		capacity:   capacity,
+		ttl:        ttl,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+		subscribed: subscribed,
+		fetching:   make(map[string]*nearFetch),
+	}
+}
+
+func (n *nearCache) get(key string) (Entry, bool) {
+	if n == nil {
+		return Entry{}, false
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	el, ok := n.entries[key]
+	if !ok {
+		return Entry{}, false
+	}
+	e := el.Value.(*nearEntry)
+	if !time.Now().Before(e.expires) {
+		n.order.Remove(el)
+		delete(n.entries, key)
+		return Entry{}, false
+	}
+	n.order.MoveToFront(el)
+	return e.entry, true
+}
+
+// begin marks keys as being fetched from the server and returns the token
+// to pass to put. Every begin is followed by an end for the same keys.
+func (n *nearCache) begin(keys ...string) uint64 {
+	if n == nil {
+		return 0
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for _, key := range keys {
+		f, ok := n.fetching[key]
+		if !ok {
+			f = &nearFetch{}
+			n.fetching[key] = f
+		}
+		f.count++
+	}
+	return n.removals
+}
+
+func (n *nearCache) end(keys ...string) {
+	if n == nil {
+		return
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for _, key := range keys {
+		if f := n.fetching[key]; f != nil {
+			if f.count--; f.count <= 0 {
+				delete(n.fetching, key)
+			}
+		}
+	}
+}
+
+// put keeps e for the near-cache TTL, or until the server would expire
+// it if that is sooner, unless the key was removed since the begin that
+// returned since: the server's answer may predate that change.
+func (n *nearCache) put(e Entry, since uint64) {
+	if n == nil {
+		return
+	}
+	expires := time.Now().Add(n.ttl)
+	if !e.ExpiresAt.IsZero() && e.ExpiresAt.Before(expires) {
+		expires = e.ExpiresAt
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.subscribed && !n.live {
+		return
+	}
+	if f := n.fetching[e.Key]; f != nil && f.removed > since {
+		return
+	}
+	if el, ok := n.entries[e.Key]; ok {
+		el.Value = &nearEntry{entry: e, expires: expires}
+		n.order.MoveToFront(el)
+		return
+	}
+	n.entries[e.Key] = n.order.PushFront(&nearEntry{entry: e, expires: expires})
+	if n.order.Len() > n.capacity {
+		oldest := n.order.Back()
+		n.order.Remove(oldest)
+		delete(n.entries, oldest.Value.(*nearEntry).entry.Key)
+	}
+}
+
+func (n *nearCache) remove(key string) {
+	if n == nil {
+		return
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.removals++
+	if f := n.fetching[key]; f != nil {
+		f.removed = n.removals
+	}
+	if el, ok := n.entries[key]; ok {
+		n.order.Remove(el)
+		delete(n.entries, key)
+	}
+}
+
+// setLive empties the cache and records whether the event stream is up:
+// changes made while it was down were missed.
+func (n *nearCache) setLive(live bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.live = live
+	n.order.Init()
+	clear(n.entries)
+	// Fetches in flight may have read what was missed.
+	n.removals++
+	for _, f := range n.fetching {
+		f.removed = n.removals
+	}
+}
+
+// subscribe follows the server's event stream until ctx is done, dropping
+// every key that is set, deleted, evicted or expired from the near cache,
+// and reconnects when the stream fails.
+func (c *CacheClient) subscribe(ctx context.Context) {
+	backoff := c.options.RetryBackoff
+	for {
+		if c.followEvents(ctx) {
+			backoff = c.options.RetryBackoff
+		}
+		c.near.setLive(false)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, maxRetryAfter)
+	}
+}
+
+// followEvents reads the event stream until it fails and reports whether
+// it connected.
+func (c *CacheClient) followEvents(ctx context.Context) bool {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, err := c.newRequest(ctx, http.MethodGet, "/v1/events", nil, nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		return false
+	}
+	if resp.StatusCode >= 300 {
+		decodeResponse(resp, nil)
+		return false
+	}
+	defer resp.Body.Close()
+	c.near.setLive(true)
+
+	idle := time.AfterFunc(nearStreamIdle, cancel)
+	defer idle.Stop()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64<<10), 16<<20)
+	for scanner.Scan() {
+		idle.Reset(nearStreamIdle)
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event struct {
+			Key string `json:"key"`
+		}
+		if json.Unmarshal([]byte(data), &event) == nil {
+			c.near.remove(event.Key)
+		}
+	}
+	return true
+}
+
+// Close ends the near cache's event subscription, if any. The client can
+// still be used, with the near cache bypassed.
+func (c *CacheClient) Close() error {
+	if c.unsubscribe != nil {
+		c.unsubscribe()
+		c.subscribed.Wait()
+	}
+	return nil
+}
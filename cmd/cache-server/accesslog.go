@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type accessLogEntry struct {
+	Time      string  `json:"time"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Key       string  `json:"key,omitempty"`
+	Status    int     `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Outcome   string  `json:"outcome,omitempty"`
+	RequestID string  `json:"request_id,omitempty"`
+}
+
+// accessRecord collects the parts of a log entry only the handler knows.
+type accessRecord struct {
+	keys   []string
+	hits   int
+	misses int
+}
+
+func (a *accessRecord) outcome() string {
+	switch {
+	case a.hits > 0 && a.misses > 0:
+		return "partial"
+	case a.hits > 0:
+		return "hit"
+	case a.misses > 0:
+		return "miss"
+	}
+	return ""
+}
+
+type accessRecordKey struct{}
+
+func accessRecordFrom(r *http.Request) *accessRecord {
+	a, _ := r.Context().Value(accessRecordKey{}).(*accessRecord)
+	return a
+}
+
+func recordWrite(r *http.Request, key string) {
+	if a := accessRecordFrom(r); a != nil {
+		a.keys = append(a.keys, key)
+	}
+}
+
+func recordLookup(r *http.Request, key string, hit bool) {
+	a := accessRecordFrom(r)
+	if a == nil {
+		return
+	}
+	a.keys = append(a.keys, key)
+	if hit {
+		a.hits++
+	} else {
+		a.misses++
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	if s.status == 0 {
+		s.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+type accessLogger struct {
+	config AccessLogConfig
+	mutex  sync.Mutex
+	out    io.Writer
+}
+
+func newAccessLogger(config AccessLogConfig, out io.Writer) *accessLogger {
+	return &accessLogger{config: config, out: out}
+}
+
+func (l *accessLogger) Middleware(next http.Handler) http.Handler {
+	if !l.config.Enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		record := &accessRecord{}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), accessRecordKey{}, record)))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if !l.sampled(rec.status) {
+			return
+		}
+		l.write(accessLogEntry{
+			Time:      start.UTC().Format(time.RFC3339Nano),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Key:       strings.Join(record.keys, ","),
+			Status:    rec.status,
+			LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			Outcome:   record.outcome(),
+			RequestID: requestIDFrom(r.Context()),
+		})
+	})
+}
+
+func (l *accessLogger) sampled(status int) bool {
+	if l.config.LogErrors && status >= 400 {
+		return true
+	}
+	return l.config.SampleRate >= 1 || rand.Float64() < l.config.SampleRate
+}
+
+func (l *accessLogger) write(e accessLogEntry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.out.Write(append(b, '\n'))
+}
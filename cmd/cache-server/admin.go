@@ -0,0 +1,57 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// newAdminMux returns the mux for the admin listener. It carries the
+// maintenance routes that are withheld from the public port, plus pprof
+// and expvar. pprof.Index serves the named profiles (heap, goroutine,
+// mutex, block, allocs) under /debug/pprof/.
+func newAdminMux(cache *LRUCache, profiling ProfilingConfig) *http.ServeMux {
+	runtime.SetMutexProfileFraction(profiling.MutexProfileFraction)
+	runtime.SetBlockProfileRate(profiling.BlockProfileRate)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	publishVars(cache)
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	return mux
+}
+
+// publishVars adds the cache counters and a few runtime figures to
+// expvar, beside its own cmdline and memstats. It must be called once.
+func publishVars(cache *LRUCache) {
+	started := time.Now()
+	expvar.Publish("cache", expvar.Func(func() any {
+		counters, entries, bytes := cache.Counters()
+		vars := map[string]any{
+			"entries":     entries,
+			"bytes":       bytes,
+			"hits":        counters.Hits,
+			"misses":      counters.Misses,
+			"evictions":   counters.Evictions,
+			"expirations": counters.Expirations,
+			"hit_rate":    0.0,
+		}
+		if total := counters.Hits + counters.Misses; total > 0 {
+			vars["hit_rate"] = float64(counters.Hits) / float64(total)
+		}
+		return vars
+	}))
+	expvar.Publish("runtime", expvar.Func(func() any {
+		return map[string]any{
+			"goroutines":     runtime.NumGoroutine(),
+			"gomaxprocs":     runtime.GOMAXPROCS(0),
+			"go_version":     runtime.Version(),
+			"uptime_seconds": time.Since(started).Seconds(),
+		}
+	}))
+}
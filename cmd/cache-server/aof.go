@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	fsyncAlways   = "always"
+	fsyncEverySec = "everysec"
+	fsyncNo       = "no"
+)
+
+// aofRecord is one line of the append-only log. ExpiresAt is absolute, in
+// Unix milliseconds, so that replay does not depend on when it happens.
+type aofRecord struct {
+	Op        string `json:"op"`
+	Key       string `json:"key"`
+	Value     string `json:"value,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+	Flags     uint32 `json:"flags,omitempty"`
+	// Seq, on a replication stream, counts the primary's writes up to
+	// and including this one.
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+const (
+	aofSet    = "set"
+	aofDelete = "del"
+)
+
+// appendOnlyLog is a MutationSink that appends every set and delete to a
+// file. Evictions and expirations are not logged: replaying the sets in
+// order against the same capacity evicts the same entries, and expired
+// ones are skipped.
+//
+// A rewrite (see snapshotter.Snapshot) first rotates the log to
+// path.next, so that the new file covers everything from before the
+// snapshot starts, then renames it over the old log once the snapshot is
+// in place. Replay reads path and then path.next, which is correct after a
+// crash at any point in between.
+type appendOnlyLog struct {
+	mutex   sync.Mutex
+	path    string
+	f       *os.File
+	w       *bufio.Writer
+	size    int64
+	rotated bool
+	fsync   string
+	cipher  *fileCipher
+	codec   lineCodec
+	failed  bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// aofNextPath is where records go while a rewrite is in progress.
+func aofNextPath(path string) string { return path + ".next" }
+
+// openAOF opens the log for appending, sealing new files with c, which may
+// be nil.
+func openAOF(config AOFConfig, c *fileCipher) (*appendOnlyLog, error) {
+	switch config.Fsync {
+	case fsyncAlways, fsyncEverySec, fsyncNo:
+	default:
+		return nil, fmt.Errorf("unknown fsync policy %q", config.Fsync)
+	}
+	l := &appendOnlyLog{path: config.Path, fsync: config.Fsync, cipher: c, done: make(chan struct{})}
+	// Resume a rewrite that was interrupted: its records are newer than
+	// the log's and must stay after them.
+	name := config.Path
+	if _, err := os.Stat(aofNextPath(config.Path)); err == nil {
+		name, l.rotated = aofNextPath(config.Path), true
+	}
+	codec, exists, err := upgradeAOF(name, c)
+	if err != nil {
+		return nil, fmt.Errorf("upgrading %s: %w", name, err)
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	l.f, l.w, l.size, l.codec = f, bufio.NewWriterSize(f, 64<<10), info.Size(), codec
+	if !exists {
+		if err := l.writeHeader(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if l.fsync != fsyncAlways {
+		l.wg.Add(1)
+		go l.run()
+	}
+	return l, nil
+}
+
+func (l *appendOnlyLog) Record(e CacheEvent, item Item) {
+	var record aofRecord
+	switch e.Type {
+	case eventSet:
+		record = aofSetRecord(item)
+	case eventDelete:
+		record = aofRecord{Op: aofDelete, Key: e.Key}
+	default:
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	line, err := l.codec.marshal(record)
+	if err != nil {
+		return
+	}
+	l.w.Write(line)
+	l.w.WriteByte('\n')
+	l.size += int64(len(line)) + 1
+	if l.fsync == fsyncAlways {
+		l.sync(true)
+	}
+}
+
+// run writes out the buffer every second, with an fsync under everysec.
+// Under no, the kernel decides when the data reaches the disk.
+func (l *appendOnlyLog) run() {
+	defer l.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			l.mutex.Lock()
+			l.sync(l.fsync == fsyncEverySec)
+			l.mutex.Unlock()
+		}
+	}
+}
+
+// Size is the number of bytes in the current log file.
+func (l *appendOnlyLog) Size() int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.size
+}
+
+// rotate starts a rewrite by sending new records to path.next. It does
+// nothing if a rewrite that failed is still pending.
+func (l *appendOnlyLog) rotate() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.rotated {
+		return nil
+	}
+	if err := l.sync(true); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(aofNextPath(l.path), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	l.f.Close()
+	l.f, l.size, l.rotated = f, 0, true
+	l.w.Reset(f)
+	return l.writeHeader()
+}
+
+// writeHeader starts an empty log file, with a new key if it is
+// encrypted. The caller holds l.mutex, or has not shared l yet.
+func (l *appendOnlyLog) writeHeader() error {
+	h, codec, err := l.cipher.newHeader(aofFormat, aofVersion)
+	if err != nil {
+		return err
+	}
+	n, err := writeFormatHeader(l.w, h)
+	l.size += int64(n)
+	l.codec = codec
+	return err
+}
+
+// finishRotation replaces the log with path.next once the snapshot it
+// complements is in place.
+func (l *appendOnlyLog) finishRotation() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.rotated {
+		return nil
+	}
+	if err := l.sync(true); err != nil {
+		return err
+	}
+	if err := os.Rename(aofNextPath(l.path), l.path); err != nil {
+		return err
+	}
+	l.rotated = false
+	return nil
+}
+
+// sync flushes the buffer and optionally fsyncs. Failures are logged once
+// until a sync succeeds again. The caller holds l.mutex.
+func (l *appendOnlyLog) sync(fsync bool) error {
+	err := l.w.Flush()
+	if err == nil && fsync {
+		err = l.f.Sync()
+	}
+	switch {
+	case err != nil && !l.failed:
+		persistenceLog.Error("Failed to write append-only log", "err", err)
+		l.failed = true
+	case err == nil && l.failed:
+		persistenceLog.Info("Append-only log writes recovered")
+		l.failed = false
+	}
+	return err
+}
+
+// Close flushes and fsyncs the log. The cache must no longer be calling
+// Record.
+func (l *appendOnlyLog) Close() error {
+	close(l.done)
+	l.wg.Wait()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err := l.sync(true); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
+
+// replayAOF applies the log at path to cache and returns the number of
+// records applied. A torn last line, left by a crash mid-write, is cut off
+// so that new records start on a clean line. A log in a format this build
+// does not read, or that is encrypted with a key c cannot unwrap, is an
+// error.
+func replayAOF(ctx context.Context, path string, cache *LRUCache, c *fileCipher) (n int, err error) {
+	ctx, span := tracer.Start(ctx, "aof.replay")
+	defer func() { endSpan(span, err) }()
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	var codec lineCodec
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				persistenceLog.Warn("Append-only log ends in a partial record; truncating it", "path", path, "bytes", len(line))
+				return n, f.Truncate(offset)
+			}
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		offset += int64(len(line))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if offset == int64(len(line)) {
+			h, isHeader, err := parseFormatHeader(line, aofFormat, aofVersion)
+			if err != nil {
+				return 0, err
+			}
+			if codec, err = c.codec(h); err != nil {
+				return 0, err
+			}
+			if isHeader {
+				continue
+			}
+		}
+
+		var record aofRecord
+		if err := codec.unmarshal(bytes.TrimRight(line, "\r\n"), &record); err != nil {
+			return n, fmt.Errorf("record at byte %d: %w", offset-int64(len(line)), err)
+		}
+		if err := applyAOFRecord(ctx, cache, record); err != nil {
+			return n, fmt.Errorf("record at byte %d: %w", offset-int64(len(line)), err)
+		}
+		n++
+	}
+}
+
+// applyAOFRecord makes the change record describes, as replay and
+// replication do.
+func applyAOFRecord(ctx context.Context, cache *LRUCache, record aofRecord) error {
+	switch record.Op {
+	case aofSet:
+		ttl := time.Until(time.UnixMilli(record.ExpiresAt))
+		if ttl <= 0 {
+			// A later write that has since expired still replaces
+			// whatever the snapshot held.
+			cache.Delete(ctx, record.Key)
+			break
+		}
+		cache.Store(ctx, Write{Key: record.Key, Value: record.Value, TTL: ttl, Flags: record.Flags})
+	case aofDelete:
+		cache.Delete(ctx, record.Key)
+	default:
+		return fmt.Errorf("unknown op %q", record.Op)
+	}
+	return nil
+}
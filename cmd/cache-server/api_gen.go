@@ -0,0 +1,441 @@
+// Code generated by openapigen from openapi.yaml; DO NOT EDIT.
+
+package main
+
+import (
+	"myproject/cache"
+	"net/http"
+	"time"
+)
+
+type BackupInfo struct {
+	Name string `json:"name" msgpack:"name"`
+	// A named backup, or the configured snapshot ("snapshot") and
+	// the one it replaced ("snapshot.prev").
+	Kind       string    `json:"kind" msgpack:"kind"`
+	Bytes      int64     `json:"bytes" msgpack:"bytes"`
+	ModifiedAt time.Time `json:"modified_at" msgpack:"modified_at"`
+}
+
+type BackupList struct {
+	// Newest first.
+	Backups []BackupInfo `json:"backups" msgpack:"backups"`
+}
+
+type BackupResponse struct {
+	Name    string `json:"name" msgpack:"name"`
+	Entries int    `json:"entries" msgpack:"entries"`
+	Bytes   int64  `json:"bytes" msgpack:"bytes"`
+}
+
+type CacheEntry struct {
+	Key   int `json:"key" msgpack:"key"`
+	Value int `json:"value" msgpack:"value"`
+}
+
+type CacheEvent = cache.Event
+
+// CacheMemory estimated memory held by the entries. It leaves out the cache's
+// fixed costs and what the allocator rounds sizes up by, so the
+// process uses somewhat more.
+type CacheMemory = cache.Memory
+
+// CacheSettings what can be tuned while the cache is in use.
+type CacheSettings = cache.Settings
+
+// CacheTuning settings to change; those left out keep their value.
+type CacheTuning struct {
+	// Default TTL in seconds.
+	DefaultTTL      *int   `json:"default_ttl,omitempty" msgpack:"default_ttl,omitempty"`
+	SweepIntervalMs *int64 `json:"sweep_interval_ms,omitempty" msgpack:"sweep_interval_ms,omitempty"`
+	// Zero turns the watermarks off.
+	HighWatermark *float64 `json:"high_watermark,omitempty" msgpack:"high_watermark,omitempty"`
+	LowWatermark  *float64 `json:"low_watermark,omitempty" msgpack:"low_watermark,omitempty"`
+	// New profiles, replacing the named namespaces' whole profiles.
+	Namespaces map[string]NamespaceProfile `json:"namespaces,omitempty" msgpack:"namespaces,omitempty"`
+}
+
+type ClusterInfo struct {
+	// This node's base URL.
+	Self string `json:"self" msgpack:"self"`
+	// The base URL of every node, sorted. A key belongs to the node
+	// after it on the consistent-hash ring of these names.
+	Nodes []string `json:"nodes" msgpack:"nodes"`
+	// Whether nodes proxy requests for keys they do not own, rather
+	// than answering 421.
+	Proxy bool `json:"proxy" msgpack:"proxy"`
+}
+
+type ConfigReload struct {
+	// The changed settings now in effect.
+	Applied []string `json:"applied" msgpack:"applied"`
+	// The changed settings that take effect only after a restart.
+	RestartRequired []string `json:"restart_required" msgpack:"restart_required"`
+}
+
+type EfficiencyReport = cache.EfficiencyReport
+
+type EfficiencyWindow = cache.EfficiencyWindow
+
+type Error struct {
+	Error string `json:"error" msgpack:"error"`
+}
+
+type EvictionLog = cache.EvictionLog
+
+type GetResponse struct {
+	// The cached value, or -1 on a miss.
+	Value int `json:"value" msgpack:"value"`
+	// Unix time at which an entry written now would expire.
+	Expiration int64 `json:"expiration" msgpack:"expiration"`
+}
+
+type GraphQLRequest struct {
+	Query         string                 `json:"query" msgpack:"query"`
+	OperationName string                 `json:"operationName,omitempty" msgpack:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty" msgpack:"variables,omitempty"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty" msgpack:"extensions,omitempty"`
+}
+
+type GraphQLResponse struct {
+	Data       map[string]interface{}   `json:"data,omitempty" msgpack:"data,omitempty"`
+	Errors     []map[string]interface{} `json:"errors,omitempty" msgpack:"errors,omitempty"`
+	Extensions map[string]interface{}   `json:"extensions,omitempty" msgpack:"extensions,omitempty"`
+}
+
+type HotKey = cache.HotKey
+
+type HotKeyRate = cache.HotKeyRate
+
+type HotKeyReport = cache.HotKeyReport
+
+type ImportError struct {
+	// 1-based line number of the rejected row.
+	Row   int    `json:"row" msgpack:"row"`
+	Error string `json:"error" msgpack:"error"`
+}
+
+type ImportReport struct {
+	Imported int `json:"imported" msgpack:"imported"`
+	Failed   int `json:"failed" msgpack:"failed"`
+	// The first 1000 rejected rows.
+	Errors []ImportError `json:"errors" msgpack:"errors"`
+	// Set when more rows failed than are listed.
+	ErrorsTruncated bool `json:"errors_truncated,omitempty" msgpack:"errors_truncated,omitempty"`
+	// Set when the body itself could not be read to the end (e.g. a
+	// line longer than the request limit); rows before that point
+	// were still imported.
+	Aborted string `json:"aborted,omitempty" msgpack:"aborted,omitempty"`
+}
+
+type LogLevels struct {
+	// Levels by subsystem, each debug, info, warn or error.
+	Levels map[string]string `json:"levels" msgpack:"levels"`
+}
+
+type MGetResponse struct {
+	Entries    []CacheEntry `json:"entries" msgpack:"entries"`
+	Expiration int64        `json:"expiration" msgpack:"expiration"`
+}
+
+type MSetRequest struct {
+	Entries []CacheEntry `json:"entries" msgpack:"entries"`
+}
+
+type Member struct {
+	Name string `json:"name" msgpack:"name"`
+	// The member's gossip address.
+	Addr string `json:"addr" msgpack:"addr"`
+	// The member's base URL, in cluster mode.
+	URL  string            `json:"url,omitempty" msgpack:"url,omitempty"`
+	Tags map[string]string `json:"tags,omitempty" msgpack:"tags,omitempty"`
+}
+
+type MemberList struct {
+	// Sorted by name.
+	Members []Member `json:"members" msgpack:"members"`
+}
+
+type MigrateResponse struct {
+	// Entries stored; the rest were already here or had expired.
+	Stored int `json:"stored" msgpack:"stored"`
+}
+
+type NamespaceProfile struct {
+	// Entry quota; zero is unlimited.
+	MaxEntries int `json:"max_entries,omitempty" msgpack:"max_entries,omitempty"`
+	// Byte quota; zero is unlimited.
+	MaxBytes int64 `json:"max_bytes,omitempty" msgpack:"max_bytes,omitempty"`
+	// Default TTL in seconds; zero is the cache's.
+	TTL      int    `json:"ttl,omitempty" msgpack:"ttl,omitempty"`
+	Eviction string `json:"eviction,omitempty" msgpack:"eviction,omitempty"`
+	// Compresses values of more than compress_above bytes as they are
+	// written. Entries already stored stay as they are.
+	Compression string `json:"compression,omitempty" msgpack:"compression,omitempty"`
+	// Size in bytes above which values are compressed.
+	CompressAbove int `json:"compress_above,omitempty" msgpack:"compress_above,omitempty"`
+	// Bytes kept for the namespace when the whole cache evicts, so
+	// that other namespaces' writes cannot take them; at most
+	// max_bytes, if that is set. Zero reserves nothing.
+	ReservedBytes int64 `json:"reserved_bytes,omitempty" msgpack:"reserved_bytes,omitempty"`
+}
+
+type NamespaceStats = cache.NamespaceStats
+
+type OperationLatency = cache.OperationLatency
+
+type PubSubMessage struct {
+	// The subscribed pattern the channel matched; absent for a channel subscribed to by name.
+	Pattern string `json:"pattern,omitempty" msgpack:"pattern,omitempty"`
+	Channel string `json:"channel" msgpack:"channel"`
+	Message string `json:"message" msgpack:"message"`
+}
+
+type RaftServer struct {
+	ID    string `json:"id" msgpack:"id"`
+	Addr  string `json:"addr" msgpack:"addr"`
+	Voter bool   `json:"voter" msgpack:"voter"`
+}
+
+type RaftStatus struct {
+	ID    string `json:"id" msgpack:"id"`
+	State string `json:"state" msgpack:"state"`
+	// The leader's ID, absent while there is none.
+	Leader      string `json:"leader,omitempty" msgpack:"leader,omitempty"`
+	LeaderURL   string `json:"leader_url,omitempty" msgpack:"leader_url,omitempty"`
+	Term        uint64 `json:"term" msgpack:"term"`
+	CommitIndex uint64 `json:"commit_index" msgpack:"commit_index"`
+	// The last log entry applied to the cache here.
+	AppliedIndex uint64       `json:"applied_index" msgpack:"applied_index"`
+	Peers        []RaftServer `json:"peers" msgpack:"peers"`
+}
+
+type RegionApplyResponse struct {
+	Applied int `json:"applied" msgpack:"applied"`
+	// Changes older than the entry or tombstone here, which were ignored.
+	Stale int `json:"stale" msgpack:"stale"`
+}
+
+type RegionChange struct {
+	Op    string `json:"op" msgpack:"op"`
+	Key   string `json:"key" msgpack:"key"`
+	Value string `json:"value,omitempty" msgpack:"value,omitempty"`
+	// When a set entry expires.
+	ExpiresAt time.Time `json:"expires_at,omitempty" msgpack:"expires_at,omitempty"`
+	// When the change was made, in its region; the later change to a key wins, and of two at once the one from the region whose name sorts last.
+	StoredAt time.Time `json:"stored_at" msgpack:"stored_at"`
+	Flags    int64     `json:"flags,omitempty" msgpack:"flags,omitempty"`
+}
+
+type RegionChangeList struct {
+	// The region the changes were made in.
+	Region  string         `json:"region" msgpack:"region"`
+	Changes []RegionChange `json:"changes" msgpack:"changes"`
+}
+
+type ReplicationStatus struct {
+	// A replica that has taken over from its primary reports primary.
+	Role string `json:"role" msgpack:"role"`
+	// Replicas streaming from this node.
+	Replicas int `json:"replicas" msgpack:"replicas"`
+	// The primary this node replicates, or did until it took over.
+	Primary   string `json:"primary,omitempty" msgpack:"primary,omitempty"`
+	Connected bool   `json:"connected,omitempty" msgpack:"connected,omitempty"`
+	// Since the last line from the primary.
+	SecondsSinceContact float64 `json:"seconds_since_contact,omitempty" msgpack:"seconds_since_contact,omitempty"`
+	// Records applied from the primary since startup.
+	Applied   uint64 `json:"applied,omitempty" msgpack:"applied,omitempty"`
+	FullSyncs uint64 `json:"full_syncs,omitempty" msgpack:"full_syncs,omitempty"`
+	// Whether the replica took over after losing its primary. It does
+	// not step down when the primary returns.
+	Promoted bool `json:"promoted,omitempty" msgpack:"promoted,omitempty"`
+}
+
+type ResizeRequest struct {
+	Capacity int `json:"capacity" msgpack:"capacity"`
+}
+
+type ResizeResponse struct {
+	Capacity int `json:"capacity" msgpack:"capacity"`
+	// Entries evicted to fit the new capacity.
+	Evicted int `json:"evicted" msgpack:"evicted"`
+}
+
+type RestoreRequest struct {
+	Name string `json:"name" msgpack:"name"`
+	// Defaults to backup.
+	Kind string `json:"kind,omitempty" msgpack:"kind,omitempty"`
+}
+
+type RestoreResponse struct {
+	Restored int `json:"restored" msgpack:"restored"`
+	// Entries in the backup that have expired since, and were skipped.
+	Expired int `json:"expired" msgpack:"expired"`
+}
+
+type SlowOperation = cache.SlowOperation
+
+type SlowOperationLog = cache.SlowOperationLog
+
+type Stats = cache.Stats
+
+type V1Entry struct {
+	Key   string `json:"key" msgpack:"key"`
+	Value string `json:"value" msgpack:"value"`
+	// Seconds until the entry expires. On writes, the TTL to apply;
+	// zero or absent uses the server default.
+	TTL int64 `json:"ttl,omitempty" msgpack:"ttl,omitempty"`
+	// When the entry expires. Ignored on writes.
+	ExpiresAt time.Time `json:"expires_at,omitempty" msgpack:"expires_at,omitempty"`
+	// Changes on every write to the entry; also sent as the ETag. Ignored on writes.
+	Version uint64 `json:"version,omitempty" msgpack:"version,omitempty"`
+}
+
+type V1EntryList struct {
+	Entries []V1Entry `json:"entries" msgpack:"entries"`
+}
+
+type V1PutRequest struct {
+	Value string `json:"value" msgpack:"value"`
+	// Seconds to keep the entry; zero or absent uses the server default.
+	TTL int64 `json:"ttl,omitempty" msgpack:"ttl,omitempty"`
+}
+
+type Webhook struct {
+	// Assigned on registration; ignored in requests.
+	ID string `json:"id,omitempty" msgpack:"id,omitempty"`
+	// The http or https URL events are POSTed to.
+	URL string `json:"url" msgpack:"url"`
+	// Only keys in this namespace; empty is any.
+	Namespace string `json:"namespace,omitempty" msgpack:"namespace,omitempty"`
+	// Only keys matching this pattern (path.Match syntax); empty is any.
+	Pattern string `json:"pattern,omitempty" msgpack:"pattern,omitempty"`
+	// The event types to send; empty is both.
+	Events []string `json:"events,omitempty" msgpack:"events,omitempty"`
+}
+
+type WebhookList struct {
+	Webhooks []Webhook `json:"webhooks" msgpack:"webhooks"`
+}
+
+// apiServer has one handler per operation in the spec.
+type apiServer interface {
+	GetHandler(w http.ResponseWriter, r *http.Request)
+	MGetHandler(w http.ResponseWriter, r *http.Request)
+	SetHandler(w http.ResponseWriter, r *http.Request)
+	MSetHandler(w http.ResponseWriter, r *http.Request)
+	DeleteHandler(w http.ResponseWriter, r *http.Request)
+	StatsHandler(w http.ResponseWriter, r *http.Request)
+	EfficiencyHandler(w http.ResponseWriter, r *http.Request)
+	HotKeysHandler(w http.ResponseWriter, r *http.Request)
+	EventsHandler(w http.ResponseWriter, r *http.Request)
+	WebSocketHandler(w http.ResponseWriter, r *http.Request)
+	ImportHandler(w http.ResponseWriter, r *http.Request)
+	ExportHandler(w http.ResponseWriter, r *http.Request)
+	FlushHandler(w http.ResponseWriter, r *http.Request)
+	V1GetHandler(w http.ResponseWriter, r *http.Request)
+	V1HeadHandler(w http.ResponseWriter, r *http.Request)
+	V1PutHandler(w http.ResponseWriter, r *http.Request)
+	V1DeleteHandler(w http.ResponseWriter, r *http.Request)
+	V1MGetHandler(w http.ResponseWriter, r *http.Request)
+	V1MSetHandler(w http.ResponseWriter, r *http.Request)
+	V1FlushHandler(w http.ResponseWriter, r *http.Request)
+	V1ResizeHandler(w http.ResponseWriter, r *http.Request)
+	V1TuneCacheHandler(w http.ResponseWriter, r *http.Request)
+	V1BackupHandler(w http.ResponseWriter, r *http.Request)
+	V1ListBackupsHandler(w http.ResponseWriter, r *http.Request)
+	V1RestoreHandler(w http.ResponseWriter, r *http.Request)
+	V1EvictionsHandler(w http.ResponseWriter, r *http.Request)
+	V1MembersHandler(w http.ResponseWriter, r *http.Request)
+	V1ReplicationHandler(w http.ResponseWriter, r *http.Request)
+	V1RaftHandler(w http.ResponseWriter, r *http.Request)
+	V1ReplicationStreamHandler(w http.ResponseWriter, r *http.Request)
+	V1SlowOpsHandler(w http.ResponseWriter, r *http.Request)
+	V1ReloadConfigHandler(w http.ResponseWriter, r *http.Request)
+	V1GetLogLevelsHandler(w http.ResponseWriter, r *http.Request)
+	V1SetLogLevelsHandler(w http.ResponseWriter, r *http.Request)
+	V1ListWebhooksHandler(w http.ResponseWriter, r *http.Request)
+	V1AddWebhookHandler(w http.ResponseWriter, r *http.Request)
+	V1DeleteWebhookHandler(w http.ResponseWriter, r *http.Request)
+	V1ClusterHandler(w http.ResponseWriter, r *http.Request)
+	V1MigrateHandler(w http.ResponseWriter, r *http.Request)
+	V1RegionReplicateHandler(w http.ResponseWriter, r *http.Request)
+	GraphQLWebSocketHandler(w http.ResponseWriter, r *http.Request)
+	GraphQLHandler(w http.ResponseWriter, r *http.Request)
+}
+
+type apiRoute struct {
+	Method     string
+	Path       string
+	Permission permission
+	Streaming  bool
+	Idempotent bool
+	AnyEngine  bool
+	handler    func(apiServer, http.ResponseWriter, *http.Request)
+}
+
+var apiRoutes = []apiRoute{
+	{Method: "GET", Path: "/cache/get", Permission: permRead, AnyEngine: true, handler: apiServer.GetHandler},
+	{Method: "GET", Path: "/cache/mget", Permission: permRead, AnyEngine: true, handler: apiServer.MGetHandler},
+	{Method: "POST", Path: "/cache/set", Permission: permWrite, Idempotent: true, AnyEngine: true, handler: apiServer.SetHandler},
+	{Method: "POST", Path: "/cache/mset", Permission: permWrite, Idempotent: true, AnyEngine: true, handler: apiServer.MSetHandler},
+	{Method: "DELETE", Path: "/cache/delete", Permission: permWrite, AnyEngine: true, handler: apiServer.DeleteHandler},
+	{Method: "GET", Path: "/cache/stats", Permission: permRead, AnyEngine: true, handler: apiServer.StatsHandler},
+	{Method: "GET", Path: "/cache/stats/efficiency", Permission: permRead, handler: apiServer.EfficiencyHandler},
+	{Method: "GET", Path: "/cache/stats/hotkeys", Permission: permRead, handler: apiServer.HotKeysHandler},
+	{Method: "GET", Path: "/cache/events", Permission: permRead, Streaming: true, handler: apiServer.EventsHandler},
+	{Method: "GET", Path: "/cache/ws", Permission: permRead, Streaming: true, handler: apiServer.WebSocketHandler},
+	{Method: "POST", Path: "/cache/import", Permission: permAdmin, Idempotent: true, handler: apiServer.ImportHandler},
+	{Method: "GET", Path: "/cache/export", Permission: permAdmin, Streaming: true, handler: apiServer.ExportHandler},
+	{Method: "POST", Path: "/cache/flush", Permission: permAdmin, handler: apiServer.FlushHandler},
+	{Method: "GET", Path: "/v1/keys/{key}", Permission: permRead, AnyEngine: true, handler: apiServer.V1GetHandler},
+	{Method: "HEAD", Path: "/v1/keys/{key}", Permission: permRead, handler: apiServer.V1HeadHandler},
+	{Method: "PUT", Path: "/v1/keys/{key}", Permission: permWrite, Idempotent: true, AnyEngine: true, handler: apiServer.V1PutHandler},
+	{Method: "DELETE", Path: "/v1/keys/{key}", Permission: permWrite, AnyEngine: true, handler: apiServer.V1DeleteHandler},
+	{Method: "GET", Path: "/v1/keys", Permission: permRead, AnyEngine: true, handler: apiServer.V1MGetHandler},
+	{Method: "POST", Path: "/v1/keys", Permission: permWrite, Idempotent: true, AnyEngine: true, handler: apiServer.V1MSetHandler},
+	{Method: "DELETE", Path: "/v1/keys", Permission: permAdmin, handler: apiServer.V1FlushHandler},
+	{Method: "POST", Path: "/v1/import", Permission: permAdmin, Idempotent: true, handler: apiServer.ImportHandler},
+	{Method: "GET", Path: "/v1/export", Permission: permAdmin, Streaming: true, handler: apiServer.ExportHandler},
+	{Method: "POST", Path: "/v1/admin/resize", Permission: permAdmin, handler: apiServer.V1ResizeHandler},
+	{Method: "PATCH", Path: "/v1/admin/cache", Permission: permAdmin, handler: apiServer.V1TuneCacheHandler},
+	{Method: "POST", Path: "/v1/admin/backup", Permission: permAdmin, Streaming: true, handler: apiServer.V1BackupHandler},
+	{Method: "GET", Path: "/v1/admin/backups", Permission: permAdmin, handler: apiServer.V1ListBackupsHandler},
+	{Method: "POST", Path: "/v1/admin/restore", Permission: permAdmin, handler: apiServer.V1RestoreHandler},
+	{Method: "GET", Path: "/v1/admin/evictions", Permission: permAdmin, handler: apiServer.V1EvictionsHandler},
+	{Method: "GET", Path: "/v1/admin/members", Permission: permAdmin, handler: apiServer.V1MembersHandler},
+	{Method: "GET", Path: "/v1/admin/replication", Permission: permAdmin, handler: apiServer.V1ReplicationHandler},
+	{Method: "GET", Path: "/v1/admin/raft", Permission: permAdmin, handler: apiServer.V1RaftHandler},
+	{Method: "GET", Path: "/v1/admin/replication/stream", Permission: permAdmin, Streaming: true, handler: apiServer.V1ReplicationStreamHandler},
+	{Method: "GET", Path: "/v1/admin/slow-ops", Permission: permAdmin, handler: apiServer.V1SlowOpsHandler},
+	{Method: "POST", Path: "/v1/admin/config", Permission: permAdmin, handler: apiServer.V1ReloadConfigHandler},
+	{Method: "GET", Path: "/v1/admin/log-levels", Permission: permAdmin, AnyEngine: true, handler: apiServer.V1GetLogLevelsHandler},
+	{Method: "PUT", Path: "/v1/admin/log-levels", Permission: permAdmin, AnyEngine: true, handler: apiServer.V1SetLogLevelsHandler},
+	{Method: "GET", Path: "/v1/admin/webhooks", Permission: permAdmin, handler: apiServer.V1ListWebhooksHandler},
+	{Method: "POST", Path: "/v1/admin/webhooks", Permission: permAdmin, handler: apiServer.V1AddWebhookHandler},
+	{Method: "DELETE", Path: "/v1/admin/webhooks/{id}", Permission: permAdmin, handler: apiServer.V1DeleteWebhookHandler},
+	{Method: "GET", Path: "/v1/stats", Permission: permRead, AnyEngine: true, handler: apiServer.StatsHandler},
+	{Method: "GET", Path: "/v1/stats/efficiency", Permission: permRead, handler: apiServer.EfficiencyHandler},
+	{Method: "GET", Path: "/v1/stats/hotkeys", Permission: permRead, handler: apiServer.HotKeysHandler},
+	{Method: "GET", Path: "/v1/cluster", Permission: permRead, handler: apiServer.V1ClusterHandler},
+	{Method: "POST", Path: "/v1/cluster/migrate", Permission: permWrite, handler: apiServer.V1MigrateHandler},
+	{Method: "POST", Path: "/v1/regions/replicate", Permission: permWrite, handler: apiServer.V1RegionReplicateHandler},
+	{Method: "GET", Path: "/v1/events", Permission: permRead, Streaming: true, handler: apiServer.EventsHandler},
+	{Method: "GET", Path: "/v1/ws", Permission: permRead, Streaming: true, handler: apiServer.WebSocketHandler},
+	{Method: "GET", Path: "/graphql", Permission: permRead, Streaming: true, handler: apiServer.GraphQLWebSocketHandler},
+	{Method: "POST", Path: "/graphql", Permission: permRead, handler: apiServer.GraphQLHandler},
+}
+
+// Pattern is the ServeMux pattern for the route.
+func (r apiRoute) Pattern() string {
+	return r.Method + " " + r.Path
+}
+
+// registerAPIRoutes passes every spec operation to register, which decides
+// where it is mounted and what per-route middleware it gets.
+func registerAPIRoutes(s apiServer, register func(apiRoute, http.Handler)) {
+	for _, route := range apiRoutes {
+		handler := route.handler
+		register(route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { handler(s, w, r) }))
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"myproject/cache"
+)
+
+// withClient starts a client identity for requests from addr; the
+// authentication that follows fills in the principal.
+func withClient(ctx context.Context, addr string) context.Context {
+	return cache.WithClient(ctx, addr)
+}
+
+func setPrincipal(ctx context.Context, principal string) {
+	cache.SetPrincipal(ctx, principal)
+}
+
+// apiKeyPrincipal names an API key by a prefix of its SHA-256, which is
+// enough to tell keys apart in the audit log without recording them.
+func apiKeyPrincipal(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "api-key:" + hex.EncodeToString(sum[:6])
+}
+
+// clientMiddleware records the remote address of each request for the
+// audit log.
+func clientMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(withClient(r.Context(), r.RemoteAddr)))
+	})
+}
+
+// auditRecord is one line of the audit file, or one element of a webhook
+// batch. Values are never recorded.
+type auditRecord struct {
+	Time      time.Time `json:"time"`
+	Op        string    `json:"op"`
+	Key       string    `json:"key"`
+	Reason    string    `json:"reason,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Addr      string    `json:"addr,omitempty"`
+	Principal string    `json:"principal,omitempty"`
+}
+
+// auditLog records every set and delete, with who made it, to a file
+// that is rotated by size, to a webhook, or both. Like the Kafka sink it
+// queues records for a background writer and drops them, with a log line,
+// rather than stall the cache when the writer falls behind.
+type auditLog struct {
+	config  AuditConfig
+	queue   chan auditRecord
+	dropped atomic.Uint64
+	client  *http.Client
+	f       *os.File
+	w       *bufio.Writer
+	size    int64
+	done    sync.WaitGroup
+}
+
+func openAuditLog(config AuditConfig) (*auditLog, error) {
+	l := &auditLog{
+		config: config,
+		queue:  make(chan auditRecord, config.QueueSize),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	if config.Path != "" {
+		if err := l.openFile(); err != nil {
+			return nil, err
+		}
+	}
+	l.done.Add(1)
+	go l.run()
+	return l, nil
+}
+
+// record queues e. It is the cache's audit sink, so the caller holds the
+// cache lock.
+func (l *auditLog) record(e CacheEvent, client clientIdentity) {
+	select {
+	case l.queue <- auditRecord{Time: e.Time, Op: e.Type, Key: e.Key, Reason: e.Reason, RequestID: e.RequestID, Addr: client.Addr, Principal: client.Principal}:
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+func (l *auditLog) run() {
+	defer l.done.Done()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	batchSize := max(l.config.BatchSize, 1)
+	for {
+		select {
+		case record, ok := <-l.queue:
+			if !ok {
+				return
+			}
+			l.write(drainBatch(l.queue, record, batchSize))
+		case <-ticker.C:
+			if n := l.dropped.Swap(0); n > 0 {
+				serverLog.Warn("Audit log dropped records in the last minute; queue full", "dropped", n)
+			}
+		}
+	}
+}
+
+func (l *auditLog) write(batch []auditRecord) {
+	if l.f != nil {
+		if err := l.writeFile(batch); err != nil {
+			serverLog.Error("Failed to write audit file", "path", l.config.Path, "records", len(batch), "err", err)
+		}
+	}
+	if l.config.WebhookURL != "" {
+		if err := postJSON(l.client, l.config.WebhookURL, batch); err != nil {
+			serverLog.Error("Failed to send audit records to webhook", "records", len(batch), "err", err)
+		}
+	}
+}
+
+func (l *auditLog) openFile() error {
+	f, err := os.OpenFile(l.config.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, info.Size()
+	if l.w == nil {
+		l.w = bufio.NewWriterSize(f, 64<<10)
+	} else {
+		l.w.Reset(f)
+	}
+	return nil
+}
+
+func (l *auditLog) writeFile(batch []auditRecord) error {
+	for _, record := range batch {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if l.config.MaxBytes > 0 && l.size > 0 && l.size+int64(len(line))+1 > l.config.MaxBytes {
+			if err := l.rotate(); err != nil {
+				return fmt.Errorf("rotating: %w", err)
+			}
+		}
+		l.w.Write(line)
+		l.w.WriteByte('\n')
+		l.size += int64(len(line)) + 1
+	}
+	return l.w.Flush()
+}
+
+// rotate renames the file with the time it was closed and starts a new
+// one, deleting the oldest rotated files beyond MaxFiles.
+func (l *auditLog) rotate() error {
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	l.f.Close()
+	rotated := l.config.Path + "." + time.Now().UTC().Format("20060102T150405.000000000")
+	if err := os.Rename(l.config.Path, rotated); err != nil {
+		return err
+	}
+	if err := l.openFile(); err != nil {
+		return err
+	}
+	if l.config.MaxFiles > 0 {
+		// The timestamps sort in the order the files were rotated.
+		old, err := filepath.Glob(l.config.Path + ".*")
+		if err != nil {
+			return err
+		}
+		slices.Sort(old)
+		for len(old) > l.config.MaxFiles {
+			os.Remove(old[0])
+			old = old[1:]
+		}
+	}
+	return nil
+}
+
+// Close writes out what is queued and closes the file. The cache must no
+// longer be calling record.
+func (l *auditLog) Close() error {
+	close(l.queue)
+	l.done.Wait()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Sync()
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
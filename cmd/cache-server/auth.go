@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type permission int
+
+const (
+	permRead permission = iota + 1
+	permWrite
+	permAdmin
+)
+
+func parsePermission(s string) (permission, error) {
+	switch strings.ToLower(s) {
+	case "read", "read-only", "ro":
+		return permRead, nil
+	case "write", "read-write", "rw":
+		return permWrite, nil
+	case "admin":
+		return permAdmin, nil
+	}
+	return 0, fmt.Errorf("unknown permission %q", s)
+}
+
+// authenticator accepts either an X-API-Key header or an Authorization
+// bearer token, depending on which mechanisms are configured.
+type authenticator struct {
+	apiKeys *apiKeyAuth
+	jwt     *jwtAuth
+	// limiter, if set, charges requests refused for their credentials to
+	// their IP, as they never reach the rate limit behind Require.
+	limiter *rateLimiter
+}
+
+func newAuthenticator(config AuthConfig, jwtConfig JWTConfig, namespaces map[string]NamespaceConfig) (*authenticator, error) {
+	a := &authenticator{}
+	if config.Enabled {
+		apiKeys, err := newAPIKeyAuth(config, namespaces)
+		if err != nil {
+			return nil, err
+		}
+		a.apiKeys = apiKeys
+	}
+	if jwtConfig.Enabled {
+		j, err := newJWTAuth(jwtConfig)
+		if err != nil {
+			return nil, err
+		}
+		a.jwt = j
+	}
+	return a, nil
+}
+
+func (a *authenticator) Require(perm permission, next http.Handler) http.Handler {
+	if !a.enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		granted, principal, status, msg := a.authenticate(r)
+		if status != 0 {
+			if !a.limiter.allow(w, "ip:"+remoteIP(r)) {
+				return
+			}
+			if status == http.StatusUnauthorized && a.jwt != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="cache"`)
+			}
+			http.Error(w, msg, status)
+			return
+		}
+		if granted < perm {
+			http.Error(w, "Insufficient permissions", http.StatusForbidden)
+			return
+		}
+		setPrincipal(r.Context(), principal)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), permissionKey{}, granted)))
+	})
+}
+
+// authenticate returns the caller's permission and principal, or the
+// status and message to refuse it with.
+func (a *authenticator) authenticate(r *http.Request) (permission, string, int, string) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && a.jwt != nil {
+		perm, principal, err := a.jwt.permission(strings.TrimSpace(token))
+		if err != nil {
+			return 0, "", http.StatusUnauthorized, "Invalid bearer token"
+		}
+		return perm, principal, 0, ""
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" && a.apiKeys != nil {
+		perm, ok := a.apiKeys.lookup(key)
+		if !ok {
+			perm, ok = a.apiKeys.lookupScoped(key, r.PathValue("key"))
+		}
+		if !ok {
+			return 0, "", http.StatusUnauthorized, "Invalid API key"
+		}
+		return perm, apiKeyPrincipal(key), 0, ""
+	}
+	return 0, "", http.StatusUnauthorized, "Missing credentials"
+}
+
+type permissionKey struct{}
+
+// permitted reports whether the caller behind ctx holds perm, for handlers
+// such as GraphQL whose operations need more than the route's permission.
+// Without authentication everything is permitted.
+func permitted(ctx context.Context, perm permission) bool {
+	granted, ok := ctx.Value(permissionKey{}).(permission)
+	return !ok || granted >= perm
+}
+
+// enabled reports whether any credential is required.
+func (a *authenticator) enabled() bool {
+	return a.apiKeys != nil || a.jwt != nil
+}
+
+// credential checks a secret presented outside HTTP, such as a RESP AUTH
+// password, as an API key and then as a bearer token. It returns the
+// principal for the audit log with the permission.
+func (a *authenticator) credential(secret string) (permission, string, bool) {
+	if a.apiKeys != nil {
+		if perm, ok := a.apiKeys.lookup(secret); ok {
+			return perm, apiKeyPrincipal(secret), true
+		}
+	}
+	if a.jwt != nil {
+		if perm, principal, err := a.jwt.permission(secret); err == nil {
+			return perm, principal, true
+		}
+	}
+	return 0, "", false
+}
+
+// apiKeyAuth validates the X-API-Key header against keys from the config
+// and, optionally, a keys file that is re-read whenever it changes.
+type apiKeyAuth struct {
+	config  AuthConfig
+	mutex   sync.RWMutex
+	keys    map[string]permission
+	modTime time.Time
+	// scoped holds the namespaces' own keys, by key and then namespace.
+	scoped map[string]map[string]permission
+}
+
+func newAPIKeyAuth(config AuthConfig, namespaces map[string]NamespaceConfig) (*apiKeyAuth, error) {
+	a := &apiKeyAuth{config: config, scoped: make(map[string]map[string]permission)}
+	for name, ns := range namespaces {
+		for key, p := range ns.Keys {
+			perm, err := parsePermission(p)
+			if err != nil {
+				return nil, fmt.Errorf("namespace %q: api key %q: %w", name, key, err)
+			}
+			if a.scoped[key] == nil {
+				a.scoped[key] = make(map[string]permission)
+			}
+			a.scoped[key][name] = perm
+		}
+	}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	go a.startReloadRoutine()
+	return a, nil
+}
+
+// update replaces the configured keys and keys file, keeping the old ones
+// if the new ones do not load.
+func (a *apiKeyAuth) update(config AuthConfig) error {
+	a.mutex.Lock()
+	previous := a.config
+	a.config.Keys, a.config.KeysFile = config.Keys, config.KeysFile
+	a.mutex.Unlock()
+	if err := a.reload(); err != nil {
+		a.mutex.Lock()
+		a.config = previous
+		a.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (a *apiKeyAuth) lookup(key string) (permission, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	perm, ok := a.keys[key]
+	return perm, ok
+}
+
+// lookupScoped finds a namespace's key. It grants nothing, though the key
+// is valid, unless cacheKey, the key a request is for, is in one of its
+// namespaces.
+func (a *apiKeyAuth) lookupScoped(key, cacheKey string) (permission, bool) {
+	namespaces, ok := a.scoped[key]
+	if !ok {
+		return 0, false
+	}
+	if cacheKey == "" {
+		return 0, true
+	}
+	return namespaces[namespaceOf(cacheKey)], true
+}
+
+func (a *apiKeyAuth) reload() error {
+	a.mutex.RLock()
+	config := a.config
+	a.mutex.RUnlock()
+
+	keys := make(map[string]permission, len(config.Keys))
+	for key, p := range config.Keys {
+		perm, err := parsePermission(p)
+		if err != nil {
+			return fmt.Errorf("api key %q: %w", key, err)
+		}
+		keys[key] = perm
+	}
+
+	var modTime time.Time
+	if config.KeysFile != "" {
+		info, err := os.Stat(config.KeysFile)
+		if err != nil {
+			return err
+		}
+		modTime = info.ModTime()
+		if err := readKeysFile(config.KeysFile, keys); err != nil {
+			return err
+		}
+	}
+
+	a.mutex.Lock()
+	a.keys = keys
+	a.modTime = modTime
+	a.mutex.Unlock()
+	return nil
+}
+
+func (a *apiKeyAuth) startReloadRoutine() {
+	ticker := time.Tick(a.config.ReloadInterval)
+	for range ticker {
+		a.mutex.RLock()
+		path := a.config.KeysFile
+		a.mutex.RUnlock()
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			authLog.Error("Failed to stat API keys file", "err", err)
+			continue
+		}
+		a.mutex.RLock()
+		changed := !info.ModTime().Equal(a.modTime)
+		a.mutex.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := a.reload(); err != nil {
+			authLog.Error("Failed to reload API keys, keeping previous set", "err", err)
+		}
+	}
+}
+
+// readKeysFile parses lines of the form "<key> <read|read-write>". Blank
+// lines and lines starting with # are ignored.
+func readKeysFile(path string, keys map[string]permission) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: expected \"<key> <permission>\"", path, line)
+		}
+		perm, err := parsePermission(fields[1])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		keys[fields[0]] = perm
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var backupNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// V1BackupHandler snapshots the cache on demand. The entries are copied
+// under the lock in one pass, so the backup is a single point in time;
+// encoding and writing happen after the lock is released.
+func (h *CacheHandler) V1BackupHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		items := h.lru.Entries()
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="cache-`+time.Now().UTC().Format("20060102T150405Z")+`.ndjson"`)
+		encodeSnapshot(w, slices.Values(items), h.cipher)
+		return
+	}
+
+	if h.backupDir == "" {
+		writeError(w, http.StatusBadRequest, "no backup directory is configured")
+		return
+	}
+	if !backupNamePattern.MatchString(name) {
+		writeError(w, http.StatusBadRequest, "name must be letters, digits, '.', '_' or '-', starting with a letter or digit")
+		return
+	}
+	if err := os.MkdirAll(h.backupDir, 0o700); err != nil {
+		persistenceLog.Error("Failed to create backup directory", "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to write backup")
+		return
+	}
+	path := filepath.Join(h.backupDir, name)
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusConflict, "backup "+name+" already exists")
+		return
+	}
+
+	n, err := writeSnapshot(path, slices.Values(h.lru.Entries()), h.cipher)
+	if err != nil {
+		persistenceLog.Error("Failed to write backup", "path", path, "err", err)
+		writeError(w, http.StatusInternalServerError, "failed to write backup")
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to write backup")
+		return
+	}
+	persistenceLog.Info("Wrote backup", "entries", n, "path", path)
+	writeResponse(w, r, &BackupResponse{Name: name, Entries: n, Bytes: info.Size()})
+}
+
+const (
+	backupKindBackup   = "backup"
+	backupKindSnapshot = "snapshot"
+)
+
+// V1ListBackupsHandler lists the named backups in the backup directory and
+// the configured snapshot and its predecessor.
+func (h *CacheHandler) V1ListBackupsHandler(w http.ResponseWriter, r *http.Request) {
+	list := &BackupList{Backups: []BackupInfo{}}
+	if h.backupDir != "" {
+		files, err := os.ReadDir(h.backupDir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			persistenceLog.Error("Failed to list backups", "err", err)
+			writeError(w, http.StatusInternalServerError, "failed to list backups")
+			return
+		}
+		for _, file := range files {
+			// Skip backups still being written.
+			if !file.Type().IsRegular() || !backupNamePattern.MatchString(file.Name()) || strings.Contains(file.Name(), ".tmp-") {
+				continue
+			}
+			info, err := file.Info()
+			if err != nil {
+				continue
+			}
+			list.Backups = append(list.Backups, BackupInfo{Name: file.Name(), Kind: backupKindBackup, Bytes: info.Size(), ModifiedAt: info.ModTime()})
+		}
+	}
+	if h.snapshotPath != "" {
+		for _, name := range []string{"snapshot", "snapshot.prev"} {
+			path, _ := h.backupPath(backupKindSnapshot, name)
+			size, modTime, err := statSnapshot(r.Context(), path)
+			if err != nil {
+				if !errors.Is(err, os.ErrNotExist) {
+					persistenceLog.Error("Failed to stat snapshot", "path", path, "err", err)
+				}
+				continue
+			}
+			list.Backups = append(list.Backups, BackupInfo{Name: name, Kind: backupKindSnapshot, Bytes: size, ModifiedAt: modTime})
+		}
+	}
+	slices.SortFunc(list.Backups, func(a, b BackupInfo) int { return b.ModifiedAt.Compare(a.ModifiedAt) })
+	writeResponse(w, r, list)
+}
+
+// backupPath returns where the backup or snapshot named name is, or false
+// if there is no such name.
+func (h *CacheHandler) backupPath(kind, name string) (string, bool) {
+	switch kind {
+	case backupKindBackup, "":
+		if h.backupDir == "" || !backupNamePattern.MatchString(name) {
+			return "", false
+		}
+		return filepath.Join(h.backupDir, name), true
+	case backupKindSnapshot:
+		switch {
+		case h.snapshotPath == "":
+		case name == "snapshot":
+			return h.snapshotPath, true
+		case name == "snapshot.prev":
+			return snapshotPrevPath(h.snapshotPath), true
+		}
+	}
+	return "", false
+}
+
+func statSnapshot(ctx context.Context, path string) (int64, time.Time, error) {
+	if isObjectURL(path) {
+		return statObject(ctx, path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return info.Size(), info.ModTime(), nil
+}
+
+// V1RestoreHandler replaces the cache contents with a backup. The backup
+// is read in full before the flush, so that a damaged one changes
+// nothing; unlike at startup, there is no falling back to an older one.
+func (h *CacheHandler) V1RestoreHandler(w http.ResponseWriter, r *http.Request) {
+	var req RestoreRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Kind != "" && req.Kind != backupKindBackup && req.Kind != backupKindSnapshot {
+		writeError(w, http.StatusBadRequest, "kind must be backup or snapshot")
+		return
+	}
+	path, ok := h.backupPath(req.Kind, req.Name)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "no backup can have that name")
+		return
+	}
+
+	start := time.Now()
+	writes, expired, err := readSnapshot(r.Context(), path, h.cipher)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		writeError(w, http.StatusNotFound, "no backup "+req.Name)
+		return
+	case err != nil:
+		persistenceLog.Error("Failed to read backup", "path", path, "err", err)
+		writeError(w, http.StatusUnprocessableEntity, "backup "+req.Name+" cannot be restored: "+err.Error())
+		return
+	}
+
+	h.lru.Flush(r.Context())
+	loadWrites(r.Context(), h.lru, writes)
+	persistenceLog.Info("Restored backup", "entries", len(writes), "path", path, "took", time.Since(start).Round(time.Millisecond), "expired", expired)
+	writeResponse(w, r, &RestoreResponse{Restored: len(writes), Expired: expired})
+}
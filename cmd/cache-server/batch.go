@@ -0,0 +1,21 @@
+package main
+
+// drainBatch returns first with whatever else is already queued on ch, up
+// to limit in all, without waiting for more. It stops at a closed channel,
+// for the caller's next receive to find closed. The webhook, Kafka, Redis
+// tier, region and audit sinks batch their writes with it.
+func drainBatch[T any](ch <-chan T, first T, limit int) []T {
+	batch := []T{first}
+	for len(batch) < limit {
+		select {
+		case v, ok := <-ch:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, v)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
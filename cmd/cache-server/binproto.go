@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// The binary protocol: length-prefixed frames for clients too small to
+// parse HTTP or RESP. The format is documented, with a reference encoder,
+// in client/binary.go. Requests may be pipelined; responses come back in
+// order, each carrying its request's opaque value.
+
+const (
+	binOpNoop   = 0x00
+	binOpGet    = 0x01
+	binOpSet    = 0x02
+	binOpDelete = 0x03
+	binOpAuth   = 0x04
+)
+
+const (
+	binStatusOK           = 0x00
+	binStatusNotFound     = 0x01
+	binStatusBadRequest   = 0x02
+	binStatusUnauthorized = 0x03
+	binStatusForbidden    = 0x04
+	binStatusServerError  = 0x05
+)
+
+// binHeaderLen is the op and opaque that follow every length prefix.
+const binHeaderLen = 5
+
+type binaryServer struct {
+	connServer
+	cache    *LRUCache
+	auth     *authenticator
+	maxFrame int
+}
+
+func newBinaryServer(cache *LRUCache, auth *authenticator, maxBody int64) *binaryServer {
+	s := &binaryServer{
+		cache: cache,
+		auth:  auth,
+		// Room for a full-size value plus the set header and a long key.
+		maxFrame: int(maxBody) + binHeaderLen + 6 + 64<<10,
+	}
+	s.connServer.serve = s.serveConn
+	return s
+}
+
+var errBinaryFrame = errors.New("frame too large")
+
+func (s *binaryServer) serveConn(conn net.Conn) {
+	ctx := withClient(withRequestID(context.Background(), "bin-"+newRequestID()), conn.RemoteAddr().String())
+	perm := permAdmin
+	if s.auth.enabled() {
+		perm = 0
+	}
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	var frame []byte
+	for {
+		var err error
+		frame, err = readBinaryFrame(r, frame, s.maxFrame)
+		if err != nil {
+			if errors.Is(err, errBinaryFrame) {
+				writeBinaryFrame(w, binStatusBadRequest, 0, []byte(err.Error()))
+				w.Flush()
+			}
+			return
+		}
+		if len(frame) < binHeaderLen {
+			writeBinaryFrame(w, binStatusBadRequest, 0, []byte("short frame"))
+			w.Flush()
+			return
+		}
+		op, opaque, body := frame[0], binary.BigEndian.Uint32(frame[1:5]), frame[5:]
+		status, resp := s.exec(ctx, &perm, op, body)
+		writeBinaryFrame(w, status, opaque, resp)
+		if r.Buffered() == 0 && w.Flush() != nil {
+			return
+		}
+	}
+}
+
+func (s *binaryServer) exec(ctx context.Context, perm *permission, op byte, body []byte) (byte, []byte) {
+	switch op {
+	case binOpNoop:
+		return binStatusOK, nil
+	case binOpAuth:
+		granted, principal, ok := s.auth.credential(string(body))
+		if !ok {
+			return binStatusUnauthorized, []byte("invalid credentials")
+		}
+		*perm = granted
+		setPrincipal(ctx, principal)
+		return binStatusOK, nil
+	}
+
+	required := permRead
+	switch op {
+	case binOpGet:
+	case binOpSet, binOpDelete:
+		required = permWrite
+	default:
+		return binStatusBadRequest, []byte("unknown op")
+	}
+	switch {
+	case *perm == 0:
+		return binStatusUnauthorized, []byte("authentication required")
+	case *perm < required:
+		return binStatusForbidden, []byte("insufficient permissions")
+	}
+
+	switch op {
+	case binOpGet:
+		if len(body) == 0 {
+			return binStatusBadRequest, []byte("key must not be empty")
+		}
+		item, found, err := s.cache.GetOrLoad(ctx, string(body))
+		switch {
+		case err != nil:
+			return binStatusServerError, []byte("loading from origin failed")
+		case !found:
+			return binStatusNotFound, nil
+		}
+		resp := make([]byte, 12, 12+len(item.Value))
+		binary.BigEndian.PutUint32(resp[0:4], uint32(max(remainingSeconds(item.ExpiresAt), 0)))
+		binary.BigEndian.PutUint64(resp[4:12], item.Version)
+		return binStatusOK, append(resp, item.Value...)
+	case binOpSet:
+		// ttl u32, key length u16, key, value.
+		if len(body) < 6 {
+			return binStatusBadRequest, []byte("short set")
+		}
+		ttl := time.Duration(binary.BigEndian.Uint32(body[0:4])) * time.Second
+		keyLen := int(binary.BigEndian.Uint16(body[4:6]))
+		if keyLen == 0 || len(body) < 6+keyLen {
+			return binStatusBadRequest, []byte("bad key length")
+		}
+		key, value := string(body[6:6+keyLen]), string(body[6+keyLen:])
+		value, err := limitValue(value)
+		if err != nil {
+			return binStatusBadRequest, []byte(err.Error())
+		}
+		item := s.cache.Set(ctx, key, value, ttl)
+		return binStatusOK, binary.BigEndian.AppendUint64(nil, item.Version)
+	default: // binOpDelete
+		if !s.cache.Delete(ctx, string(body)) {
+			return binStatusNotFound, nil
+		}
+		return binStatusOK, nil
+	}
+}
+
+// readBinaryFrame reads one length-prefixed frame into buf's storage.
+func readBinaryFrame(r *bufio.Reader, buf []byte, maxFrame int) ([]byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(prefix[:])
+	if n > uint32(maxFrame) {
+		return nil, errBinaryFrame
+	}
+	if cap(buf) < int(n) {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	_, err := io.ReadFull(r, buf)
+	return buf, err
+}
+
+func writeBinaryFrame(w *bufio.Writer, status byte, opaque uint32, body []byte) {
+	var header [4 + binHeaderLen]byte
+	binary.BigEndian.PutUint32(header[0:4], uint32(binHeaderLen+len(body)))
+	header[4] = status
+	binary.BigEndian.PutUint32(header[5:9], opaque)
+	w.Write(header[:])
+	w.Write(body)
+}
@@ -0,0 +1,39 @@
+package main
+
+import "myproject/cache"
+
+// The server is built on the cache package; these names let the rest of
+// package main use its types as its own.
+type (
+	Cache            = cache.Cache
+	LRUCache         = cache.LRUCache
+	Item             = cache.Item
+	Write            = cache.Write
+	Loader           = cache.Loader
+	MutationSink     = cache.MutationSink
+	Hooks            = cache.Hooks
+	HookEvent        = cache.HookEvent
+	HookOptions      = cache.HookOptions
+	HookRegistration = cache.HookRegistration
+	Quota            = cache.Quota
+	clientIdentity   = cache.Client
+)
+
+const (
+	eventSet    = cache.EventSet
+	eventDelete = cache.EventDelete
+	eventEvict  = cache.EventEvict
+	eventExpire = cache.EventExpire
+)
+
+var (
+	ErrNotFound   = cache.ErrNotFound
+	ErrNotInteger = cache.ErrNotInteger
+	ErrOverflow   = cache.ErrOverflow
+
+	namespaceOf         = cache.NamespaceOf
+	parseEvictionPolicy = cache.ParseEvictionPolicy
+	parseCompression    = cache.ParseCompression
+	detectMemoryLimit   = cache.DetectMemoryLimit
+	capacityFor         = cache.CapacityFor
+)
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"myproject/internal/hashring"
+)
+
+const (
+	// forwardedHeader marks a request proxied from another node, which is
+	// served where it arrives so that nodes with different views of the
+	// ring cannot bounce it between them.
+	forwardedHeader = "X-Cache-Forwarded"
+	// ownerHeader names the owning node on a 421 response.
+	ownerHeader = "X-Cache-Owner"
+)
+
+// cluster routes the single-key /v1/keys/{key} requests to the node that
+// owns the key on the consistent-hash ring of the cluster's nodes, which
+// are ClusterConfig.Nodes or, with gossip, the members that are alive, or
+// those discovered.
+// Other requests, multi-key ones included, see only this node's keys.
+type cluster struct {
+	self  string
+	ring  atomic.Pointer[hashring.Ring]
+	proxy bool
+
+	mutex   sync.Mutex
+	proxies map[string]*httputil.ReverseProxy
+	changed []func()
+	// handoff, if not nil, holds the proxied writes owners cannot take.
+	handoff *handoff
+}
+
+// newCluster returns the cluster of config.Nodes or, if dynamic, of this
+// node alone until gossip or discovery finds others.
+func newCluster(config ClusterConfig, dynamic bool) (*cluster, error) {
+	self := strings.TrimSuffix(config.Self, "/")
+	nodes := []string{self}
+	if !dynamic {
+		nodes = make([]string, len(config.Nodes))
+		for i, node := range config.Nodes {
+			nodes[i] = strings.TrimSuffix(node, "/")
+		}
+		if !slices.Contains(nodes, self) {
+			return nil, fmt.Errorf("self %q is not one of the nodes", config.Self)
+		}
+	}
+	c := &cluster{self: self, proxy: config.Proxy, proxies: make(map[string]*httputil.ReverseProxy)}
+	if err := c.setNodes(nodes); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// setNodes replaces the ring. Requests already routed finish where they
+// were sent.
+func (c *cluster) setNodes(nodes []string) error {
+	for _, node := range nodes {
+		target, err := url.Parse(node)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+			return fmt.Errorf("node %q is not an http or https URL", node)
+		}
+	}
+	c.ring.Store(hashring.New(nodes))
+	c.mutex.Lock()
+	changed := c.changed
+	c.mutex.Unlock()
+	for _, fn := range changed {
+		fn()
+	}
+	return nil
+}
+
+// onChange registers fn to be called after each change of the ring. It
+// must not block.
+func (c *cluster) onChange(fn func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.changed = append(c.changed, fn)
+}
+
+// proxyTo returns the proxy for node, whose URL setNodes has checked.
+func (c *cluster) proxyTo(node string) *httputil.ReverseProxy {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if p, ok := c.proxies[node]; ok {
+		return p
+	}
+	target, _ := url.Parse(node)
+	p := nodeProxy(target, c.self)
+	c.proxies[node] = p
+	return p
+}
+
+// nodeProxy forwards requests to the node at target, marking them as
+// forwarded by self unless it is empty.
+func nodeProxy(target *url.URL, self string) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+			if self != "" {
+				r.Out.Header.Set(forwardedHeader, self)
+			}
+			if id := requestIDFrom(r.In.Context()); id != "" {
+				r.Out.Header.Set(requestIDHeader, id)
+			}
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if fallback, ok := r.Context().Value(proxyFallbackKey{}).(func(http.ResponseWriter, *http.Request)); ok && unreachable(err) {
+				fallback(w, r)
+				return
+			}
+			httpLog.Warn("Failed to proxy request", "node", target.String(), "err", err)
+			writeError(w, http.StatusBadGateway, "node "+target.String()+" is unreachable")
+		},
+	}
+}
+
+// Route serves requests for keys this node owns and proxies the rest to
+// their owner or, without Proxy, refuses them with 421 and the owner in
+// X-Cache-Owner. A nil cluster serves everything.
+func (c *cluster) Route(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(forwardedHeader) != "" {
+			next.ServeHTTP(w, r.WithContext(withPeer(r.Context())))
+			return
+		}
+		owner := c.ring.Load().Owner(r.PathValue("key"))
+		if owner == c.self {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !c.proxy {
+			w.Header().Set(ownerHeader, owner)
+			writeError(w, http.StatusMisdirectedRequest, "key is owned by "+owner)
+			return
+		}
+		if c.handoff != nil {
+			c.handoff.serve(c.proxyTo(owner), next, w, r)
+			return
+		}
+		c.proxyTo(owner).ServeHTTP(w, r)
+	})
+}
+
+// V1ClusterHandler describes the ring, for clients that route keys
+// themselves.
+func (h *CacheHandler) V1ClusterHandler(w http.ResponseWriter, r *http.Request) {
+	if h.cluster == nil {
+		writeError(w, http.StatusNotFound, "cluster mode is off")
+		return
+	}
+	writeResponse(w, r, &ClusterInfo{Self: h.cluster.self, Nodes: h.cluster.ring.Load().Nodes(), Proxy: h.cluster.proxy})
+}
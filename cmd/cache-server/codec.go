@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type codec interface {
+	ContentType() string
+	Encode(w io.Writer, v interface{}) error
+	Decode(r io.Reader, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (jsonCodec) Decode(r io.Reader, v interface{}) error {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errTrailingData
+	}
+	return nil
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return "application/msgpack" }
+
+func (msgpackCodec) Encode(w io.Writer, v interface{}) error {
+	return msgpack.NewEncoder(w).Encode(v)
+}
+
+func (msgpackCodec) Decode(r io.Reader, v interface{}) error {
+	dec := msgpack.NewDecoder(r)
+	dec.DisallowUnknownFields(true)
+	return dec.Decode(v)
+}
+
+// protoMessage is implemented by the wire types in proto.go.
+type protoMessage interface {
+	marshalProto() []byte
+	unmarshalProto(b []byte) error
+}
+
+var (
+	errNotProtoMessage = errors.New("value has no protobuf encoding")
+	errTrailingData    = errors.New("unexpected data after request body")
+)
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string { return "application/protobuf" }
+
+func (protobufCodec) Encode(w io.Writer, v interface{}) error {
+	m, ok := v.(protoMessage)
+	if !ok {
+		return errNotProtoMessage
+	}
+	_, err := w.Write(m.marshalProto())
+	return err
+}
+
+func (protobufCodec) Decode(r io.Reader, v interface{}) error {
+	m, ok := v.(protoMessage)
+	if !ok {
+		return errNotProtoMessage
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return m.unmarshalProto(b)
+}
+
+var codecs = map[string]codec{
+	"application/json":         jsonCodec{},
+	"application/msgpack":      msgpackCodec{},
+	"application/x-msgpack":    msgpackCodec{},
+	"application/protobuf":     protobufCodec{},
+	"application/x-protobuf":   protobufCodec{},
+	"application/x-gob":        serializerCodec{gobSerializer{}},
+	"application/octet-stream": serializerCodec{rawSerializer{}},
+}
+
+// responseSerializers are what responses fall back to when the Accept
+// header names no supported type, set at startup from the Serializer
+// settings: the namespace's, for requests about one key, or the
+// server's. Their zero value is JSON.
+var responseSerializers struct {
+	server     serializer
+	namespaces map[string]serializer
+}
+
+// defaultCodec is the codec for a response to r that names none.
+func defaultCodec(r *http.Request) codec {
+	if key := r.PathValue("key"); key != "" {
+		if s, ok := responseSerializers.namespaces[namespaceOf(key)]; ok {
+			return codecs[s.ContentType()]
+		}
+	}
+	if s := responseSerializers.server; s != nil {
+		return codecs[s.ContentType()]
+	}
+	return jsonCodec{}
+}
+
+// responseCodec picks the first supported media type listed in the Accept
+// header for r, falling back to defaultCodec.
+func responseCodec(r *http.Request) codec {
+	accept := r.Header.Get("Accept")
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if c, ok := codecs[mediaType]; ok {
+			return c
+		}
+	}
+	return defaultCodec(r)
+}
+
+// requestCodec picks the codec matching the Content-Type header. Anything
+// else is decoded as JSON, which is what the API accepted before negotiation.
+func requestCodec(contentType string) codec {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return jsonCodec{}
+	}
+	if c, ok := codecs[mediaType]; ok {
+		return c
+	}
+	return jsonCodec{}
+}
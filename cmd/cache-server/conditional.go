@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func etag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// writePrecondition turns If-Match and If-None-Match headers into a
+// condition for LRUCache.SetIf. ok is false when the request has neither.
+// Only strong tags are compared, as RFC 9110 requires for If-Match.
+func writePrecondition(r *http.Request) (cond func(Item, bool) bool, ok bool) {
+	return precondition(r.Header.Get("If-Match"), r.Header.Get("If-None-Match"))
+}
+
+// precondition is writePrecondition given the two headers' values, for
+// writes that are applied away from their request.
+func precondition(ifMatch, ifNoneMatch string) (cond func(Item, bool) bool, ok bool) {
+	if ifMatch != "" {
+		tags := strings.Split(ifMatch, ",")
+		return func(current Item, exists bool) bool {
+			return exists && etagMatches(tags, current.Version)
+		}, true
+	}
+	if ifNoneMatch != "" {
+		tags := strings.Split(ifNoneMatch, ",")
+		return func(current Item, exists bool) bool {
+			return !exists || !etagMatches(tags, current.Version)
+		}, true
+	}
+	return nil, false
+}
+
+func etagMatches(tags []string, version uint64) bool {
+	current := etag(version)
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// setFreshness sets the headers downstream HTTP caches use to decide how long
+// to keep a response. max-age is the TTL the entry was written with and Age
+// how much of it has passed, so a cache's copy goes stale when the entry
+// expires here.
+func (h *CacheHandler) setFreshness(w http.ResponseWriter, item Item) {
+	now := time.Now()
+	maxAge := int64(item.ExpiresAt.Sub(item.StoredAt) / time.Second)
+	age := min(int64(math.Ceil(now.Sub(item.StoredAt).Seconds())), maxAge)
+
+	cacheControl := "max-age=" + strconv.FormatInt(maxAge, 10)
+	if h.privateResponses {
+		cacheControl = "private, " + cacheControl
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("Age", strconv.FormatInt(age, 10))
+	w.Header().Set("Expires", item.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("ETag", etag(item.Version))
+}
+
+// notModified reports whether the request's If-None-Match already names
+// item's version, so a revalidating cache can be answered with 304.
+func notModified(r *http.Request, item Item) bool {
+	header := r.Header.Get("If-None-Match")
+	return header != "" && etagMatches(strings.Split(header, ","), item.Version)
+}
@@ -0,0 +1,945 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"time"
+)
+
+// Any address below may be "unix:<path>" to listen on a Unix domain socket
+// instead of TCP.
+type Config struct {
+	Addr string
+	// SocketPath, if set, serves the same HTTP API on a Unix socket as
+	// well as on Addr, for sidecars on the same host.
+	SocketPath string
+	// SocketMode is the permission set on Unix sockets this server
+	// creates. Connecting needs write permission.
+	SocketMode os.FileMode
+	// AdminAddr, if set, serves admin routes (flush, import, export,
+	// resize), the dashboard, /metrics, pprof and expvar on a separate
+	// listener, and removes them from Addr. Bind it to localhost or an
+	// internal interface. Without it, expvar is not served.
+	AdminAddr string
+	// RESPAddr, if set, serves the Redis protocol subset in resp.go.
+	RESPAddr string
+	// MemcacheAddr, if set, serves the memcached ASCII protocol.
+	MemcacheAddr string
+	// BinaryAddr, if set, serves the length-prefixed binary protocol in
+	// binproto.go.
+	BinaryAddr string
+	// GRPCAddr, if set, serves the CacheService from cache.proto, with TLS
+	// when TLS is enabled.
+	GRPCAddr string
+	// HTTP3Addr, if set, serves the HTTP API over QUIC on this UDP address,
+	// usually the same port as Addr. It needs TLS; responses on Addr
+	// advertise it with Alt-Svc.
+	HTTP3Addr string
+
+	// Features turns on experimental subsystems: "resp" (RESPAddr),
+	// "replication" (following a primary, and streaming to replicas) and
+	// "disk-tier" (Overflow). Configuring one that is not turned on here
+	// is an error, so that none of them runs by default.
+	Features []string
+
+	// Serializer encodes the records of snapshots, the AOF and the
+	// replication stream, and responses that ask for no media type:
+	// "json", "msgpack" or "gob" (see serializer.go).
+	Serializer string
+
+	// Capacity is the most entries to hold. Zero sizes the cache to
+	// autoMemoryFraction of the memory limit (see MemoryLimit), and
+	// evicts under memory pressure past it unless MemoryPressure is set;
+	// without a limit it is defaultCapacity.
+	Capacity int
+	// DefaultTTL is how long entries written without a TTL are kept.
+	DefaultTTL time.Duration
+	// MaxBodyBytes caps the size of request bodies; larger ones get 413.
+	MaxBodyBytes int64
+	// MaxValueBytes caps the size of a single value, whatever the
+	// protocol; zero is no limit. OversizeValues is "reject", answering
+	// HTTP writes of larger values with 413, or "truncate", storing
+	// their first MaxValueBytes.
+	MaxValueBytes  int
+	OversizeValues string
+	// ChunkSize, if set, has values longer than it held in chunks of
+	// that many bytes, so that a multi-megabyte value takes no single
+	// allocation while it is cached.
+	ChunkSize int
+	// DedupAbove, if set, has values longer than it that several keys
+	// hold stored once, for workloads where many keys map to the same
+	// payload.
+	DedupAbove int
+	// MemoryPressure, if positive, is the fraction of MemoryLimit bytes
+	// past which the sweep evicts least recently used entries, so that
+	// the process is not killed for running out of memory. Zero leaves
+	// it to the capacity (see Capacity), and a negative fraction turns
+	// it off whatever the capacity. A MemoryLimit of zero is the
+	// container's cgroup limit, or GOMEMLIMIT.
+	MemoryLimit    int64
+	MemoryPressure float64
+	// RequestTimeout bounds each non-streaming request, including any
+	// loader calls it makes.
+	RequestTimeout time.Duration
+	// ShutdownTimeout bounds how long in-flight requests may drain after
+	// SIGINT/SIGTERM.
+	ShutdownTimeout time.Duration
+
+	// RecentRemovals is how many of the latest removals (evictions,
+	// expirations and deletes) /v1/admin/evictions lists.
+	RecentRemovals int
+
+	// Namespaces sets quotas, defaults and API keys for key namespaces
+	// (the part of a key before its first ":").
+	Namespaces map[string]NamespaceConfig
+
+	Log         LogConfig
+	Profiling   ProfilingConfig
+	HotKeys     HotKeysConfig
+	SlowOps     SlowOpsConfig
+	Server      ServerConfig
+	Idempotency IdempotencyConfig
+	AccessLog   AccessLogConfig
+	RateLimit   RateLimitConfig
+	Auth        AuthConfig
+	JWT         JWTConfig
+	TLS         TLSConfig
+	Loader      LoaderConfig
+	MQTT        MQTTConfig
+	Kafka       KafkaConfig
+	Audit       AuditConfig
+	Webhooks    WebhooksConfig
+	Cluster     ClusterConfig
+	Proxy       ProxyConfig
+	Engine      EngineConfig
+	Gossip      GossipConfig
+	Replication ReplicationConfig
+	L2          L2Config
+	Regions     RegionsConfig
+	Raft        RaftConfig
+	Snapshot    SnapshotConfig
+	AOF         AOFConfig
+	Overflow    OverflowConfig
+	Store       StoreConfig
+	Encryption  EncryptionConfig
+	Tracing     TracingConfig
+	StatsD      StatsDConfig
+}
+
+// The experimental subsystems Config.Features can turn on.
+const (
+	featureRESP        = "resp"
+	featureReplication = "replication"
+	featureDiskTier    = "disk-tier"
+)
+
+var knownFeatures = []string{featureRESP, featureReplication, featureDiskTier}
+
+// feature reports whether Features turns name on.
+func (config *Config) feature(name string) bool {
+	return slices.Contains(config.Features, name)
+}
+
+// LogConfig sets up the server's own log, on stderr; request logging is
+// AccessLog. Levels can be changed at runtime through
+// /v1/admin/log-levels.
+type LogConfig struct {
+	// Level is "debug", "info", "warn" or "error".
+	Level string
+	// Format is "text" or "json".
+	Format string
+	// Subsystems overrides Level for some of "server", "http", "janitor",
+	// "persistence", "events", "auth" and "cluster".
+	Subsystems map[string]string
+}
+
+// ProfilingConfig turns on the sampling behind the mutex and block
+// profiles at /debug/pprof on the admin listener; the CPU, heap and
+// goroutine profiles need none. Zero leaves a profile empty.
+type ProfilingConfig struct {
+	// MutexProfileFraction samples 1 in this many contention events on
+	// sync.Mutex and RWMutex, including the cache lock.
+	MutexProfileFraction int
+	// BlockProfileRate samples one blocking event per this many
+	// nanoseconds spent blocked, on channels as well as locks. It costs
+	// more than the mutex profile.
+	BlockProfileRate int
+}
+
+// HotKeysConfig sizes the tracker behind /v1/stats/hotkeys.
+type HotKeysConfig struct {
+	// TopK is how many of the most-read keys are tracked; zero turns
+	// tracking off.
+	TopK int
+	// Window is the period reads are counted over.
+	Window time.Duration
+	// SketchWidth is the counters per row of the count-min sketch. Wider
+	// sketches collide less; each counter is 4 bytes, in 4 rows.
+	SketchWidth int
+}
+
+// ClusterConfig spreads keys over several servers by consistent hashing.
+type ClusterConfig struct {
+	// Self is this server's base URL as the other nodes and clients reach
+	// it, e.g. "http://10.0.0.1:8080". It must be one of Nodes.
+	Self string
+	// Nodes is the base URL of every server in the cluster, this one
+	// included, the same on each. Empty turns cluster mode off unless
+	// gossip or Discovery is on, when Nodes is not used: the nodes are the
+	// members that are alive, or those discovered.
+	Nodes []string
+	// Discovery finds the nodes in DNS or from Kubernetes instead.
+	Discovery DiscoveryConfig
+	// Rebalance hands entries over to their new owners when the nodes
+	// change.
+	Rebalance RebalanceConfig
+	// Handoff accepts proxied writes for owners that are down and replays
+	// them later. It needs Proxy.
+	Handoff HandoffConfig
+	// Proxy forwards requests for keys another node owns to it; without
+	// it they get 421 Misdirected Request.
+	Proxy bool
+	// PeerFill fills a miss on a key another node owns from that node,
+	// rather than from the loader, so that only the owner loads it.
+	PeerFill bool
+	// APIKey authenticates fills, rebalancing and handoff to the other
+	// nodes when they require read or write permission.
+	APIKey string
+}
+
+// ProxyConfig runs the server as a proxy in front of other servers,
+// holding no data itself (see proxy.go). It serves only /v1/keys and
+// /v1/keys/{key}, on Addr; the backends enforce authentication.
+type ProxyConfig struct {
+	// Backends is the base URL of each server keys are spread over, by
+	// consistent hashing as in cluster mode; empty runs a cache server
+	// instead. The backends need not be in cluster mode themselves.
+	Backends []string
+	// Timeout bounds each request to a backend for a multi-key request.
+	Timeout time.Duration
+}
+
+// EngineConfig chooses the storage engine (see engine.go). Only the lru
+// engine, the default, has loaders, events, hooks, conditional writes
+// and quotas, and so the features built on them; the others serve the
+// key API and stats alone, on Addr, and the server refuses to start with
+// any of those features on.
+type EngineConfig struct {
+	// Kind is "lru"; "lfu", which evicts the least frequently read
+	// entries; "sharded", Shards caches of Policy ("lru" or "lfu")
+	// splitting the capacity, for less lock contention; or "tiered", an
+	// LFU cache of L1Capacity entries in front of an LRU one.
+	Kind       string
+	Shards     int
+	Policy     string
+	L1Capacity int
+}
+
+// HandoffConfig holds writes for unreachable owners as hints (see
+// handoff.go).
+type HandoffConfig struct {
+	Enabled bool
+	// MaxHints bounds the writes held; beyond it they fail with 502.
+	MaxHints int
+	// MaxAge is how long a hint is kept for an owner that stays down.
+	MaxAge time.Duration
+	// Interval is how often the owners are retried.
+	Interval time.Duration
+}
+
+// RebalanceConfig moves entries between nodes when the ring changes (see
+// rebalance.go), rather than leaving the new owners to miss.
+type RebalanceConfig struct {
+	Enabled bool
+	// Rate caps the entries handed over per second, to spare the network
+	// and the nodes while they also serve requests.
+	Rate float64
+	// BatchSize is the entries sent to a node per request.
+	BatchSize int
+}
+
+// DiscoveryConfig keeps the cluster's nodes to those listed in DNS or by
+// the Kubernetes API (see discovery.go), such as the pods behind a
+// headless service. Nodes are named by URL from their address and port,
+// so Cluster.Self must be too, e.g. "http://$(POD_IP):8080".
+type DiscoveryConfig struct {
+	// Mode is "dns" or "kubernetes"; empty turns discovery off.
+	Mode string
+	// Name is looked up in DNS: its SRV records if it has any, or else its
+	// addresses, with Port.
+	Name string
+	// Service and Namespace name the Kubernetes service whose ready
+	// endpoints are the nodes; Namespace defaults to this pod's.
+	Service   string
+	Namespace string
+	// PortName picks the service port the nodes serve on, if it has more
+	// than one.
+	PortName string
+	// Port is the nodes' port where the lookup does not give one.
+	Port int
+	// Scheme is "http" or "https".
+	Scheme string
+	// Interval between lookups, which also bounds each one.
+	Interval time.Duration
+}
+
+// GossipConfig has the servers find each other and detect failures by
+// gossip, with hashicorp/memberlist, rather than from a fixed list.
+type GossipConfig struct {
+	// BindAddr is the TCP and UDP address gossip uses, e.g. ":7946";
+	// empty turns gossip off.
+	BindAddr string
+	// AdvertiseAddr is where the other members reach this one, if not at
+	// BindAddr or, when that is every interface, the first private
+	// address.
+	AdvertiseAddr string
+	// Name identifies this member, and must be unique; the hostname by
+	// default.
+	Name string
+	// Join is the gossip address of members to join at startup; reaching
+	// any one is enough.
+	Join []string
+	// SecretKey, if set, encrypts gossip. It must be 16, 24 or 32 bytes,
+	// the same on every member.
+	SecretKey string
+	// Tags is metadata shared with the other members, such as a zone.
+	Tags map[string]string
+	// Invalidate broadcasts the keys set or deleted here, so that the
+	// other members drop their copies, such as those filled from the
+	// owner in cluster mode, within a few gossip intervals.
+	Invalidate bool
+}
+
+// ReplicationConfig makes this server a replica of another, which it
+// copies over a stream of its changes and serves reads from while
+// refusing writes over HTTP.
+type ReplicationConfig struct {
+	// PrimaryURL is the base URL of the primary's admin routes, e.g.
+	// "http://10.0.0.1:9090". Empty makes this server a primary.
+	PrimaryURL string
+	// APIKey authenticates to the primary when it requires admin
+	// permission.
+	APIKey string
+	// FailoverAfter is how long the primary may be unreachable before
+	// the replica stops following it and accepts writes; zero waits
+	// forever.
+	FailoverAfter time.Duration
+	// Buffer is how many changes a replica of this server may fall behind
+	// before it is disconnected to resync.
+	Buffer int
+	// SessionWait is how long a replica holds a read whose X-Cache-Session
+	// token names a write it has not applied yet, before refusing it with
+	// 421 and the primary.
+	SessionWait time.Duration
+}
+
+// RegionsConfig replicates changes between regions, each a server or a
+// cluster of its own, written to at once (see region.go). Every region
+// lists the others.
+type RegionsConfig struct {
+	// Name is this region's; empty turns cross-region replication off.
+	Name string
+	// Peers is the base URL of each other region, by name, such as that of
+	// a load balancer in front of its nodes.
+	Peers map[string]string
+	// APIKey authenticates to the other regions when they require write
+	// permission.
+	APIKey string
+	// QueueSize is how many changes may wait for each region; more are
+	// dropped and counted.
+	QueueSize int
+	BatchSize int
+	Timeout   time.Duration
+	// TombstoneTTL is how long deletes are remembered, to refuse the sets
+	// made before them that arrive after. A change older than it to a key
+	// not here is refused, so it should exceed the longest a region may
+	// be out of reach.
+	TombstoneTTL time.Duration
+}
+
+// L2Config puts a Redis server below this cache as a second level shared
+// with other servers (see l2.go). For changes there to reach this level,
+// Redis needs keyspace notifications on, with notify-keyspace-events
+// set to at least "K$g".
+type L2Config struct {
+	// RedisAddr is the Redis server, e.g. "redis:6379"; empty turns the
+	// second level off.
+	RedisAddr string
+	Username  string
+	Password  string
+	DB        int
+	// KeyPrefix is put before every key in Redis, to keep this cache's
+	// keys apart from others in the same database.
+	KeyPrefix string
+	// Timeout bounds each Redis command.
+	Timeout time.Duration
+	// QueueSize bounds writes waiting to go to Redis. When it is full,
+	// further ones are dropped and counted rather than slowing the cache,
+	// and Redis keeps the old value until it expires.
+	QueueSize int
+	BatchSize int
+}
+
+// RaftConfig turns on the strongly consistent mode, in which writes to
+// /v1/keys/{key} are committed through a Raft log on a majority of the
+// peers before they are applied, and reads of it are served by the leader
+// once it has confirmed it still leads, so both are linearizable. Other
+// writes over HTTP are refused; those over the other protocols, and the
+// admin routes, act on this node alone.
+type RaftConfig struct {
+	// NodeID is this server's ID among Peers; empty turns the mode off.
+	NodeID string
+	// BindAddr is the TCP address Raft uses, e.g. ":7000". It must be
+	// this node's Addr in Peers unless that is reachable another way.
+	BindAddr string
+	// Dir holds the log and snapshots.
+	Dir string
+	// Peers is every node of the group, this one included, and must be
+	// the same on all of them. It is used to start a new group; one that
+	// has already started keeps the membership in its log.
+	Peers []RaftPeer
+	// ApplyTimeout bounds how long a write waits to be committed.
+	ApplyTimeout time.Duration
+}
+
+// RaftPeer is one node of the Raft group.
+type RaftPeer struct {
+	ID string
+	// Addr is the node's Raft address.
+	Addr string
+	// URL is the node's base URL, to which the others proxy requests
+	// while it leads.
+	URL string
+}
+
+// SlowOpsConfig sets what /v1/admin/slow-ops and the log count as slow.
+type SlowOpsConfig struct {
+	// Threshold is the duration from which a cache operation, loader call
+	// or expiration sweep is logged; zero turns the log off.
+	Threshold time.Duration
+	// Recent is how many of the latest slow operations are kept.
+	Recent int
+}
+
+// ServerConfig holds connection-level limits for the HTTP server. Zero
+// values mean no limit, as in net/http.
+type ServerConfig struct {
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout covers reading the whole request, body included.
+	ReadTimeout time.Duration
+	// WriteTimeout covers writing the response. Streaming routes clear it
+	// (and the read deadline) once they start.
+	WriteTimeout   time.Duration
+	IdleTimeout    time.Duration
+	MaxHeaderBytes int
+	// MaxConns caps concurrently open connections.
+	MaxConns int
+	// MaxConcurrentStreams caps the requests in flight on one HTTP/2 or
+	// HTTP/3 connection.
+	MaxConcurrentStreams int
+	// H2C serves HTTP/2 without TLS to clients that use it with prior
+	// knowledge. With TLS, HTTP/2 is always negotiated through ALPN.
+	H2C bool
+}
+
+type NamespaceConfig struct {
+	MaxEntries int
+	MaxBytes   int64
+	// TTL is the default for the namespace's keys, in place of
+	// DefaultTTL.
+	TTL time.Duration
+	// Eviction is "lru" or "lfu", for the entries evicted when the
+	// namespace is over its quota.
+	Eviction string
+	// Compression is "none", "snappy" or "zstd", for the namespace's
+	// values of more than CompressAbove bytes, which are kept
+	// compressed in memory.
+	Compression   string
+	CompressAbove int
+	// ReservedBytes is kept for the namespace when the whole cache
+	// evicts, for its capacity, watermarks or memory pressure, so that
+	// other namespaces' writes cannot take it. It is at most MaxBytes,
+	// if that is set.
+	ReservedBytes int64
+	// Serializer, if set, encodes responses about the namespace's keys
+	// that ask for no media type, in place of the server's Serializer.
+	// It may also be "raw", the value alone.
+	Serializer string
+	// Keys maps API keys to "read" or "read-write" on this namespace's
+	// keys alone, through the /v1/keys/{key} routes. They need
+	// Auth.Enabled, and work with no other route or protocol.
+	Keys map[string]string
+}
+
+type IdempotencyConfig struct {
+	Enabled bool
+	// Window is how long a response is kept for replay to retries.
+	Window time.Duration
+	// MaxKeys bounds the responses kept. Once reached, new keyed requests
+	// are served without a record until old ones expire.
+	MaxKeys int
+}
+
+type AccessLogConfig struct {
+	Enabled bool
+	// SampleRate is the fraction of requests logged, from 0 to 1.
+	SampleRate float64
+	// LogErrors logs every response with status >= 400 regardless of sampling.
+	LogErrors bool
+}
+
+type RateLimitConfig struct {
+	Enabled bool
+	// RPS is the sustained requests per second allowed for each client.
+	RPS   float64
+	Burst int
+	// IdleTimeout is how long an unused client bucket is kept.
+	IdleTimeout time.Duration
+	// MaxClients bounds the buckets kept, dropping the least recently
+	// used client's past it; zero keeps every bucket until IdleTimeout.
+	MaxClients int
+}
+
+type AuthConfig struct {
+	Enabled bool
+	// Keys maps API keys to "read" or "read-write".
+	Keys map[string]string
+	// KeysFile holds additional keys, one "<key> <permission>" per line,
+	// and is reloaded when its modification time changes.
+	KeysFile       string
+	ReloadInterval time.Duration
+}
+
+type JWTConfig struct {
+	Enabled  bool
+	JWKSURL  string
+	Issuer   string
+	Audience string
+	// RolesClaim names the claim carrying the caller's roles, either a list
+	// or a space-separated string.
+	RolesClaim string
+	// RoleMap translates claim values (e.g. IdP group names) into the
+	// cache roles admin, writer and reader.
+	RoleMap         map[string]string
+	RefreshInterval time.Duration
+}
+
+type TLSConfig struct {
+	Enabled  bool
+	CertFile string
+	KeyFile  string
+	// AutocertDomains obtains certificates from Let's Encrypt for these
+	// hosts instead of using CertFile/KeyFile.
+	AutocertDomains  []string
+	AutocertCacheDir string
+	// ClientCAFile enables mutual TLS: client certificates are verified
+	// against it, and required when RequireClientCert is set.
+	ClientCAFile      string
+	RequireClientCert bool
+}
+
+// MQTTConfig bridges cache events to an MQTT broker.
+type MQTTConfig struct {
+	// BrokerURL, e.g. "tcp://broker:1883" or "ssl://broker:8883". Empty
+	// disables the bridge.
+	BrokerURL string
+	ClientID  string
+	Username  string
+	Password  string
+	QoS       byte
+	// EventTopic receives a JSON CacheEvent for each set, delete and
+	// expire.
+	EventTopic string
+	// InvalidationTopic, if set, is subscribed to for {"keys": [...]} or
+	// {"flush": true} messages. Changes they cause are not published back
+	// to EventTopic.
+	InvalidationTopic string
+}
+
+// KafkaConfig writes every cache mutation to a Kafka topic.
+type KafkaConfig struct {
+	// Brokers lists bootstrap addresses; empty disables the sink.
+	Brokers []string
+	Topic   string
+	// IncludeValues adds the stored value to set records.
+	IncludeValues bool
+	// QueueSize bounds mutations waiting to be written. When it is full,
+	// further ones are dropped and counted rather than slowing the cache.
+	QueueSize    int
+	BatchSize    int
+	BatchTimeout time.Duration
+}
+
+// AuditConfig records every set and delete with the time, key, request
+// ID, client address and principal (an API key fingerprint or a JWT
+// subject), but never the value.
+type AuditConfig struct {
+	// Path is a file of JSON lines; empty writes none.
+	Path string
+	// MaxBytes rotates the file once it would grow past this size,
+	// renaming it with a timestamp suffix. Zero never rotates.
+	MaxBytes int64
+	// MaxFiles is how many rotated files are kept; zero keeps all.
+	MaxFiles int
+	// WebhookURL receives POSTs of JSON arrays of records; empty sends
+	// none. A batch is dropped after three failed attempts.
+	WebhookURL string
+	// QueueSize bounds records waiting to be written. When it is full,
+	// further ones are dropped and counted rather than slowing the cache.
+	QueueSize int
+	BatchSize int
+}
+
+// WebhooksConfig POSTs the expirations and evictions of keys to HTTP
+// endpoints, so that systems holding state derived from them can refresh
+// or clean it up.
+type WebhooksConfig struct {
+	// Hooks are registered at startup; /v1/admin/webhooks adds more.
+	Hooks []WebhookConfig
+	// QueueSize bounds the events waiting for each webhook. When it is
+	// full, further ones are dropped and counted rather than slowing the
+	// cache.
+	QueueSize int
+	BatchSize int
+}
+
+// WebhookConfig is one endpoint and the events it receives.
+type WebhookConfig struct {
+	URL string
+	// Namespace and Pattern, in path.Match syntax, narrow the keys whose
+	// events are sent; empty is any.
+	Namespace string
+	Pattern   string
+	// Events is "expire", "evict" or both; empty is both.
+	Events []string
+}
+
+// SnapshotConfig persists the cache to disk so that a restart does not
+// start cold.
+type SnapshotConfig struct {
+	// Path is the snapshot file; empty disables snapshots. It is replaced
+	// atomically on every write. It may instead be an s3:// or gs:// URL
+	// (see objectstore.go).
+	Path string
+	// Interval between periodic snapshots. With zero, snapshots are only
+	// written at shutdown, after the server has drained, and to rewrite
+	// the AOF.
+	Interval time.Duration
+	// BackupDir receives named backups from POST /v1/admin/backup.
+	BackupDir string
+	// SkipRestore starts with an empty cache instead of loading Path and
+	// replaying the AOF, which then starts over. The -skip-restore flag
+	// sets it.
+	SkipRestore bool
+}
+
+// AOFConfig logs every set and delete to an append-only file that is
+// replayed on startup after the snapshot, so little is lost between
+// snapshots.
+type AOFConfig struct {
+	// Path is the log file; empty disables it.
+	Path string
+	// Fsync is "always" (before the write returns), "everysec" (up to a
+	// second of writes can be lost) or "no" (left to the kernel).
+	Fsync string
+	// RewriteSize triggers a snapshot, which empties the log, once the log
+	// reaches this many bytes. It needs Snapshot.Path; zero disables it.
+	RewriteSize int64
+}
+
+// OverflowConfig adds a disk tier below the in-memory LRU: entries evicted
+// for capacity move there, and misses check it before the loader.
+type OverflowConfig struct {
+	// Path is the Bolt file, which is recreated on startup and removed
+	// on shutdown. Empty disables the tier.
+	Path string
+}
+
+// EncryptionConfig seals snapshots, backups and the AOF with AES-GCM (see
+// encryption.go). The Store backends and the overflow tier are not
+// encrypted. Files written in plaintext are still read, and the AOF is
+// converted when opened.
+type EncryptionConfig struct {
+	// KMSKeyURL names the key that wraps each file's key, such as
+	// "awskms://alias/cache?region=eu-west-1" or
+	// "gcpkms://projects/p/locations/l/keyRings/r/cryptoKeys/k".
+	KMSKeyURL string
+	// KeyEnv names the environment variable holding a base64 256-bit key
+	// to use instead of a KMS. Encryption is off if neither is set.
+	KeyEnv string
+}
+
+// TracingConfig exports OpenTelemetry spans for requests, cache
+// operations, loader calls and persistence (see tracing.go).
+type TracingConfig struct {
+	// Endpoint is the OTLP/HTTP collector, such as "localhost:4318";
+	// empty disables tracing.
+	Endpoint string
+	// Insecure sends spans over plain HTTP.
+	Insecure bool
+	// SampleRatio is the fraction of new traces sampled. Requests that
+	// carry a traceparent follow the caller's decision.
+	SampleRatio float64
+	ServiceName string
+}
+
+// StatsDConfig sends metrics to a StatsD or DogStatsD server over UDP
+// (see statsd.go), for setups without Prometheus: the cache counters and
+// gauges every Interval, and request and cache operation timings.
+type StatsDConfig struct {
+	// Addr is the server, such as "localhost:8125"; empty disables the
+	// exporter.
+	Addr string
+	// Prefix starts every metric name, e.g. "cache" for "cache.hits".
+	Prefix string
+	// Tags are added to every metric in the DogStatsD format, which the
+	// Datadog agent and Telegraf accept, as are each metric's own tags
+	// (op, route, code, reason).
+	Tags map[string]string
+	// Plain sends no tags, for servers without the DogStatsD extension: a
+	// metric's own tag values are appended to its name instead, and Tags
+	// is ignored.
+	Plain    bool
+	Interval time.Duration
+	// TimingSampleRate is the fraction of timings sent, from 0 to 1. The
+	// server scales the counts it derives from them back up.
+	TimingSampleRate float64
+}
+
+// StoreConfig mirrors the cache into a durability backend (see store.go),
+// as an alternative to snapshots and the AOF. The cache is loaded from it
+// on startup.
+type StoreConfig struct {
+	// Backend is "file", "bolt" or "sqlite"; empty disables the store.
+	Backend string
+	// Path is the backend's file.
+	Path string
+	// CompactInterval between rewrites of the store from the cache, which
+	// drop what the file backend has accumulated and repair any mutations
+	// the writer missed. Zero compacts only after drops.
+	CompactInterval time.Duration
+}
+
+// LoaderConfig enables read-through from an HTTP origin on cache misses.
+type LoaderConfig struct {
+	// URL is the origin address with "{key}" where the key goes, e.g.
+	// "http://origin.internal/items/{key}". Empty disables the loader.
+	URL      string
+	Timeout  time.Duration
+	TTL      time.Duration
+	MaxBytes int64
+}
+
+const (
+	// defaultCapacity is the capacity when there is none set and no
+	// memory limit to size it by.
+	defaultCapacity = 1024
+	// autoMemoryFraction is the share of the memory limit a cache
+	// sized by it takes, leaving the rest to the server and the runtime.
+	autoMemoryFraction = 0.6
+	// autoEntryBytes is the key and value size assumed when sizing the
+	// cache. It errs small, so that memory pressure, rather than the
+	// number of entries, bounds a cache of larger values.
+	autoEntryBytes = 256
+)
+
+// memoryLimit is MemoryLimit, or if that is zero the limit detected for
+// the process; zero if there is neither.
+func (c Config) memoryLimit() int64 {
+	if c.MemoryLimit > 0 {
+		return c.MemoryLimit
+	}
+	return detectMemoryLimit()
+}
+
+// capacity is Capacity, or if that is zero the entries that fit in
+// autoMemoryFraction of the memory limit, or defaultCapacity without one.
+func (c Config) capacity() int {
+	if c.Capacity > 0 {
+		return c.Capacity
+	}
+	limit := c.memoryLimit()
+	if limit == 0 {
+		return defaultCapacity
+	}
+	return capacityFor(int64(float64(limit)*autoMemoryFraction), autoEntryBytes)
+}
+
+// memoryPressure is MemoryPressure, or autoMemoryFraction for a cache
+// sized by the memory limit that leaves it unset; zero is off.
+func (c Config) memoryPressure() float64 {
+	switch {
+	case c.MemoryPressure < 0:
+		return 0
+	case c.MemoryPressure == 0 && c.Capacity == 0:
+		return autoMemoryFraction
+	}
+	return c.MemoryPressure
+}
+
+func defaultConfig() Config {
+	return Config{
+		Addr:            ":8080",
+		AdminAddr:       "127.0.0.1:9090",
+		SocketMode:      0o660,
+		Serializer:      "json",
+		DefaultTTL:      5 * time.Second,
+		MaxBodyBytes:    1 << 20,
+		OversizeValues:  "reject",
+		RequestTimeout:  30 * time.Second,
+		ShutdownTimeout: 15 * time.Second,
+		RecentRemovals:  1000,
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Profiling: ProfilingConfig{
+			MutexProfileFraction: 100,
+		},
+		HotKeys: HotKeysConfig{
+			TopK:        100,
+			Window:      10 * time.Second,
+			SketchWidth: 4096,
+		},
+		SlowOps: SlowOpsConfig{
+			Threshold: 100 * time.Millisecond,
+			Recent:    100,
+		},
+		Server: ServerConfig{
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      60 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			MaxHeaderBytes:    64 << 10,
+			MaxConns:          10000,
+
+			MaxConcurrentStreams: 250,
+		},
+		Idempotency: IdempotencyConfig{
+			Enabled: true,
+			Window:  10 * time.Minute,
+			MaxKeys: 10000,
+		},
+		AccessLog: AccessLogConfig{
+			Enabled:    true,
+			SampleRate: 1,
+			LogErrors:  true,
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:     true,
+			RPS:         100,
+			Burst:       200,
+			IdleTimeout: 10 * time.Minute,
+			MaxClients:  100000,
+		},
+		Auth: AuthConfig{
+			ReloadInterval: 5 * time.Second,
+		},
+		JWT: JWTConfig{
+			RolesClaim:      "roles",
+			RefreshInterval: 5 * time.Minute,
+		},
+		TLS: TLSConfig{
+			AutocertCacheDir: "autocert",
+		},
+		Loader: LoaderConfig{
+			Timeout:  10 * time.Second,
+			MaxBytes: 1 << 20,
+		},
+		Kafka: KafkaConfig{
+			Topic:         "cache-mutations",
+			IncludeValues: true,
+			QueueSize:     10000,
+			BatchSize:     100,
+			BatchTimeout:  100 * time.Millisecond,
+		},
+		Audit: AuditConfig{
+			MaxBytes:  100 << 20,
+			MaxFiles:  10,
+			QueueSize: 10000,
+			BatchSize: 100,
+		},
+		Webhooks: WebhooksConfig{
+			QueueSize: 10000,
+			BatchSize: 100,
+		},
+		Cluster: ClusterConfig{
+			Proxy:    true,
+			PeerFill: true,
+			Discovery: DiscoveryConfig{
+				Scheme:   "http",
+				Interval: 10 * time.Second,
+			},
+			Rebalance: RebalanceConfig{
+				Enabled:   true,
+				Rate:      5000,
+				BatchSize: 100,
+			},
+			Handoff: HandoffConfig{
+				MaxHints: 10000,
+				MaxAge:   time.Hour,
+				Interval: 5 * time.Second,
+			},
+		},
+		Proxy: ProxyConfig{
+			Timeout: 10 * time.Second,
+		},
+		Engine: EngineConfig{
+			Kind:       engineLRU,
+			Shards:     16,
+			Policy:     "lru",
+			L1Capacity: 1024,
+		},
+		Gossip: GossipConfig{
+			Invalidate: true,
+		},
+		Replication: ReplicationConfig{
+			FailoverAfter: 30 * time.Second,
+			Buffer:        100000,
+			SessionWait:   time.Second,
+		},
+		Regions: RegionsConfig{
+			QueueSize:    10000,
+			BatchSize:    500,
+			Timeout:      10 * time.Second,
+			TombstoneTTL: time.Hour,
+		},
+		L2: L2Config{
+			Timeout:   time.Second,
+			QueueSize: 10000,
+			BatchSize: 100,
+		},
+		Raft: RaftConfig{
+			Dir:          "raft",
+			ApplyTimeout: 5 * time.Second,
+		},
+		Snapshot: SnapshotConfig{
+			Interval:  5 * time.Minute,
+			BackupDir: "backups",
+		},
+		AOF: AOFConfig{
+			Fsync:       fsyncEverySec,
+			RewriteSize: 64 << 20,
+		},
+		Store: StoreConfig{
+			CompactInterval: time.Hour,
+		},
+		Encryption: EncryptionConfig{
+			KeyEnv: "CACHE_ENCRYPTION_KEY",
+		},
+		Tracing: TracingConfig{
+			SampleRatio: 1,
+			ServiceName: "cache",
+		},
+		StatsD: StatsDConfig{
+			Prefix:           "cache",
+			Interval:         10 * time.Second,
+			TimingSampleRate: 1,
+		},
+		MQTT: MQTTConfig{
+			ClientID:   "cache",
+			QoS:        1,
+			EventTopic: "cache/events",
+		},
+	}
+}
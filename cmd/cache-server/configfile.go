@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfigFile overlays the settings in the YAML or TOML file at path,
+// by its extension, on config. Settings are named after the Config
+// fields, in any case and with or without underscores, so MaxBodyBytes,
+// maxbodybytes and max_body_bytes are the same; durations are strings
+// such as "30s". A setting the file leaves out keeps its default, except
+// that a map or list given in the file replaces the default one. Unknown
+// settings and values of the wrong type are errors, naming the setting.
+//
+//	capacity: 100000
+//	request_timeout: 10s
+//	cluster:
+//	  self: http://10.0.0.1:8080
+//	  nodes: [http://10.0.0.1:8080, http://10.0.0.2:8080]
+func loadConfigFile(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var doc map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	default:
+		return fmt.Errorf("%s: unknown config format; use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := setConfigValue(reflect.ValueOf(config).Elem(), doc, ""); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+var (
+	durationType = reflect.TypeFor[time.Duration]()
+	fileModeType = reflect.TypeFor[os.FileMode]()
+)
+
+// configName folds a field or setting name for matching.
+func configName(name string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(name))
+}
+
+// setConfigValue stores raw, as decoded from YAML or TOML, in v. name is
+// the setting's dotted path, for errors.
+func setConfigValue(v reflect.Value, raw any, name string) error {
+	if raw == nil {
+		// An empty setting, such as "tls:" alone, changes nothing.
+		return nil
+	}
+	wrongType := func(want string) error {
+		return fmt.Errorf("%s: want %s, not %v", name, want, raw)
+	}
+
+	switch v.Type() {
+	case durationType:
+		s, ok := raw.(string)
+		if !ok {
+			if n, ok := configInt(raw); ok && n == 0 {
+				v.SetInt(0)
+				return nil
+			}
+			return wrongType(`a duration such as "10s"`)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return wrongType(`a duration such as "10s"`)
+		}
+		v.SetInt(int64(d))
+		return nil
+	case fileModeType:
+		if s, ok := raw.(string); ok {
+			// "0660", as YAML 1.2 and TOML read an unquoted 0660 as decimal.
+			mode, err := strconv.ParseUint(strings.TrimPrefix(s, "0o"), 8, 32)
+			if err != nil {
+				return wrongType("an octal file mode such as \"0660\"")
+			}
+			v.SetUint(mode)
+			return nil
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		s, ok := raw.(string)
+		if !ok {
+			return wrongType("a string")
+		}
+		v.SetString(s)
+	case reflect.Bool:
+		b, ok := raw.(bool)
+		if !ok {
+			return wrongType("true or false")
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := configInt(raw)
+		if !ok || v.OverflowInt(n) {
+			return wrongType("an integer")
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := configInt(raw)
+		if !ok || n < 0 || v.OverflowUint(uint64(n)) {
+			return wrongType("a non-negative integer")
+		}
+		v.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		switch f := raw.(type) {
+		case float64:
+			v.SetFloat(f)
+		default:
+			n, ok := configInt(raw)
+			if !ok {
+				return wrongType("a number")
+			}
+			v.SetFloat(float64(n))
+		}
+	case reflect.Slice:
+		list, ok := raw.([]any)
+		if !ok {
+			return wrongType("a list")
+		}
+		s := reflect.MakeSlice(v.Type(), len(list), len(list))
+		for i, item := range list {
+			if err := setConfigValue(s.Index(i), item, fmt.Sprintf("%s[%d]", name, i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Map:
+		table, ok := configTable(raw)
+		if !ok {
+			return wrongType("a table of settings")
+		}
+		m := reflect.MakeMapWithSize(v.Type(), len(table))
+		for key, item := range table {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := setConfigValue(elem, item, name+"."+key); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(key), elem)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		table, ok := configTable(raw)
+		if !ok {
+			return wrongType("a table of settings")
+		}
+		fields := make(map[string]int, v.NumField())
+		for i := range v.NumField() {
+			if v.Type().Field(i).IsExported() {
+				fields[configName(v.Type().Field(i).Name)] = i
+			}
+		}
+		for key, item := range table {
+			path := key
+			if name != "" {
+				path = name + "." + key
+			}
+			i, ok := fields[configName(key)]
+			if !ok {
+				return fmt.Errorf("unknown setting %s", path)
+			}
+			if err := setConfigValue(v.Field(i), item, path); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%s: cannot be set from a file", name)
+	}
+	return nil
+}
+
+// configInt reads the integers YAML and TOML decode, and floats with no
+// fraction.
+func configInt(raw any) (int64, bool) {
+	switch n := raw.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint64:
+		return int64(n), n <= math.MaxInt64
+	case float64:
+		return int64(n), n == math.Trunc(n) && math.Abs(n) < 1<<63
+	}
+	return 0, false
+}
+
+// configTable reads a YAML mapping or TOML table with string keys.
+func configTable(raw any) (map[string]any, bool) {
+	switch t := raw.(type) {
+	case map[string]any:
+		return t, true
+	case map[any]any:
+		table := make(map[string]any, len(t))
+		for k, v := range t {
+			key, ok := k.(string)
+			if !ok {
+				return nil, false
+			}
+			table[key] = v
+		}
+		return table, true
+	}
+	return nil, false
+}
+
+// validate checks the settings that no type can, reporting every problem
+// at once.
+func (config *Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(config.Addr != "", "addr must not be empty")
+	check(config.Capacity >= 0, "capacity must not be negative, not %d", config.Capacity)
+	check(config.DefaultTTL > 0, "default_ttl must be positive, not %v", config.DefaultTTL)
+	check(config.MaxBodyBytes > 0, "max_body_bytes must be positive, not %d", config.MaxBodyBytes)
+	check(config.MaxValueBytes >= 0, "max_value_bytes must not be negative, not %d", config.MaxValueBytes)
+	check(config.ChunkSize >= 0, "chunk_size must not be negative, not %d", config.ChunkSize)
+	check(config.DedupAbove >= 0, "dedup_above must not be negative, not %d", config.DedupAbove)
+	check(config.MemoryLimit >= 0, "memory_limit must not be negative, not %d", config.MemoryLimit)
+	check(config.MemoryPressure <= 1, "memory_pressure must be at most 1, or negative for off, not %v", config.MemoryPressure)
+	check(config.OversizeValues == "reject" || config.OversizeValues == "truncate", "oversize_values must be reject or truncate, not %q", config.OversizeValues)
+	check(config.RequestTimeout >= 0, "request_timeout must not be negative")
+	check(config.ShutdownTimeout >= 0, "shutdown_timeout must not be negative")
+	check(config.Log.Format == "text" || config.Log.Format == "json", "log.format must be text or json, not %q", config.Log.Format)
+	var level slog.Level
+	check(level.UnmarshalText([]byte(config.Log.Level)) == nil, "log.level must be debug, info, warn or error, not %q", config.Log.Level)
+	for name, l := range config.Log.Subsystems {
+		_, known := logLevels[name]
+		check(known, "log.subsystems: unknown subsystem %q; want one of %s", name, strings.Join(logSubsystems, ", "))
+		check(level.UnmarshalText([]byte(l)) == nil, "log.subsystems.%s must be debug, info, warn or error, not %q", name, l)
+	}
+	check(config.RateLimit.RPS >= 0 && config.RateLimit.Burst >= 0, "rate_limit.rps and rate_limit.burst must not be negative")
+	check(config.RateLimit.MaxClients >= 0, "rate_limit.max_clients must not be negative")
+	check(slices.Contains(recordSerializers, config.Serializer), "serializer must be one of %s, not %q", strings.Join(recordSerializers, ", "), config.Serializer)
+	for _, f := range config.Features {
+		check(slices.Contains(knownFeatures, f), "features: unknown feature %q; want one of %s", f, strings.Join(knownFeatures, ", "))
+	}
+	check(config.RESPAddr == "" || config.feature(featureRESP), "resp_addr needs the %q feature", featureRESP)
+	check(config.Replication.PrimaryURL == "" || config.feature(featureReplication), "replication.primary_url needs the %q feature", featureReplication)
+	check(config.Overflow.Path == "" || config.feature(featureDiskTier), "overflow.path needs the %q feature", featureDiskTier)
+	check(slices.Contains(engineKinds, config.Engine.Kind), "engine.kind must be one of %s, not %q", strings.Join(engineKinds, ", "), config.Engine.Kind)
+	check(config.Engine.Shards >= 1, "engine.shards must be at least 1, not %d", config.Engine.Shards)
+	_, err := parseEvictionPolicy(config.Engine.Policy)
+	check(err == nil, "engine.policy: %v", err)
+	check(config.Engine.L1Capacity >= 1, "engine.l1_capacity must be at least 1, not %d", config.Engine.L1Capacity)
+	if config.Engine.Kind != engineLRU {
+		for _, f := range config.lruFeatures() {
+			check(!f.on, "%s needs the lru engine", f.setting)
+		}
+	}
+	check(len(config.Cluster.Nodes) == 0 || config.Cluster.Self != "", "cluster.self is needed with cluster.nodes")
+	check(!config.Cluster.Handoff.Enabled || config.Cluster.Proxy, "cluster.handoff needs cluster.proxy")
+	check(config.Regions.Name == "" || len(config.Regions.Peers) > 0, "regions.peers is needed with regions.name")
+	check(config.Regions.Name == "" || config.Regions.TombstoneTTL > 0, "regions.tombstone_ttl must be positive")
+	for i, hook := range config.Webhooks.Hooks {
+		_, err := newWebhook(Webhook{URL: hook.URL, Namespace: hook.Namespace, Pattern: hook.Pattern, Events: hook.Events})
+		check(err == nil, "webhooks.hooks[%d]: %v", i, err)
+	}
+	for name, ns := range config.Namespaces {
+		check(ns.MaxEntries >= 0 && ns.MaxBytes >= 0, "namespaces.%s: quotas must not be negative", name)
+		check(ns.TTL >= 0, "namespaces.%s.ttl must not be negative", name)
+		_, err := parseEvictionPolicy(ns.Eviction)
+		check(err == nil, "namespaces.%s.eviction: %v", name, err)
+		_, err = parseCompression(ns.Compression)
+		check(err == nil, "namespaces.%s.compression: %v", name, err)
+		check(ns.CompressAbove >= 0, "namespaces.%s.compress_above must not be negative", name)
+		check(ns.ReservedBytes >= 0, "namespaces.%s.reserved_bytes must not be negative", name)
+		check(ns.MaxBytes == 0 || ns.ReservedBytes <= ns.MaxBytes, "namespaces.%s.reserved_bytes must not exceed max_bytes", name)
+		_, err = serializerNamed(ns.Serializer)
+		check(err == nil, "namespaces.%s.serializer: %v", name, err)
+		check(len(ns.Keys) == 0 || config.Auth.Enabled, "namespaces.%s.keys needs auth.enabled", name)
+		for key, p := range ns.Keys {
+			perm, err := parsePermission(p)
+			check(err == nil && perm != permAdmin, "namespaces.%s.keys: api key %q must be read or read-write, not %q", name, key, p)
+		}
+	}
+	return errors.Join(errs...)
+}
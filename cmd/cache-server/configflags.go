@@ -0,0 +1,234 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configEnvPrefix starts the environment variables that override the
+// config file.
+const configEnvPrefix = "CACHE_"
+
+// configFlags are the settings common enough to have a flag, which
+// overrides both the file and the environment.
+var configFlags = []struct {
+	name, setting, usage string
+}{
+	{"addr", "Addr", "address to serve the HTTP API on"},
+	{"admin-addr", "AdminAddr", "address to serve admin routes on; empty serves them on -addr"},
+	{"capacity", "Capacity", "most entries to hold; 0 sizes the cache to the memory limit"},
+	{"ttl", "DefaultTTL", "how long to keep entries written without a TTL, e.g. 5m"},
+	{"max-body-bytes", "MaxBodyBytes", "largest request body accepted"},
+	{"max-value-bytes", "MaxValueBytes", "largest value stored; 0 is no limit"},
+	{"memory-pressure", "MemoryPressure", "fraction of the memory limit past which to evict; 0 is the default and negative is off"},
+	{"log-level", "Log.Level", "debug, info, warn or error"},
+	{"log-format", "Log.Format", "text or json"},
+	{"snapshot", "Snapshot.Path", "file to snapshot the cache to and restore it from"},
+	{"aof", "AOF.Path", "append-only log of writes"},
+	{"cluster-self", "Cluster.Self", "this node's base URL in cluster mode"},
+	{"cluster-nodes", "Cluster.Nodes", "comma-separated base URLs of the cluster's nodes"},
+	{"primary", "Replication.PrimaryURL", "admin base URL of the primary to replicate"},
+	{"engine", "Engine.Kind", "storage engine: lru, lfu, sharded or tiered"},
+	{"features", "Features", "comma-separated experimental subsystems to turn on: resp, replication, disk-tier"},
+}
+
+// loadConfig builds the configuration from, in increasing precedence,
+// the defaults, the file named by -config or CACHE_CONFIG, the CACHE_*
+// environment variables and the flags in args, and validates it.
+//
+// Every setting has a variable: CACHE_ and its path in the file, in upper
+// case with underscores, such as CACHE_CAPACITY, CACHE_REQUEST_TIMEOUT or
+// CACHE_CLUSTER_SELF. Lists are comma-separated, as are maps of
+// key=value pairs. An unknown CACHE_* variable is an error.
+func loadConfig(args []string, environ []string) (Config, error) {
+	config := defaultConfig()
+	fs := flag.NewFlagSet("cache", flag.ContinueOnError)
+	configPath := fs.String("config", "", "read settings from this YAML or TOML `file` (CACHE_CONFIG)")
+	port := fs.Int("port", 0, "port to serve the HTTP API on, keeping the host of -addr")
+	skipRestore := fs.Bool("skip-restore", false, "start empty instead of restoring the snapshot")
+	values := make(map[string]*string, len(configFlags))
+	for _, f := range configFlags {
+		def, _ := configString(config, f.setting)
+		values[f.name] = fs.String(f.name, def, f.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if name, value, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(name, configEnvPrefix) {
+			env[name] = value
+		}
+	}
+	path := env[configEnvPrefix+"CONFIG"]
+	delete(env, configEnvPrefix+"CONFIG")
+	if *configPath != "" {
+		path = *configPath
+	}
+	if path != "" {
+		if err := loadConfigFile(path, &config); err != nil {
+			return Config{}, err
+		}
+	}
+
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	root := reflect.ValueOf(&config).Elem()
+	for _, name := range names {
+		segments := strings.Split(strings.TrimPrefix(name, configEnvPrefix), "_")
+		if err := setConfigPath(root, segments, env[name], name); err != nil {
+			return Config{}, err
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		switch f.Name {
+		case "port":
+			host, _, splitErr := net.SplitHostPort(config.Addr)
+			if splitErr != nil {
+				err = fmt.Errorf("-port: cannot set the port of addr %q", config.Addr)
+				return
+			}
+			config.Addr = net.JoinHostPort(host, strconv.Itoa(*port))
+		case "skip-restore":
+			config.Snapshot.SkipRestore = *skipRestore
+		default:
+			for _, cf := range configFlags {
+				if cf.name == f.Name {
+					err = setConfigPath(root, strings.Split(cf.setting, "."), *values[f.Name], "-"+f.Name)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return Config{}, err
+	}
+	if err := config.validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return config, nil
+}
+
+// setConfigPath sets the setting whose path is segments, words matched
+// against the field names as in the file, to the string value. name is
+// the variable or flag, for errors.
+func setConfigPath(v reflect.Value, segments []string, value, name string) error {
+	if len(segments) == 0 {
+		return setConfigString(v, value, name)
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("unknown setting %s", name)
+	}
+	// The longest run of words that names a field, so that
+	// REQUEST_TIMEOUT is RequestTimeout rather than Request.Timeout.
+	for n := len(segments); n > 0; n-- {
+		want := configName(strings.Join(segments[:n], ""))
+		for i := range v.NumField() {
+			if v.Type().Field(i).IsExported() && configName(v.Type().Field(i).Name) == want {
+				return setConfigPath(v.Field(i), segments[n:], value, name)
+			}
+		}
+	}
+	return fmt.Errorf("unknown setting %s", name)
+}
+
+// setConfigString parses s as the value of v: as a number, bool or
+// duration, a comma-separated list, or comma-separated key=value pairs.
+func setConfigString(v reflect.Value, s, name string) error {
+	if v.Type() == durationType || v.Type() == fileModeType {
+		return setConfigValue(v, s, name)
+	}
+	wrongType := func(want string) error {
+		return fmt.Errorf("%s: want %s, not %q", name, want, s)
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return setConfigValue(v, s, name)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return wrongType("true or false")
+		}
+		return setConfigValue(v, b, name)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return wrongType("an integer")
+		}
+		return setConfigValue(v, n, name)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return wrongType("a number")
+		}
+		return setConfigValue(v, f, name)
+	case reflect.Slice:
+		var items []string
+		if s != "" {
+			items = strings.Split(s, ",")
+		}
+		list := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setConfigString(list.Index(i), strings.TrimSpace(item), fmt.Sprintf("%s[%d]", name, i)); err != nil {
+				return err
+			}
+		}
+		v.Set(list)
+		return nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String || v.Type().Elem().Kind() == reflect.Struct {
+			break
+		}
+		m := reflect.MakeMap(v.Type())
+		for _, pair := range strings.Split(s, ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			key, item, ok := strings.Cut(pair, "=")
+			if !ok {
+				return wrongType("key=value pairs")
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := setConfigString(elem, strings.TrimSpace(item), name+"."+strings.TrimSpace(key)); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(key)), elem)
+		}
+		v.Set(m)
+		return nil
+	}
+	return fmt.Errorf("%s cannot be set from a string; use the config file", name)
+}
+
+// configString formats the setting at the dotted path, for flag defaults.
+func configString(config Config, setting string) (string, bool) {
+	v := reflect.ValueOf(config)
+	for _, field := range strings.Split(setting, ".") {
+		v = v.FieldByName(field)
+		if !v.IsValid() {
+			return "", false
+		}
+	}
+	if v.Kind() == reflect.Slice {
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return strings.Join(items, ","), true
+	}
+	return fmt.Sprint(v.Interface()), true
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+)
+
+// The operations of the Raft log.
+const (
+	raftSet    = "set"
+	raftDelete = "delete"
+)
+
+// raftCommand is one entry of the Raft log. The expiry is absolute, set
+// by the leader, so that every node applying the entry agrees on it; the
+// preconditions are checked as it is applied.
+type raftCommand struct {
+	Op          string `json:"op"`
+	Key         string `json:"key"`
+	Value       string `json:"value,omitempty"`
+	ExpiresAt   int64  `json:"expires_at,omitempty"`
+	IfMatch     string `json:"if_match,omitempty"`
+	IfNoneMatch string `json:"if_none_match,omitempty"`
+	RequestID   string `json:"request_id,omitempty"`
+}
+
+// raftResult is what applying a command returns to the leader: the entry
+// and whether it was stored, or for a delete whether there was one.
+type raftResult struct {
+	item Item
+	ok   bool
+}
+
+// consensus runs the strongly consistent mode of RaftConfig. The cache is
+// Raft's state machine, and an entry's version is the index of the log
+// entry that wrote it, so ETags agree on every node.
+type consensus struct {
+	raft   *raft.Raft
+	store  *raftStore
+	cache  *LRUCache
+	config RaftConfig
+	urls   map[raft.ServerID]string
+	// barrierTerm is the last term in which this node, as leader, has
+	// applied everything committed before it led.
+	barrierTerm atomic.Uint64
+
+	mutex   sync.Mutex
+	proxies map[string]*httputil.ReverseProxy
+}
+
+func startConsensus(cache *LRUCache, config RaftConfig) (*consensus, error) {
+	c := &consensus{cache: cache, config: config, urls: make(map[raft.ServerID]string), proxies: make(map[string]*httputil.ReverseProxy)}
+	var self *RaftPeer
+	servers := make([]raft.Server, 0, len(config.Peers))
+	for i, peer := range config.Peers {
+		if peer.ID == config.NodeID {
+			self = &config.Peers[i]
+		}
+		if _, err := url.Parse(peer.URL); err != nil || peer.URL == "" {
+			return nil, fmt.Errorf("peer %q has no valid URL", peer.ID)
+		}
+		c.urls[raft.ServerID(peer.ID)] = peer.URL
+		servers = append(servers, raft.Server{ID: raft.ServerID(peer.ID), Address: raft.ServerAddress(peer.Addr)})
+	}
+	if self == nil {
+		return nil, fmt.Errorf("node %q is not one of the peers", config.NodeID)
+	}
+	advertise, err := net.ResolveTCPAddr("tcp", self.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("address of %q: %w", self.ID, err)
+	}
+
+	if err := os.MkdirAll(config.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	if c.store, err = openRaftStore(filepath.Join(config.Dir, "raft.db")); err != nil {
+		return nil, err
+	}
+	logger := hclog.New(&hclog.LoggerOptions{Name: "raft", Level: hclog.Debug, Output: libraryLog{prefix: "raft: "}, DisableTime: true})
+	snapshots, err := raft.NewFileSnapshotStoreWithLogger(config.Dir, 2, logger)
+	if err != nil {
+		c.store.Close()
+		return nil, err
+	}
+	transport, err := raft.NewTCPTransportWithLogger(config.BindAddr, advertise, 3, 10*time.Second, logger)
+	if err != nil {
+		c.store.Close()
+		return nil, err
+	}
+	existing, err := raft.HasExistingState(c.store, c.store, snapshots)
+	if err != nil {
+		transport.Close()
+		c.store.Close()
+		return nil, err
+	}
+
+	rc := raft.DefaultConfig()
+	rc.LocalID = raft.ServerID(config.NodeID)
+	rc.Logger = logger
+	if c.raft, err = raft.NewRaft(rc, &raftFSM{cache: cache}, c.store, c.store, snapshots, transport); err != nil {
+		transport.Close()
+		c.store.Close()
+		return nil, err
+	}
+	if !existing {
+		// Every peer bootstraps the same configuration, so whichever
+		// starts first, they agree on the group.
+		if err := c.raft.BootstrapCluster(raft.Configuration{Servers: servers}).Error(); err != nil && !errors.Is(err, raft.ErrCantBootstrap) {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Set commits a write of key through the log, with the preconditions of
+// the If-Match and If-None-Match values given. A zero ttl is the key's
+// default.
+func (c *consensus) Set(ctx context.Context, key, value string, ttl time.Duration, ifMatch, ifNoneMatch string) (Item, bool, error) {
+	if ttl <= 0 {
+		ttl = c.cache.TTLFor(key)
+	}
+	return c.apply(raftCommand{
+		Op: raftSet, Key: key, Value: value, ExpiresAt: time.Now().Add(ttl).UnixMilli(),
+		IfMatch: ifMatch, IfNoneMatch: ifNoneMatch, RequestID: requestIDFrom(ctx),
+	})
+}
+
+// Delete commits a delete of key through the log and reports whether
+// there was an entry to delete.
+func (c *consensus) Delete(ctx context.Context, key string) (bool, error) {
+	_, found, err := c.apply(raftCommand{Op: raftDelete, Key: key, RequestID: requestIDFrom(ctx)})
+	return found, err
+}
+
+func (c *consensus) apply(cmd raftCommand) (Item, bool, error) {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return Item{}, false, err
+	}
+	f := c.raft.Apply(data, c.config.ApplyTimeout)
+	if err := f.Error(); err != nil {
+		return Item{}, false, err
+	}
+	switch resp := f.Response().(type) {
+	case raftResult:
+		return resp.item, resp.ok, nil
+	case error:
+		return Item{}, false, resp
+	}
+	return Item{}, false, fmt.Errorf("unexpected Raft response %T", f.Response())
+}
+
+// linearize returns once reads on this node, the leader, see every write
+// committed before it was called: it confirms with a majority that this
+// node still leads and waits for the commit index at the call to be
+// applied.
+func (c *consensus) linearize(ctx context.Context) error {
+	if term := c.raft.CurrentTerm(); c.barrierTerm.Load() != term {
+		// Until an entry of its own term commits, a new leader's commit
+		// index can be behind writes the last leader acknowledged.
+		if err := c.raft.Barrier(c.config.ApplyTimeout).Error(); err != nil {
+			return err
+		}
+		c.barrierTerm.Store(term)
+	}
+	commit := c.raft.CommitIndex()
+	if err := c.raft.VerifyLeader().Error(); err != nil {
+		return err
+	}
+	for c.raft.AppliedIndex() < commit {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// leaderURL is the base URL of the leader, or empty while there is none.
+func (c *consensus) leaderURL() string {
+	_, id := c.raft.LeaderWithID()
+	return c.urls[id]
+}
+
+func (c *consensus) proxyTo(node string) *httputil.ReverseProxy {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if p, ok := c.proxies[node]; ok {
+		return p
+	}
+	target, _ := url.Parse(node)
+	p := nodeProxy(target, c.urls[raft.ServerID(c.config.NodeID)])
+	c.proxies[node] = p
+	return p
+}
+
+// Route serves the /v1/keys/{key} requests on the leader, after making
+// reads linearizable, and proxies them there from the other nodes. A nil
+// consensus serves everything.
+func (c *consensus) Route(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.raft.State() != raft.Leader {
+			leader := c.leaderURL()
+			if leader == "" || r.Header.Get(forwardedHeader) != "" {
+				// A forwarded request means the nodes disagree on the
+				// leader, as they briefly can during an election.
+				w.Header().Set("Retry-After", "1")
+				writeError(w, http.StatusServiceUnavailable, "no Raft leader")
+				return
+			}
+			c.proxyTo(leader).ServeHTTP(w, r)
+			return
+		}
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			if err := c.linearize(r.Context()); err != nil {
+				writeRaftError(w, err)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RefuseWrites refuses the HTTP writes that would bypass the log. A nil
+// consensus allows everything.
+func (c *consensus) RefuseWrites(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotImplemented, "in consistent mode, write with PUT or DELETE /v1/keys/{key}")
+	})
+}
+
+func writeRaftError(w http.ResponseWriter, err error) {
+	w.Header().Set("Retry-After", "1")
+	writeError(w, http.StatusServiceUnavailable, "not committed: "+err.Error())
+}
+
+// Close stops taking part in the group.
+func (c *consensus) Close() {
+	if err := c.raft.Shutdown().Error(); err != nil {
+		clusterLog.Error("Failed to shut down Raft", "err", err)
+	}
+	if err := c.store.Close(); err != nil {
+		clusterLog.Error("Failed to close Raft store", "err", err)
+	}
+}
+
+// V1RaftHandler reports this node's view of the Raft group.
+func (h *CacheHandler) V1RaftHandler(w http.ResponseWriter, r *http.Request) {
+	c := h.consensus
+	if c == nil {
+		writeError(w, http.StatusNotFound, "consistent mode is off")
+		return
+	}
+	_, leader := c.raft.LeaderWithID()
+	status := &RaftStatus{
+		ID:           c.config.NodeID,
+		State:        c.raft.State().String(),
+		Leader:       string(leader),
+		LeaderURL:    c.urls[leader],
+		Term:         c.raft.CurrentTerm(),
+		CommitIndex:  c.raft.CommitIndex(),
+		AppliedIndex: c.raft.AppliedIndex(),
+		Peers:        []RaftServer{},
+	}
+	if f := c.raft.GetConfiguration(); f.Error() == nil {
+		for _, s := range f.Configuration().Servers {
+			status.Peers = append(status.Peers, RaftServer{ID: string(s.ID), Addr: string(s.Address), Voter: s.Suffrage == raft.Voter})
+		}
+		sort.Slice(status.Peers, func(i, j int) bool { return status.Peers[i].ID < status.Peers[j].ID })
+	}
+	writeResponse(w, r, status)
+}
+
+// raftFSM applies the log to the cache.
+type raftFSM struct {
+	cache *LRUCache
+}
+
+func (f *raftFSM) Apply(log *raft.Log) any {
+	var cmd raftCommand
+	if err := json.Unmarshal(log.Data, &cmd); err != nil {
+		clusterLog.Error("Failed to decode Raft log entry", "index", log.Index, "err", err)
+		return err
+	}
+	ctx := withRequestID(context.Background(), cmd.RequestID)
+	switch cmd.Op {
+	case raftSet:
+		cond, conditional := precondition(cmd.IfMatch, cmd.IfNoneMatch)
+		// An entry replayed after its expiry is stored and expires at
+		// once, as it would have if it had been applied in time.
+		ttl := max(time.Until(time.UnixMilli(cmd.ExpiresAt)), time.Millisecond)
+		item, stored := f.cache.Update(ctx, cmd.Key, func(current Item, ok bool) (Write, bool) {
+			return Write{Value: cmd.Value, TTL: ttl, Version: log.Index}, !conditional || cond(current, ok)
+		})
+		return raftResult{item: item, ok: stored}
+	case raftDelete:
+		return raftResult{ok: f.cache.Delete(ctx, cmd.Key)}
+	}
+	return fmt.Errorf("unknown Raft operation %q", cmd.Op)
+}
+
+// Snapshot copies the entries; Raft does not apply the log while it does.
+func (f *raftFSM) Snapshot() (raft.FSMSnapshot, error) {
+	return raftSnapshot(f.cache.Entries()), nil
+}
+
+// Restore replaces the cache contents with a snapshot's.
+func (f *raftFSM) Restore(r io.ReadCloser) error {
+	defer r.Close()
+	var writes []Write
+	now := time.Now()
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var item Item
+		if err := dec.Decode(&item); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if now.Before(item.ExpiresAt) {
+			writes = append(writes, Write{Key: item.Key, Value: item.Value, TTL: item.ExpiresAt.Sub(now), Flags: item.Flags, Version: item.Version})
+		}
+	}
+	ctx := context.Background()
+	f.cache.Flush(ctx)
+	loadWrites(ctx, f.cache, writes)
+	return nil
+}
+
+// raftSnapshot is the cache contents as JSON lines, most recently used
+// first.
+type raftSnapshot []Item
+
+func (s raftSnapshot) Persist(sink raft.SnapshotSink) error {
+	w := bufio.NewWriter(sink)
+	enc := json.NewEncoder(w)
+	for _, item := range s {
+		if err := enc.Encode(item); err != nil {
+			sink.Cancel()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (raftSnapshot) Release() {}
@@ -0,0 +1,133 @@
+"use strict";
+
+const apiKeyInput = document.getElementById("api-key");
+apiKeyInput.value = localStorage.getItem("cache-api-key") || "";
+apiKeyInput.addEventListener("change", () => {
+  localStorage.setItem("cache-api-key", apiKeyInput.value);
+});
+
+function api(method, path, body) {
+  const headers = { Accept: "application/json" };
+  if (apiKeyInput.value) {
+    headers["X-API-Key"] = apiKeyInput.value;
+  }
+  if (body !== undefined) {
+    headers["Content-Type"] = "application/json";
+    body = JSON.stringify(body);
+  }
+  return fetch(path, { method, headers, body }).then(async (resp) => {
+    const text = await resp.text();
+    if (!resp.ok) {
+      throw new Error(`${resp.status} ${text.trim()}`);
+    }
+    return text ? JSON.parse(text) : null;
+  });
+}
+
+const history = [];
+const maxPoints = 120;
+let previous = null;
+
+function updateStats() {
+  api("GET", "/v1/stats")
+    .then((stats) => {
+      for (const id of ["entries", "hits", "misses", "evictions", "expirations"]) {
+        document.getElementById(id).textContent = stats[id];
+      }
+      document.getElementById("hit-rate").textContent = (stats.hit_rate * 100).toFixed(1) + "%";
+
+      if (previous) {
+        const hits = stats.hits - previous.hits;
+        const lookups = hits + stats.misses - previous.misses;
+        history.push(lookups > 0 ? hits / lookups : null);
+        if (history.length > maxPoints) {
+          history.shift();
+        }
+      }
+      previous = stats;
+      drawGraph();
+
+      const rows = stats.hot_keys.map((k) => {
+        const row = document.createElement("tr");
+        for (const text of [k.key, k.hits]) {
+          const cell = document.createElement("td");
+          cell.textContent = text;
+          row.appendChild(cell);
+        }
+        return row;
+      });
+      document.getElementById("hot-keys").replaceChildren(...rows);
+    })
+    .catch((err) => {
+      document.getElementById("result").textContent = err.message;
+    });
+}
+
+function drawGraph() {
+  const canvas = document.getElementById("hit-rate-graph");
+  const ctx = canvas.getContext("2d");
+  ctx.clearRect(0, 0, canvas.width, canvas.height);
+  ctx.strokeStyle = "#dde";
+  ctx.beginPath();
+  ctx.moveTo(0, canvas.height / 2);
+  ctx.lineTo(canvas.width, canvas.height / 2);
+  ctx.stroke();
+
+  ctx.strokeStyle = "#2a7de1";
+  ctx.lineWidth = 2;
+  ctx.beginPath();
+  let drawing = false;
+  history.forEach((rate, i) => {
+    if (rate === null) {
+      drawing = false;
+      return;
+    }
+    const x = (i / (maxPoints - 1)) * canvas.width;
+    const y = canvas.height - rate * canvas.height;
+    if (drawing) {
+      ctx.lineTo(x, y);
+    } else {
+      ctx.moveTo(x, y);
+      drawing = true;
+    }
+  });
+  ctx.stroke();
+}
+
+function show(promise) {
+  const result = document.getElementById("result");
+  promise
+    .then((data) => {
+      result.textContent = data ? JSON.stringify(data, null, 2) : "OK";
+      updateStats();
+    })
+    .catch((err) => {
+      result.textContent = err.message;
+    });
+}
+
+function onSubmit(id, handler) {
+  document.getElementById(id).addEventListener("submit", (event) => {
+    event.preventDefault();
+    show(handler(new FormData(event.target)));
+  });
+}
+
+function keyPath(form) {
+  return "/v1/keys/" + encodeURIComponent(form.get("key"));
+}
+
+onSubmit("get-form", (form) => api("GET", keyPath(form)));
+onSubmit("set-form", (form) =>
+  api("PUT", keyPath(form), { value: form.get("value"), ttl: Number(form.get("ttl") || 0) }),
+);
+onSubmit("delete-form", (form) => api("DELETE", keyPath(form)));
+onSubmit("flush-form", () => {
+  if (!confirm("Remove every entry from the cache?")) {
+    return Promise.resolve(null);
+  }
+  return api("DELETE", "/v1/keys");
+});
+
+updateStats();
+setInterval(updateStats, 1000);
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// withRequestTimeout bounds the request context, and with it loader calls
+// and bulk operations, by timeout.
+func withRequestTimeout(timeout time.Duration, next http.Handler) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// withoutConnDeadlines lifts the server's read and write timeouts for
+// long-lived streams, which would otherwise be cut off mid-stream.
+func withoutConnDeadlines(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		rc.SetReadDeadline(time.Time{})
+		rc.SetWriteDeadline(time.Time{})
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestCanceled reports whether the request's context is done, replying
+// 503 if it timed out. A client that went away gets no reply.
+func requestCanceled(w http.ResponseWriter, r *http.Request) bool {
+	err := r.Context().Err()
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeError(w, http.StatusServiceUnavailable, "request timed out")
+	}
+	return true
+}
+
+func writeLoadError(w http.ResponseWriter, r *http.Request, err error) {
+	if requestCanceled(w, r) {
+		return
+	}
+	writeError(w, http.StatusBadGateway, "loading from origin failed: "+err.Error())
+}
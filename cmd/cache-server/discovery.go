@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The in-cluster service account files every pod is given.
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	serviceAccountCA  = serviceAccountDir + "/ca.crt"
+	serviceAccountNS  = serviceAccountDir + "/namespace"
+	serviceAccountJWT = serviceAccountDir + "/token"
+)
+
+// discovery keeps the cluster's ring to the nodes a lookup finds, in DNS
+// or from Kubernetes, polling every interval. This node is always on its
+// own ring, as with gossip, so that it serves its keys while it is not
+// yet listed, as before its pod is ready. A failed or empty lookup
+// leaves the ring as it was.
+type discovery struct {
+	cluster  *cluster
+	lookup   func(ctx context.Context) ([]string, error)
+	interval time.Duration
+	stop     chan struct{}
+	done     sync.WaitGroup
+}
+
+func startDiscovery(config DiscoveryConfig, c *cluster) (*discovery, error) {
+	d := &discovery{cluster: c, interval: config.Interval, stop: make(chan struct{})}
+	scheme := config.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	switch config.Mode {
+	case "dns":
+		if config.Name == "" {
+			return nil, errors.New("dns discovery needs a name")
+		}
+		d.lookup = func(ctx context.Context) ([]string, error) { return lookupDNS(ctx, config, scheme) }
+	case "kubernetes":
+		k, err := newKubernetesDiscovery(config, scheme)
+		if err != nil {
+			return nil, err
+		}
+		d.lookup = k.lookup
+	default:
+		return nil, fmt.Errorf("unknown discovery mode %q", config.Mode)
+	}
+	d.done.Add(1)
+	go d.run()
+	return d, nil
+}
+
+func (d *discovery) run() {
+	defer d.done.Done()
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		d.refresh()
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *discovery) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), d.interval)
+	defer cancel()
+	nodes, err := d.lookup(ctx)
+	if err != nil {
+		clusterLog.Warn("Failed to discover nodes; keeping the ring", "err", err)
+		return
+	}
+	if len(nodes) == 0 {
+		clusterLog.Warn("Discovered no nodes; keeping the ring")
+		return
+	}
+	nodes = append(nodes, d.cluster.self)
+	slices.Sort(nodes)
+	nodes = slices.Compact(nodes)
+	if slices.Equal(nodes, d.cluster.ring.Load().Nodes()) {
+		return
+	}
+	if err := d.cluster.setNodes(nodes); err != nil {
+		clusterLog.Warn("Ignoring discovered nodes", "err", err)
+		return
+	}
+	clusterLog.Info("Cluster ring changed", "nodes", len(nodes))
+}
+
+func (d *discovery) Close() {
+	close(d.stop)
+	d.done.Wait()
+}
+
+// lookupDNS reads the nodes from the SRV records of config.Name if it has
+// any, as "_http._tcp.cache.default.svc.cluster.local" does, and
+// otherwise from its addresses, as those of a headless service, with
+// config.Port.
+func lookupDNS(ctx context.Context, config DiscoveryConfig, scheme string) ([]string, error) {
+	var nodes []string
+	_, srvs, err := net.DefaultResolver.LookupSRV(ctx, "", "", config.Name)
+	if err == nil {
+		for _, srv := range srvs {
+			host := strings.TrimSuffix(srv.Target, ".")
+			nodes = append(nodes, scheme+"://"+net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+		}
+		return nodes, nil
+	}
+	if config.Port == 0 {
+		return nil, err
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, config.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		nodes = append(nodes, scheme+"://"+net.JoinHostPort(addr, strconv.Itoa(config.Port)))
+	}
+	return nodes, nil
+}
+
+// kubernetesDiscovery lists the ready endpoints of a service through the
+// Kubernetes API, with the pod's service account. The account needs to
+// list endpointslices in the namespace.
+type kubernetesDiscovery struct {
+	client   *http.Client
+	url      string
+	portName string
+	port     int
+	scheme   string
+}
+
+func newKubernetesDiscovery(config DiscoveryConfig, scheme string) (*kubernetesDiscovery, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("kubernetes discovery needs to run in a pod")
+	}
+	if config.Service == "" {
+		return nil, errors.New("kubernetes discovery needs a service")
+	}
+	namespace := config.Namespace
+	if namespace == "" {
+		ns, err := os.ReadFile(serviceAccountNS)
+		if err != nil {
+			return nil, err
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+	ca, err := os.ReadFile(serviceAccountCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in %s", serviceAccountCA)
+	}
+	query := url.Values{"labelSelector": {"kubernetes.io/service-name=" + config.Service}}
+	return &kubernetesDiscovery{
+		client: &http.Client{
+			Timeout:   config.Interval,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+		url:      "https://" + net.JoinHostPort(host, port) + "/apis/discovery.k8s.io/v1/namespaces/" + url.PathEscape(namespace) + "/endpointslices?" + query.Encode(),
+		portName: config.PortName,
+		port:     config.Port,
+		scheme:   scheme,
+	}, nil
+}
+
+// endpointSliceList is the part of a discovery.k8s.io/v1 EndpointSliceList
+// that lookup reads.
+type endpointSliceList struct {
+	Items []struct {
+		Endpoints []struct {
+			Addresses  []string `json:"addresses"`
+			Conditions struct {
+				Ready *bool `json:"ready"`
+			} `json:"conditions"`
+		} `json:"endpoints"`
+		Ports []struct {
+			Name *string `json:"name"`
+			Port *int    `json:"port"`
+		} `json:"ports"`
+	} `json:"items"`
+}
+
+func (k *kubernetesDiscovery) lookup(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// The token is rotated, so it is read afresh every time.
+	token, err := os.ReadFile(serviceAccountJWT)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kubernetes API returned %s", resp.Status)
+	}
+	var list endpointSliceList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	var nodes []string
+	for _, slice := range list.Items {
+		port := k.port
+		for _, p := range slice.Ports {
+			if p.Port != nil && (k.portName == "" || (p.Name != nil && *p.Name == k.portName)) {
+				port = *p.Port
+				break
+			}
+		}
+		if port == 0 {
+			continue
+		}
+		for _, e := range slice.Endpoints {
+			// A missing condition means ready.
+			if e.Conditions.Ready != nil && !*e.Conditions.Ready {
+				continue
+			}
+			for _, addr := range e.Addresses {
+				nodes = append(nodes, k.scheme+"://"+net.JoinHostPort(addr, strconv.Itoa(port)))
+			}
+		}
+	}
+	return nodes, nil
+}
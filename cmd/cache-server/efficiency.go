@@ -0,0 +1,9 @@
+package main
+
+import "net/http"
+
+// EfficiencyHandler reports hit ratio, lifetime and churn over sliding
+// windows.
+func (h *CacheHandler) EfficiencyHandler(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, h.lru.Efficiency())
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"gocloud.dev/secrets"
+	_ "gocloud.dev/secrets/awskms"
+	_ "gocloud.dev/secrets/gcpkms"
+	"gocloud.dev/secrets/localsecrets"
+)
+
+// Encrypted snapshots and AOFs use envelope encryption: each file gets a
+// random AES-256 key, stored in its header wrapped by the key encryption
+// key, which is either a KMS key or a local key from the environment.
+// Every line after the header is sealed separately with AES-GCM, so the
+// AOF can still be appended to and a torn last line cut off.
+const encryptionAESGCM = "aes-256-gcm"
+
+// fileCipher wraps and unwraps file keys, and picks the serializer new
+// files' records are written with. A nil *fileCipher, or one without a
+// keeper, writes plaintext, and a nil one writes JSON.
+type fileCipher struct {
+	keeper     *secrets.Keeper
+	serializer serializer
+}
+
+// newFileCipher returns the cipher for config and the records serializer
+// s, or nil if encryption is not configured and s is JSON.
+func newFileCipher(config EncryptionConfig, s serializer) (*fileCipher, error) {
+	if config.KMSKeyURL != "" {
+		keeper, err := secrets.OpenKeeper(context.Background(), config.KMSKeyURL)
+		if err != nil {
+			return nil, err
+		}
+		return &fileCipher{keeper: keeper, serializer: s}, nil
+	}
+	encoded := os.Getenv(config.KeyEnv)
+	if config.KeyEnv == "" || encoded == "" {
+		if s.Name() == "json" {
+			return nil, nil
+		}
+		return &fileCipher{serializer: s}, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s must be 32 bytes, base64-encoded", config.KeyEnv)
+	}
+	return &fileCipher{keeper: localsecrets.NewKeeper([32]byte(key)), serializer: s}, nil
+}
+
+// encrypted reports whether new files are sealed.
+func (c *fileCipher) encrypted() bool {
+	return c != nil && c.keeper != nil
+}
+
+// recordSerializer is the serializer for new files' records.
+func (c *fileCipher) recordSerializer() serializer {
+	if c == nil || c.serializer == nil {
+		return jsonSerializer{}
+	}
+	return c.serializer
+}
+
+// newHeader returns the header for a new file and the codec for its lines.
+func (c *fileCipher) newHeader(format string, version int) (formatHeader, lineCodec, error) {
+	s := c.recordSerializer()
+	h := formatHeader{Format: format, Version: version}
+	if s.Name() != "json" {
+		h.Serializer = s.Name()
+	}
+	if !c.encrypted() {
+		return h, lineCodec{serializer: s}, nil
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	wrapped, err := c.keeper.Encrypt(ctx, key)
+	if err != nil {
+		return formatHeader{}, lineCodec{}, fmt.Errorf("wrapping file key: %w", err)
+	}
+	codec, err := newLineCodec(key, s)
+	if err != nil {
+		return formatHeader{}, lineCodec{}, err
+	}
+	h.Encryption, h.Key = encryptionAESGCM, wrapped
+	return h, codec, nil
+}
+
+// codec returns the codec for the lines of a file with header h.
+func (c *fileCipher) codec(h formatHeader) (lineCodec, error) {
+	s := serializer(jsonSerializer{})
+	if h.Serializer != "" {
+		var err error
+		if s, err = serializerNamed(h.Serializer); err != nil || !slices.Contains(recordSerializers, s.Name()) {
+			return lineCodec{}, fmt.Errorf("%w: serializer %q", errUnsupportedFormat, h.Serializer)
+		}
+	}
+	switch {
+	case h.Encryption == "":
+		return lineCodec{serializer: s}, nil
+	case h.Encryption != encryptionAESGCM:
+		return lineCodec{}, fmt.Errorf("%w: encryption %q", errUnsupportedFormat, h.Encryption)
+	case !c.encrypted():
+		return lineCodec{}, fmt.Errorf("%w: file is encrypted and no key is configured", errUnsupportedFormat)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	key, err := c.keeper.Decrypt(ctx, h.Key)
+	if err != nil {
+		return lineCodec{}, fmt.Errorf("unwrapping file key: %w", err)
+	}
+	return newLineCodec(key, s)
+}
+
+func (c *fileCipher) Close() error {
+	if !c.encrypted() {
+		return nil
+	}
+	return c.keeper.Close()
+}
+
+// lineCodec serializes records as lines, and seals them as base64 of
+// nonce and ciphertext. Without a cipher, binary serializations are
+// base64 alone. The zero value writes JSON lines as they are.
+type lineCodec struct {
+	aead       cipher.AEAD
+	serializer serializer
+}
+
+func newLineCodec(key []byte, s serializer) (lineCodec, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return lineCodec{}, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return lineCodec{}, err
+	}
+	return lineCodec{aead: aead, serializer: s}, nil
+}
+
+func (c lineCodec) serializerOrJSON() serializer {
+	if c.serializer == nil {
+		return jsonSerializer{}
+	}
+	return c.serializer
+}
+
+// marshal serializes v as a line, without its newline.
+func (c lineCodec) marshal(v any) ([]byte, error) {
+	s := c.serializerOrJSON()
+	data, err := s.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if c.aead == nil && s.Binary() {
+		return base64.StdEncoding.AppendEncode(nil, data), nil
+	}
+	return c.encode(data), nil
+}
+
+// unmarshal reverses marshal.
+func (c lineCodec) unmarshal(line []byte, v any) error {
+	s := c.serializerOrJSON()
+	data, err := c.decode(line)
+	if err == nil && c.aead == nil && s.Binary() {
+		data, err = base64.StdEncoding.AppendDecode(nil, data)
+	}
+	if err != nil {
+		return err
+	}
+	return s.Unmarshal(data, v)
+}
+
+func (c lineCodec) encode(line []byte) []byte {
+	if c.aead == nil {
+		return line
+	}
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(line)+c.aead.Overhead())
+	rand.Read(nonce)
+	sealed := c.aead.Seal(nonce, nonce, line, nil)
+	return base64.StdEncoding.AppendEncode(nil, sealed)
+}
+
+// decode reverses encode on a line without its newline.
+func (c lineCodec) decode(line []byte) ([]byte, error) {
+	if c.aead == nil {
+		return line, nil
+	}
+	sealed, err := base64.StdEncoding.AppendDecode(nil, line)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < c.aead.NonceSize() {
+		return nil, fmt.Errorf("sealed line too short")
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	return c.aead.Open(nil, nonce, ciphertext, nil)
+}
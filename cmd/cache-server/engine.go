@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"myproject/cache"
+)
+
+// The storage engines EngineConfig.Kind can choose.
+const (
+	engineLRU     = "lru"
+	engineLFU     = "lfu"
+	engineSharded = "sharded"
+	engineTiered  = "tiered"
+)
+
+var engineKinds = []string{engineLRU, engineLFU, engineSharded, engineTiered}
+
+// lruFeature is a setting that turns on something only the lru engine
+// has.
+type lruFeature struct {
+	setting string
+	on      bool
+}
+
+func (c Config) lruFeatures() []lruFeature {
+	return []lruFeature{
+		{"resp_addr", c.RESPAddr != ""},
+		{"memcache_addr", c.MemcacheAddr != ""},
+		{"binary_addr", c.BinaryAddr != ""},
+		{"grpc_addr", c.GRPCAddr != ""},
+		{"http3_addr", c.HTTP3Addr != ""},
+		{"chunk_size", c.ChunkSize > 0},
+		{"dedup_above", c.DedupAbove > 0},
+		{"memory_pressure", c.MemoryPressure > 0},
+		{"namespaces", len(c.Namespaces) > 0},
+		{"loader.url", c.Loader.URL != ""},
+		{"mqtt.broker_url", c.MQTT.BrokerURL != ""},
+		{"kafka.brokers", len(c.Kafka.Brokers) > 0},
+		{"audit.path", c.Audit.Path != ""},
+		{"audit.webhook_url", c.Audit.WebhookURL != ""},
+		{"webhooks.hooks", len(c.Webhooks.Hooks) > 0},
+		{"cluster.nodes", len(c.Cluster.Nodes) > 0},
+		{"cluster.discovery.mode", c.Cluster.Discovery.Mode != ""},
+		{"gossip.bind_addr", c.Gossip.BindAddr != ""},
+		{"replication.primary_url", c.Replication.PrimaryURL != ""},
+		{"l2.redis_addr", c.L2.RedisAddr != ""},
+		{"regions.name", c.Regions.Name != ""},
+		{"raft.node_id", c.Raft.NodeID != ""},
+		{"snapshot.path", c.Snapshot.Path != ""},
+		{"aof.path", c.AOF.Path != ""},
+		{"overflow.path", c.Overflow.Path != ""},
+		{"store.backend", c.Store.Backend != ""},
+		{"statsd.addr", c.StatsD.Addr != ""},
+	}
+}
+
+// newEngine makes the cache of an engine other than lru, which main
+// makes with all its options, holding capacity entries.
+func newEngine(config Config, capacity int) (Cache, error) {
+	opts := []cache.Option{cache.WithCapacity(capacity), cache.WithTTL(config.DefaultTTL)}
+	switch config.Engine.Kind {
+	case engineLFU:
+		return cache.NewEngine(append(opts, cache.WithPolicy(cache.EvictLFU))...)
+	case engineSharded:
+		// validate has checked the policy.
+		policy, _ := parseEvictionPolicy(config.Engine.Policy)
+		return cache.NewEngine(append(opts, cache.WithPolicy(policy), cache.WithShards(config.Engine.Shards))...)
+	}
+	l1, err := cache.NewLFU(cache.WithCapacity(config.Engine.L1Capacity), cache.WithTTL(config.DefaultTTL))
+	if err != nil {
+		return nil, err
+	}
+	l2, err := cache.NewCache(append(opts, cache.WithLogger(janitorLog))...)
+	if err != nil {
+		l1.Close()
+		return nil, err
+	}
+	return cache.NewTiered(l1, l2), nil
+}
+
+// get reads key, through the loader on the lru engine, which alone has
+// one.
+func (h *CacheHandler) get(ctx context.Context, key string) (Item, bool, error) {
+	if h.lru == nil {
+		item, ok := h.cache.GetItem(key)
+		return item, ok, nil
+	}
+	return h.lru.GetOrLoad(ctx, key)
+}
+
+// runEngine serves the routes marked x-any-engine from a cache of an
+// engine other than lru, on Addr. Nothing else runs: no admin listener,
+// no other protocols and no persistence, which validate has made sure
+// are not asked for.
+func runEngine(config Config) {
+	capacity := config.capacity()
+	engine, err := newEngine(config, capacity)
+	if err != nil {
+		fatal(serverLog, "Invalid cache configuration", "err", err)
+	}
+	auth, err := newAuthenticator(config.Auth, config.JWT, config.Namespaces)
+	if err != nil {
+		fatal(authLog, "Failed to initialize authentication", "err", err)
+	}
+	limiter := newRateLimiter(config.RateLimit)
+	auth.limiter = limiter
+	idempotency := newIdempotencyStore(config.Idempotency)
+	h := &CacheHandler{
+		cache:            engine,
+		maxBodyBytes:     config.MaxBodyBytes,
+		hotKeys:          10,
+		streamsDone:      make(chan struct{}),
+		privateResponses: config.Auth.Enabled || config.JWT.Enabled,
+	}
+
+	mux := http.NewServeMux()
+	registerAPIRoutes(h, func(route apiRoute, h http.Handler) {
+		if !route.AnyEngine {
+			return
+		}
+		h = withRequestTimeout(config.RequestTimeout, h)
+		if route.Idempotent {
+			h = idempotency.Middleware(h)
+		}
+		h = limiter.Middleware(h)
+		h = auth.Require(route.Permission, h)
+		mux.Handle(route.Pattern(), otelhttp.NewHandler(h, route.Pattern()))
+	})
+	mux.Handle("GET /readyz", limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })))
+	mux.Handle("GET /openapi", limiter.Middleware(http.HandlerFunc(swaggerUIHandler)))
+	mux.Handle("GET /openapi.yaml", limiter.Middleware(http.HandlerFunc(openAPISpecHandler)))
+
+	accessLog := newAccessLogger(config.AccessLog, os.Stdout)
+	server := &http.Server{
+		Handler:           requestIDMiddleware(clientMiddleware(accessLog.Middleware(corsMiddleware(mux, mux)))),
+		ReadHeaderTimeout: config.Server.ReadHeaderTimeout,
+		ReadTimeout:       config.Server.ReadTimeout,
+		WriteTimeout:      config.Server.WriteTimeout,
+		IdleTimeout:       config.Server.IdleTimeout,
+		MaxHeaderBytes:    config.Server.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(httpLog.Handler(), slog.LevelWarn),
+	}
+	if config.TLS.Enabled {
+		if server.TLSConfig, err = serverTLSConfig(config.TLS); err != nil {
+			fatal(serverLog, "Failed to configure TLS", "err", err)
+		}
+	}
+	ln, err := listen(config.Addr, config.SocketMode)
+	if err != nil {
+		fatal(serverLog, "Failed to listen", "addr", config.Addr, "err", err)
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		if config.TLS.Enabled {
+			serveErr <- server.ServeTLS(ln, "", "")
+		} else {
+			serveErr <- server.Serve(ln)
+		}
+	}()
+	serverLog.Info("Serving the key API", "addr", config.Addr, "engine", config.Engine.Kind, "capacity", capacity)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-serveErr:
+		fatal(serverLog, "Listener failed", "err", err)
+	case <-ctx.Done():
+	}
+	serverLog.Info("Shutting down, draining connections", "timeout", config.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		serverLog.Warn("Shutdown did not complete cleanly", "err", err)
+	}
+	engine.Close()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// exportFlushEvery bounds how many rows sit in the write buffer before they
+// are pushed to the client.
+const exportFlushEvery = 256
+
+// ExportHandler streams the entries under ?prefix= as NDJSON or CSV, in the
+// row format ImportHandler accepts, with ttl set to the seconds remaining.
+func (h *CacheHandler) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	format := query.Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+
+	var write func(importRow) error
+	var flush func() error
+	bw := bufio.NewWriter(w)
+	switch format {
+	case "ndjson", "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(bw)
+		write = func(row importRow) error { return enc.Encode(row) }
+		flush = bw.Flush
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(bw)
+		if err := cw.Write([]string{"key", "value", "ttl"}); err != nil {
+			return
+		}
+		write = func(row importRow) error {
+			return cw.Write([]string{row.Key, row.Value, strconv.FormatInt(row.TTL, 10)})
+		}
+		flush = func() error {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+			return bw.Flush()
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported format "+strconv.Quote(format))
+		return
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	flusher, _ := w.(http.Flusher)
+	n := 0
+	for item := range h.lru.Scan(prefix) {
+		if r.Context().Err() != nil {
+			return
+		}
+		ttl := max(remainingSeconds(item.ExpiresAt), 1)
+		if err := write(importRow{Key: item.Key, Value: item.Value, TTL: ttl}); err != nil {
+			return
+		}
+		if n++; n%exportFlushEvery == 0 {
+			if flush() != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	flush()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Snapshot and AOF files start with a header line naming their format and
+// version, which is bumped whenever entries change shape. Files written
+// before versioning have no header and are read as version 1. A file from
+// a newer build is refused, not misread: replacing the binary with an
+// older one must not lose the data a newer one wrote.
+//
+// Snapshot versions:
+//
+//	1  ttl_ms relative to the file's modification time
+//	2  expires_at, absolute Unix milliseconds, so copied or uploaded
+//	   snapshots keep their expirations
+//	3  the header may name an encryption (see encryption.go)
+//	4  a trailer with a checksum ends the file (see snapshotTrailer)
+//	5  the header may name a serializer (see serializer.go)
+//
+// AOF versions:
+//
+//	1  no header
+//	2  header; records unchanged
+//	3  the header may name an encryption
+//	4  the header may name a serializer
+const (
+	snapshotFormat  = "cache-snapshot"
+	snapshotVersion = 5
+	aofFormat       = "cache-aof"
+	aofVersion      = 4
+)
+
+var errUnsupportedFormat = errors.New("unsupported format")
+
+type formatHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	// Encryption, if set, is how the lines after the header are sealed,
+	// and Key their key, wrapped.
+	Encryption string `json:"encryption,omitempty"`
+	Key        []byte `json:"key,omitempty"`
+	// Serializer, if set, is how the records are encoded, in place of
+	// JSON.
+	Serializer string `json:"serializer,omitempty"`
+}
+
+func writeFormatHeader(w io.Writer, h formatHeader) (int, error) {
+	line, err := json.Marshal(h)
+	if err != nil {
+		return 0, err
+	}
+	return w.Write(append(line, '\n'))
+}
+
+// parseFormatHeader parses the first line of a file, and reports whether
+// it is a header at all; if it is not, the file is version 1 and the line
+// is its first entry.
+func parseFormatHeader(line []byte, format string, current int) (h formatHeader, isHeader bool, err error) {
+	if json.Unmarshal(line, &h) != nil || h.Format == "" {
+		return formatHeader{Format: format, Version: 1}, false, nil
+	}
+	if h.Format != format {
+		return h, true, fmt.Errorf("%w: file is a %s, not a %s", errUnsupportedFormat, h.Format, format)
+	}
+	if h.Version < 1 || h.Version > current {
+		return h, true, fmt.Errorf("%w: %s version %d, this build reads up to %d", errUnsupportedFormat, format, h.Version, current)
+	}
+	return h, true, nil
+}
+
+// upgradeAOF rewrites the log at path in the current version, sealed with
+// c and with its serializer, unless it already is, so that appending to
+// it yields a consistent file; a log from before versioning, or written
+// before encryption was turned on or off or the serializer changed, is
+// converted this way. It returns the codec for new
+// lines, or ok false if there is no log. The copy replaces the log
+// atomically.
+func upgradeAOF(path string, c *fileCipher) (codec lineCodec, ok bool, err error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return lineCodec{}, false, nil
+	}
+	if err != nil {
+		return lineCodec{}, false, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	first, err := r.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return lineCodec{}, false, err
+	}
+	if len(first) == 0 {
+		return lineCodec{}, false, nil
+	}
+	h, isHeader, err := parseFormatHeader(first, aofFormat, aofVersion)
+	if err != nil {
+		return lineCodec{}, false, err
+	}
+	old, err := c.codec(h)
+	if err != nil {
+		return lineCodec{}, false, err
+	}
+	if h.Version == aofVersion && (h.Encryption != "") == c.encrypted() && old.serializerOrJSON() == c.recordSerializer() {
+		return old, true, nil
+	}
+
+	h, codec, err = c.newHeader(aofFormat, aofVersion)
+	if err != nil {
+		return lineCodec{}, false, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return lineCodec{}, false, err
+	}
+	w := bufio.NewWriter(tmp)
+	_, err = writeFormatHeader(w, h)
+	var src io.Reader = r
+	if !isHeader {
+		src = io.MultiReader(bytes.NewReader(first), r)
+	}
+	lines := bufio.NewScanner(src)
+	lines.Buffer(nil, 1<<30)
+	for err == nil && lines.Scan() {
+		if len(bytes.TrimSpace(lines.Bytes())) == 0 {
+			continue
+		}
+		var record aofRecord
+		var line []byte
+		if err = old.unmarshal(lines.Bytes(), &record); err == nil {
+			line, err = codec.marshal(record)
+		}
+		if err == nil {
+			w.Write(line)
+			err = w.WriteByte('\n')
+		}
+	}
+	if err == nil {
+		err = lines.Err()
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return lineCodec{}, false, err
+	}
+	return codec, true, nil
+}
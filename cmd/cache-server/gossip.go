@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// gossipMeta is what each member tells the others about itself. It must
+// fit in memberlist.MetaMaxSize bytes.
+type gossipMeta struct {
+	URL  string            `json:"url,omitempty"`
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// gossip keeps track of the other servers with memberlist, which
+// discovers them from any one seed and finds failures by probing, without
+// a coordinator. In cluster mode the ring follows the members that are
+// alive. With a cache, it also broadcasts invalidations (see
+// invalidation.go).
+type gossip struct {
+	list    *memberlist.Memberlist
+	meta    []byte
+	cluster *cluster
+	cache   *LRUCache
+	// broadcasts holds invalidations for memberlist to piggyback;
+	// maxMsg is the longest that are.
+	broadcasts *memberlist.TransmitLimitedQueue
+	maxMsg     int
+	// changed is signalled when membership changes. The event callbacks
+	// run under memberlist's locks, so run rebuilds the ring instead.
+	changed chan struct{}
+	stop    chan struct{}
+	done    sync.WaitGroup
+}
+
+// startGossip joins the members at config.Join, advertising url as this
+// server's base URL. c, if not nil, gets the members' URLs as its ring.
+// cache, if not nil, has its keys dropped when other members invalidate
+// them; Record broadcasts its own.
+func startGossip(config GossipConfig, url string, c *cluster, cache *LRUCache) (*gossip, error) {
+	meta, err := json.Marshal(gossipMeta{URL: url, Tags: config.Tags})
+	if err != nil {
+		return nil, err
+	}
+	if len(meta) > memberlist.MetaMaxSize {
+		return nil, fmt.Errorf("tags and URL take %d bytes, more than the %d gossip allows", len(meta), memberlist.MetaMaxSize)
+	}
+
+	mc := memberlist.DefaultLANConfig()
+	if config.Name != "" {
+		mc.Name = config.Name
+	}
+	if mc.BindAddr, mc.BindPort, err = splitGossipAddr(config.BindAddr); err != nil {
+		return nil, fmt.Errorf("bind address: %w", err)
+	}
+	if mc.BindAddr == "" {
+		mc.BindAddr = "0.0.0.0"
+	}
+	if config.AdvertiseAddr != "" {
+		if mc.AdvertiseAddr, mc.AdvertisePort, err = splitGossipAddr(config.AdvertiseAddr); err != nil {
+			return nil, fmt.Errorf("advertise address: %w", err)
+		}
+	}
+	if config.SecretKey != "" {
+		mc.SecretKey = []byte(config.SecretKey)
+	}
+	mc.Logger = log.New(libraryLog{prefix: "memberlist: "}, "", 0)
+
+	g := &gossip{meta: meta, cluster: c, cache: cache, changed: make(chan struct{}, 1), stop: make(chan struct{})}
+	g.broadcasts = &memberlist.TransmitLimitedQueue{
+		NumNodes:       func() int { return g.list.NumMembers() },
+		RetransmitMult: mc.RetransmitMult,
+	}
+	// Leave room in each packet for the probe it rides on.
+	g.maxMsg = mc.UDPBufferSize / 2
+	mc.Delegate = g
+	mc.Events = g
+	if g.list, err = memberlist.Create(mc); err != nil {
+		return nil, err
+	}
+	if len(config.Join) > 0 {
+		// The seeds may not be up yet; they join this node when they are.
+		if n, err := g.list.Join(config.Join); err != nil {
+			clusterLog.Warn("Failed to join any gossip seed", "seeds", config.Join, "err", err)
+		} else {
+			clusterLog.Info("Joined gossip", "contacted", n)
+		}
+	}
+	g.done.Add(1)
+	go g.run()
+	return g, nil
+}
+
+func splitGossipAddr(addr string) (string, int, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	n, err := strconv.Atoi(port)
+	return host, n, err
+}
+
+func (g *gossip) run() {
+	defer g.done.Done()
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-g.changed:
+		}
+		if g.cluster == nil {
+			continue
+		}
+		// Members leaves out those that have failed or left; suspects
+		// stay until they are found dead.
+		var nodes []string
+		for _, n := range g.list.Members() {
+			var meta gossipMeta
+			if json.Unmarshal(n.Meta, &meta) == nil && meta.URL != "" {
+				nodes = append(nodes, meta.URL)
+			}
+		}
+		if err := g.cluster.setNodes(nodes); err != nil {
+			clusterLog.Warn("Ignoring membership change", "err", err)
+			continue
+		}
+		clusterLog.Info("Cluster ring changed", "nodes", len(nodes))
+	}
+}
+
+func (g *gossip) notify() {
+	select {
+	case g.changed <- struct{}{}:
+	default:
+	}
+}
+
+// NodeMeta and the rest of memberlist.Delegate share the metadata, and
+// invalidations through NotifyMsg and GetBroadcasts, but no state.
+func (g *gossip) NodeMeta(limit int) []byte              { return g.meta }
+func (g *gossip) LocalState(join bool) []byte            { return nil }
+func (g *gossip) MergeRemoteState(buf []byte, join bool) {}
+
+func (g *gossip) NotifyJoin(n *memberlist.Node) {
+	clusterLog.Info("Member joined", "name", n.Name, "addr", n.Address())
+	g.notify()
+}
+
+// NotifyLeave is called both for members that leave and for those found
+// dead; memberlist does not say which.
+func (g *gossip) NotifyLeave(n *memberlist.Node) {
+	clusterLog.Warn("Member left or failed", "name", n.Name, "addr", n.Address())
+	g.notify()
+}
+
+func (g *gossip) NotifyUpdate(n *memberlist.Node) {
+	g.notify()
+}
+
+// Close tells the other members this one is leaving, so they drop it
+// from their rings at once instead of waiting to find it dead.
+func (g *gossip) Close() {
+	if err := g.list.Leave(time.Second); err != nil {
+		clusterLog.Warn("Failed to announce leaving", "err", err)
+	}
+	g.list.Shutdown()
+	close(g.stop)
+	g.done.Wait()
+}
+
+// V1MembersHandler lists the members gossip knows of that have not
+// failed or left, this server included. Members suspected of failing are
+// listed until they are found dead.
+func (h *CacheHandler) V1MembersHandler(w http.ResponseWriter, r *http.Request) {
+	if h.gossip == nil {
+		writeError(w, http.StatusNotFound, "gossip is off")
+		return
+	}
+	list := &MemberList{Members: []Member{}}
+	for _, n := range h.gossip.list.Members() {
+		var meta gossipMeta
+		json.Unmarshal(n.Meta, &meta)
+		list.Members = append(list.Members, Member{Name: n.Name, Addr: n.Address(), URL: meta.URL, Tags: meta.Tags})
+	}
+	sort.Slice(list.Members, func(i, j int) bool { return list.Members[i].Name < list.Members[j].Name })
+	writeResponse(w, r, list)
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+const graphQLSchema = `
+schema {
+	query: Query
+	mutation: Mutation
+	subscription: Subscription
+}
+
+scalar Time
+
+type Query {
+	get(key: String!): Entry
+	mget(keys: [String!]!): [Entry!]!
+	stats(hotKeys: Int = 10): Stats!
+}
+
+type Mutation {
+	# ttl is in seconds; omitted or 0 uses the default.
+	set(key: String!, value: String!, ttl: Int): Entry!
+	delete(key: String!): Boolean!
+	# Requires the admin permission.
+	flush: Boolean!
+}
+
+type Subscription {
+	# Changes to keys matching any pattern (path.Match syntax), or to every
+	# key when none are given.
+	events(patterns: [String!]): Event!
+}
+
+type Entry {
+	key: String!
+	value: String!
+	ttl: Int!
+	expiresAt: Time!
+	# A uint64, as a string since GraphQL Int is 32 bits.
+	version: String!
+}
+
+type HotKey {
+	key: String!
+	hits: Float!
+}
+
+type Stats {
+	entries: Int!
+	capacity: Int!
+	hits: Float!
+	misses: Float!
+	hitRate: Float!
+	evictions: Float!
+	expirations: Float!
+	hotKeys: [HotKey!]!
+}
+
+type Event {
+	type: String!
+	key: String!
+	reason: String
+	requestId: String
+	time: Time!
+}
+`
+
+const graphQLMaxDepth = 10
+
+var errGraphQLForbidden = errors.New("insufficient permissions")
+
+func newGraphQLSchema(h *CacheHandler) *graphql.Schema {
+	return graphql.MustParseSchema(graphQLSchema, &graphQLResolver{h: h}, graphql.MaxDepth(graphQLMaxDepth))
+}
+
+// GraphQLHandler executes queries and mutations posted as
+// {"query", "operationName", "variables"}. The route needs only read; each
+// mutation checks the permission it needs.
+func (h *CacheHandler) GraphQLHandler(w http.ResponseWriter, r *http.Request) {
+	var req GraphQLRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, h.maxBodyBytes)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid GraphQL request: "+err.Error())
+		return
+	}
+	resp := h.graphQL.Exec(r.Context(), req.Query, req.OperationName, req.Variables)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+var graphQLUpgrader = websocket.Upgrader{
+	Subprotocols: []string{"graphql-transport-ws"},
+	CheckOrigin:  wsUpgrader.CheckOrigin,
+}
+
+// graphQLWSMessage is a frame of the graphql-transport-ws protocol.
+type graphQLWSMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// GraphQLWebSocketHandler serves subscriptions (and any other operation)
+// over the graphql-transport-ws protocol.
+func (h *CacheHandler) GraphQLWebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := graphQLUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var writeMutex sync.Mutex
+	send := func(msg graphQLWSMessage) error {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		return conn.WriteJSON(msg)
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-h.streamsDone:
+			writeMutex.Lock()
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(wsWriteWait))
+			writeMutex.Unlock()
+			conn.Close()
+		case <-ctx.Done():
+		}
+	}()
+
+	var opsMutex sync.Mutex
+	ops := make(map[string]context.CancelFunc)
+	defer func() {
+		opsMutex.Lock()
+		for _, stop := range ops {
+			stop()
+		}
+		opsMutex.Unlock()
+	}()
+
+	initialized := false
+	for {
+		var msg graphQLWSMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		switch msg.Type {
+		case "connection_init":
+			if initialized {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(4429, "Too many initialisation requests"), time.Now().Add(wsWriteWait))
+				return
+			}
+			initialized = true
+			send(graphQLWSMessage{Type: "connection_ack"})
+		case "ping":
+			send(graphQLWSMessage{Type: "pong"})
+		case "pong":
+		case "subscribe":
+			if !initialized {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(4401, "Unauthorized"), time.Now().Add(wsWriteWait))
+				return
+			}
+			var req GraphQLRequest
+			if err := json.Unmarshal(msg.Payload, &req); err != nil || msg.ID == "" {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(4400, "Invalid subscribe message"), time.Now().Add(wsWriteWait))
+				return
+			}
+			opsMutex.Lock()
+			if _, dup := ops[msg.ID]; dup {
+				opsMutex.Unlock()
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(4409, "Subscriber for "+msg.ID+" already exists"), time.Now().Add(wsWriteWait))
+				return
+			}
+			opCtx, stop := context.WithCancel(ctx)
+			ops[msg.ID] = stop
+			opsMutex.Unlock()
+
+			results, err := h.graphQL.Subscribe(opCtx, req.Query, req.OperationName, req.Variables)
+			if err != nil {
+				stop()
+				payload, _ := json.Marshal([]map[string]string{{"message": err.Error()}})
+				send(graphQLWSMessage{ID: msg.ID, Type: "error", Payload: payload})
+				continue
+			}
+			go func(id string) {
+				defer func() {
+					opsMutex.Lock()
+					_, active := ops[id]
+					delete(ops, id)
+					opsMutex.Unlock()
+					stop()
+					// A client-sent complete needs no reply.
+					if active {
+						send(graphQLWSMessage{ID: id, Type: "complete"})
+					}
+				}()
+				for result := range results {
+					payload, err := json.Marshal(result)
+					if err != nil {
+						continue
+					}
+					if send(graphQLWSMessage{ID: id, Type: "next", Payload: payload}) != nil {
+						return
+					}
+				}
+			}(msg.ID)
+		case "complete":
+			opsMutex.Lock()
+			if stop, ok := ops[msg.ID]; ok {
+				delete(ops, msg.ID)
+				stop()
+			}
+			opsMutex.Unlock()
+		default:
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(4400, "Unknown message type "+strconv.Quote(msg.Type)), time.Now().Add(wsWriteWait))
+			return
+		}
+	}
+}
+
+type graphQLResolver struct {
+	h *CacheHandler
+}
+
+func (q *graphQLResolver) Get(ctx context.Context, args struct{ Key string }) (*graphQLEntry, error) {
+	item, found, err := q.h.get(ctx, args.Key)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &graphQLEntry{item}, nil
+}
+
+func (q *graphQLResolver) Mget(ctx context.Context, args struct{ Keys []string }) ([]*graphQLEntry, error) {
+	entries := make([]*graphQLEntry, 0, len(args.Keys))
+	for _, key := range args.Keys {
+		item, found, err := q.h.get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			entries = append(entries, &graphQLEntry{item})
+		}
+	}
+	return entries, nil
+}
+
+func (q *graphQLResolver) Stats(args struct{ HotKeys int32 }) *graphQLStats {
+	return &graphQLStats{q.h.cache.Stats(int(args.HotKeys))}
+}
+
+func (q *graphQLResolver) Set(ctx context.Context, args struct {
+	Key   string
+	Value string
+	TTL   *int32
+}) (*graphQLEntry, error) {
+	if !permitted(ctx, permWrite) {
+		return nil, errGraphQLForbidden
+	}
+	if args.Key == "" {
+		return nil, errors.New("key must not be empty")
+	}
+	var ttl time.Duration
+	if args.TTL != nil {
+		if *args.TTL < 0 {
+			return nil, errors.New("ttl must not be negative")
+		}
+		ttl = time.Duration(*args.TTL) * time.Second
+	}
+	value, err := limitValue(args.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &graphQLEntry{q.h.cache.Set(ctx, args.Key, value, ttl)}, nil
+}
+
+func (q *graphQLResolver) Delete(ctx context.Context, args struct{ Key string }) (bool, error) {
+	if !permitted(ctx, permWrite) {
+		return false, errGraphQLForbidden
+	}
+	return q.h.cache.Delete(ctx, args.Key), nil
+}
+
+func (q *graphQLResolver) Flush(ctx context.Context) (bool, error) {
+	if !permitted(ctx, permAdmin) {
+		return false, errGraphQLForbidden
+	}
+	q.h.lru.Flush(ctx)
+	return true, nil
+}
+
+func (q *graphQLResolver) Events(ctx context.Context, args struct{ Patterns *[]string }) (<-chan *graphQLEvent, error) {
+	patterns := &patternSet{patterns: make(map[string]struct{})}
+	all := args.Patterns == nil || len(*args.Patterns) == 0
+	if !all {
+		if _, err := patterns.update("subscribe", *args.Patterns); err != nil {
+			return nil, err
+		}
+	}
+
+	events, cancel := q.h.lru.Subscribe(256)
+	out := make(chan *graphQLEvent)
+	go func() {
+		defer close(out)
+		defer cancel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.h.streamsDone:
+				return
+			case e := <-events:
+				if !all && !patterns.matches(e.Key) {
+					continue
+				}
+				select {
+				case out <- &graphQLEvent{e}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+type graphQLEntry struct {
+	item Item
+}
+
+func (e *graphQLEntry) Key() string   { return e.item.Key }
+func (e *graphQLEntry) Value() string { return e.item.Value }
+func (e *graphQLEntry) TTL() int32    { return int32(remainingSeconds(e.item.ExpiresAt)) }
+func (e *graphQLEntry) ExpiresAt() graphql.Time {
+	return graphql.Time{Time: e.item.ExpiresAt}
+}
+func (e *graphQLEntry) Version() string { return strconv.FormatUint(e.item.Version, 10) }
+
+type graphQLStats struct {
+	s *Stats
+}
+
+func (s *graphQLStats) Entries() int32       { return int32(s.s.Entries) }
+func (s *graphQLStats) Capacity() int32      { return int32(s.s.Capacity) }
+func (s *graphQLStats) Hits() float64        { return float64(s.s.Hits) }
+func (s *graphQLStats) Misses() float64      { return float64(s.s.Misses) }
+func (s *graphQLStats) HitRate() float64     { return s.s.HitRate }
+func (s *graphQLStats) Evictions() float64   { return float64(s.s.Evictions) }
+func (s *graphQLStats) Expirations() float64 { return float64(s.s.Expirations) }
+func (s *graphQLStats) HotKeys() []*graphQLHotKey {
+	keys := make([]*graphQLHotKey, len(s.s.HotKeys))
+	for i := range s.s.HotKeys {
+		keys[i] = &graphQLHotKey{s.s.HotKeys[i]}
+	}
+	return keys
+}
+
+type graphQLHotKey struct {
+	k HotKey
+}
+
+func (k *graphQLHotKey) Key() string   { return k.k.Key }
+func (k *graphQLHotKey) Hits() float64 { return float64(k.k.Hits) }
+
+type graphQLEvent struct {
+	e CacheEvent
+}
+
+func (e *graphQLEvent) Type() string { return e.e.Type }
+func (e *graphQLEvent) Key() string  { return e.e.Key }
+func (e *graphQLEvent) Reason() *string {
+	return optionalString(e.e.Reason)
+}
+func (e *graphQLEvent) RequestID() *string {
+	return optionalString(e.e.RequestID)
+}
+func (e *graphQLEvent) Time() graphql.Time { return graphql.Time{Time: e.e.Time} }
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
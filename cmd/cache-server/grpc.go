@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// gRPC messages from cache.proto that have no HTTP counterpart. Their wire
+// encodings are in proto.go.
+
+type KeyRequest struct {
+	Key string
+}
+
+type KeysRequest struct {
+	Keys []string
+}
+
+type SetRequest struct {
+	Key   string
+	Value string
+	TTL   int64
+}
+
+type SetManyRequest struct {
+	Entries []SetRequest
+}
+
+type DeleteResponse struct {
+	Deleted bool
+}
+
+type WatchRequest struct {
+	Patterns []string
+}
+
+// grpcCodec lets grpc-go marshal the hand-written protoMessage types in place
+// of generated ones. It keeps the "proto" name, so on the wire it is plain
+// protobuf and clients generated from cache.proto work unchanged.
+type grpcCodec struct{}
+
+func (grpcCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(protoMessage)
+	if !ok {
+		return nil, fmt.Errorf("grpc: cannot marshal %T", v)
+	}
+	return m.marshalProto(), nil
+}
+
+func (grpcCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(protoMessage)
+	if !ok {
+		return fmt.Errorf("grpc: cannot unmarshal into %T", v)
+	}
+	return m.unmarshalProto(data)
+}
+
+func (grpcCodec) Name() string { return "proto" }
+
+// grpcService implements cache.CacheService from cache.proto.
+type grpcService struct {
+	cache *LRUCache
+	auth  *authenticator
+	// done ends Watch streams on shutdown; GracefulStop would otherwise
+	// wait on them forever.
+	done <-chan struct{}
+}
+
+var grpcPermissions = map[string]permission{
+	"Get":    permRead,
+	"MGet":   permRead,
+	"Watch":  permRead,
+	"Set":    permWrite,
+	"Delete": permWrite,
+	"MSet":   permWrite,
+}
+
+func newGRPCServer(s *grpcService, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ForceServerCodec(grpcCodec{}),
+		grpc.UnaryInterceptor(s.unaryInterceptor),
+		grpc.StreamInterceptor(s.streamInterceptor),
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+	)
+	server := grpc.NewServer(opts...)
+	server.RegisterService(&cacheServiceDesc, s)
+	return server
+}
+
+var cacheServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cache.CacheService",
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		grpcUnary("Get", (*grpcService).Get),
+		grpcUnary("Set", (*grpcService).Set),
+		grpcUnary("Delete", (*grpcService).Delete),
+		grpcUnary("MGet", (*grpcService).MGet),
+		grpcUnary("MSet", (*grpcService).MSet),
+	},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Watch",
+		ServerStreams: true,
+		Handler: func(srv any, stream grpc.ServerStream) error {
+			var req WatchRequest
+			if err := stream.RecvMsg(&req); err != nil {
+				return err
+			}
+			return srv.(*grpcService).Watch(&req, stream)
+		},
+	}},
+	Metadata: "cache.proto",
+}
+
+// grpcUnary adapts a typed method to grpc.MethodDesc, doing what generated
+// code would: decode the request and run it through the interceptor.
+func grpcUnary[Req any, PReq interface {
+	*Req
+	protoMessage
+}, Resp protoMessage](name string, method func(*grpcService, context.Context, PReq) (Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			req := PReq(new(Req))
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req any) (any, error) {
+				return method(srv.(*grpcService), ctx, req.(PReq))
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/cache.CacheService/" + name}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
+
+func (s *grpcService) Get(ctx context.Context, req *KeyRequest) (*V1Entry, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key must not be empty")
+	}
+	item, found, err := s.cache.GetOrLoad(ctx, req.Key)
+	if err != nil {
+		return nil, grpcLoadError(err)
+	}
+	if !found {
+		return nil, status.Error(codes.NotFound, "key not found")
+	}
+	return v1Entry(item), nil
+}
+
+func (s *grpcService) Set(ctx context.Context, req *SetRequest) (*V1Entry, error) {
+	if err := validateSetRequest(req); err != nil {
+		return nil, err
+	}
+	item := s.cache.Set(ctx, req.Key, req.Value, time.Duration(req.TTL)*time.Second)
+	return v1Entry(item), nil
+}
+
+func (s *grpcService) Delete(ctx context.Context, req *KeyRequest) (*DeleteResponse, error) {
+	return &DeleteResponse{Deleted: s.cache.Delete(ctx, req.Key)}, nil
+}
+
+func (s *grpcService) MGet(ctx context.Context, req *KeysRequest) (*V1EntryList, error) {
+	resp := &V1EntryList{Entries: make([]V1Entry, 0, len(req.Keys))}
+	for _, key := range req.Keys {
+		item, found, err := s.cache.GetOrLoad(ctx, key)
+		if err != nil {
+			return nil, grpcLoadError(err)
+		}
+		if found {
+			resp.Entries = append(resp.Entries, *v1Entry(item))
+		}
+	}
+	return resp, nil
+}
+
+func (s *grpcService) MSet(ctx context.Context, req *SetManyRequest) (*V1EntryList, error) {
+	writes := make([]Write, 0, len(req.Entries))
+	for i := range req.Entries {
+		e := &req.Entries[i]
+		if err := validateSetRequest(e); err != nil {
+			return nil, err
+		}
+		writes = append(writes, Write{Key: e.Key, Value: e.Value, TTL: time.Duration(e.TTL) * time.Second})
+	}
+	s.cache.SetMany(ctx, writes)
+
+	resp := &V1EntryList{Entries: make([]V1Entry, 0, len(writes))}
+	for _, w := range writes {
+		if item, ok := s.cache.Peek(w.Key); ok {
+			resp.Entries = append(resp.Entries, *v1Entry(item))
+		}
+	}
+	return resp, nil
+}
+
+func (s *grpcService) Watch(req *WatchRequest, stream grpc.ServerStream) error {
+	patterns := &patternSet{patterns: make(map[string]struct{})}
+	if _, err := patterns.update("subscribe", req.Patterns); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	all := len(req.Patterns) == 0
+
+	events, cancel := s.cache.Subscribe(256)
+	defer cancel()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.done:
+			return status.Error(codes.Unavailable, "server shutting down")
+		case e := <-events:
+			if !all && !patterns.matches(e.Key) {
+				continue
+			}
+			if err := stream.SendMsg((*protoEvent)(&e)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// validateSetRequest checks req, and holds its value to the size limit.
+func validateSetRequest(req *SetRequest) error {
+	switch {
+	case req.Key == "":
+		return status.Error(codes.InvalidArgument, "key must not be empty")
+	case req.TTL < 0:
+		return status.Error(codes.InvalidArgument, "ttl must not be negative")
+	}
+	value, err := limitValue(req.Value)
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	req.Value = value
+	return nil
+}
+
+func grpcLoadError(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	return status.Error(codes.Unavailable, "loading from origin failed: "+err.Error())
+}
+
+func (s *grpcService) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := s.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *grpcService) streamInterceptor(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authorize(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &grpcContextStream{ServerStream: stream, ctx: ctx})
+}
+
+// authorize attaches a request ID, from x-request-id metadata or fresh, and
+// checks credentials sent as "authorization: Bearer <token>" or
+// "x-api-key" metadata against the method's permission.
+func (s *grpcService) authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := firstMetadata(md, "x-request-id")
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id))
+	ctx = withRequestID(ctx, id)
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	ctx = withClient(ctx, addr)
+
+	if !s.auth.enabled() {
+		return ctx, nil
+	}
+	secret, _ := strings.CutPrefix(firstMetadata(md, "authorization"), "Bearer ")
+	if secret == "" {
+		secret = firstMetadata(md, "x-api-key")
+	}
+	if secret == "" {
+		return nil, status.Error(codes.Unauthenticated, "missing credentials")
+	}
+	perm, principal, ok := s.auth.credential(strings.TrimSpace(secret))
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid credentials")
+	}
+	setPrincipal(ctx, principal)
+	method := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	if perm < grpcPermissions[method] {
+		return nil, status.Error(codes.PermissionDenied, "insufficient permissions")
+	}
+	return ctx, nil
+}
+
+func firstMetadata(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// grpcContextStream carries the context built by the stream interceptor.
+type grpcContextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcContextStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// handoffRequestPrefix marks the deletes of the copies kept for hints
+// once the owners have them, so that they are not passed on.
+const handoffRequestPrefix = "handoff-"
+
+// hint is a write accepted here for a key whose owner could not be
+// reached, kept to be replayed to the owner as it arrived.
+type hint struct {
+	id          uint64
+	key         string
+	method      string
+	target      string
+	contentType string
+	body        []byte
+	at          time.Time
+}
+
+// handoff keeps writes available while the owner of a key is down: a
+// proxied write that cannot reach the owner is applied here and kept as
+// a hint, which is replayed to the owner once it is back, in order, and
+// the copy here then dropped. Until then this node stands in for the
+// owner for the key, serving its reads and queueing later writes behind
+// the first. Hints are held in memory only, and writes to the key through
+// other nodes in the meantime may be overwritten by the replay.
+type handoff struct {
+	cluster      *cluster
+	cache        *LRUCache
+	client       *http.Client
+	apiKey       string
+	config       HandoffConfig
+	maxBodyBytes int64
+
+	mutex  sync.Mutex
+	nextID uint64
+	hints  []hint
+	// held counts the hints for each key.
+	held map[string]int
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+func startHandoff(c *cluster, cache *LRUCache, config HandoffConfig, apiKey string, maxBodyBytes int64) *handoff {
+	h := &handoff{
+		cluster:      c,
+		cache:        cache,
+		client:       &http.Client{Timeout: 10 * time.Second, Transport: otelhttp.NewTransport(http.DefaultTransport)},
+		apiKey:       apiKey,
+		config:       config,
+		maxBodyBytes: maxBodyBytes,
+		held:         make(map[string]int),
+		stop:         make(chan struct{}),
+	}
+	c.handoff = h
+	h.done.Add(1)
+	go h.run()
+	return h
+}
+
+// proxyFallbackKey carries the function nodeProxy calls instead of
+// answering 502 when the node cannot be reached.
+type proxyFallbackKey struct{}
+
+// unreachable reports whether err from a proxied request means that it
+// never reached the node, so it can safely be served elsewhere.
+func unreachable(err error) bool {
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "dial"
+}
+
+// serve proxies r to the key's owner through p or, as described on
+// handoff, serves it here with local.
+func (h *handoff) serve(p http.Handler, local http.Handler, w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	write := r.Method != http.MethodGet && r.Method != http.MethodHead
+	var body []byte
+	if write {
+		var err error
+		body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxBodyBytes))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+				return
+			}
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	if h.holding(key) {
+		h.standIn(local, w, r, key, body)
+		return
+	}
+	p.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), proxyFallbackKey{}, func(w http.ResponseWriter, r *http.Request) {
+		if !write && !h.holding(key) {
+			writeError(w, http.StatusBadGateway, "owner of the key is unreachable")
+			return
+		}
+		h.standIn(local, w, r, key, body)
+	})))
+}
+
+func (h *handoff) holding(key string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.held[key] > 0
+}
+
+// standIn serves r here for the key's owner, keeping a write as a hint.
+func (h *handoff) standIn(local http.Handler, w http.ResponseWriter, r *http.Request, key string, body []byte) {
+	r = r.WithContext(withPeer(r.Context()))
+	if body == nil {
+		local.ServeHTTP(w, r)
+		return
+	}
+	h.mutex.Lock()
+	full := len(h.hints) >= h.config.MaxHints
+	h.mutex.Unlock()
+	if full {
+		writeError(w, http.StatusBadGateway, "owner of the key is unreachable and too many writes are held for it")
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	rec := &statusRecorder{ResponseWriter: w}
+	local.ServeHTTP(rec, r)
+	if rec.status < 300 {
+		h.add(hint{key: key, method: r.Method, target: r.URL.RequestURI(), contentType: r.Header.Get("Content-Type"), body: body, at: time.Now()})
+	}
+}
+
+func (h *handoff) add(hi hint) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.nextID++
+	hi.id = h.nextID
+	h.hints = append(h.hints, hi)
+	h.held[hi.key]++
+	if len(h.hints) == 1 {
+		clusterLog.Warn("Owner unreachable; holding writes for it", "key", hi.key, "owner", h.cluster.ring.Load().Owner(hi.key))
+	}
+}
+
+func (h *handoff) run() {
+	defer h.done.Done()
+	ticker := time.NewTicker(h.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+		}
+		h.replay()
+	}
+}
+
+// replay sends the hints to their owners, oldest first, leaving those
+// for owners still down, and those behind them, for the next round.
+func (h *handoff) replay() {
+	h.mutex.Lock()
+	pending := h.hints
+	h.mutex.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	ring := h.cluster.ring.Load()
+	down := make(map[string]bool)
+	finished := make(map[uint64]bool)
+	sent, expired := 0, 0
+	for _, hi := range pending {
+		owner := ring.Owner(hi.key)
+		switch {
+		case time.Since(hi.at) > h.config.MaxAge:
+			expired++
+		case owner == h.cluster.self:
+			// The ring moved the key here, where the write already is.
+		case down[owner]:
+			continue
+		default:
+			if err := h.send(owner, hi); err != nil {
+				clusterLog.Debug("Owner still unreachable; keeping its writes", "owner", owner, "err", err)
+				down[owner] = true
+				continue
+			}
+			sent++
+		}
+		finished[hi.id] = true
+	}
+
+	h.mutex.Lock()
+	var released []string
+	kept := h.hints[:0:0]
+	for _, hi := range h.hints {
+		if !finished[hi.id] {
+			kept = append(kept, hi)
+			continue
+		}
+		if h.held[hi.key]--; h.held[hi.key] <= 0 {
+			delete(h.held, hi.key)
+			released = append(released, hi.key)
+		}
+	}
+	h.hints = kept
+	h.mutex.Unlock()
+
+	// The owners have these keys now; copies left here would go stale.
+	ctx := withRequestID(context.Background(), handoffRequestPrefix+newRequestID())
+	for _, key := range released {
+		if ring.Owner(key) != h.cluster.self {
+			h.cache.Delete(ctx, key)
+		}
+	}
+	if sent > 0 || expired > 0 {
+		clusterLog.Info("Replayed held writes to their owners", "sent", sent, "expired", expired, "held", len(kept))
+	}
+}
+
+// send replays hi to owner. A write the owner answers, even with an
+// error, has been delivered; only failing to reach it, or a 503 while it
+// starts, is worth retrying.
+func (h *handoff) send(owner string, hi hint) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-h.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	req, err := http.NewRequestWithContext(ctx, hi.method, owner+hi.target, bytes.NewReader(hi.body))
+	if err != nil {
+		return err
+	}
+	if hi.contentType != "" {
+		req.Header.Set("Content-Type", hi.contentType)
+	}
+	req.Header.Set(forwardedHeader, h.cluster.self)
+	req.Header.Set(requestIDHeader, newRequestID())
+	if h.apiKey != "" {
+		req.Header.Set("X-API-Key", h.apiKey)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	switch {
+	case resp.StatusCode == http.StatusServiceUnavailable:
+		return errors.New("owner is not ready")
+	case resp.StatusCode >= 400:
+		clusterLog.Warn("Owner refused a held write", "owner", owner, "key", hi.key, "method", hi.method, "status", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close stops replaying; hints not yet delivered are lost.
+func (h *handoff) Close() {
+	close(h.stop)
+	h.done.Wait()
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if len(h.hints) > 0 {
+		clusterLog.Warn("Dropping writes held for unreachable owners", "held", len(h.hints))
+	}
+}
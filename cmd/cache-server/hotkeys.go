@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// HotKeysHandler reports the most-read keys by rate, up to ?n= of them.
+func (h *CacheHandler) HotKeysHandler(w http.ResponseWriter, r *http.Request) {
+	n := h.hotKeys
+	if s := r.URL.Query().Get("n"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "n must be a positive integer")
+			return
+		}
+	}
+	report := h.lru.HotKeys(n)
+	if report == nil {
+		writeError(w, http.StatusNotFound, "hot key tracking is off")
+		return
+	}
+	writeResponse(w, r, report)
+}
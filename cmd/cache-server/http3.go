@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newHTTP3Server serves handler over QUIC. There are no per-connection read
+// or write deadlines here; RequestTimeout still bounds each request.
+func newHTTP3Server(addr string, handler http.Handler, tlsConfig *tls.Config, config ServerConfig) *http3.Server {
+	return &http3.Server{
+		Addr:           addr,
+		Handler:        handler,
+		TLSConfig:      http3.ConfigureTLSConfig(tlsConfig.Clone()),
+		MaxHeaderBytes: config.MaxHeaderBytes,
+		IdleTimeout:    config.IdleTimeout,
+		QUICConfig: &quic.Config{
+			MaxIncomingStreams: int64(config.MaxConcurrentStreams),
+			MaxIdleTimeout:     config.IdleTimeout,
+		},
+	}
+}
+
+// advertiseHTTP3 adds the Alt-Svc header that tells clients on TCP they
+// can switch to s.
+func advertiseHTTP3(s *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 3 {
+			s.SetQUICHeaders(w.Header())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	maxIdempotencyKeyLen = 255
+	// maxReplayBytes caps the response body kept for replay. Larger
+	// responses are not recorded, so a retry of them runs again.
+	maxReplayBytes = 8 << 10
+)
+
+type idempotentResponse struct {
+	fingerprint [sha256.Size]byte
+	// status is 0 while the first attempt is still running.
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// idempotencyStore remembers the responses to writes sent with an
+// Idempotency-Key so that retries are answered without reapplying them.
+type idempotencyStore struct {
+	config    IdempotencyConfig
+	mutex     sync.Mutex
+	responses map[[sha256.Size]byte]*idempotentResponse
+}
+
+func newIdempotencyStore(config IdempotencyConfig) *idempotencyStore {
+	s := &idempotencyStore{
+		config:    config,
+		responses: make(map[[sha256.Size]byte]*idempotentResponse),
+	}
+	if config.Enabled {
+		go s.startCleanupRoutine()
+	}
+	return s
+}
+
+func (s *idempotencyStore) Middleware(next http.Handler) http.Handler {
+	if !s.config.Enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			writeError(w, http.StatusBadRequest, "Idempotency-Key is too long")
+			return
+		}
+		scope := idempotencyScope(r, key)
+
+		s.mutex.Lock()
+		prev, ok := s.responses[scope]
+		if ok && time.Now().After(prev.expires) {
+			delete(s.responses, scope)
+			ok = false
+		}
+		if ok {
+			s.mutex.Unlock()
+			s.replay(w, r, prev)
+			return
+		}
+		record := len(s.responses) < s.config.MaxKeys
+		if record {
+			s.responses[scope] = &idempotentResponse{expires: time.Now().Add(s.config.Window)}
+		}
+		s.mutex.Unlock()
+		if !record {
+			// Full: serve the request, but without a record a retry of it
+			// will be applied again.
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		hash := sha256.New()
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(r.Body, hash), r.Body}
+		rec := &replayRecorder{ResponseWriter: w}
+		completed := false
+		defer func() {
+			// A panicking handler must not leave the key stuck in progress.
+			if !completed {
+				s.mutex.Lock()
+				delete(s.responses, scope)
+				s.mutex.Unlock()
+			}
+		}()
+		next.ServeHTTP(rec, r)
+		io.Copy(hash, r.Body)
+		completed = true
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if rec.status >= 500 || rec.overflow || r.Context().Err() != nil {
+			delete(s.responses, scope)
+			return
+		}
+		resp := s.responses[scope]
+		copy(resp.fingerprint[:], hash.Sum(nil))
+		resp.status = rec.status
+		if resp.status == 0 {
+			resp.status = http.StatusOK
+		}
+		resp.header = rec.header
+		if resp.header == nil {
+			resp.header = w.Header().Clone()
+		}
+		resp.body = rec.body.Bytes()
+	})
+}
+
+func (s *idempotencyStore) replay(w http.ResponseWriter, r *http.Request, resp *idempotentResponse) {
+	hash := sha256.New()
+	io.Copy(hash, r.Body)
+
+	s.mutex.Lock()
+	status, header, body, fingerprint := resp.status, resp.header, resp.body, resp.fingerprint
+	s.mutex.Unlock()
+
+	if status == 0 {
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusConflict, "a request with this Idempotency-Key is still in progress")
+		return
+	}
+	if !bytes.Equal(hash.Sum(nil), fingerprint[:]) {
+		writeError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request body")
+		return
+	}
+	for k, v := range header {
+		// The replay is still a new request with its own ID.
+		if k != http.CanonicalHeaderKey(requestIDHeader) {
+			w.Header()[k] = v
+		}
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func (s *idempotencyStore) startCleanupRoutine() {
+	ticker := time.Tick(time.Minute)
+	for range ticker {
+		now := time.Now()
+		s.mutex.Lock()
+		for scope, resp := range s.responses {
+			if resp.status != 0 && now.After(resp.expires) {
+				delete(s.responses, scope)
+			}
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// idempotencyScope ties a key to the client and the request target, so
+// that two clients picking the same key do not see each other's responses.
+func idempotencyScope(r *http.Request, key string) [sha256.Size]byte {
+	h := sha256.New()
+	for _, part := range []string{clientID(r), r.Method, r.URL.RequestURI(), key} {
+		io.WriteString(h, part)
+		h.Write([]byte{0})
+	}
+	var scope [sha256.Size]byte
+	copy(scope[:], h.Sum(nil))
+	return scope
+}
+
+// replayRecorder keeps a copy of the response for later replay.
+type replayRecorder struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (rec *replayRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.header = rec.ResponseWriter.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *replayRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if rec.body.Len()+len(b) > maxReplayBytes {
+		rec.overflow = true
+	} else if !rec.overflow {
+		rec.body.Write(b)
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *replayRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
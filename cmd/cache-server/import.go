@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	importBatchSize = 1000
+	maxImportErrors = 1000
+)
+
+type importRow struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	TTL   int64  `json:"ttl"`
+	// exactTTL, if set, replaces TTL, for formats that keep more than
+	// whole seconds.
+	exactTTL time.Duration
+}
+
+// rowError is a problem with a single row; the import skips the row and
+// carries on. Any other error from an importReader ends the import.
+type rowError struct {
+	line int
+	err  error
+}
+
+func (e *rowError) Error() string { return e.err.Error() }
+
+type importReader interface {
+	// Next returns the next row, a *rowError for a bad row, or io.EOF.
+	Next() (*importRow, error)
+}
+
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (n *ndjsonReader) Next() (*importRow, error) {
+	for n.scanner.Scan() {
+		n.line++
+		text := strings.TrimSpace(n.scanner.Text())
+		if text == "" {
+			continue
+		}
+		var row importRow
+		dec := json.NewDecoder(strings.NewReader(text))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&row); err != nil {
+			return nil, &rowError{line: n.line, err: err}
+		}
+		if err := validateImportRow(&row); err != nil {
+			return nil, &rowError{line: n.line, err: err}
+		}
+		return &row, nil
+	}
+	if err := n.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("line %d: %w", n.line+1, err)
+	}
+	return nil, io.EOF
+}
+
+type csvReader struct {
+	reader *csv.Reader
+	first  bool
+}
+
+func (c *csvReader) Next() (*importRow, error) {
+	for {
+		record, err := c.reader.Read()
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return nil, &rowError{line: parseErr.Line, err: parseErr.Err}
+			}
+			return nil, err
+		}
+		line, _ := c.reader.FieldPos(0)
+
+		if c.first {
+			c.first = false
+			if len(record) >= 2 && strings.EqualFold(record[0], "key") && strings.EqualFold(record[1], "value") {
+				continue
+			}
+		}
+		if len(record) < 2 || len(record) > 3 {
+			return nil, &rowError{line: line, err: fmt.Errorf("expected key,value[,ttl], got %d fields", len(record))}
+		}
+		row := &importRow{Key: record[0], Value: record[1]}
+		if len(record) == 3 && record[2] != "" {
+			ttl, err := strconv.ParseInt(record[2], 10, 64)
+			if err != nil {
+				return nil, &rowError{line: line, err: fmt.Errorf("invalid ttl %q", record[2])}
+			}
+			row.TTL = ttl
+		}
+		if err := validateImportRow(row); err != nil {
+			return nil, &rowError{line: line, err: err}
+		}
+		return row, nil
+	}
+}
+
+// validateImportRow checks row, and holds its value to the size limit.
+func validateImportRow(row *importRow) error {
+	switch {
+	case row.Key == "":
+		return errors.New("key must not be empty")
+	case row.TTL < 0:
+		return errors.New("ttl must not be negative")
+	}
+	value, err := limitValue(row.Value)
+	if err != nil {
+		return err
+	}
+	row.Value = value
+	return nil
+}
+
+func newImportReader(r *http.Request, maxLine int) (importReader, error) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "text/csv":
+			format = "csv"
+		case "application/x-redis-rdb":
+			format = "rdb"
+		default:
+			format = "ndjson"
+		}
+	}
+	switch format {
+	case "ndjson", "jsonl":
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(make([]byte, 64*1024), maxLine)
+		return &ndjsonReader{scanner: scanner}, nil
+	case "csv":
+		reader := csv.NewReader(r.Body)
+		reader.FieldsPerRecord = -1
+		return &csvReader{reader: reader, first: true}, nil
+	case "rdb":
+		return newRDBReader(r.Body, maxLine), nil
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+// ImportHandler streams NDJSON, CSV or Redis RDB rows into the cache in
+// batches. Bad rows are skipped and listed in the report; the rest are
+// imported.
+func (h *CacheHandler) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := newImportReader(r, int(h.maxBodyBytes))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report := &ImportReport{Errors: []ImportError{}}
+	batch := make([]Write, 0, importBatchSize)
+	flush := func() {
+		h.lru.SetMany(r.Context(), batch)
+		report.Imported += len(batch)
+		batch = batch[:0]
+	}
+
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		var bad *rowError
+		if errors.As(err, &bad) {
+			report.Failed++
+			if len(report.Errors) < maxImportErrors {
+				report.Errors = append(report.Errors, ImportError{Row: bad.line, Error: bad.Error()})
+			} else {
+				report.ErrorsTruncated = true
+			}
+			continue
+		}
+		if err != nil {
+			if requestCanceled(w, r) {
+				return
+			}
+			// Keep what was read so far; the report says where it stopped.
+			flush()
+			report.Aborted = err.Error()
+			break
+		}
+
+		ttl := time.Duration(row.TTL) * time.Second
+		if row.exactTTL > 0 {
+			ttl = row.exactTTL
+		}
+		batch = append(batch, Write{Key: row.Key, Value: row.Value, TTL: ttl})
+		if len(batch) == importBatchSize {
+			if requestCanceled(w, r) {
+				return
+			}
+			flush()
+		}
+	}
+	if requestCanceled(w, r) {
+		return
+	}
+	flush()
+
+	writeResponse(w, r, report)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// gossipRequestPrefix marks deletes made for other members' invalidations,
+// so that they are not broadcast again.
+const gossipRequestPrefix = "gossip-"
+
+// msgInvalidate is the type byte of an invalidation, which is followed by
+// the time of the change, in big-endian Unix nanoseconds, and the key.
+const msgInvalidate byte = 1
+
+// invalidationBroadcast tells the other members to drop key. It relies
+// on the members' clocks roughly agreeing, as the TTLs do. memberlist
+// piggybacks it on gossip, sending it a few times to each member; a
+// newer one for the same key replaces it in the queue.
+type invalidationBroadcast struct {
+	key string
+	msg []byte
+}
+
+func (b *invalidationBroadcast) Invalidates(other memberlist.Broadcast) bool {
+	o, ok := other.(*invalidationBroadcast)
+	return ok && o.key == b.key
+}
+
+func (b *invalidationBroadcast) Message() []byte { return b.msg }
+func (b *invalidationBroadcast) Finished()       {}
+
+// fromElsewhere reports whether a change with requestID repeats one made
+// elsewhere: an invalidation by gossip, MQTT or the Redis tier, which
+// every member receives for itself, or a migration between nodes. Such
+// changes are not passed on again. Nor are the drops of copies held for
+// hinted handoff.
+func fromElsewhere(requestID string) bool {
+	for _, prefix := range []string{gossipRequestPrefix, mqttRequestPrefix, l2RequestPrefix, migrateRequestPrefix, handoffRequestPrefix} {
+		if strings.HasPrefix(requestID, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Record is the MutationSink that broadcasts the keys written or deleted
+// here. Values filled by the loader or from another node, touches,
+// evictions, expirations and flushes leave the other members' copies
+// alone.
+func (g *gossip) Record(e CacheEvent, _ Item) {
+	if fromElsewhere(e.RequestID) {
+		return
+	}
+	switch {
+	case e.Type == eventSet && e.Reason == "":
+	case e.Type == eventDelete && e.Reason == "explicit":
+	default:
+		return
+	}
+	msg := binary.BigEndian.AppendUint64([]byte{msgInvalidate}, uint64(e.Time.UnixNano()))
+	msg = append(msg, e.Key...)
+	if len(msg) > g.maxMsg {
+		// Too big to share a gossip packet; send it on its own, over TCP.
+		go func() {
+			for _, n := range g.list.Members() {
+				if n.Name != g.list.LocalNode().Name {
+					if err := g.list.SendReliable(n, msg); err != nil {
+						clusterLog.Warn("Failed to send invalidation", "member", n.Name, "key", e.Key, "err", err)
+					}
+				}
+			}
+		}()
+		return
+	}
+	g.broadcasts.QueueBroadcast(&invalidationBroadcast{key: e.Key, msg: msg})
+}
+
+// NotifyMsg drops the keys other members invalidate, unless they were
+// stored here after the change, which would make the invalidation
+// stale.
+func (g *gossip) NotifyMsg(msg []byte) {
+	if len(msg) < 9 || msg[0] != msgInvalidate || g.cache == nil {
+		return
+	}
+	changed := time.Unix(0, int64(binary.BigEndian.Uint64(msg[1:9])))
+	// memberlist reuses msg once this returns.
+	key := string(msg[9:])
+	ctx := withRequestID(context.Background(), gossipRequestPrefix+newRequestID())
+	g.cache.DeleteIf(ctx, key, func(current Item) bool { return current.StoredAt.Before(changed) })
+}
+
+func (g *gossip) GetBroadcasts(overhead, limit int) [][]byte {
+	return g.broadcasts.GetBroadcasts(overhead, limit)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var roles = map[string]permission{
+	"reader": permRead,
+	"writer": permWrite,
+	"admin":  permAdmin,
+}
+
+// jwtAuth validates bearer tokens against the signing keys published at
+// a JWKS endpoint and maps a roles claim onto permissions.
+type jwtAuth struct {
+	config    JWTConfig
+	client    *http.Client
+	mutex     sync.RWMutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+func newJWTAuth(config JWTConfig) (*jwtAuth, error) {
+	a := &jwtAuth{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	if err := a.refresh(); err != nil {
+		return nil, fmt.Errorf("fetch JWKS: %w", err)
+	}
+	return a, nil
+}
+
+// permission returns what token grants and its principal for the audit
+// log, "jwt:" and its subject.
+func (a *jwtAuth) permission(token string) (permission, string, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithExpirationRequired(),
+	}
+	if a.config.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(a.config.Issuer))
+	}
+	if a.config.Audience != "" {
+		opts = append(opts, jwt.WithAudience(a.config.Audience))
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, a.keyFunc, opts...); err != nil {
+		return 0, "", err
+	}
+
+	var granted permission
+	for _, role := range claimValues(claims[a.config.RolesClaim]) {
+		if mapped, ok := a.config.RoleMap[role]; ok {
+			role = mapped
+		}
+		if perm := roles[role]; perm > granted {
+			granted = perm
+		}
+	}
+	if granted == 0 {
+		return 0, "", errors.New("token grants no cache role")
+	}
+	subject, _ := claims.GetSubject()
+	return granted, "jwt:" + subject, nil
+}
+
+func (a *jwtAuth) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if key, ok := a.key(kid); ok {
+		return key, nil
+	}
+	// The issuer may have rotated keys; refetch, but not more than once
+	// per refresh interval.
+	a.mutex.RLock()
+	stale := time.Since(a.fetchedAt) > a.config.RefreshInterval
+	a.mutex.RUnlock()
+	if stale {
+		if err := a.refresh(); err != nil {
+			return nil, err
+		}
+		if key, ok := a.key(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (a *jwtAuth) key(kid string) (interface{}, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	key, ok := a.keys[kid]
+	return key, ok
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (a *jwtAuth) refresh() error {
+	resp, err := a.client.Get(a.config.JWKSURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	a.mutex.Lock()
+	a.keys = keys
+	a.fetchedAt = time.Now()
+	a.mutex.Unlock()
+	return nil
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// claimValues accepts a claim holding either a list of strings or a single
+// space-separated string, as OAuth scope claims do.
+func claimValues(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
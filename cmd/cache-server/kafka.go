@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaRecord is the JSON value of each message; the message key is the
+// cache key, so one key's mutations stay ordered within a partition.
+type kafkaRecord struct {
+	CacheEvent
+	Value     *string    `json:"value,omitempty"`
+	Version   uint64     `json:"version,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// kafkaSink is a MutationSink that queues records for a background writer.
+type kafkaSink struct {
+	writer        *kafka.Writer
+	includeValues bool
+	batchSize     int
+	queue         chan kafka.Message
+	dropped       atomic.Uint64
+	done          sync.WaitGroup
+}
+
+func newKafkaSink(config KafkaConfig) *kafkaSink {
+	s := &kafkaSink{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(config.Brokers...),
+			Topic:        config.Topic,
+			Balancer:     &kafka.Hash{},
+			BatchSize:    config.BatchSize,
+			BatchTimeout: config.BatchTimeout,
+			RequiredAcks: kafka.RequireAll,
+		},
+		includeValues: config.IncludeValues,
+		batchSize:     max(config.BatchSize, 1),
+		queue:         make(chan kafka.Message, config.QueueSize),
+	}
+	s.done.Add(1)
+	go s.run()
+	return s
+}
+
+func (s *kafkaSink) Record(e CacheEvent, item Item) {
+	record := kafkaRecord{CacheEvent: e}
+	if e.Type == eventSet {
+		record.Version = item.Version
+		record.ExpiresAt = &item.ExpiresAt
+		if s.includeValues {
+			record.Value = &item.Value
+		}
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	select {
+	case s.queue <- kafka.Message{Key: []byte(e.Key), Value: value, Time: e.Time}:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+func (s *kafkaSink) run() {
+	defer s.done.Done()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, ok := <-s.queue:
+			if !ok {
+				return
+			}
+			s.write(drainBatch(s.queue, msg, s.batchSize))
+		case <-ticker.C:
+			if n := s.dropped.Swap(0); n > 0 {
+				eventsLog.Warn("Kafka sink dropped mutations in the last minute; queue full", "dropped", n)
+			}
+		}
+	}
+}
+
+func (s *kafkaSink) write(batch []kafka.Message) {
+	// The writer retries internally; past that the batch is lost.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := s.writer.WriteMessages(ctx, batch...); err != nil {
+		eventsLog.Error("Kafka sink failed to write mutations", "mutations", len(batch), "err", err)
+	}
+}
+
+// Close writes out what is queued and closes the writer. The cache must no
+// longer be calling Record.
+func (s *kafkaSink) Close() {
+	close(s.queue)
+	s.done.Wait()
+	s.writer.Close()
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// l2RequestPrefix marks changes made by keyspace notifications, so that
+// they are not written back to Redis.
+const l2RequestPrefix = "l2-"
+
+// l2Op is a write queued for Redis; a zero ttl deletes the key.
+type l2Op struct {
+	key   string
+	value string
+	ttl   time.Duration
+}
+
+// l2Tier makes a Redis server a second level shared by several servers.
+// Misses here are looked up in Redis before the loader, and sets and
+// deletes are written to Redis behind the cache, by a queue like the
+// Kafka sink's. A change to a key in Redis by anyone else, found through
+// its keyspace notifications, drops the key here.
+//
+// Redis does not say who made a change, so the tier counts its own writes
+// to each key and ignores that many notifications for it.
+type l2Tier struct {
+	client  *redis.Client
+	pubsub  *redis.PubSub
+	cache   *LRUCache
+	prefix  string
+	queue   chan l2Op
+	dropped atomic.Uint64
+	done    sync.WaitGroup
+
+	mutex sync.Mutex
+	// own counts the notifications still to come for this tier's writes.
+	own map[string]int
+	// fills holds values just read from Redis, whose sets must not be
+	// written back.
+	fills map[string]string
+}
+
+func startL2(cache *LRUCache, config L2Config) (*l2Tier, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:         config.RedisAddr,
+		Username:     config.Username,
+		Password:     config.Password,
+		DB:           config.DB,
+		ReadTimeout:  config.Timeout,
+		WriteTimeout: config.Timeout,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	if events, err := client.ConfigGet(ctx, "notify-keyspace-events").Result(); err == nil {
+		// K turns the keyspace channels on; $ and g (or A, for all) cover
+		// sets and deletes; x and e cover expirations and evictions.
+		flags := events["notify-keyspace-events"]
+		if !strings.Contains(flags, "K") || !strings.ContainsAny(flags, "A$") || !strings.ContainsAny(flags, "Ag") {
+			clusterLog.Warn("Redis keyspace notifications are off; changes made by other servers will not invalidate entries here", "notify-keyspace-events", flags, "want", "K$gxe")
+		}
+	}
+
+	t := &l2Tier{
+		client: client,
+		cache:  cache,
+		prefix: config.KeyPrefix,
+		queue:  make(chan l2Op, config.QueueSize),
+		own:    make(map[string]int),
+		fills:  make(map[string]string),
+	}
+	t.pubsub = client.PSubscribe(context.Background(), fmt.Sprintf("__keyspace@%d__:%s*", config.DB, t.prefix))
+	t.done.Add(2)
+	go t.invalidate()
+	go t.run(max(config.BatchSize, 1))
+	return t, nil
+}
+
+// Loader wraps origin, this node's loader or nil, so that a miss is
+// read from Redis first.
+func (t *l2Tier) Loader(origin Loader) Loader {
+	return func(ctx context.Context, key string) (string, time.Duration, error) {
+		pipe := t.client.Pipeline()
+		get := pipe.Get(ctx, t.prefix+key)
+		pttl := pipe.PTTL(ctx, t.prefix+key)
+		_, err := pipe.Exec(ctx)
+		switch {
+		case err == nil:
+			ttl := pttl.Val()
+			if ttl < 0 {
+				// No expiry in Redis: the cache's default.
+				ttl = 0
+			}
+			t.mutex.Lock()
+			t.fills[key] = get.Val()
+			t.mutex.Unlock()
+			return get.Val(), ttl, nil
+		case !errors.Is(err, redis.Nil):
+			clusterLog.Warn("Failed to read from Redis; loading from the origin", "key", key, "err", err)
+		}
+		if origin == nil {
+			return "", 0, ErrNotFound
+		}
+		return origin(ctx, key)
+	}
+}
+
+// Record is the MutationSink that queues sets and deletes for Redis.
+// Evictions and expirations only concern this level; flushes, and changes
+// that repeat ones made elsewhere, such as the deletes notifications
+// cause, are not written either.
+func (t *l2Tier) Record(e CacheEvent, item Item) {
+	if fromElsewhere(e.RequestID) {
+		return
+	}
+	var op l2Op
+	switch {
+	case e.Type == eventSet:
+		t.mutex.Lock()
+		value, filled := t.fills[e.Key]
+		delete(t.fills, e.Key)
+		t.mutex.Unlock()
+		if filled && value == item.Value {
+			return
+		}
+		op = l2Op{key: e.Key, value: item.Value, ttl: time.Until(item.ExpiresAt)}
+		if op.ttl <= 0 {
+			return
+		}
+	case e.Type == eventDelete && e.Reason == "explicit":
+		op = l2Op{key: e.Key}
+	default:
+		return
+	}
+	select {
+	case t.queue <- op:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+func (t *l2Tier) run(batchSize int) {
+	defer t.done.Done()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case op, ok := <-t.queue:
+			if !ok {
+				return
+			}
+			t.write(drainBatch(t.queue, op, batchSize))
+		case <-ticker.C:
+			if n := t.dropped.Swap(0); n > 0 {
+				clusterLog.Warn("Redis tier dropped writes in the last minute; queue full", "dropped", n)
+			}
+		}
+	}
+}
+
+func (t *l2Tier) write(batch []l2Op) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	t.mutex.Lock()
+	for _, op := range batch {
+		t.own[op.key]++
+	}
+	t.mutex.Unlock()
+
+	pipe := t.client.Pipeline()
+	cmds := make([]redis.Cmder, len(batch))
+	for i, op := range batch {
+		if op.ttl > 0 {
+			cmds[i] = pipe.Set(ctx, t.prefix+op.key, op.value, op.ttl)
+		} else {
+			cmds[i] = pipe.Del(ctx, t.prefix+op.key)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		clusterLog.Error("Failed to write to Redis", "writes", len(batch), "err", err)
+	}
+
+	// A failed write, or a delete of a key Redis did not have, sends no
+	// notification.
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for i, op := range batch {
+		if del, ok := cmds[i].(*redis.IntCmd); cmds[i].Err() != nil || (ok && del.Val() == 0) {
+			t.forget(op.key)
+		}
+	}
+}
+
+// forget takes one of the tier's own writes to key off the count. The
+// caller holds t.mutex.
+func (t *l2Tier) forget(key string) {
+	if t.own[key]--; t.own[key] <= 0 {
+		delete(t.own, key)
+	}
+}
+
+// invalidate drops the keys others change in Redis from this level.
+func (t *l2Tier) invalidate() {
+	defer t.done.Done()
+	for msg := range t.pubsub.Channel() {
+		// The channel is __keyspace@<db>__:<key> and the payload the
+		// command's event. expire only changes a TTL, and comes with the
+		// set of a SET with one.
+		if msg.Payload == "expire" {
+			continue
+		}
+		_, key, _ := strings.Cut(msg.Channel, ":")
+		key = strings.TrimPrefix(key, t.prefix)
+		t.mutex.Lock()
+		_, mine := t.own[key]
+		if mine {
+			t.forget(key)
+		}
+		t.mutex.Unlock()
+		if mine {
+			continue
+		}
+		t.cache.Delete(withRequestID(context.Background(), l2RequestPrefix+newRequestID()), key)
+	}
+}
+
+// Close writes out what is queued and disconnects. The cache must no
+// longer be calling Record.
+func (t *l2Tier) Close() error {
+	close(t.queue)
+	t.pubsub.Close()
+	t.done.Wait()
+	return t.client.Close()
+}
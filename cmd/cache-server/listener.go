@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// listen listens on a TCP address, or on a Unix socket for "unix:<path>".
+// A socket file left behind by an earlier run is replaced, and the new one
+// gets mode; it is removed again when the listener is closed.
+func listen(addr string, mode os.FileMode) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		os.Remove(path)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// limitListener caps the number of connections open at once. Accept blocks
+// while the limit is reached, leaving further clients in the kernel backlog.
+// Listeners given the same slots share one limit.
+type limitListener struct {
+	net.Listener
+	slots chan struct{}
+}
+
+func newLimitListener(l net.Listener, slots chan struct{}) net.Listener {
+	if slots == nil {
+		return l
+	}
+	return &limitListener{Listener: l, slots: slots}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	l.slots <- struct{}{}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: func() { <-l.slots }}, nil
+}
+
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+// connServer is the accept loop shared by the non-HTTP protocol listeners.
+// serve handles one connection; it is closed when serve returns.
+type connServer struct {
+	serve func(net.Conn)
+
+	mutex    sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+// Serve accepts connections on ln until Close is called.
+func (s *connServer) Serve(ln net.Listener) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		ln.Close()
+		return nil
+	}
+	s.listener = ln
+	s.mutex.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			closed := s.closed
+			s.mutex.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.mutex.Lock()
+		if s.conns == nil {
+			s.conns = make(map[net.Conn]struct{})
+		}
+		s.conns[conn] = struct{}{}
+		s.mutex.Unlock()
+
+		go func() {
+			defer func() {
+				conn.Close()
+				s.mutex.Lock()
+				delete(s.conns, conn)
+				s.mutex.Unlock()
+			}()
+			s.serve(conn)
+		}()
+	}
+}
+
+// Close stops accepting and drops open connections. These protocols have
+// no way to tell a client to go away, so unlike HTTP there is nothing to
+// drain.
+func (s *connServer) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// newHTTPLoader reads misses from an origin server. The key is substituted,
+// path-escaped, for "{key}" in config.URL; a 404 from the origin is a miss.
+func newHTTPLoader(config LoaderConfig) Loader {
+	// The transport sends the trace context on to the origin.
+	client := &http.Client{Timeout: config.Timeout, Transport: otelhttp.NewTransport(http.DefaultTransport)}
+	return func(ctx context.Context, key string) (string, time.Duration, error) {
+		target := strings.ReplaceAll(config.URL, "{key}", url.PathEscape(key))
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return "", 0, err
+		}
+		if id := requestIDFrom(ctx); id != "" {
+			req.Header.Set(requestIDHeader, id)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", 0, err
+		}
+		defer resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			return "", 0, ErrNotFound
+		case resp.StatusCode != http.StatusOK:
+			return "", 0, fmt.Errorf("origin returned %s", resp.Status)
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, config.MaxBytes+1))
+		if err != nil {
+			return "", 0, err
+		}
+		if int64(len(body)) > config.MaxBytes {
+			return "", 0, fmt.Errorf("origin value exceeds %d bytes", config.MaxBytes)
+		}
+		return string(body), config.TTL, nil
+	}
+}
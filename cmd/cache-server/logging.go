@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Subsystems with a logger and level of their own.
+const (
+	logServer      = "server"
+	logHTTP        = "http"
+	logJanitor     = "janitor"
+	logPersistence = "persistence"
+	logEvents      = "events"
+	logAuth        = "auth"
+	logCluster     = "cluster"
+)
+
+var logSubsystems = []string{logServer, logHTTP, logJanitor, logPersistence, logEvents, logAuth, logCluster}
+
+// logLevels holds each subsystem's level. They are LevelVars so that
+// V1SetLogLevelsHandler can change them while the loggers are in use.
+var logLevels = func() map[string]*slog.LevelVar {
+	levels := make(map[string]*slog.LevelVar, len(logSubsystems))
+	for _, name := range logSubsystems {
+		levels[name] = new(slog.LevelVar)
+	}
+	return levels
+}()
+
+// The subsystem loggers. setupLogging replaces them before anything logs.
+var (
+	serverLog      = slog.Default()
+	httpLog        = slog.Default()
+	janitorLog     = slog.Default()
+	persistenceLog = slog.Default()
+	eventsLog      = slog.Default()
+	authLog        = slog.Default()
+	clusterLog     = slog.Default()
+)
+
+// setupLogging points the subsystem loggers, and the log package, at out
+// in the configured format and sets their levels.
+func setupLogging(config LogConfig, out io.Writer) error {
+	if config.Format != "text" && config.Format != "json" {
+		return fmt.Errorf("unknown log format %q", config.Format)
+	}
+	levels := make(map[string]string, len(logSubsystems))
+	for _, name := range logSubsystems {
+		levels[name] = config.Level
+	}
+	for name, level := range config.Subsystems {
+		levels[name] = level
+	}
+	if err := setLogLevels(levels); err != nil {
+		return err
+	}
+
+	logger := func(name string) *slog.Logger {
+		opts := &slog.HandlerOptions{Level: logLevels[name]}
+		var handler slog.Handler
+		if config.Format == "json" {
+			handler = slog.NewJSONHandler(out, opts)
+		} else {
+			handler = slog.NewTextHandler(out, opts)
+		}
+		return slog.New(handler).With("subsystem", name)
+	}
+	serverLog = logger(logServer)
+	httpLog = logger(logHTTP)
+	janitorLog = logger(logJanitor)
+	persistenceLog = logger(logPersistence)
+	eventsLog = logger(logEvents)
+	authLog = logger(logAuth)
+	clusterLog = logger(logCluster)
+	// Libraries that use the log package go through the server logger.
+	slog.SetDefault(serverLog)
+	return nil
+}
+
+// setLogLevels parses every level before setting any, so a bad one
+// changes nothing.
+func setLogLevels(levels map[string]string) error {
+	parsed, err := parseLogLevels(levels)
+	if err != nil {
+		return err
+	}
+	applyLogLevels(parsed)
+	return nil
+}
+
+// parseLogLevels checks levels, which maps subsystems to level names.
+func parseLogLevels(levels map[string]string) (map[string]slog.Level, error) {
+	parsed := make(map[string]slog.Level, len(levels))
+	for name, level := range levels {
+		if _, ok := logLevels[name]; !ok {
+			return nil, fmt.Errorf("unknown log subsystem %q; want one of %s", name, strings.Join(logSubsystems, ", "))
+		}
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("log level for %s: %w", name, err)
+		}
+		parsed[name] = l
+	}
+	return parsed, nil
+}
+
+func applyLogLevels(levels map[string]slog.Level) {
+	for name, l := range levels {
+		logLevels[name].Set(l)
+	}
+}
+
+func currentLogLevels() *LogLevels {
+	levels := &LogLevels{Levels: make(map[string]string, len(logLevels))}
+	for name, level := range logLevels {
+		levels.Levels[name] = strings.ToLower(level.Level().String())
+	}
+	return levels
+}
+
+// fatal logs msg at error level and exits, like log.Fatal.
+func fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+// libraryLog sends the log lines of memberlist and raft, which start
+// with a level in brackets, to the cluster logger at that level, without
+// prefix.
+type libraryLog struct {
+	prefix string
+}
+
+var libraryLevels = []struct {
+	prefix string
+	level  slog.Level
+}{{"[TRACE]", slog.LevelDebug}, {"[DEBUG]", slog.LevelDebug}, {"[INFO]", slog.LevelInfo}, {"[WARN]", slog.LevelWarn}, {"[ERR]", slog.LevelError}, {"[ERROR]", slog.LevelError}}
+
+func (l libraryLog) Write(p []byte) (int, error) {
+	msg, level := strings.TrimSpace(string(p)), slog.LevelInfo
+	for _, ll := range libraryLevels {
+		if rest, ok := strings.CutPrefix(msg, ll.prefix); ok {
+			msg, level = strings.TrimSpace(rest), ll.level
+			break
+		}
+	}
+	clusterLog.Log(context.Background(), level, strings.TrimPrefix(msg, l.prefix))
+	return len(p), nil
+}
+
+// V1GetLogLevelsHandler reports each subsystem's log level.
+func (h *CacheHandler) V1GetLogLevelsHandler(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, currentLogLevels())
+}
+
+// V1SetLogLevelsHandler changes the levels of the subsystems named in the
+// request and leaves the rest. The change lasts until restart.
+func (h *CacheHandler) V1SetLogLevelsHandler(w http.ResponseWriter, r *http.Request) {
+	var req LogLevels
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	if err := setLogLevels(req.Levels); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	names := make([]string, 0, len(req.Levels))
+	for name := range req.Levels {
+		names = append(names, name+"="+req.Levels[name])
+	}
+	slices.Sort(names)
+	serverLog.Info("Changed log levels", "levels", strings.Join(names, " "))
+	writeResponse(w, r, currentLogLevels())
+}
@@ -0,0 +1,847 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/quic-go/quic-go/http3"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"myproject/cache"
+)
+
+type CacheHandler struct {
+	// cache is the storage engine, which serves reads, writes, deletes
+	// and stats; lru is the same cache when the engine is lru, for all
+	// else, and nil otherwise (see engine.go).
+	cache        Cache
+	lru          *LRUCache
+	mutex        sync.Mutex
+	maxBodyBytes int64
+	hotKeys      int
+	cluster      *cluster
+	gossip       *gossip
+	consensus    *consensus
+	streamsDone  chan struct{}
+	closeOnce    sync.Once
+	// privateResponses marks GET responses private, keeping shared caches
+	// from serving one client's authenticated reads to another.
+	privateResponses bool
+	graphQL          *graphql.Schema
+	// backupDir holds named backups from V1BackupHandler, which are
+	// sealed with cipher if it is not nil.
+	backupDir string
+	cipher    *fileCipher
+	// snapshotPath is the configured snapshot, which V1RestoreHandler
+	// can also restore.
+	snapshotPath string
+	// serveReplicas allows replicas to stream from this node, and
+	// replicationBuffer is how far behind one may fall; replicas counts
+	// those streaming; replica is set when this node is itself a
+	// replica.
+	serveReplicas     bool
+	replicationBuffer int
+	replicas          atomic.Int64
+	replica           *replica
+	// regions is set when cross-region replication is on.
+	regions *regionReplicator
+	// pubsub serves subscriptions to channels, keyspace notifications
+	// among them, over RESP and server-sent events.
+	pubsub *pubSub
+	// webhooks sends expirations and evictions to the webhooks
+	// registered in the configuration and through the admin API.
+	webhooks *webhookSink
+	// reloader applies configuration changes while the server runs.
+	reloader *reloader
+	// sessions counts the writes here, for session tokens and the
+	// replication stream.
+	sessions *sessionClock
+	// ready is set once startup (including any snapshot restore) is done
+	// and cleared when shutdown begins.
+	ready atomic.Bool
+}
+
+// The handlers below serve the original /cache API, which only knew integer
+// keys and values. They map onto the string-keyed core so that legacy and
+// /v1 clients see the same data; a value that isn't an integer reads as a
+// miss (-1) here.
+
+func (h *CacheHandler) SetHandler(w http.ResponseWriter, r *http.Request) {
+	var data CacheEntry
+	if !h.decodeRequest(w, r, &data) {
+		return
+	}
+
+	key := strconv.Itoa(data.Key)
+	h.cache.Set(r.Context(), key, strconv.Itoa(data.Value), 0)
+	recordWrite(r, key)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *CacheHandler) MSetHandler(w http.ResponseWriter, r *http.Request) {
+	var data MSetRequest
+	if !h.decodeRequest(w, r, &data) {
+		return
+	}
+
+	for _, e := range data.Entries {
+		if requestCanceled(w, r) {
+			return
+		}
+		key := strconv.Itoa(e.Key)
+		h.cache.Set(r.Context(), key, strconv.Itoa(e.Value), 0)
+		recordWrite(r, key)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *CacheHandler) GetHandler(w http.ResponseWriter, r *http.Request) {
+	keyStr := r.URL.Query().Get("key")
+	key, err := strconv.Atoi(keyStr)
+	if err != nil {
+		http.Error(w, "Invalid key", http.StatusBadRequest)
+		return
+	}
+
+	value := h.legacyGet(r, key)
+	if requestCanceled(w, r) {
+		return
+	}
+	writeResponse(w, r, &GetResponse{
+		Value:      value,
+		Expiration: time.Now().Add(h.cache.DefaultTTL()).Unix(),
+	})
+}
+
+func (h *CacheHandler) MGetHandler(w http.ResponseWriter, r *http.Request) {
+	keys := r.URL.Query()["key"]
+	resp := &MGetResponse{
+		Entries:    make([]CacheEntry, 0, len(keys)),
+		Expiration: time.Now().Add(h.cache.DefaultTTL()).Unix(),
+	}
+	for _, keyStr := range keys {
+		key, err := strconv.Atoi(keyStr)
+		if err != nil {
+			http.Error(w, "Invalid key", http.StatusBadRequest)
+			return
+		}
+		if requestCanceled(w, r) {
+			return
+		}
+		resp.Entries = append(resp.Entries, CacheEntry{Key: key, Value: h.legacyGet(r, key)})
+	}
+
+	writeResponse(w, r, resp)
+}
+
+// legacyGet reads through the loader like /v1 does; loader failures read
+// as a miss since the legacy response has no way to report them.
+func (h *CacheHandler) legacyGet(r *http.Request, key int) int {
+	keyStr := strconv.Itoa(key)
+	item, hit, err := h.get(r.Context(), keyStr)
+	recordLookup(r, keyStr, hit)
+	if !hit || err != nil {
+		return -1
+	}
+	n, err := strconv.Atoi(item.Value)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func (h *CacheHandler) DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	key, err := strconv.Atoi(r.URL.Query().Get("key"))
+	if err != nil {
+		http.Error(w, "Invalid key", http.StatusBadRequest)
+		return
+	}
+
+	keyStr := strconv.Itoa(key)
+	if !h.cache.Delete(r.Context(), keyStr) {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+	recordWrite(r, keyStr)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *CacheHandler) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, h.cache.Stats(h.hotKeys))
+}
+
+// ReadyHandler is the readiness probe: 200 while serving, 503 before
+// startup completes and while draining.
+func (h *CacheHandler) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.ready.Load() {
+		writeError(w, http.StatusServiceUnavailable, "not ready")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *CacheHandler) FlushHandler(w http.ResponseWriter, r *http.Request) {
+	h.lru.Flush(r.Context())
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *CacheHandler) decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	body := http.MaxBytesReader(w, r.Body, h.maxBodyBytes)
+	c := requestCodec(r.Header.Get("Content-Type"))
+	if err := c.Decode(body, v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return false
+		}
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeResponse(w http.ResponseWriter, r *http.Request, v interface{}) {
+	c := responseCodec(r)
+	if _, ok := v.(protoMessage); !ok && c.ContentType() == "application/protobuf" {
+		c = jsonCodec{}
+	}
+	if _, ok := v.(*V1Entry); !ok && c.ContentType() == "application/octet-stream" {
+		c = jsonCodec{}
+	}
+	w.Header().Set("Content-Type", c.ContentType())
+	w.Header().Add("Vary", "Accept")
+	if err := c.Encode(w, v); err != nil {
+		httpLog.Error("Failed to encode response", "err", err)
+	}
+}
+
+func main() {
+	config, err := loadConfig(os.Args[1:], os.Environ())
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if err := setupLogging(config.Log, os.Stderr); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	shutdownTracing, err := startTracing(config.Tracing)
+	if err != nil {
+		fatal(serverLog, "Failed to start tracing", "err", err)
+	}
+	if len(config.Proxy.Backends) > 0 {
+		runProxy(config)
+		if err := shutdownTracing(context.Background()); err != nil {
+			serverLog.Error("Failed to flush traces", "err", err)
+		}
+		return
+	}
+	if config.Engine.Kind != engineLRU {
+		runEngine(config)
+		if err := shutdownTracing(context.Background()); err != nil {
+			serverLog.Error("Failed to flush traces", "err", err)
+		}
+		return
+	}
+
+	capacity := config.capacity()
+	cache, err := cache.NewCache(cache.WithCapacity(capacity), cache.WithTTL(config.DefaultTTL), cache.WithChunkSize(config.ChunkSize),
+		cache.WithDedup(config.DedupAbove), cache.WithLogger(janitorLog))
+	if err != nil {
+		fatal(serverLog, "Invalid cache configuration", "err", err)
+	}
+	var overflow *overflowStore
+	if config.Overflow.Path != "" {
+		var err error
+		if overflow, err = openOverflowStore(config.Overflow.Path); err != nil {
+			fatal(persistenceLog, "Failed to open overflow store", "err", err)
+		}
+		cache.SetOverflow(overflow)
+	}
+	var loader Loader
+	if config.Loader.URL != "" {
+		loader = newHTTPLoader(config.Loader)
+		cache.SetLoader(loader)
+	}
+	cache.SetRemovalLog(config.RecentRemovals)
+	if config.Capacity == 0 {
+		if limit := config.memoryLimit(); limit > 0 {
+			serverLog.Info("Sized the cache to the memory limit", "capacity", capacity, "limit", limit)
+		} else {
+			serverLog.Info("No memory limit set or found; using the default capacity", "capacity", capacity)
+		}
+	}
+	if pressure := config.memoryPressure(); pressure > 0 {
+		limit := config.memoryLimit()
+		if limit == 0 && config.MemoryPressure > 0 {
+			serverLog.Warn("No memory limit set or found; memory pressure eviction is off")
+		} else if limit > 0 {
+			if err := cache.SetMemoryPressure(limit, pressure); err != nil {
+				fatal(serverLog, "Invalid memory pressure", "err", err)
+			}
+			serverLog.Info("Evicting under memory pressure", "limit", limit, "fraction", pressure)
+		}
+	}
+	if config.HotKeys.TopK > 0 {
+		cache.SetHotKeyTracking(config.HotKeys.TopK, config.HotKeys.Window, config.HotKeys.SketchWidth)
+	}
+	if config.SlowOps.Threshold > 0 {
+		cache.SetSlowOpLog(config.SlowOps.Threshold, config.SlowOps.Recent, serverLog)
+	}
+	valueLimit.max, valueLimit.truncate = config.MaxValueBytes, config.OversizeValues == "truncate"
+	// validate has checked the policies, compressions and serializers.
+	records, _ := serializerNamed(config.Serializer)
+	responseSerializers.server = records
+	responseSerializers.namespaces = make(map[string]serializer)
+	for name, ns := range config.Namespaces {
+		eviction, _ := parseEvictionPolicy(ns.Eviction)
+		compression, _ := parseCompression(ns.Compression)
+		cache.SetQuota(name, Quota{MaxEntries: ns.MaxEntries, MaxBytes: ns.MaxBytes, TTL: ns.TTL, Eviction: eviction,
+			Compression: compression, CompressAbove: ns.CompressAbove, ReservedBytes: ns.ReservedBytes})
+		if ns.Serializer != "" {
+			responseSerializers.namespaces[name], _ = serializerNamed(ns.Serializer)
+		}
+	}
+	// Restore before any listener or event consumer starts, so clients
+	// never see a half-loaded cache and the restore is not replayed to
+	// Kafka, MQTT or the AOF.
+	restoreCtx := withRequestID(context.Background(), "restore")
+	fileCipher, err := newFileCipher(config.Encryption, records)
+	if err != nil {
+		fatal(persistenceLog, "Failed to set up encryption", "err", err)
+	}
+	if config.Snapshot.Path != "" && !config.Snapshot.SkipRestore {
+		start := time.Now()
+		n, expired, err := restoreSnapshot(restoreCtx, config.Snapshot.Path, cache, fileCipher)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			persistenceLog.Info("No snapshot, starting empty", "path", config.Snapshot.Path)
+		case errors.Is(err, errUnsupportedFormat):
+			// The next snapshot would replace it.
+			fatal(persistenceLog, "Refusing to start with snapshot; -skip-restore discards it", "path", config.Snapshot.Path, "err", err)
+		case err != nil:
+			persistenceLog.Warn("Snapshot is damaged; restored the entries before the damage", "path", config.Snapshot.Path, "entries", n, "err", err)
+		default:
+			persistenceLog.Info("Restored snapshot", "entries", n, "path", config.Snapshot.Path, "took", time.Since(start).Round(time.Millisecond), "expired", expired)
+		}
+	}
+
+	var aof *appendOnlyLog
+	if config.AOF.Path != "" {
+		// The log is read in the order written: path, then what an
+		// unfinished rewrite sent to path.next.
+		paths := []string{config.AOF.Path, aofNextPath(config.AOF.Path)}
+		for _, path := range paths {
+			if config.Snapshot.SkipRestore {
+				if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+					fatal(persistenceLog, "Failed to reset append-only log", "err", err)
+				}
+				continue
+			}
+			start := time.Now()
+			n, err := replayAOF(restoreCtx, path, cache, fileCipher)
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+			case err != nil:
+				fatal(persistenceLog, "Failed to replay append-only log", "path", path, "records", n, "err", err)
+			default:
+				persistenceLog.Info("Replayed append-only log", "records", n, "path", path, "took", time.Since(start).Round(time.Millisecond))
+			}
+		}
+		var err error
+		if aof, err = openAOF(config.AOF, fileCipher); err != nil {
+			fatal(persistenceLog, "Failed to open append-only log", "err", err)
+		}
+		cache.AddMutationSink(aof.Record)
+		if config.Snapshot.Path == "" && config.AOF.RewriteSize > 0 {
+			persistenceLog.Warn("Append-only log rewriting needs a snapshot path; the log will grow without bound")
+		}
+	}
+
+	var storeSink *storeWriter
+	if config.Store.Backend != "" {
+		store, err := openStore(config.Store)
+		if err != nil {
+			fatal(persistenceLog, "Failed to open store", "backend", config.Store.Backend, "err", err)
+		}
+		if config.Snapshot.SkipRestore {
+			// Start the store over, as the AOF does.
+			if err := store.Snapshot(cache.Scan("")); err != nil {
+				fatal(persistenceLog, "Failed to reset store", "backend", config.Store.Backend, "err", err)
+			}
+		} else {
+			start := time.Now()
+			n, err := loadStore(restoreCtx, store, cache)
+			if err != nil {
+				fatal(persistenceLog, "Failed to load store", "backend", config.Store.Backend, "entries", n, "err", err)
+			}
+			persistenceLog.Info("Loaded store", "entries", n, "backend", config.Store.Backend, "path", config.Store.Path, "took", time.Since(start).Round(time.Millisecond))
+		}
+		storeSink = startStoreWriter(store, cache, config.Store.CompactInterval)
+		cache.AddMutationSink(storeSink.Record)
+	}
+
+	var l2 *l2Tier
+	if config.L2.RedisAddr != "" {
+		if l2, err = startL2(cache, config.L2); err != nil {
+			fatal(clusterLog, "Failed to connect to Redis", "addr", config.L2.RedisAddr, "err", err)
+		}
+		loader = l2.Loader(loader)
+		cache.SetLoader(loader)
+		cache.AddMutationSink(l2.Record)
+	}
+
+	var snapshots *snapshotter
+	if config.Snapshot.Path != "" {
+		snapshots = startSnapshotter(cache, config.Snapshot, aof, config.AOF.RewriteSize, fileCipher)
+	}
+
+	cacheHandler := &CacheHandler{
+		cache:        cache,
+		lru:          cache,
+		maxBodyBytes: config.MaxBodyBytes,
+		hotKeys:      10,
+		streamsDone:  make(chan struct{}),
+
+		privateResponses: config.Auth.Enabled || config.JWT.Enabled,
+		backupDir:        config.Snapshot.BackupDir,
+		cipher:           fileCipher,
+		snapshotPath:     config.Snapshot.Path,
+		sessions:         newSessionClock(cache),
+		pubsub:           newPubSub(cache),
+		webhooks:         newWebhookSink(cache, config.Webhooks),
+	}
+	cacheHandler.graphQL = newGraphQLSchema(cacheHandler)
+	gossipOn := config.Gossip.BindAddr != ""
+	discoveryOn := config.Cluster.Discovery.Mode != ""
+	if gossipOn && discoveryOn {
+		fatal(clusterLog, "Gossip and discovery cannot both choose the cluster's nodes")
+	}
+	var discovery *discovery
+	var rebalancer *rebalancer
+	var handoff *handoff
+	if len(config.Cluster.Nodes) > 0 || ((gossipOn || discoveryOn) && config.Cluster.Self != "") {
+		if cacheHandler.cluster, err = newCluster(config.Cluster, gossipOn || discoveryOn); err != nil {
+			fatal(clusterLog, "Invalid cluster configuration", "err", err)
+		}
+		if config.Cluster.Rebalance.Enabled {
+			rebalancer = startRebalancer(cacheHandler.cluster, cache, config.Cluster.Rebalance, config.Cluster.APIKey)
+		}
+		if config.Cluster.Handoff.Enabled {
+			handoff = startHandoff(cacheHandler.cluster, cache, config.Cluster.Handoff, config.Cluster.APIKey, config.MaxBodyBytes)
+		}
+		if discoveryOn {
+			if discovery, err = startDiscovery(config.Cluster.Discovery, cacheHandler.cluster); err != nil {
+				fatal(clusterLog, "Failed to start discovery", "err", err)
+			}
+		}
+		if config.Cluster.PeerFill {
+			cache.SetLoader(cacheHandler.cluster.peerFill(loader, config.Cluster.APIKey))
+		}
+	}
+	if gossipOn {
+		var invalidated *LRUCache
+		if config.Gossip.Invalidate {
+			invalidated = cache
+		}
+		if cacheHandler.gossip, err = startGossip(config.Gossip, config.Cluster.Self, cacheHandler.cluster, invalidated); err != nil {
+			fatal(clusterLog, "Failed to start gossip", "err", err)
+		}
+		if config.Gossip.Invalidate {
+			cache.AddMutationSink(cacheHandler.gossip.Record)
+		}
+	}
+	cacheHandler.serveReplicas = config.feature(featureReplication)
+	cacheHandler.replicationBuffer = config.Replication.Buffer
+	if config.Replication.PrimaryURL != "" {
+		cacheHandler.replica = startReplica(cache, config.Replication, cacheHandler.sessions)
+	}
+	if config.Raft.NodeID != "" {
+		if cacheHandler.cluster != nil || cacheHandler.replica != nil {
+			fatal(clusterLog, "Consistent mode cannot be combined with cluster mode or replication")
+		}
+		if config.Snapshot.Path != "" || config.AOF.Path != "" || config.Store.Backend != "" {
+			fatal(clusterLog, "Consistent mode keeps its own log and snapshots; turn off snapshots, the append-only log and the store")
+		}
+		if cacheHandler.consensus, err = startConsensus(cache, config.Raft); err != nil {
+			fatal(clusterLog, "Failed to start Raft", "err", err)
+		}
+	}
+
+	auth, err := newAuthenticator(config.Auth, config.JWT, config.Namespaces)
+	if err != nil {
+		fatal(authLog, "Failed to initialize authentication", "err", err)
+	}
+
+	idempotency := newIdempotencyStore(config.Idempotency)
+
+	var audit *auditLog
+	if config.Audit.Path != "" || config.Audit.WebhookURL != "" {
+		if audit, err = openAuditLog(config.Audit); err != nil {
+			fatal(serverLog, "Failed to open audit log", "err", err)
+		}
+		cache.SetAuditSink(audit.record)
+	}
+
+	for _, hook := range config.Webhooks.Hooks {
+		// validate has checked them.
+		cacheHandler.webhooks.Add(Webhook{URL: hook.URL, Namespace: hook.Namespace, Pattern: hook.Pattern, Events: hook.Events})
+	}
+
+	var kafkaSink *kafkaSink
+	if len(config.Kafka.Brokers) > 0 {
+		kafkaSink = newKafkaSink(config.Kafka)
+		cache.AddMutationSink(kafkaSink.Record)
+	}
+
+	if config.Regions.Name != "" {
+		cacheHandler.regions = startRegionReplicator(config.Regions, config.Cluster.APIKey)
+		cache.AddMutationSink(cacheHandler.regions.Record)
+	}
+
+	var bridge *mqttBridge
+	if config.MQTT.BrokerURL != "" {
+		bridge = startMQTTBridge(cache, config.MQTT)
+	}
+
+	limiter := newRateLimiter(config.RateLimit)
+	auth.limiter = limiter
+	metrics := newMetrics(cache)
+	if cacheHandler.regions != nil {
+		metrics.registry.MustRegister(cacheHandler.regions)
+	}
+	var statsd *statsdExporter
+	if config.StatsD.Addr != "" {
+		if statsd, err = startStatsD(cache, config.StatsD); err != nil {
+			fatal(serverLog, "Failed to start StatsD exporter", "err", err)
+		}
+	}
+	mux := http.NewServeMux()
+	// With a separate admin listener, it serves every route and the public
+	// listener everything but the admin-permission ones.
+	var adminMux *http.ServeMux
+	if config.AdminAddr != "" {
+		adminMux = newAdminMux(cache, config.Profiling)
+	}
+	registerAPIRoutes(cacheHandler, func(route apiRoute, h http.Handler) {
+		if route.Streaming {
+			h = withoutConnDeadlines(h)
+		} else {
+			h = withRequestTimeout(config.RequestTimeout, h)
+			h = statsd.Instrument(route.Pattern(), metrics.Instrument(route.Pattern(), h))
+		}
+		if route.Idempotent {
+			h = idempotency.Middleware(h)
+		}
+		if strings.Contains(route.Path, "{key}") {
+			h = cacheHandler.cluster.Route(h)
+			h = cacheHandler.consensus.Route(h)
+		} else if route.Permission == permWrite {
+			h = cacheHandler.consensus.RefuseWrites(h)
+		}
+		if route.Permission == permWrite {
+			h = cacheHandler.sessions.Issue(h)
+			h = cacheHandler.replica.ReadOnly(h)
+		} else if !route.Streaming {
+			h = cacheHandler.replica.Consistent(h)
+		}
+		h = limiter.Middleware(h)
+		h = auth.Require(route.Permission, h)
+		h = otelhttp.NewHandler(h, route.Pattern())
+		if adminMux != nil {
+			adminMux.Handle(route.Pattern(), h)
+			if route.Permission == permAdmin {
+				return
+			}
+		}
+		mux.Handle(route.Pattern(), h)
+	})
+	mux.Handle("GET /readyz", limiter.Middleware(http.HandlerFunc(cacheHandler.ReadyHandler)))
+	mux.Handle("GET /openapi", limiter.Middleware(http.HandlerFunc(swaggerUIHandler)))
+	mux.Handle("GET /openapi.yaml", limiter.Middleware(http.HandlerFunc(openAPISpecHandler)))
+	if adminMux != nil {
+		adminMux.HandleFunc("GET /readyz", cacheHandler.ReadyHandler)
+		adminMux.Handle("GET /admin/", dashboardHandler())
+		adminMux.Handle("GET /metrics", metrics.Handler())
+	} else {
+		mux.Handle("GET /admin/", limiter.Middleware(dashboardHandler()))
+		mux.Handle("GET /metrics", limiter.Middleware(metrics.Handler()))
+	}
+
+	accessLog := newAccessLogger(config.AccessLog, os.Stdout)
+	cacheHandler.reloader = &reloader{
+		load:    func() (Config, error) { return loadConfig(os.Args[1:], os.Environ()) },
+		cache:   cache,
+		limiter: limiter,
+		auth:    auth,
+		config:  config,
+	}
+
+	handler := requestIDMiddleware(clientMiddleware(accessLog.Middleware(corsMiddleware(mux, mux))))
+
+	server := &http.Server{
+		Addr:              config.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: config.Server.ReadHeaderTimeout,
+		ReadTimeout:       config.Server.ReadTimeout,
+		WriteTimeout:      config.Server.WriteTimeout,
+		IdleTimeout:       config.Server.IdleTimeout,
+		MaxHeaderBytes:    config.Server.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(httpLog.Handler(), slog.LevelWarn),
+	}
+	server.RegisterOnShutdown(cacheHandler.closeStreams)
+
+	server.Protocols = new(http.Protocols)
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetHTTP2(true)
+	server.Protocols.SetUnencryptedHTTP2(config.Server.H2C)
+	server.HTTP2 = &http.HTTP2Config{MaxConcurrentStreams: config.Server.MaxConcurrentStreams}
+
+	if config.TLS.Enabled {
+		server.TLSConfig, err = serverTLSConfig(config.TLS)
+		if err != nil {
+			fatal(serverLog, "Failed to configure TLS", "err", err)
+		}
+	}
+
+	var h3Server *http3.Server
+	if config.HTTP3Addr != "" {
+		if server.TLSConfig == nil {
+			fatal(serverLog, "HTTP3Addr needs TLS to be enabled")
+		}
+		h3Server = newHTTP3Server(config.HTTP3Addr, handler, server.TLSConfig, config.Server)
+		server.Handler = advertiseHTTP3(h3Server, handler)
+	}
+
+	// The TCP and socket listeners share one connection cap.
+	var slots chan struct{}
+	if config.Server.MaxConns > 0 {
+		slots = make(chan struct{}, config.Server.MaxConns)
+	}
+	serveErr := make(chan error, 8)
+	serveHTTP := func(addr string) {
+		ln, err := listen(addr, config.SocketMode)
+		if err != nil {
+			fatal(serverLog, "Failed to listen", "addr", addr, "err", err)
+		}
+		ln = newLimitListener(ln, slots)
+		go func() {
+			if config.TLS.Enabled {
+				serveErr <- server.ServeTLS(ln, "", "")
+			} else {
+				serveErr <- server.Serve(ln)
+			}
+		}()
+	}
+	serveHTTP(config.Addr)
+	if config.SocketPath != "" {
+		serveHTTP("unix:" + config.SocketPath)
+	}
+	if h3Server != nil {
+		conn, err := net.ListenPacket("udp", config.HTTP3Addr)
+		if err != nil {
+			fatal(serverLog, "Failed to listen", "addr", config.HTTP3Addr, "err", err)
+		}
+		go func() { serveErr <- h3Server.Serve(conn) }()
+	}
+
+	var adminServer *http.Server
+	if adminMux != nil {
+		// Plain HTTP, no connection cap and no write timeout, which would
+		// cut off CPU profiles; this port is not meant to be reachable from
+		// outside.
+		adminServer = &http.Server{
+			Addr:              config.AdminAddr,
+			Handler:           requestIDMiddleware(clientMiddleware(accessLog.Middleware(adminMux))),
+			ReadHeaderTimeout: config.Server.ReadHeaderTimeout,
+			IdleTimeout:       config.Server.IdleTimeout,
+			MaxHeaderBytes:    config.Server.MaxHeaderBytes,
+			ErrorLog:          slog.NewLogLogger(httpLog.Handler(), slog.LevelWarn),
+		}
+		adminServer.RegisterOnShutdown(cacheHandler.closeStreams)
+		adminLn, err := listen(config.AdminAddr, config.SocketMode)
+		if err != nil {
+			fatal(serverLog, "Failed to listen", "addr", config.AdminAddr, "err", err)
+		}
+		go func() { serveErr <- adminServer.Serve(adminLn) }()
+	}
+
+	// Non-HTTP protocol listeners, closed on shutdown.
+	var protocols []*connServer
+	serveProtocol := func(addr string, srv *connServer) {
+		ln, err := listen(addr, config.SocketMode)
+		if err != nil {
+			fatal(serverLog, "Failed to listen", "addr", addr, "err", err)
+		}
+		protocols = append(protocols, srv)
+		go func() { serveErr <- srv.Serve(ln) }()
+	}
+	if config.RESPAddr != "" {
+		serveProtocol(config.RESPAddr, &newRESPServer(cache, cacheHandler.pubsub, auth, config.MaxBodyBytes).connServer)
+	}
+	if config.MemcacheAddr != "" {
+		serveProtocol(config.MemcacheAddr, &newMemcacheServer(cache, auth, config.MaxBodyBytes).connServer)
+	}
+	if config.BinaryAddr != "" {
+		serveProtocol(config.BinaryAddr, &newBinaryServer(cache, auth, config.MaxBodyBytes).connServer)
+	}
+
+	var grpcServer *grpc.Server
+	if config.GRPCAddr != "" {
+		var opts []grpc.ServerOption
+		if server.TLSConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(server.TLSConfig)))
+		}
+		grpcServer = newGRPCServer(&grpcService{cache: cache, auth: auth, done: cacheHandler.streamsDone}, opts...)
+		grpcLn, err := listen(config.GRPCAddr, config.SocketMode)
+		if err != nil {
+			fatal(serverLog, "Failed to listen", "addr", config.GRPCAddr, "err", err)
+		}
+		go func() { serveErr <- grpcServer.Serve(grpcLn) }()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	cacheHandler.reloader.reloadOnHangup(ctx)
+	cacheHandler.ready.Store(true)
+
+	select {
+	case err := <-serveErr:
+		fatal(serverLog, "Listener failed", "err", err)
+	case <-ctx.Done():
+	}
+	stop()
+	cacheHandler.ready.Store(false)
+	serverLog.Info("Shutting down, draining connections", "timeout", config.ShutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		serverLog.Warn("Shutdown did not complete cleanly", "err", err)
+	}
+	if h3Server != nil {
+		if err := h3Server.Shutdown(shutdownCtx); err != nil {
+			serverLog.Warn("HTTP/3 shutdown did not complete cleanly", "err", err)
+		}
+	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(shutdownCtx); err != nil {
+			serverLog.Warn("Admin shutdown did not complete cleanly", "err", err)
+		}
+	}
+	for _, srv := range protocols {
+		srv.Close()
+	}
+	if grpcServer != nil {
+		// Watch streams have ended with the HTTP streams above, so a
+		// graceful stop only waits for in-flight calls.
+		cacheHandler.closeStreams()
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-shutdownCtx.Done():
+			grpcServer.Stop()
+		}
+	}
+	if cacheHandler.gossip != nil {
+		cacheHandler.gossip.Close()
+	}
+	if discovery != nil {
+		discovery.Close()
+	}
+	if rebalancer != nil {
+		rebalancer.Close()
+	}
+	if handoff != nil {
+		handoff.Close()
+	}
+	if bridge != nil {
+		bridge.Close()
+	}
+	if cacheHandler.replica != nil {
+		cacheHandler.replica.Close()
+	}
+	if cacheHandler.consensus != nil {
+		cacheHandler.consensus.Close()
+	}
+	cache.Close()
+	cache.ClearMutationSinks()
+	if statsd != nil {
+		if err := statsd.Close(); err != nil {
+			serverLog.Error("Failed to close StatsD exporter", "err", err)
+		}
+	}
+	if cacheHandler.regions != nil {
+		cacheHandler.regions.Close()
+	}
+	if kafkaSink != nil {
+		kafkaSink.Close()
+	}
+	cacheHandler.webhooks.Close()
+	cacheHandler.pubsub.Close()
+	if l2 != nil {
+		if err := l2.Close(); err != nil {
+			clusterLog.Error("Failed to close Redis connection", "err", err)
+		}
+	}
+	if audit != nil {
+		cache.SetAuditSink(nil)
+		if err := audit.Close(); err != nil {
+			serverLog.Error("Failed to close audit log", "err", err)
+		}
+	}
+	if storeSink != nil {
+		if err := storeSink.Close(); err != nil {
+			persistenceLog.Error("Failed to close store", "backend", config.Store.Backend, "err", err)
+		}
+	}
+
+	if snapshots != nil {
+		snapshots.Close()
+		if n, err := snapshots.Snapshot(); err != nil {
+			persistenceLog.Error("Failed to write shutdown snapshot", "err", err)
+		} else {
+			persistenceLog.Info("Wrote snapshot", "entries", n, "path", config.Snapshot.Path)
+		}
+	}
+	if aof != nil {
+		if err := aof.Close(); err != nil {
+			persistenceLog.Error("Failed to close append-only log", "err", err)
+		}
+	}
+	if overflow != nil {
+		if err := overflow.Close(); err != nil {
+			persistenceLog.Error("Failed to close overflow store", "err", err)
+		}
+	}
+	fileCipher.Close()
+	if err := shutdownTracing(context.Background()); err != nil {
+		serverLog.Error("Failed to flush traces", "err", err)
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxMemcacheKeyLen = 250
+	// memcacheRelativeLimit is the largest exptime read as seconds from
+	// now; larger values are Unix timestamps, as in memcached.
+	memcacheRelativeLimit = 60 * 60 * 24 * 30
+	memcacheVersion       = "1.6.0"
+)
+
+var errMemcacheLine = errors.New("line too long")
+
+// memcacheServer speaks the memcached ASCII protocol: get, gets, set,
+// delete, touch, stats, version and quit, plus the meta commands in
+// memcache_meta.go.
+//
+// memcached's exptime 0 means "never expires"; here it means the cache's
+// default TTL, since every entry expires. When authentication is enabled
+// the connection must first authenticate the way memcached -Y does: a set
+// of any key whose data is "<username> <password>", the password being an
+// API key or JWT.
+type memcacheServer struct {
+	connServer
+	cache    *LRUCache
+	auth     *authenticator
+	maxValue int
+	started  time.Time
+}
+
+func newMemcacheServer(cache *LRUCache, auth *authenticator, maxValue int64) *memcacheServer {
+	s := &memcacheServer{
+		cache:    cache,
+		auth:     auth,
+		maxValue: int(maxValue),
+		started:  time.Now(),
+	}
+	s.connServer.serve = s.serveConn
+	return s
+}
+
+type memcacheSession struct {
+	ctx  context.Context
+	perm permission
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func (s *memcacheServer) serveConn(conn net.Conn) {
+	session := &memcacheSession{
+		ctx: withClient(withRequestID(context.Background(), "memcache-"+newRequestID()), conn.RemoteAddr().String()),
+		r:   bufio.NewReader(conn),
+		w:   bufio.NewWriter(conn),
+	}
+	if !s.auth.enabled() {
+		session.perm = permAdmin
+	}
+
+	for {
+		line, err := readMemcacheLine(session.r)
+		if err != nil {
+			if errors.Is(err, errMemcacheLine) {
+				session.w.WriteString("CLIENT_ERROR line too long\r\n")
+				session.w.Flush()
+			}
+			return
+		}
+		quit := s.exec(session, strings.Fields(line))
+		if quit || session.r.Buffered() == 0 {
+			if session.w.Flush() != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+func (s *memcacheServer) exec(session *memcacheSession, fields []string) (quit bool) {
+	w := session.w
+	if len(fields) == 0 {
+		w.WriteString("ERROR\r\n")
+		return false
+	}
+	cmd, args := fields[0], fields[1:]
+
+	switch cmd {
+	case "quit":
+		return true
+	case "version":
+		w.WriteString("VERSION " + memcacheVersion + "\r\n")
+		return false
+	case "set":
+		// set reads its data block before any permission check, so that a
+		// refused set does not leave the data to be parsed as commands.
+		return s.set(session, args)
+	case "ms":
+		return s.metaSet(session, args)
+	case "mn":
+		w.WriteString("MN\r\n")
+		return false
+	case "mg":
+		s.metaGet(session, args)
+		return false
+	case "md":
+		s.metaDelete(session, args)
+		return false
+	case "ma":
+		s.metaArithmetic(session, args)
+		return false
+	}
+
+	required := permRead
+	switch cmd {
+	case "get", "gets", "stats":
+	case "delete", "touch":
+		required = permWrite
+	default:
+		w.WriteString("ERROR\r\n")
+		return false
+	}
+	if !s.allowed(session, required) {
+		return false
+	}
+
+	switch cmd {
+	case "get", "gets":
+		if len(args) == 0 {
+			w.WriteString("ERROR\r\n")
+			return false
+		}
+		for _, key := range args {
+			if !validMemcacheKey(key) {
+				w.WriteString("CLIENT_ERROR bad command line format\r\n")
+				return false
+			}
+		}
+		for _, key := range args {
+			item, found, err := s.cache.GetOrLoad(session.ctx, key)
+			if err != nil {
+				w.WriteString("SERVER_ERROR loading from origin failed\r\n")
+				return false
+			}
+			if !found {
+				continue
+			}
+			fmt.Fprintf(w, "VALUE %s %d %d", key, item.Flags, len(item.Value))
+			if cmd == "gets" {
+				fmt.Fprintf(w, " %d", item.Version)
+			}
+			w.WriteString("\r\n" + item.Value + "\r\n")
+		}
+		w.WriteString("END\r\n")
+	case "delete":
+		noreply := trimNoreply(&args)
+		// "delete <key> 0" is an old form some clients still send.
+		if len(args) == 2 && args[1] == "0" {
+			args = args[:1]
+		}
+		if len(args) != 1 || !validMemcacheKey(args[0]) {
+			w.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return false
+		}
+		reply := "NOT_FOUND"
+		if s.cache.Delete(session.ctx, args[0]) {
+			reply = "DELETED"
+		}
+		if !noreply {
+			w.WriteString(reply + "\r\n")
+		}
+	case "touch":
+		noreply := trimNoreply(&args)
+		if len(args) != 2 || !validMemcacheKey(args[0]) {
+			w.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return false
+		}
+		ttl, expired, ok := memcacheTTL(args[1])
+		if !ok {
+			w.WriteString("CLIENT_ERROR invalid exptime argument\r\n")
+			return false
+		}
+		var touched bool
+		if expired {
+			touched = s.cache.Delete(session.ctx, args[0])
+		} else {
+			if ttl == 0 {
+				ttl = s.cache.TTLFor(args[0])
+			}
+			touched = s.cache.Expire(session.ctx, args[0], ttl)
+		}
+		if !noreply {
+			if touched {
+				w.WriteString("TOUCHED\r\n")
+			} else {
+				w.WriteString("NOT_FOUND\r\n")
+			}
+		}
+	case "stats":
+		if len(args) > 0 {
+			// Only the general group is supported.
+			w.WriteString("END\r\n")
+			return false
+		}
+		s.writeStats(w)
+	}
+	return false
+}
+
+func (s *memcacheServer) allowed(session *memcacheSession, required permission) bool {
+	switch {
+	case session.perm == 0:
+		session.w.WriteString("CLIENT_ERROR unauthenticated\r\n")
+		return false
+	case session.perm < required:
+		session.w.WriteString("CLIENT_ERROR permission denied\r\n")
+		return false
+	}
+	return true
+}
+
+// set handles "set <key> <flags> <exptime> <bytes> [noreply]" and the data
+// block that follows it.
+func (s *memcacheServer) set(session *memcacheSession, args []string) bool {
+	w := session.w
+	noreply := trimNoreply(&args)
+	if len(args) != 4 || !validMemcacheKey(args[0]) {
+		w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return false
+	}
+	flags, err1 := strconv.ParseUint(args[1], 10, 32)
+	size, err2 := strconv.Atoi(args[3])
+	ttl, expired, ok := memcacheTTL(args[2])
+	if err1 != nil || err2 != nil || size < 0 || !ok {
+		w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return false
+	}
+	if size > s.maxValue {
+		// Skip the data block so the connection stays in sync.
+		io.CopyN(io.Discard, session.r, int64(size)+2)
+		w.WriteString("SERVER_ERROR object too large for cache\r\n")
+		return false
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(session.r, data); err != nil {
+		return true
+	}
+	if data[size] != '\r' || data[size+1] != '\n' {
+		w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return false
+	}
+	value := string(data[:size])
+
+	if session.perm == 0 {
+		_, password, _ := strings.Cut(value, " ")
+		perm, principal, ok := s.auth.credential(strings.TrimSpace(password))
+		if !ok {
+			w.WriteString("CLIENT_ERROR authentication failure\r\n")
+			return false
+		}
+		session.perm = perm
+		setPrincipal(session.ctx, principal)
+		w.WriteString("STORED\r\n")
+		return false
+	}
+	if !s.allowed(session, permWrite) {
+		return false
+	}
+	value, err := limitValue(value)
+	if err != nil {
+		w.WriteString("SERVER_ERROR object too large for cache\r\n")
+		return false
+	}
+
+	if expired {
+		s.cache.Delete(session.ctx, args[0])
+	} else {
+		s.cache.Store(session.ctx, Write{Key: args[0], Value: value, TTL: ttl, Flags: uint32(flags)})
+	}
+	if !noreply {
+		w.WriteString("STORED\r\n")
+	}
+	return false
+}
+
+func (s *memcacheServer) writeStats(w *bufio.Writer) {
+	stats := s.cache.Stats(0)
+	var bytes int64
+	for _, ns := range stats.Namespaces {
+		bytes += ns.Bytes
+	}
+	now := time.Now()
+	for _, stat := range []struct {
+		name  string
+		value any
+	}{
+		{"pid", os.Getpid()},
+		{"uptime", int64(now.Sub(s.started).Seconds())},
+		{"time", now.Unix()},
+		{"version", memcacheVersion},
+		{"curr_items", stats.Entries},
+		{"bytes", bytes},
+		{"limit_maxitems", stats.Capacity},
+		{"get_hits", stats.Hits},
+		{"get_misses", stats.Misses},
+		{"evictions", stats.Evictions},
+		{"expired_unfetched", stats.Expirations},
+	} {
+		fmt.Fprintf(w, "STAT %s %v\r\n", stat.name, stat.value)
+	}
+	w.WriteString("END\r\n")
+}
+
+// memcacheTTL interprets an exptime: 0 is the default TTL, negative is
+// already expired, up to 30 days is relative and beyond that absolute.
+func memcacheTTL(s string) (ttl time.Duration, expired, ok bool) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	switch {
+	case err != nil:
+		return 0, false, false
+	case n < 0:
+		return 0, true, true
+	case n == 0:
+		return 0, false, true
+	case n <= memcacheRelativeLimit:
+		return time.Duration(n) * time.Second, false, true
+	}
+	ttl = time.Until(time.Unix(n, 0))
+	return ttl, ttl <= 0, true
+}
+
+func trimNoreply(args *[]string) bool {
+	if n := len(*args); n > 0 && (*args)[n-1] == "noreply" {
+		*args = (*args)[:n-1]
+		return true
+	}
+	return false
+}
+
+func validMemcacheKey(key string) bool {
+	if key == "" || len(key) > maxMemcacheKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func readMemcacheLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", errMemcacheLine
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
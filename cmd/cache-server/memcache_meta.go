@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The memcached meta protocol: mg, ms, md, ma and mn. Each command takes a
+// key and single-letter flags, some with a token ("T30", "Oabc"). Return
+// flags are echoed in the order requested; q suppresses the reply on
+// success (on a miss for mg), so a pipeline of quiet commands ends with mn.
+
+type metaFlags struct {
+	order  []byte
+	tokens map[byte]string
+}
+
+func parseMetaFlags(args []string) (metaFlags, bool) {
+	f := metaFlags{tokens: make(map[byte]string, len(args))}
+	for _, arg := range args {
+		c := arg[0]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+			return f, false
+		}
+		f.order = append(f.order, c)
+		f.tokens[c] = arg[1:]
+	}
+	return f, true
+}
+
+func (f metaFlags) has(c byte) bool {
+	_, ok := f.tokens[c]
+	return ok
+}
+
+// only reports whether every flag given is in allowed.
+func (f metaFlags) only(allowed string) bool {
+	for _, c := range f.order {
+		if !strings.ContainsRune(allowed, rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// returned formats the return flags for item: b, c, f, k, O, s, t.
+func (f metaFlags) returned(rawKey string, item Item) string {
+	var b strings.Builder
+	for _, c := range f.order {
+		switch c {
+		case 'b':
+			if f.has('k') {
+				b.WriteString(" b")
+			}
+		case 'c':
+			fmt.Fprintf(&b, " c%d", item.Version)
+		case 'f':
+			fmt.Fprintf(&b, " f%d", item.Flags)
+		case 'k':
+			b.WriteString(" k" + rawKey)
+		case 'O':
+			b.WriteString(" O" + f.tokens['O'])
+		case 's':
+			fmt.Fprintf(&b, " s%d", len(item.Value))
+		case 't':
+			fmt.Fprintf(&b, " t%d", remainingSeconds(item.ExpiresAt))
+		}
+	}
+	return b.String()
+}
+
+// metaKey decodes the key argument, which is base64 under the b flag.
+func metaKey(raw string, f metaFlags) (string, bool) {
+	if !f.has('b') {
+		return raw, validMemcacheKey(raw)
+	}
+	key, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil || len(key) == 0 || len(key) > maxMemcacheKeyLen {
+		return "", false
+	}
+	return string(key), true
+}
+
+// metaCAS parses the C flag; ok is false if it is present but malformed.
+func metaCAS(f metaFlags) (cas uint64, given, ok bool) {
+	token, given := f.tokens['C']
+	if !given {
+		return 0, false, true
+	}
+	cas, err := strconv.ParseUint(token, 10, 64)
+	return cas, true, err == nil
+}
+
+// metaTTL parses the token of a TTL flag (T or N) like an exptime.
+func metaTTL(f metaFlags, c byte) (ttl time.Duration, expired, given, ok bool) {
+	token, given := f.tokens[c]
+	if !given {
+		return 0, false, false, true
+	}
+	ttl, expired, ok = memcacheTTL(token)
+	return ttl, expired, true, ok
+}
+
+// metaCommand parses "<cmd> <key> <flags>*", writing the error reply and
+// returning ok false if it is malformed.
+func (s *memcacheServer) metaCommand(session *memcacheSession, args []string, allowed string) (key, rawKey string, f metaFlags, ok bool) {
+	if len(args) == 0 {
+		session.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return "", "", f, false
+	}
+	rawKey = args[0]
+	f, ok = parseMetaFlags(args[1:])
+	if !ok || !f.only(allowed) {
+		session.w.WriteString("CLIENT_ERROR invalid flag\r\n")
+		return "", "", f, false
+	}
+	key, ok = metaKey(rawKey, f)
+	if !ok {
+		session.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return "", "", f, false
+	}
+	return key, rawKey, f, true
+}
+
+// metaGet handles "mg <key> <flags>*".
+func (s *memcacheServer) metaGet(session *memcacheSession, args []string) {
+	w := session.w
+	key, rawKey, f, ok := s.metaCommand(session, args, "bcfkOqstTv")
+	if !ok {
+		return
+	}
+	ttl, expired, touch, ok := metaTTL(f, 'T')
+	if !ok {
+		w.WriteString("CLIENT_ERROR bad token in command line format\r\n")
+		return
+	}
+	required := permRead
+	if touch {
+		required = permWrite
+	}
+	if !s.allowed(session, required) {
+		return
+	}
+
+	item, found, err := s.cache.GetOrLoad(session.ctx, key)
+	if err != nil {
+		w.WriteString("SERVER_ERROR loading from origin failed\r\n")
+		return
+	}
+	if found && touch {
+		if expired {
+			s.cache.Delete(session.ctx, key)
+		} else {
+			if ttl == 0 {
+				ttl = s.cache.TTLFor(key)
+			}
+			s.cache.Expire(session.ctx, key, ttl)
+			item.ExpiresAt = time.Now().Add(ttl)
+		}
+	}
+	if !found {
+		if !f.has('q') {
+			w.WriteString("EN\r\n")
+		}
+		return
+	}
+	if f.has('v') {
+		fmt.Fprintf(w, "VA %d%s\r\n%s\r\n", len(item.Value), f.returned(rawKey, item), item.Value)
+	} else {
+		w.WriteString("HD" + f.returned(rawKey, item) + "\r\n")
+	}
+}
+
+// metaSet handles "ms <key> <datalen> <flags>*" and its data block. The M
+// flag picks the mode: S set (default), E add, R replace, A append or P
+// prepend.
+func (s *memcacheServer) metaSet(session *memcacheSession, args []string) bool {
+	w := session.w
+	if len(args) < 2 {
+		w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return false
+	}
+	size, err := strconv.Atoi(args[1])
+	if err != nil || size < 0 {
+		w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return false
+	}
+	if size > s.maxValue {
+		io.CopyN(io.Discard, session.r, int64(size)+2)
+		w.WriteString("SERVER_ERROR object too large for cache\r\n")
+		return false
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(session.r, data); err != nil {
+		return true
+	}
+	if data[size] != '\r' || data[size+1] != '\n' {
+		w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return false
+	}
+	value := string(data[:size])
+
+	key, rawKey, f, ok := s.metaCommand(session, append(args[:1:1], args[2:]...), "bcCFkMOqT")
+	if !ok {
+		return false
+	}
+	cas, checkCAS, ok1 := metaCAS(f)
+	ttl, expired, _, ok2 := metaTTL(f, 'T')
+	flags, err := strconv.ParseUint(f.tokens['F'], 10, 32)
+	if !f.has('F') {
+		flags, err = 0, nil
+	}
+	mode := f.tokens['M']
+	if !f.has('M') {
+		mode = "S"
+	}
+	if !ok1 || !ok2 || err != nil || len(mode) != 1 || !strings.Contains("SERAPserap", mode) {
+		w.WriteString("CLIENT_ERROR bad token in command line format\r\n")
+		return false
+	}
+	if !s.allowed(session, permWrite) {
+		return false
+	}
+	if value, err = limitValue(value); err != nil {
+		w.WriteString("SERVER_ERROR object too large for cache\r\n")
+		return false
+	}
+
+	reply := "HD"
+	tooLarge := false
+	item, stored := s.cache.Update(session.ctx, key, func(current Item, exists bool) (Write, bool) {
+		next := Write{Value: value, TTL: ttl, Flags: uint32(flags)}
+		switch {
+		case checkCAS && !exists:
+			reply = "NF"
+		case checkCAS && current.Version != cas:
+			reply = "EX"
+		default:
+			switch strings.ToUpper(mode) {
+			case "E":
+				if exists {
+					reply = "NS"
+				}
+			case "R":
+				if !exists {
+					reply = "NS"
+				}
+			case "A", "P":
+				if !exists {
+					reply = "NS"
+					break
+				}
+				// Appending keeps the entry's flags and expiry.
+				next.Flags, next.TTL = current.Flags, time.Until(current.ExpiresAt)
+				if strings.ToUpper(mode) == "A" {
+					next.Value = current.Value + value
+				} else {
+					next.Value = value + current.Value
+				}
+				if next.Value, err = limitValue(next.Value); err != nil {
+					reply, tooLarge = "", true
+				}
+			}
+		}
+		return next, reply == "HD"
+	})
+	if tooLarge {
+		w.WriteString("SERVER_ERROR object too large for cache\r\n")
+		return false
+	}
+	if stored && expired {
+		s.cache.Delete(session.ctx, key)
+	}
+	if reply == "HD" && f.has('q') {
+		return false
+	}
+	w.WriteString(reply + f.returned(rawKey, item) + "\r\n")
+	return false
+}
+
+// metaDelete handles "md <key> <flags>*".
+func (s *memcacheServer) metaDelete(session *memcacheSession, args []string) {
+	w := session.w
+	key, rawKey, f, ok := s.metaCommand(session, args, "bCkOq")
+	if !ok {
+		return
+	}
+	cas, checkCAS, ok := metaCAS(f)
+	if !ok {
+		w.WriteString("CLIENT_ERROR bad token in command line format\r\n")
+		return
+	}
+	if !s.allowed(session, permWrite) {
+		return
+	}
+
+	found, deleted := s.cache.DeleteIf(session.ctx, key, func(current Item) bool {
+		return !checkCAS || current.Version == cas
+	})
+	reply := "HD"
+	switch {
+	case !found:
+		reply = "NF"
+	case !deleted:
+		reply = "EX"
+	case f.has('q'):
+		return
+	}
+	w.WriteString(reply + f.returned(rawKey, Item{}) + "\r\n")
+}
+
+// metaArithmetic handles "ma <key> <flags>*". Values are unsigned 64-bit
+// as in memcached: incrementing wraps and decrementing stops at zero. N
+// creates a missing key with the J value (default 0) and N's TTL.
+func (s *memcacheServer) metaArithmetic(session *memcacheSession, args []string) {
+	w := session.w
+	key, rawKey, f, ok := s.metaCommand(session, args, "bcCDJkMNOqtTv")
+	if !ok {
+		return
+	}
+	cas, checkCAS, ok1 := metaCAS(f)
+	ttl, _, setTTL, ok2 := metaTTL(f, 'T')
+	vivifyTTL, _, vivify, ok3 := metaTTL(f, 'N')
+	delta, initial := uint64(1), uint64(0)
+	var err1, err2 error
+	if f.has('D') {
+		delta, err1 = strconv.ParseUint(f.tokens['D'], 10, 64)
+	}
+	if f.has('J') {
+		initial, err2 = strconv.ParseUint(f.tokens['J'], 10, 64)
+	}
+	mode := strings.ToUpper(f.tokens['M'])
+	if !f.has('M') {
+		mode = "I"
+	}
+	if !ok1 || !ok2 || !ok3 || err1 != nil || err2 != nil || (mode != "I" && mode != "+" && mode != "D" && mode != "-") {
+		w.WriteString("CLIENT_ERROR bad token in command line format\r\n")
+		return
+	}
+	if !s.allowed(session, permWrite) {
+		return
+	}
+
+	reply := "HD"
+	item, _ := s.cache.Update(session.ctx, key, func(current Item, exists bool) (Write, bool) {
+		if !exists {
+			if !vivify {
+				reply = "NF"
+				return Write{}, false
+			}
+			return Write{Value: strconv.FormatUint(initial, 10), TTL: vivifyTTL}, true
+		}
+		if checkCAS && current.Version != cas {
+			reply = "EX"
+			return Write{}, false
+		}
+		n, err := strconv.ParseUint(current.Value, 10, 64)
+		if err != nil {
+			reply = "CLIENT_ERROR cannot increment or decrement non-numeric value"
+			return Write{}, false
+		}
+		switch {
+		case mode == "I" || mode == "+":
+			n += delta
+		case n < delta:
+			n = 0
+		default:
+			n -= delta
+		}
+		next := Write{Value: strconv.FormatUint(n, 10), TTL: time.Until(current.ExpiresAt), Flags: current.Flags}
+		if setTTL {
+			next.TTL = ttl
+		}
+		return next, true
+	})
+	switch {
+	case reply != "HD":
+		w.WriteString(reply + "\r\n")
+	case f.has('q'):
+	case f.has('v'):
+		fmt.Fprintf(w, "VA %d%s\r\n%s\r\n", len(item.Value), f.returned(rawKey, item), item.Value)
+	default:
+		w.WriteString("HD" + f.returned(rawKey, item) + "\r\n")
+	}
+}
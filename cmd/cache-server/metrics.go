@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"myproject/cache"
+)
+
+// metrics exports the cache counters, request latencies and expiration
+// sweeps for Prometheus at GET /metrics.
+type metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.HistogramVec
+	sweeps   prometheus.Histogram
+}
+
+func newMetrics(cache *LRUCache) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cache_http_request_duration_seconds",
+			Help:    "HTTP request latency by route, excluding streaming routes.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"route", "code"}),
+		sweeps: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "cache_expiration_sweep_duration_seconds",
+			Help:    "Time taken by each sweep for expired entries.",
+			Buckets: prometheus.ExponentialBuckets(.0001, 4, 10),
+		}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		cacheCollector{cache},
+		m.requests,
+		m.sweeps,
+	)
+	cache.SetSweepObserver(func(d time.Duration, _ int) { m.sweeps.Observe(d.Seconds()) })
+	return m
+}
+
+func (m *metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Instrument records the latency of requests to route.
+func (m *metrics) Instrument(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		m.requests.WithLabelValues(route, strconv.Itoa(rec.status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+var (
+	cacheHitsDesc        = prometheus.NewDesc("cache_hits_total", "Reads that found an entry.", nil, nil)
+	cacheMissesDesc      = prometheus.NewDesc("cache_misses_total", "Reads that found no entry.", nil, nil)
+	cacheEvictionsDesc   = prometheus.NewDesc("cache_evictions_total", "Entries evicted for capacity or a namespace quota.", nil, nil)
+	cacheExpirationsDesc = prometheus.NewDesc("cache_expirations_total", "Entries removed because their TTL passed.", nil, nil)
+	cacheEntriesDesc     = prometheus.NewDesc("cache_entries", "Entries in memory.", nil, nil)
+	cacheBytesDesc       = prometheus.NewDesc("cache_bytes", "Estimated bytes held by keys and values in memory.", nil, nil)
+	cacheMemoryDesc      = prometheus.NewDesc("cache_memory_bytes", "Estimated memory held by entries, by part: keys, values or overhead.", []string{"part"}, nil)
+	cacheDedupDesc       = prometheus.NewDesc("cache_deduplicated_bytes", "Bytes of values that keys share rather than each hold.", nil, nil)
+	cacheRemovalsDesc    = prometheus.NewDesc("cache_removals_total", "Entries removed, by reason.", []string{"reason"}, nil)
+	cacheOpDurationDesc  = prometheus.NewDesc("cache_operation_duration_seconds", "Latency of cache operations, loader calls and expiration sweeps.", []string{"op"}, nil)
+)
+
+// cacheCollector reads the cache counters at scrape time, so the cache
+// itself needs no Prometheus types.
+type cacheCollector struct {
+	cache *LRUCache
+}
+
+func (c cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheHitsDesc
+	ch <- cacheMissesDesc
+	ch <- cacheEvictionsDesc
+	ch <- cacheExpirationsDesc
+	ch <- cacheEntriesDesc
+	ch <- cacheBytesDesc
+	ch <- cacheMemoryDesc
+	ch <- cacheDedupDesc
+	ch <- cacheRemovalsDesc
+	ch <- cacheOpDurationDesc
+}
+
+func (c cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	counters, entries, bytes := c.cache.Counters()
+	ch <- prometheus.MustNewConstMetric(cacheHitsDesc, prometheus.CounterValue, float64(counters.Hits))
+	ch <- prometheus.MustNewConstMetric(cacheMissesDesc, prometheus.CounterValue, float64(counters.Misses))
+	ch <- prometheus.MustNewConstMetric(cacheEvictionsDesc, prometheus.CounterValue, float64(counters.Evictions))
+	ch <- prometheus.MustNewConstMetric(cacheExpirationsDesc, prometheus.CounterValue, float64(counters.Expirations))
+	ch <- prometheus.MustNewConstMetric(cacheEntriesDesc, prometheus.GaugeValue, float64(entries))
+	ch <- prometheus.MustNewConstMetric(cacheBytesDesc, prometheus.GaugeValue, float64(bytes))
+	memory := c.cache.Memory()
+	ch <- prometheus.MustNewConstMetric(cacheMemoryDesc, prometheus.GaugeValue, float64(memory.Keys), "keys")
+	ch <- prometheus.MustNewConstMetric(cacheMemoryDesc, prometheus.GaugeValue, float64(memory.Values), "values")
+	ch <- prometheus.MustNewConstMetric(cacheMemoryDesc, prometheus.GaugeValue, float64(memory.Overhead), "overhead")
+	ch <- prometheus.MustNewConstMetric(cacheDedupDesc, prometheus.GaugeValue, float64(memory.Deduplicated))
+	for reason, n := range c.cache.Removals("", 0).Reasons {
+		ch <- prometheus.MustNewConstMetric(cacheRemovalsDesc, prometheus.CounterValue, float64(n), reason)
+	}
+	for op, h := range c.cache.Latencies() {
+		// The last bucket is unbounded, so it is left to +Inf.
+		buckets := make(map[float64]uint64, cache.LatencyBuckets-1)
+		var cumulative uint64
+		for i, n := range h.Counts[:cache.LatencyBuckets-1] {
+			cumulative += n
+			buckets[cache.LatencyBucketBound(i).Seconds()] = cumulative
+		}
+		ch <- prometheus.MustNewConstHistogram(cacheOpDurationDesc, h.Total, h.Sum.Seconds(), buckets, op)
+	}
+}
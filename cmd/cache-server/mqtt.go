@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttRequestPrefix marks changes made by invalidation messages, so that
+// they are not echoed back to the broker.
+const mqttRequestPrefix = "mqtt-"
+
+// mqttBridge publishes cache events to an MQTT topic and applies
+// invalidations received on another. The connection is retried in the
+// background; events raised while it is down are dropped.
+type mqttBridge struct {
+	client mqtt.Client
+	config MQTTConfig
+	cache  *LRUCache
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+type mqttInvalidation struct {
+	Keys  []string `json:"keys"`
+	Flush bool     `json:"flush"`
+}
+
+func startMQTTBridge(cache *LRUCache, config MQTTConfig) *mqttBridge {
+	b := &mqttBridge{config: config, cache: cache, done: make(chan struct{})}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(config.BrokerURL).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			eventsLog.Warn("MQTT connection lost", "err", err)
+		})
+	b.client = mqtt.NewClient(opts)
+	b.client.Connect()
+
+	events, cancel := cache.Subscribe(1024)
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		defer cancel()
+		for {
+			select {
+			case <-b.done:
+				return
+			case e := <-events:
+				b.forward(e)
+			}
+		}
+	}()
+	return b
+}
+
+// onConnect (re)subscribes on every connect, since the session may not
+// have survived.
+func (b *mqttBridge) onConnect(client mqtt.Client) {
+	eventsLog.Info("MQTT connected", "broker", b.config.BrokerURL)
+	if b.config.InvalidationTopic == "" {
+		return
+	}
+	token := client.Subscribe(b.config.InvalidationTopic, b.config.QoS, b.invalidate)
+	go func() {
+		if token.Wait(); token.Error() != nil {
+			eventsLog.Error("MQTT subscribe failed", "topic", b.config.InvalidationTopic, "err", token.Error())
+		}
+	}()
+}
+
+func (b *mqttBridge) forward(e CacheEvent) {
+	switch e.Type {
+	case eventSet, eventDelete, eventExpire:
+	default:
+		return
+	}
+	if strings.HasPrefix(e.RequestID, mqttRequestPrefix) || !b.client.IsConnectionOpen() {
+		return
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	b.client.Publish(b.config.EventTopic, b.config.QoS, false, payload)
+}
+
+func (b *mqttBridge) invalidate(_ mqtt.Client, msg mqtt.Message) {
+	var inv mqttInvalidation
+	if err := json.Unmarshal(msg.Payload(), &inv); err != nil {
+		eventsLog.Warn("MQTT: ignoring invalid message", "topic", msg.Topic(), "err", err)
+		return
+	}
+	ctx := withRequestID(context.Background(), mqttRequestPrefix+newRequestID())
+	if inv.Flush {
+		b.cache.Flush(ctx)
+		return
+	}
+	for _, key := range inv.Keys {
+		b.cache.Delete(ctx, key)
+	}
+}
+
+// Close stops forwarding and disconnects, giving queued publishes a
+// moment to go out.
+func (b *mqttBridge) Close() {
+	close(b.done)
+	b.wg.Wait()
+	b.client.Disconnect(250)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"iter"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+	_ "gocloud.dev/blob/gcsblob"
+	_ "gocloud.dev/blob/s3blob"
+	"gocloud.dev/gcerrors"
+)
+
+// Snapshots can live in an object store instead of a file, for containers
+// without a persistent disk. The path is then a URL such as
+// "s3://bucket/cache/snapshot.ndjson?region=eu-west-1" or
+// "gs://bucket/cache/snapshot.ndjson". Credentials come from the
+// environment: the AWS default chain for s3, Application Default
+// Credentials for gs.
+
+func isObjectURL(path string) bool {
+	return strings.HasPrefix(path, "s3://") || strings.HasPrefix(path, "gs://")
+}
+
+// openObjectBucket splits rawURL into its bucket, which it opens, and the
+// object key.
+func openObjectBucket(ctx context.Context, rawURL string) (*blob.Bucket, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", err
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, "", fmt.Errorf("%s: want %s://bucket/key", rawURL, u.Scheme)
+	}
+	bucketURL := u.Scheme + "://" + u.Host
+	if u.RawQuery != "" {
+		bucketURL += "?" + u.RawQuery
+	}
+	bucket, err := blob.OpenBucket(ctx, bucketURL)
+	if err != nil {
+		return nil, "", err
+	}
+	return bucket, key, nil
+}
+
+type objectReader struct {
+	*blob.Reader
+	bucket *blob.Bucket
+}
+
+func (r *objectReader) Close() error {
+	err := r.Reader.Close()
+	r.bucket.Close()
+	return err
+}
+
+// openObject opens the object for reading and returns its modification
+// time. A missing object is reported as os.ErrNotExist.
+func openObject(ctx context.Context, rawURL string) (io.ReadCloser, time.Time, error) {
+	bucket, key, err := openObjectBucket(ctx, rawURL)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	r, err := bucket.NewReader(ctx, key, nil)
+	if err != nil {
+		bucket.Close()
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return nil, time.Time{}, fmt.Errorf("%s: %w", rawURL, os.ErrNotExist)
+		}
+		return nil, time.Time{}, err
+	}
+	return &objectReader{Reader: r, bucket: bucket}, r.ModTime(), nil
+}
+
+// statObject returns the size and modification time of the object. A
+// missing object is reported as os.ErrNotExist.
+func statObject(ctx context.Context, rawURL string) (int64, time.Time, error) {
+	bucket, key, err := openObjectBucket(ctx, rawURL)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer bucket.Close()
+	attrs, err := bucket.Attributes(ctx, key)
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return 0, time.Time{}, fmt.Errorf("%s: %w", rawURL, os.ErrNotExist)
+		}
+		return 0, time.Time{}, err
+	}
+	return attrs.Size, attrs.ModTime, nil
+}
+
+// writeObject uploads items in the snapshot format. The object only
+// changes once the upload completes; the one it replaces is copied to
+// snapshotPrevPath first.
+func writeObject(rawURL string, items iter.Seq[Item], c *fileCipher) (int, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bucket, key, err := openObjectBucket(ctx, rawURL)
+	if err != nil {
+		return 0, err
+	}
+	defer bucket.Close()
+
+	// Keep the object being replaced, as writeSnapshot does a file.
+	if err := bucket.Copy(ctx, key+".prev", key, nil); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return 0, err
+	}
+	w, err := bucket.NewWriter(ctx, key, &blob.WriterOptions{ContentType: "application/x-ndjson"})
+	if err != nil {
+		return 0, err
+	}
+	n, err := encodeSnapshot(w, items, c)
+	if err != nil {
+		// Cancelling before Close abandons the upload.
+		cancel()
+		w.Close()
+		return 0, err
+	}
+	return n, w.Close()
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// The overflow tier: entries evicted for capacity are demoted to a Bolt
+// file instead of being dropped, and a miss in memory promotes them back.
+// It extends capacity, not durability; the file is emptied on startup and
+// its entries are not part of snapshots.
+
+var overflowBucket = []byte("entries")
+
+// overflowStore writes to Bolt in the background so that evictions, which
+// happen under the cache lock, never wait for the disk. Until a write is
+// applied it sits in pending, which lookups check first.
+type overflowStore struct {
+	db   *bolt.DB
+	path string
+
+	mutex sync.Mutex
+	// pending maps keys to writes not yet in db; nil is a delete.
+	pending map[string]*Item
+	// applying is held while pending writes go to db, and by Clear.
+	applying sync.Mutex
+	failed   bool
+
+	wake chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func openOverflowStore(path string) (*overflowStore, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second, NoSync: true, NoFreelistSync: true})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(overflowBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &overflowStore{
+		db:      db,
+		path:    path,
+		pending: make(map[string]*Item),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+func (s *overflowStore) Put(item Item) {
+	s.mutex.Lock()
+	s.pending[item.Key] = &item
+	s.mutex.Unlock()
+	s.signal()
+}
+
+// Remove drops any copy of key.
+func (s *overflowStore) Remove(key string) {
+	s.mutex.Lock()
+	if _, ok := s.pending[key]; ok {
+		s.pending[key] = nil
+		s.mutex.Unlock()
+		s.signal()
+		return
+	}
+	s.mutex.Unlock()
+	if _, ok := s.get(key); ok {
+		s.mutex.Lock()
+		s.pending[key] = nil
+		s.mutex.Unlock()
+		s.signal()
+	}
+}
+
+// Take removes key and returns its entry, if it has one that has not
+// expired.
+func (s *overflowStore) Take(key string) (Item, bool) {
+	s.mutex.Lock()
+	if p, ok := s.pending[key]; ok {
+		s.pending[key] = nil
+		s.mutex.Unlock()
+		if p == nil || !time.Now().Before(p.ExpiresAt) {
+			return Item{}, false
+		}
+		return *p, true
+	}
+	s.mutex.Unlock()
+
+	item, ok := s.get(key)
+	if !ok {
+		return Item{}, false
+	}
+	s.mutex.Lock()
+	s.pending[key] = nil
+	s.mutex.Unlock()
+	s.signal()
+	return item, time.Now().Before(item.ExpiresAt)
+}
+
+func (s *overflowStore) get(key string) (Item, bool) {
+	var item Item
+	var ok bool
+	s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(overflowBucket).Get([]byte(key)); v != nil {
+			item, ok = decodeOverflowItem(key, v)
+		}
+		return nil
+	})
+	return item, ok
+}
+
+// Clear drops every entry.
+func (s *overflowStore) Clear() {
+	s.applying.Lock()
+	defer s.applying.Unlock()
+	s.mutex.Lock()
+	s.pending = make(map[string]*Item)
+	s.mutex.Unlock()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(overflowBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(overflowBucket)
+		return err
+	})
+	if err != nil {
+		persistenceLog.Error("Failed to clear overflow store", "err", err)
+	}
+}
+
+func (s *overflowStore) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *overflowStore) run() {
+	defer s.wg.Done()
+	sweep := time.NewTicker(time.Minute)
+	defer sweep.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+			s.apply()
+		case <-sweep.C:
+			s.sweep()
+		}
+	}
+}
+
+// apply writes pending to db and then forgets the writes that were not
+// replaced meanwhile, so a lookup always finds a key in one or the other.
+func (s *overflowStore) apply() {
+	s.applying.Lock()
+	defer s.applying.Unlock()
+
+	s.mutex.Lock()
+	batch := make(map[string]*Item, len(s.pending))
+	for key, item := range s.pending {
+		batch[key] = item
+	}
+	s.mutex.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(overflowBucket)
+		for key, item := range batch {
+			var err error
+			if item == nil {
+				err = b.Delete([]byte(key))
+			} else {
+				err = b.Put([]byte(key), encodeOverflowItem(item))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if !s.failed {
+			persistenceLog.Error("Failed to write overflow store", "err", err)
+			s.failed = true
+		}
+		return
+	}
+	s.failed = false
+
+	s.mutex.Lock()
+	for key, item := range batch {
+		if s.pending[key] == item {
+			delete(s.pending, key)
+		}
+	}
+	s.mutex.Unlock()
+}
+
+// sweep deletes expired entries, which would otherwise stay on disk until
+// looked up.
+func (s *overflowStore) sweep() {
+	s.applying.Lock()
+	defer s.applying.Unlock()
+	now := time.Now()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(overflowBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if len(v) >= 16 && !now.Before(time.Unix(0, int64(binary.BigEndian.Uint64(v[8:16])))) {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		persistenceLog.Error("Failed to sweep overflow store", "err", err)
+	}
+}
+
+// Close stops the writer and removes the file.
+func (s *overflowStore) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	return os.Remove(s.path)
+}
+
+// An entry on disk is stored-at and expires-at in Unix nanoseconds,
+// version and flags, then the value.
+func encodeOverflowItem(item *Item) []byte {
+	b := make([]byte, 0, 28+len(item.Value))
+	b = binary.BigEndian.AppendUint64(b, uint64(item.StoredAt.UnixNano()))
+	b = binary.BigEndian.AppendUint64(b, uint64(item.ExpiresAt.UnixNano()))
+	b = binary.BigEndian.AppendUint64(b, item.Version)
+	b = binary.BigEndian.AppendUint32(b, item.Flags)
+	return append(b, item.Value...)
+}
+
+func decodeOverflowItem(key string, b []byte) (Item, bool) {
+	if len(b) < 28 {
+		return Item{}, false
+	}
+	return Item{
+		Key:       key,
+		StoredAt:  time.Unix(0, int64(binary.BigEndian.Uint64(b[0:8]))),
+		ExpiresAt: time.Unix(0, int64(binary.BigEndian.Uint64(b[8:16]))),
+		Version:   binary.BigEndian.Uint64(b[16:24]),
+		Flags:     binary.BigEndian.Uint32(b[24:28]),
+		Value:     string(b[28:]),
+	}, true
+}
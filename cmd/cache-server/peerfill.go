@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// errPeerUnreachable is a fill that failed before the owner answered.
+var errPeerUnreachable = errors.New("owner unreachable")
+
+type peerKey struct{}
+
+// withPeer marks ctx as serving a request forwarded by another node.
+func withPeer(ctx context.Context) context.Context {
+	return context.WithValue(ctx, peerKey{}, true)
+}
+
+func fromPeer(ctx context.Context) bool {
+	return ctx.Value(peerKey{}) != nil
+}
+
+// peerFill wraps origin, this node's loader or nil, so that a miss on a
+// key another node owns is filled from the owner, which loads the key
+// itself if it must. Each node shares one fill among its misses for a
+// key and only the owner calls origin, so a key is fetched from the
+// origin once across the cluster. Misses forwarded by another node, and
+// those whose owner cannot be reached, go to origin here.
+//
+// The copies filled from the owner are invalidated when it is written to
+// only if gossip broadcasts invalidations; otherwise they expire with the
+// owner's entry.
+func (c *cluster) peerFill(origin Loader, apiKey string) Loader {
+	client := &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+	return func(ctx context.Context, key string) (string, time.Duration, error) {
+		if owner := c.ring.Load().Owner(key); owner != c.self && !fromPeer(ctx) {
+			value, ttl, err := c.fill(ctx, client, owner, key, apiKey)
+			if !errors.Is(err, errPeerUnreachable) {
+				return value, ttl, err
+			}
+			clusterLog.Warn("Failed to fill from owner; loading here", "key", key, "owner", owner, "err", err)
+		}
+		if origin == nil {
+			return "", 0, ErrNotFound
+		}
+		return origin(ctx, key)
+	}
+}
+
+// fill reads key from owner, for as long as the owner's entry has left.
+func (c *cluster) fill(ctx context.Context, client *http.Client, owner, key, apiKey string) (string, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, owner+"/v1/keys/"+url.PathEscape(key), nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(forwardedHeader, c.self)
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	if id := requestIDFrom(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: %v", errPeerUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", 0, ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		return "", 0, fmt.Errorf("owner %s returned %s", owner, resp.Status)
+	}
+	var entry V1Entry
+	if err := json.NewDecoder(resp.Body).Decode(&entry); err != nil {
+		return "", 0, fmt.Errorf("owner %s: %w", owner, err)
+	}
+	ttl := time.Until(entry.ExpiresAt)
+	if ttl <= 0 {
+		return "", 0, ErrNotFound
+	}
+	return entry.Value, ttl, nil
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Hand-written protobuf encodings, matching cache.proto, for the types
+// generated from openapi.yaml and the gRPC messages in grpc.go.
+
+var errInvalidProto = errors.New("invalid protobuf message")
+
+func (e *CacheEntry) marshalProto() []byte {
+	var b []byte
+	b = appendVarintField(b, 1, int64(e.Key))
+	b = appendVarintField(b, 2, int64(e.Value))
+	return b
+}
+
+func (e *CacheEntry) unmarshalProto(b []byte) error {
+	*e = CacheEntry{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			e.Key = int(int64(v))
+			return n, nil
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			e.Value = int(int64(v))
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+func (g *GetResponse) marshalProto() []byte {
+	var b []byte
+	b = appendVarintField(b, 1, int64(g.Value))
+	b = appendVarintField(b, 2, g.Expiration)
+	return b
+}
+
+func (g *GetResponse) unmarshalProto(b []byte) error {
+	*g = GetResponse{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			g.Value = int(int64(v))
+			return n, nil
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			g.Expiration = int64(v)
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+func (m *MGetResponse) marshalProto() []byte {
+	var b []byte
+	for i := range m.Entries {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, m.Entries[i].marshalProto())
+	}
+	b = appendVarintField(b, 2, m.Expiration)
+	return b
+}
+
+func (m *MGetResponse) unmarshalProto(b []byte) error {
+	*m = MGetResponse{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			var e CacheEntry
+			if err := e.unmarshalProto(v); err != nil {
+				return 0, err
+			}
+			m.Entries = append(m.Entries, e)
+			return n, nil
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			m.Expiration = int64(v)
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+func (m *MSetRequest) marshalProto() []byte {
+	var b []byte
+	for i := range m.Entries {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, m.Entries[i].marshalProto())
+	}
+	return b
+}
+
+func (m *MSetRequest) unmarshalProto(b []byte) error {
+	*m = MSetRequest{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num == 1 && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			var e CacheEntry
+			if err := e.unmarshalProto(v); err != nil {
+				return 0, err
+			}
+			m.Entries = append(m.Entries, e)
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+func (e *V1Entry) marshalProto() []byte {
+	var b []byte
+	b = appendBytesField(b, 1, e.Key)
+	b = appendBytesField(b, 2, e.Value)
+	b = appendVarintField(b, 3, e.TTL)
+	if !e.ExpiresAt.IsZero() {
+		b = appendVarintField(b, 4, e.ExpiresAt.Unix())
+	}
+	b = appendVarintField(b, 5, int64(e.Version))
+	return b
+}
+
+func (e *V1Entry) unmarshalProto(b []byte) error {
+	*e = V1Entry{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			e.Key = string(v)
+			return n, nil
+		case num == 2 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			e.Value = string(v)
+			return n, nil
+		case num == 3 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			e.TTL = int64(v)
+			return n, nil
+		case num == 4 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			e.ExpiresAt = time.Unix(int64(v), 0).UTC()
+			return n, nil
+		case num == 5 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			e.Version = v
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+func (p *V1PutRequest) marshalProto() []byte {
+	var b []byte
+	b = appendBytesField(b, 1, p.Value)
+	b = appendVarintField(b, 2, p.TTL)
+	return b
+}
+
+func (p *V1PutRequest) unmarshalProto(b []byte) error {
+	*p = V1PutRequest{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			p.Value = string(v)
+			return n, nil
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			p.TTL = int64(v)
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+func (l *V1EntryList) marshalProto() []byte {
+	var b []byte
+	for i := range l.Entries {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, l.Entries[i].marshalProto())
+	}
+	return b
+}
+
+func (l *V1EntryList) unmarshalProto(b []byte) error {
+	*l = V1EntryList{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num == 1 && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			var e V1Entry
+			if err := e.unmarshalProto(v); err != nil {
+				return 0, err
+			}
+			l.Entries = append(l.Entries, e)
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+// protoEvent is a CacheEvent, whose type belongs to the cache package,
+// with its protobuf encoding.
+type protoEvent CacheEvent
+
+func (e *protoEvent) marshalProto() []byte {
+	var b []byte
+	b = appendBytesField(b, 1, e.Type)
+	b = appendBytesField(b, 2, e.Key)
+	b = appendBytesField(b, 3, e.Reason)
+	b = appendBytesField(b, 4, e.RequestID)
+	if !e.Time.IsZero() {
+		b = appendVarintField(b, 5, e.Time.UnixNano())
+	}
+	return b
+}
+
+func (e *protoEvent) unmarshalProto(b []byte) error {
+	*e = protoEvent{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 5 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			e.Time = time.Unix(0, int64(v)).UTC()
+			return n, nil
+		case typ == protowire.BytesType:
+			var field *string
+			switch num {
+			case 1:
+				field = &e.Type
+			case 2:
+				field = &e.Key
+			case 3:
+				field = &e.Reason
+			case 4:
+				field = &e.RequestID
+			default:
+				return protowire.ConsumeFieldValue(num, typ, b), nil
+			}
+			v, n := protowire.ConsumeBytes(b)
+			*field = string(v)
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+func (k *KeyRequest) marshalProto() []byte {
+	return appendBytesField(nil, 1, k.Key)
+}
+
+func (k *KeyRequest) unmarshalProto(b []byte) error {
+	*k = KeyRequest{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num == 1 && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			k.Key = string(v)
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+func (k *KeysRequest) marshalProto() []byte {
+	return appendStringsField(nil, 1, k.Keys)
+}
+
+func (k *KeysRequest) unmarshalProto(b []byte) error {
+	*k = KeysRequest{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num == 1 && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			k.Keys = append(k.Keys, string(v))
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+func (w *WatchRequest) marshalProto() []byte {
+	return appendStringsField(nil, 1, w.Patterns)
+}
+
+func (w *WatchRequest) unmarshalProto(b []byte) error {
+	*w = WatchRequest{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num == 1 && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			w.Patterns = append(w.Patterns, string(v))
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+func (s *SetRequest) marshalProto() []byte {
+	var b []byte
+	b = appendBytesField(b, 1, s.Key)
+	b = appendBytesField(b, 2, s.Value)
+	b = appendVarintField(b, 3, s.TTL)
+	return b
+}
+
+func (s *SetRequest) unmarshalProto(b []byte) error {
+	*s = SetRequest{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			s.Key = string(v)
+			return n, nil
+		case num == 2 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			s.Value = string(v)
+			return n, nil
+		case num == 3 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			s.TTL = int64(v)
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+func (s *SetManyRequest) marshalProto() []byte {
+	var b []byte
+	for i := range s.Entries {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, s.Entries[i].marshalProto())
+	}
+	return b
+}
+
+func (s *SetManyRequest) unmarshalProto(b []byte) error {
+	*s = SetManyRequest{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num == 1 && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			var e SetRequest
+			if err := e.unmarshalProto(v); err != nil {
+				return 0, err
+			}
+			s.Entries = append(s.Entries, e)
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+func (d *DeleteResponse) marshalProto() []byte {
+	if !d.Deleted {
+		return nil
+	}
+	return appendVarintField(nil, 1, 1)
+}
+
+func (d *DeleteResponse) unmarshalProto(b []byte) error {
+	*d = DeleteResponse{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num == 1 && typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(b)
+			d.Deleted = v != 0
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+// appendStringsField encodes a repeated string field.
+func appendStringsField(b []byte, num protowire.Number, vs []string) []byte {
+	for _, v := range vs {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendString(b, v)
+	}
+	return b
+}
+
+func appendBytesField(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendVarintField(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+// consumeFields walks the fields of a message, handing each value to fn,
+// which returns how many bytes it consumed (negative on a malformed value).
+func consumeFields(b []byte, fn func(protowire.Number, protowire.Type, []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errInvalidProto
+		}
+		b = b[n:]
+		n, err := fn(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return errInvalidProto
+		}
+		b = b[n:]
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"myproject/internal/hashring"
+)
+
+// proxyHeaders are passed from clients to the backends on fanned-out
+// requests, so that the backends authenticate the client itself.
+var proxyHeaders = []string{"Authorization", "X-API-Key"}
+
+// shardProxy serves the /v1/keys API without holding any data: each key
+// belongs to one of the backends on a consistent-hash ring, the same one
+// the cluster client and cluster mode build, and requests are passed to
+// the owners. Multi-key requests are split among the owners, sent in
+// parallel, and their results merged in request order.
+type shardProxy struct {
+	ring         *hashring.Ring
+	proxies      map[string]*httputil.ReverseProxy
+	client       *http.Client
+	maxBodyBytes int64
+}
+
+func newShardProxy(config ProxyConfig, maxBodyBytes int64) (*shardProxy, error) {
+	p := &shardProxy{
+		proxies:      make(map[string]*httputil.ReverseProxy, len(config.Backends)),
+		client:       &http.Client{Timeout: config.Timeout, Transport: otelhttp.NewTransport(http.DefaultTransport)},
+		maxBodyBytes: maxBodyBytes,
+	}
+	nodes := make([]string, len(config.Backends))
+	for i, backend := range config.Backends {
+		nodes[i] = strings.TrimSuffix(backend, "/")
+		target, err := url.Parse(nodes[i])
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+			return nil, fmt.Errorf("backend %q is not an http or https URL", backend)
+		}
+		p.proxies[nodes[i]] = nodeProxy(target, "")
+	}
+	p.ring = hashring.New(nodes)
+	return p, nil
+}
+
+func (p *shardProxy) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/keys/{key}", func(w http.ResponseWriter, r *http.Request) {
+		p.proxies[p.ring.Owner(r.PathValue("key"))].ServeHTTP(w, r)
+	})
+	mux.HandleFunc("GET /v1/keys", p.mget)
+	mux.HandleFunc("POST /v1/keys", p.mset)
+	mux.HandleFunc("DELETE /v1/keys", p.flush)
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	return mux
+}
+
+func (p *shardProxy) mget(w http.ResponseWriter, r *http.Request) {
+	keys := r.URL.Query()["key"]
+	byNode := make(map[string]url.Values)
+	for _, key := range keys {
+		owner := p.ring.Owner(key)
+		if byNode[owner] == nil {
+			byNode[owner] = url.Values{}
+		}
+		byNode[owner].Add("key", key)
+	}
+	found := make(map[string]V1Entry, len(keys))
+	err := fanOut(p, r, byNode, func(node string, query url.Values) (*http.Request, error) {
+		return http.NewRequestWithContext(r.Context(), http.MethodGet, node+"/v1/keys?"+query.Encode(), nil)
+	}, found)
+	if err != nil {
+		writeBackendError(w, r, err)
+		return
+	}
+
+	resp := &V1EntryList{Entries: make([]V1Entry, 0, len(found))}
+	for _, key := range keys {
+		if e, ok := found[key]; ok {
+			resp.Entries = append(resp.Entries, e)
+		}
+	}
+	writeResponse(w, r, resp)
+}
+
+// mset stores each entry on its owner. If one owner fails, the others'
+// entries are stored all the same, and the response is the failure.
+func (p *shardProxy) mset(w http.ResponseWriter, r *http.Request) {
+	var data V1EntryList
+	if err := requestCodec(r.Header.Get("Content-Type")).Decode(http.MaxBytesReader(w, r.Body, p.maxBodyBytes), &data); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	byNode := make(map[string][]V1Entry)
+	for _, e := range data.Entries {
+		if e.Key == "" {
+			writeError(w, http.StatusBadRequest, "key must not be empty")
+			return
+		}
+		owner := p.ring.Owner(e.Key)
+		byNode[owner] = append(byNode[owner], e)
+	}
+	stored := make(map[string]V1Entry, len(data.Entries))
+	err := fanOut(p, r, byNode, func(node string, entries []V1Entry) (*http.Request, error) {
+		body, err := json.Marshal(V1EntryList{Entries: entries})
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, node+"/v1/keys", bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return req, err
+	}, stored)
+	if err != nil {
+		writeBackendError(w, r, err)
+		return
+	}
+
+	resp := &V1EntryList{Entries: make([]V1Entry, 0, len(data.Entries))}
+	for _, e := range data.Entries {
+		resp.Entries = append(resp.Entries, stored[e.Key])
+	}
+	writeResponse(w, r, resp)
+}
+
+// flush empties every backend.
+func (p *shardProxy) flush(w http.ResponseWriter, r *http.Request) {
+	all := make(map[string]struct{}, len(p.proxies))
+	for node := range p.proxies {
+		all[node] = struct{}{}
+	}
+	err := fanOut(p, r, all, func(node string, _ struct{}) (*http.Request, error) {
+		return http.NewRequestWithContext(r.Context(), http.MethodDelete, node+"/v1/keys", nil)
+	}, nil)
+	if err != nil {
+		writeBackendError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// backendError is a backend's failure to answer r, with the status to
+// pass on to the client.
+type backendError struct {
+	node   string
+	status int
+	msg    string
+}
+
+func (e *backendError) Error() string { return "backend " + e.node + ": " + e.msg }
+
+func writeBackendError(w http.ResponseWriter, r *http.Request, err error) {
+	if requestCanceled(w, r) {
+		return
+	}
+	var be *backendError
+	if errors.As(err, &be) {
+		writeError(w, be.status, err.Error())
+		return
+	}
+	writeError(w, http.StatusBadGateway, err.Error())
+}
+
+// fanOut sends the request newRequest builds for each node's part, in
+// parallel, with the client's credentials, and adds the entries in the
+// responses to merged. It returns the first failure.
+func fanOut[T any](p *shardProxy, r *http.Request, parts map[string]T, newRequest func(node string, part T) (*http.Request, error), merged map[string]V1Entry) error {
+	var (
+		mutex    sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	for node, part := range parts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, err := newRequest(node, part)
+			var entries []V1Entry
+			if err == nil {
+				entries, err = p.send(r, node, req)
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			for _, e := range entries {
+				merged[e.Key] = e
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// send makes req to node on behalf of r and returns the entries in the
+// response, if any.
+func (p *shardProxy) send(r *http.Request, node string, req *http.Request) ([]V1Entry, error) {
+	req.Header.Set("Accept", "application/json")
+	for _, name := range proxyHeaders {
+		if v := r.Header.Get(name); v != "" {
+			req.Header.Set(name, v)
+		}
+	}
+	if id := requestIDFrom(r.Context()); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, &backendError{node: node, status: http.StatusBadGateway, msg: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e Error
+		if json.NewDecoder(resp.Body).Decode(&e) != nil || e.Error == "" {
+			e.Error = resp.Status
+		}
+		return nil, &backendError{node: node, status: resp.StatusCode, msg: e.Error}
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	var list V1EntryList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, &backendError{node: node, status: http.StatusBadGateway, msg: err.Error()}
+	}
+	return list.Entries, nil
+}
+
+// runProxy serves the proxy on config.Addr until SIGINT or SIGTERM.
+func runProxy(config Config) {
+	p, err := newShardProxy(config.Proxy, config.MaxBodyBytes)
+	if err != nil {
+		fatal(serverLog, "Invalid proxy configuration", "err", err)
+	}
+	accessLog := newAccessLogger(config.AccessLog, os.Stdout)
+	server := &http.Server{
+		Handler:           requestIDMiddleware(clientMiddleware(accessLog.Middleware(otelhttp.NewHandler(p.Handler(), "proxy")))),
+		ReadHeaderTimeout: config.Server.ReadHeaderTimeout,
+		ReadTimeout:       config.Server.ReadTimeout,
+		WriteTimeout:      config.Server.WriteTimeout,
+		IdleTimeout:       config.Server.IdleTimeout,
+		MaxHeaderBytes:    config.Server.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(httpLog.Handler(), slog.LevelWarn),
+	}
+	ln, err := listen(config.Addr, config.SocketMode)
+	if err != nil {
+		fatal(serverLog, "Failed to listen", "addr", config.Addr, "err", err)
+	}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.Serve(ln) }()
+	serverLog.Info("Proxying to backends", "addr", config.Addr, "backends", p.ring.Nodes())
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-serveErr:
+		fatal(serverLog, "Listener failed", "err", err)
+	case <-ctx.Done():
+	}
+	serverLog.Info("Shutting down, draining connections", "timeout", config.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		serverLog.Warn("Shutdown did not complete cleanly", "err", err)
+	}
+}
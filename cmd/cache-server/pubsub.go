@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"sync"
+)
+
+// Keyspace notifications publish every cache event on two channels, as
+// Redis does: __keyspace@0__:<key>, whose message is the event, and
+// __keyevent@0__:<event>, whose message is the key. Events go by Redis's
+// names for them.
+const (
+	keyspaceChannel = "__keyspace@0__:"
+	keyeventChannel = "__keyevent@0__:"
+)
+
+var keyspaceEvents = map[string]string{
+	eventSet:    "set",
+	eventDelete: "del",
+	eventExpire: "expired",
+	eventEvict:  "evicted",
+}
+
+// pubSub delivers messages on named channels to the subscribers of the
+// channel, or of a pattern (path.Match syntax) that matches it: keyspace
+// notifications, and whatever clients publish. Like the cache's event
+// bus it never blocks: a subscriber whose queue is full misses the
+// message.
+type pubSub struct {
+	cache       *LRUCache
+	mutex       sync.RWMutex
+	subscribers map[*subscriber]struct{}
+	// feed starts publishing the cache's events with the first
+	// subscriber, until done is closed.
+	feed sync.Once
+	done chan struct{}
+}
+
+// subscriber is one client's subscriptions and the queue of messages for
+// it, which is closed when it unsubscribes from the pubSub.
+type subscriber struct {
+	mutex    sync.Mutex
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	messages chan PubSubMessage
+}
+
+func newPubSub(cache *LRUCache) *pubSub {
+	return &pubSub{cache: cache, subscribers: make(map[*subscriber]struct{}), done: make(chan struct{})}
+}
+
+// Subscribe adds a subscriber, with a queue of buffer messages, that is
+// subscribed to nothing yet.
+func (p *pubSub) Subscribe(buffer int) *subscriber {
+	p.feed.Do(p.publishEvents)
+	s := &subscriber{
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+		messages: make(chan PubSubMessage, buffer),
+	}
+	p.mutex.Lock()
+	p.subscribers[s] = struct{}{}
+	p.mutex.Unlock()
+	return s
+}
+
+// Unsubscribe removes s and closes its queue.
+func (p *pubSub) Unsubscribe(s *subscriber) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, ok := p.subscribers[s]; ok {
+		delete(p.subscribers, s)
+		close(s.messages)
+	}
+}
+
+// Publish sends message on channel and returns the number of
+// subscriptions it went to.
+func (p *pubSub) Publish(channel, message string) int {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	n := 0
+	for s := range p.subscribers {
+		n += s.deliver(channel, message)
+	}
+	return n
+}
+
+func (p *pubSub) publishEvents() {
+	events, cancel := p.cache.Subscribe(1024)
+	go func() {
+		defer cancel()
+		for {
+			select {
+			case e := <-events:
+				name := keyspaceEvents[e.Type]
+				p.Publish(keyspaceChannel+e.Key, name)
+				p.Publish(keyeventChannel+name, e.Key)
+			case <-p.done:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops publishing the cache's events.
+func (p *pubSub) Close() {
+	close(p.done)
+}
+
+func (s *subscriber) deliver(channel, message string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n := 0
+	send := func(m PubSubMessage) {
+		n++
+		select {
+		case s.messages <- m:
+		default:
+		}
+	}
+	if _, ok := s.channels[channel]; ok {
+		send(PubSubMessage{Channel: channel, Message: message})
+	}
+	for pattern := range s.patterns {
+		if ok, _ := path.Match(pattern, channel); ok {
+			send(PubSubMessage{Pattern: pattern, Channel: channel, Message: message})
+		}
+	}
+	return n
+}
+
+// checkPattern reports a pattern that path.Match cannot use.
+func checkPattern(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q", pattern)
+	}
+	return nil
+}
+
+// set is s's channels, or with pattern set its patterns. The caller holds
+// the lock.
+func (s *subscriber) set(pattern bool) map[string]struct{} {
+	if pattern {
+		return s.patterns
+	}
+	return s.channels
+}
+
+// Add subscribes s to name, a channel or a pattern, and returns the
+// number of subscriptions s then has.
+func (s *subscriber) Add(pattern bool, name string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.set(pattern)[name] = struct{}{}
+	return len(s.channels) + len(s.patterns)
+}
+
+// Remove unsubscribes s from name, and returns the number of
+// subscriptions s has left.
+func (s *subscriber) Remove(pattern bool, name string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.set(pattern), name)
+	return len(s.channels) + len(s.patterns)
+}
+
+// Names lists s's channels, or patterns, sorted.
+func (s *subscriber) Names(pattern bool) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	names := make([]string, 0, len(s.set(pattern)))
+	for name := range s.set(pattern) {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Count is the number of s's subscriptions.
+func (s *subscriber) Count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.channels) + len(s.patterns)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/raft"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	raftLogBucket    = []byte("logs")
+	raftStableBucket = []byte("stable")
+	// errRaftKeyNotFound is what raft expects, by its text, of a
+	// StableStore asked for a key it has never been given.
+	errRaftKeyNotFound = errors.New("not found")
+)
+
+// raftStore is raft's log and stable storage in one Bolt file. Log entries
+// are JSON under their big-endian index, so they sort in log order. Unlike
+// the entry store, every write is synced: raft relies on its votes and
+// log surviving a crash.
+type raftStore struct {
+	db *bolt.DB
+}
+
+func openRaftStore(path string) (*raftStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(raftLogBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(raftStableBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &raftStore{db: db}, nil
+}
+
+func raftIndexKey(index uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, index)
+}
+
+func (s *raftStore) FirstIndex() (uint64, error) {
+	var index uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if k, _ := tx.Bucket(raftLogBucket).Cursor().First(); k != nil {
+			index = binary.BigEndian.Uint64(k)
+		}
+		return nil
+	})
+	return index, err
+}
+
+func (s *raftStore) LastIndex() (uint64, error) {
+	var index uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if k, _ := tx.Bucket(raftLogBucket).Cursor().Last(); k != nil {
+			index = binary.BigEndian.Uint64(k)
+		}
+		return nil
+	})
+	return index, err
+}
+
+func (s *raftStore) GetLog(index uint64, log *raft.Log) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(raftLogBucket).Get(raftIndexKey(index))
+		if v == nil {
+			return raft.ErrLogNotFound
+		}
+		return json.Unmarshal(v, log)
+	})
+}
+
+func (s *raftStore) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+func (s *raftStore) StoreLogs(logs []*raft.Log) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(raftLogBucket)
+		for _, log := range logs {
+			v, err := json.Marshal(log)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(raftIndexKey(log.Index), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *raftStore) DeleteRange(min, max uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(raftLogBucket).Cursor()
+		for k, _ := c.Seek(raftIndexKey(min)); k != nil && binary.BigEndian.Uint64(k) <= max; k, _ = c.Next() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *raftStore) Set(key, val []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(raftStableBucket).Put(key, val)
+	})
+}
+
+func (s *raftStore) Get(key []byte) ([]byte, error) {
+	var val []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(raftStableBucket).Get(key)
+		if v == nil {
+			return errRaftKeyNotFound
+		}
+		val = append([]byte(nil), v...)
+		return nil
+	})
+	return val, err
+}
+
+func (s *raftStore) SetUint64(key []byte, val uint64) error {
+	return s.Set(key, binary.BigEndian.AppendUint64(nil, val))
+}
+
+func (s *raftStore) GetUint64(key []byte) (uint64, error) {
+	v, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(v) != 8 {
+		return 0, errors.New("stored value is not a uint64")
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+func (s *raftStore) Close() error {
+	return s.db.Close()
+}
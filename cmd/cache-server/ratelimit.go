@@ -0,0 +1,147 @@
+package main
+
+import (
+	"container/list"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"myproject/cache"
+)
+
+type clientLimiter struct {
+	id       string
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter keeps one token bucket per client, identified by the
+// principal it authenticated as and by remote IP otherwise. It runs after
+// authentication, so that made-up credentials cannot buy a client fresh
+// buckets; requests refused for bad credentials are charged to their IP.
+// Buckets go after IdleTimeout unused, and past MaxClients the least
+// recently used goes first.
+type rateLimiter struct {
+	config  RateLimitConfig
+	mutex   sync.Mutex
+	clients map[string]*list.Element
+	// order holds the clients, most recently seen first.
+	order *list.List
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	l := &rateLimiter{
+		config:  config,
+		clients: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+	if config.Enabled {
+		go l.startCleanupRoutine()
+	}
+	return l
+}
+
+func (l *rateLimiter) Middleware(next http.Handler) http.Handler {
+	if !l.config.Enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.allow(w, clientID(r)) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// allow takes a token from the bucket of id, or replies 429 and reports
+// false when there is none.
+func (l *rateLimiter) allow(w http.ResponseWriter, id string) bool {
+	if l == nil || !l.config.Enabled {
+		return true
+	}
+	reservation := l.limiterFor(id).Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		retryAfter := int(math.Ceil(delay.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// update applies new rates to every client, including those with buckets
+// already. Enabled cannot change without a restart.
+func (l *rateLimiter) update(config RateLimitConfig) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	config.Enabled = l.config.Enabled
+	l.config = config
+	for elem := l.order.Front(); elem != nil; elem = elem.Next() {
+		c := elem.Value.(*clientLimiter)
+		c.limiter.SetLimit(rate.Limit(config.RPS))
+		c.limiter.SetBurst(config.Burst)
+	}
+}
+
+func (l *rateLimiter) limiterFor(id string) *rate.Limiter {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	elem, ok := l.clients[id]
+	if ok {
+		l.order.MoveToFront(elem)
+	} else {
+		elem = l.order.PushFront(&clientLimiter{id: id, limiter: rate.NewLimiter(rate.Limit(l.config.RPS), l.config.Burst)})
+		l.clients[id] = elem
+		if limit := l.config.MaxClients; limit > 0 && l.order.Len() > limit {
+			l.remove(l.order.Back())
+		}
+	}
+	c := elem.Value.(*clientLimiter)
+	c.lastSeen = time.Now()
+	return c.limiter
+}
+
+// remove drops the bucket in elem. The caller holds the lock.
+func (l *rateLimiter) remove(elem *list.Element) {
+	l.order.Remove(elem)
+	delete(l.clients, elem.Value.(*clientLimiter).id)
+}
+
+func (l *rateLimiter) startCleanupRoutine() {
+	ticker := time.Tick(time.Minute)
+	for range ticker {
+		l.mutex.Lock()
+		// The least recently seen are at the back.
+		for elem := l.order.Back(); elem != nil; elem = l.order.Back() {
+			if time.Since(elem.Value.(*clientLimiter).lastSeen) <= l.config.IdleTimeout {
+				break
+			}
+			l.remove(elem)
+		}
+		l.mutex.Unlock()
+	}
+}
+
+// clientID identifies the client of r, for its rate limit bucket and
+// idempotency keys: its principal, once authentication has set one, or
+// else its remote IP.
+func clientID(r *http.Request) string {
+	if c := cache.ClientFrom(r.Context()); c != nil && c.Principal != "" {
+		return "principal:" + c.Principal
+	}
+	return "ip:" + remoteIP(r)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return host
+}
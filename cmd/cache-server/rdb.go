@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// rdbReader reads the string keys of a Redis RDB dump as import rows, for
+// migrating off Redis. Keys of other types are skipped and reported, and
+// keys that have expired are dropped as Redis would on load. Keys from
+// every database are imported, so they must not collide. The checksum at
+// the end is not verified.
+type rdbReader struct {
+	r       *bufio.Reader
+	maxLen  int
+	started bool
+	entry   int
+}
+
+const (
+	rdbOpSlotInfo   = 0xf4
+	rdbOpFunction   = 0xf5
+	rdbOpModuleAux  = 0xf7
+	rdbOpIdle       = 0xf8
+	rdbOpFreq       = 0xf9
+	rdbOpAux        = 0xfa
+	rdbOpResizeDB   = 0xfb
+	rdbOpExpireTime = 0xfc
+	rdbOpExpireSecs = 0xfd
+	rdbOpSelectDB   = 0xfe
+	rdbOpEOF        = 0xff
+	rdbTypeString   = 0
+	rdbEncInt8      = 0
+	rdbEncInt16     = 1
+	rdbEncInt32     = 2
+	rdbEncLZF       = 3
+)
+
+// rdbTypeNames names the value types rdbReader can skip.
+var rdbTypeNames = map[byte]string{
+	1: "list", 2: "set", 3: "sorted set", 4: "hash", 5: "sorted set",
+	9: "hash", 10: "list", 11: "set", 12: "sorted set", 13: "hash",
+	14: "list", 16: "hash", 17: "sorted set", 18: "list", 20: "set",
+}
+
+func newRDBReader(r io.Reader, maxLen int) *rdbReader {
+	return &rdbReader{r: bufio.NewReaderSize(r, 64<<10), maxLen: maxLen}
+}
+
+func (d *rdbReader) Next() (*importRow, error) {
+	if !d.started {
+		d.started = true
+		var magic [9]byte
+		if _, err := io.ReadFull(d.r, magic[:]); err != nil {
+			return nil, fmt.Errorf("reading RDB header: %w", err)
+		}
+		if string(magic[:5]) != "REDIS" {
+			return nil, errors.New("not an RDB file")
+		}
+		if _, err := strconv.Atoi(string(magic[5:])); err != nil {
+			return nil, fmt.Errorf("bad RDB version %q", magic[5:])
+		}
+	}
+
+	var expiresAt int64
+	for {
+		op, err := d.r.ReadByte()
+		if err != nil {
+			return nil, d.unexpected(err)
+		}
+		switch op {
+		case rdbOpEOF:
+			return nil, io.EOF
+		case rdbOpAux:
+			if err = d.skipStrings(2); err != nil {
+				return nil, d.unexpected(err)
+			}
+		case rdbOpFunction:
+			if err = d.skipStrings(1); err != nil {
+				return nil, d.unexpected(err)
+			}
+		case rdbOpSelectDB, rdbOpIdle:
+			if _, _, err = d.readLength(); err != nil {
+				return nil, d.unexpected(err)
+			}
+		case rdbOpResizeDB:
+			if err = d.skipLengths(2); err != nil {
+				return nil, d.unexpected(err)
+			}
+		case rdbOpSlotInfo:
+			if err = d.skipLengths(3); err != nil {
+				return nil, d.unexpected(err)
+			}
+		case rdbOpFreq:
+			if _, err = d.r.ReadByte(); err != nil {
+				return nil, d.unexpected(err)
+			}
+		case rdbOpExpireSecs:
+			var b [4]byte
+			if _, err = io.ReadFull(d.r, b[:]); err != nil {
+				return nil, d.unexpected(err)
+			}
+			expiresAt = int64(binary.LittleEndian.Uint32(b[:])) * 1000
+		case rdbOpExpireTime:
+			var b [8]byte
+			if _, err = io.ReadFull(d.r, b[:]); err != nil {
+				return nil, d.unexpected(err)
+			}
+			expiresAt = int64(binary.LittleEndian.Uint64(b[:]))
+		case rdbOpModuleAux:
+			return nil, errors.New("RDB holds module data, which cannot be skipped")
+		default:
+			d.entry++
+			key, err := d.readString()
+			if err != nil {
+				return nil, d.unexpected(err)
+			}
+			if op != rdbTypeString {
+				name, ok := rdbTypeNames[op]
+				if !ok {
+					return nil, fmt.Errorf("key %q: cannot skip values of RDB type %d", key, op)
+				}
+				if err := d.skipValue(op); err != nil {
+					return nil, d.unexpected(err)
+				}
+				return nil, &rowError{line: d.entry, err: fmt.Errorf("key %q is a %s; only strings are imported", key, name)}
+			}
+			value, err := d.readString()
+			if err != nil {
+				return nil, d.unexpected(err)
+			}
+			row := &importRow{Key: key, Value: value}
+			if expiresAt != 0 {
+				ttl := time.Until(time.UnixMilli(expiresAt))
+				if ttl <= 0 {
+					expiresAt = 0
+					continue
+				}
+				row.exactTTL = ttl
+			}
+			if err := validateImportRow(row); err != nil {
+				return nil, &rowError{line: d.entry, err: err}
+			}
+			return row, nil
+		}
+	}
+}
+
+// unexpected reports an error inside an entry, where the dump cannot end.
+func (d *rdbReader) unexpected(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("RDB entry %d: %w", d.entry+1, err)
+}
+
+// readLength reads a length, or, if special is set, the encoding of a
+// string that is not stored as a length and bytes.
+func (d *rdbReader) readLength() (n uint64, special bool, err error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+	case 1:
+		next, err := d.r.ReadByte()
+		return uint64(b&0x3f)<<8 | uint64(next), false, err
+	case 3:
+		return uint64(b & 0x3f), true, nil
+	}
+	switch b {
+	case 0x80:
+		var buf [4]byte
+		_, err = io.ReadFull(d.r, buf[:])
+		return uint64(binary.BigEndian.Uint32(buf[:])), false, err
+	case 0x81:
+		var buf [8]byte
+		_, err = io.ReadFull(d.r, buf[:])
+		return binary.BigEndian.Uint64(buf[:]), false, err
+	}
+	return 0, false, fmt.Errorf("bad length encoding %#x", b)
+}
+
+func (d *rdbReader) readBytes(n uint64) ([]byte, error) {
+	if n > uint64(d.maxLen) {
+		return nil, fmt.Errorf("string of %d bytes exceeds the %d-byte limit", n, d.maxLen)
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(d.r, b)
+	return b, err
+}
+
+func (d *rdbReader) readString() (string, error) {
+	n, special, err := d.readLength()
+	if err != nil {
+		return "", err
+	}
+	if !special {
+		b, err := d.readBytes(n)
+		return string(b), err
+	}
+	switch n {
+	case rdbEncInt8:
+		b, err := d.r.ReadByte()
+		return strconv.Itoa(int(int8(b))), err
+	case rdbEncInt16:
+		var b [2]byte
+		_, err := io.ReadFull(d.r, b[:])
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b[:])))), err
+	case rdbEncInt32:
+		var b [4]byte
+		_, err := io.ReadFull(d.r, b[:])
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(b[:])))), err
+	case rdbEncLZF:
+		clen, _, err := d.readLength()
+		if err != nil {
+			return "", err
+		}
+		ulen, _, err := d.readLength()
+		if err != nil {
+			return "", err
+		}
+		if ulen > uint64(d.maxLen) {
+			return "", fmt.Errorf("string of %d bytes exceeds the %d-byte limit", ulen, d.maxLen)
+		}
+		compressed, err := d.readBytes(clen)
+		if err != nil {
+			return "", err
+		}
+		b, err := lzfDecompress(compressed, int(ulen))
+		return string(b), err
+	}
+	return "", fmt.Errorf("unknown string encoding %d", n)
+}
+
+func (d *rdbReader) skipStrings(n uint64) error {
+	for ; n > 0; n-- {
+		l, special, err := d.readLength()
+		if err != nil {
+			return err
+		}
+		switch {
+		case !special:
+			_, err = d.r.Discard(int(l))
+		case l == rdbEncLZF:
+			var clen uint64
+			if clen, _, err = d.readLength(); err == nil {
+				if _, _, err = d.readLength(); err == nil {
+					_, err = d.r.Discard(int(clen))
+				}
+			}
+		default:
+			err = d.skipIntString(l)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipIntString skips a string stored as an integer.
+func (d *rdbReader) skipIntString(enc uint64) error {
+	var size int
+	switch enc {
+	case rdbEncInt8:
+		size = 1
+	case rdbEncInt16:
+		size = 2
+	case rdbEncInt32:
+		size = 4
+	default:
+		return fmt.Errorf("unknown string encoding %d", enc)
+	}
+	_, err := d.r.Discard(size)
+	return err
+}
+
+func (d *rdbReader) skipLengths(n int) error {
+	for ; n > 0; n-- {
+		if _, _, err := d.readLength(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipValue reads past a value of one of the types in rdbTypeNames.
+func (d *rdbReader) skipValue(typ byte) error {
+	switch typ {
+	case 9, 10, 11, 12, 13, 16, 17, 20:
+		// Encoded in a single blob.
+		return d.skipStrings(1)
+	}
+	n, _, err := d.readLength()
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case 1, 2, 14:
+		return d.skipStrings(n)
+	case 4:
+		return d.skipStrings(2 * n)
+	case 18:
+		for ; n > 0 && err == nil; n-- {
+			if _, _, err = d.readLength(); err == nil {
+				err = d.skipStrings(1)
+			}
+		}
+		return err
+	case 3:
+		// Scores are strings with a one-byte length; 253 to 255 are
+		// NaN and the infinities, with nothing after them.
+		for ; n > 0 && err == nil; n-- {
+			if err = d.skipStrings(1); err != nil {
+				break
+			}
+			var l byte
+			if l, err = d.r.ReadByte(); err == nil && l < 253 {
+				_, err = d.r.Discard(int(l))
+			}
+		}
+		return err
+	case 5:
+		for ; n > 0 && err == nil; n-- {
+			if err = d.skipStrings(1); err == nil {
+				_, err = d.r.Discard(8)
+			}
+		}
+		return err
+	}
+	return fmt.Errorf("cannot skip values of RDB type %d", typ)
+}
+
+// lzfDecompress expands LZF data, which Redis uses for long strings.
+func lzfDecompress(in []byte, size int) ([]byte, error) {
+	errCorrupt := errors.New("corrupt LZF string")
+	out := make([]byte, 0, size)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			// A literal run of ctrl+1 bytes.
+			n := ctrl + 1
+			if i+n > len(in) || len(out)+n > size {
+				return nil, errCorrupt
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+		// A back reference of n bytes.
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errCorrupt
+			}
+			n += int(in[i])
+			i++
+		}
+		n += 2
+		if i >= len(in) {
+			return nil, errCorrupt
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 || len(out)+n > size {
+			return nil, errCorrupt
+		}
+		for j := range n {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != size {
+		return nil, errCorrupt
+	}
+	return out, nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/time/rate"
+)
+
+// migrateRequestPrefix marks the changes a migration makes, on the node
+// handing entries over and on the one taking them, so that they are not
+// broadcast as invalidations.
+const migrateRequestPrefix = "migrate-"
+
+// rebalancer hands the entries this node holds for keys another node now
+// owns over to that node whenever the ring changes, so that scaling the
+// cluster does not leave the new owners cold. It walks the cache in the
+// background, at a limited rate, and drops each entry here once its new
+// owner has it. A further change restarts the walk.
+type rebalancer struct {
+	cluster *cluster
+	cache   *LRUCache
+	client  *http.Client
+	apiKey  string
+	batch   int
+	limiter *rate.Limiter
+	changed chan struct{}
+	stop    chan struct{}
+	done    sync.WaitGroup
+}
+
+func startRebalancer(c *cluster, cache *LRUCache, config RebalanceConfig, apiKey string) *rebalancer {
+	batch := max(config.BatchSize, 1)
+	b := &rebalancer{
+		cluster: c,
+		cache:   cache,
+		client:  &http.Client{Timeout: 30 * time.Second, Transport: otelhttp.NewTransport(http.DefaultTransport)},
+		apiKey:  apiKey,
+		batch:   batch,
+		limiter: rate.NewLimiter(rate.Limit(config.Rate), batch),
+		changed: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	c.onChange(b.notify)
+	b.done.Add(1)
+	go b.run()
+	return b
+}
+
+func (b *rebalancer) notify() {
+	select {
+	case b.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (b *rebalancer) run() {
+	defer b.done.Done()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-b.changed:
+		}
+		b.migrate()
+	}
+}
+
+// migrate sends the entries owned elsewhere on the current ring to their
+// owners, a batch at a time.
+func (b *rebalancer) migrate() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-b.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	start := time.Now()
+	ring := b.cluster.ring.Load()
+	pending := make(map[string][]Item)
+	moved, failed := 0, 0
+	send := func(owner string) bool {
+		items := pending[owner]
+		delete(pending, owner)
+		if err := b.limiter.WaitN(ctx, len(items)); err != nil {
+			return false
+		}
+		if err := b.send(ctx, owner, items); err != nil {
+			clusterLog.Warn("Failed to hand entries over to their owner; keeping them here", "owner", owner, "entries", len(items), "err", err)
+			failed += len(items)
+			return ctx.Err() == nil
+		}
+		dropCtx := withRequestID(ctx, migrateRequestPrefix+newRequestID())
+		for _, item := range items {
+			b.cache.DeleteIf(dropCtx, item.Key, func(current Item) bool { return current.Version == item.Version })
+		}
+		moved += len(items)
+		return true
+	}
+
+	for item := range b.cache.Scan("") {
+		owner := ring.Owner(item.Key)
+		if owner == b.cluster.self {
+			continue
+		}
+		pending[owner] = append(pending[owner], item)
+		if len(pending[owner]) < b.batch {
+			continue
+		}
+		if !send(owner) {
+			return
+		}
+		if b.cluster.ring.Load() != ring {
+			// run starts over on the new ring.
+			clusterLog.Info("Ring changed again; restarting rebalance", "moved", moved)
+			return
+		}
+	}
+	for owner := range pending {
+		if !send(owner) {
+			return
+		}
+	}
+	if moved > 0 || failed > 0 {
+		clusterLog.Info("Rebalanced entries to their new owners", "moved", moved, "failed", failed, "took", time.Since(start).Round(time.Millisecond))
+	}
+}
+
+func (b *rebalancer) send(ctx context.Context, owner string, items []Item) error {
+	list := V1EntryList{Entries: make([]V1Entry, len(items))}
+	for i, item := range items {
+		list.Entries[i] = *v1Entry(item)
+	}
+	body, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, owner+"/v1/cluster/migrate", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(forwardedHeader, b.cluster.self)
+	req.Header.Set(requestIDHeader, migrateRequestPrefix+newRequestID())
+	if b.apiKey != "" {
+		req.Header.Set("X-API-Key", b.apiKey)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("owner returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Close stops a walk in progress.
+func (b *rebalancer) Close() {
+	close(b.stop)
+	b.done.Wait()
+}
+
+// V1MigrateHandler stores entries handed over by another node, unless the
+// key is already here.
+func (h *CacheHandler) V1MigrateHandler(w http.ResponseWriter, r *http.Request) {
+	var data V1EntryList
+	if !h.decodeRequest(w, r, &data) {
+		return
+	}
+	for _, e := range data.Entries {
+		if e.Key == "" {
+			writeError(w, http.StatusBadRequest, "key must not be empty")
+			return
+		}
+	}
+
+	stored := 0
+	for _, e := range data.Entries {
+		ttl := time.Until(e.ExpiresAt)
+		if e.ExpiresAt.IsZero() {
+			ttl = time.Duration(e.TTL) * time.Second
+		}
+		if ttl <= 0 {
+			continue
+		}
+		_, ok := h.lru.Update(r.Context(), e.Key, func(_ Item, exists bool) (Write, bool) {
+			return Write{Value: e.Value, TTL: ttl}, !exists
+		})
+		if ok {
+			stored++
+		}
+	}
+	writeResponse(w, r, &MigrateResponse{Stored: stored})
+}
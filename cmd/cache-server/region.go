@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// regionRequestPrefix marks the changes applied from other regions, so
+// that they are not sent back.
+const regionRequestPrefix = "region-"
+
+const (
+	regionOpSet    = "set"
+	regionOpDelete = "delete"
+)
+
+// regionReplicator keeps regions that are each written to in step, for
+// active-active deployments: the sets and deletes made here are sent to
+// every other region, asynchronously and in batches, and theirs applied
+// here by V1RegionReplicateHandler. A change wins over the entry it meets
+// only if it is later, by the time it was made in its region, and of two
+// made at the same time the one from the region whose name sorts last
+// wins, so regions written at once converge on the last write; that
+// relies on the regions' clocks agreeing.
+//
+// Deletes leave tombstones, so that a set made before a delete but
+// arriving after it does not bring the entry back. Tombstones are kept
+// for TombstoneTTL; a change older than that to a key not here is refused
+// as stale, as a delete of the key may have been forgotten.
+type regionReplicator struct {
+	name   string
+	client *http.Client
+	apiKey string
+	// clusterAPIKey authenticates passing changes on to their owners.
+	clusterAPIKey string
+	batch         int
+	peers         []*regionPeer
+
+	// marks holds, by key, the tombstones of deletes and the regions that
+	// set the entries applied from elsewhere, for TombstoneTTL.
+	mutex        sync.Mutex
+	marks        map[string]regionMark
+	tombstoneTTL time.Duration
+
+	applied atomic.Uint64
+	stale   atomic.Uint64
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// regionMark is the last change to a key from a region: a delete, or a
+// set applied here from another region.
+type regionMark struct {
+	at      time.Time
+	region  string
+	deleted bool
+}
+
+// regionPeer is another region, with the changes queued for it, as the
+// Kafka sink queues them.
+type regionPeer struct {
+	name    string
+	url     string
+	queue   chan RegionChange
+	dropped atomic.Uint64
+	sent    atomic.Uint64
+	// oldest is when the oldest change being sent was made, in Unix
+	// nanoseconds, or 0; lag is how long the last batch delivered took to
+	// arrive after its oldest change.
+	oldest atomic.Int64
+	lag    atomic.Int64
+}
+
+func startRegionReplicator(config RegionsConfig, clusterAPIKey string) *regionReplicator {
+	rr := &regionReplicator{
+		name:          config.Name,
+		client:        &http.Client{Timeout: config.Timeout, Transport: otelhttp.NewTransport(http.DefaultTransport)},
+		apiKey:        config.APIKey,
+		clusterAPIKey: clusterAPIKey,
+		batch:         max(config.BatchSize, 1),
+		marks:         make(map[string]regionMark),
+		tombstoneTTL:  config.TombstoneTTL,
+		stop:          make(chan struct{}),
+	}
+	for name, url := range config.Peers {
+		if name == config.Name {
+			continue
+		}
+		rr.peers = append(rr.peers, &regionPeer{name: name, url: strings.TrimSuffix(url, "/"), queue: make(chan RegionChange, config.QueueSize)})
+	}
+	sort.Slice(rr.peers, func(i, j int) bool { return rr.peers[i].name < rr.peers[j].name })
+	for _, p := range rr.peers {
+		rr.done.Add(1)
+		go rr.run(p)
+	}
+	rr.done.Add(1)
+	go rr.startCleanupRoutine()
+	return rr
+}
+
+// Record is the MutationSink that queues the changes made in this region
+// for the others. Loads, which each region makes for itself, evictions,
+// expirations and flushes stay here, as do changes that repeat ones made
+// elsewhere.
+func (rr *regionReplicator) Record(e CacheEvent, item Item) {
+	if strings.HasPrefix(e.RequestID, regionRequestPrefix) || fromElsewhere(e.RequestID) {
+		return
+	}
+	var change RegionChange
+	switch {
+	case e.Type == eventSet && e.Reason != "load":
+		change = RegionChange{Op: regionOpSet, Key: e.Key, Value: item.Value, ExpiresAt: item.ExpiresAt, StoredAt: item.StoredAt, Flags: int64(item.Flags)}
+	case e.Type == eventDelete && e.Reason == "explicit":
+		change = RegionChange{Op: regionOpDelete, Key: e.Key, StoredAt: e.Time}
+		rr.mark(e.Key, regionMark{at: e.Time, region: rr.name, deleted: true})
+	default:
+		return
+	}
+	for _, p := range rr.peers {
+		select {
+		case p.queue <- change:
+		default:
+			p.dropped.Add(1)
+		}
+	}
+}
+
+func (rr *regionReplicator) run(p *regionPeer) {
+	defer rr.done.Done()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rr.stop:
+			return
+		case change := <-p.queue:
+			if !rr.deliver(p, drainBatch(p.queue, change, rr.batch)) {
+				return
+			}
+		case <-ticker.C:
+			if n := p.dropped.Swap(0); n > 0 {
+				clusterLog.Warn("Cross-region replication dropped changes in the last minute; queue full", "region", p.name, "dropped", n)
+			}
+		}
+	}
+}
+
+// deliver sends batch to p until it succeeds, backing off between
+// attempts, and reports false if the replicator stopped first.
+func (rr *regionReplicator) deliver(p *regionPeer, batch []RegionChange) bool {
+	p.oldest.Store(batch[0].StoredAt.UnixNano())
+	defer p.oldest.Store(0)
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err := rr.send(p.url, &RegionChangeList{Region: rr.name, Changes: batch}, rr.apiKey, "")
+		if err == nil {
+			p.sent.Add(uint64(len(batch)))
+			p.lag.Store(int64(time.Since(batch[0].StoredAt)))
+			if attempt > 0 {
+				clusterLog.Info("Reached region again", "region", p.name)
+			}
+			return true
+		}
+		if attempt == 0 {
+			clusterLog.Warn("Failed to replicate to region; retrying", "region", p.name, "changes", len(batch), "err", err)
+		}
+		select {
+		case <-rr.stop:
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// send posts list to the replicate route at base. forwardedBy, if not
+// empty, passes the changes on to their owner within a cluster.
+func (rr *regionReplicator) send(base string, list *RegionChangeList, apiKey, forwardedBy string) error {
+	body, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, base+"/v1/regions/replicate", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(requestIDHeader, regionRequestPrefix+newRequestID())
+	if forwardedBy != "" {
+		req.Header.Set(forwardedHeader, forwardedBy)
+	}
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	resp, err := rr.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("region returned %s", resp.Status)
+	}
+	return nil
+}
+
+// apply makes the changes here, made in region, that are later than the
+// entries and tombstones they meet.
+func (rr *regionReplicator) apply(ctx context.Context, cache *LRUCache, region string, changes []RegionChange) (applied, stale int) {
+	for _, c := range changes {
+		ok := false
+		if ttl := time.Until(c.ExpiresAt); c.Op == regionOpSet && ttl > 0 {
+			_, ok = cache.Update(ctx, c.Key, func(current Item, exists bool) (Write, bool) {
+				if exists {
+					if !later(c.StoredAt, region, current.StoredAt, rr.regionOf(c.Key, current.StoredAt)) {
+						return Write{}, false
+					}
+				} else if !rr.outlivesDelete(c.Key, c.StoredAt, region) {
+					return Write{}, false
+				}
+				rr.mark(c.Key, regionMark{at: c.StoredAt, region: region})
+				return Write{Value: c.Value, TTL: ttl, Flags: uint32(c.Flags), StoredAt: c.StoredAt}, true
+			})
+		} else {
+			// A delete, or a set that has since expired, removes an older
+			// entry, and leaves a tombstone even when there is none.
+			newer := false
+			cache.DeleteIf(ctx, c.Key, func(current Item) bool {
+				newer = !later(c.StoredAt, region, current.StoredAt, rr.regionOf(c.Key, current.StoredAt))
+				return !newer
+			})
+			if !newer {
+				rr.mark(c.Key, regionMark{at: c.StoredAt, region: region, deleted: true})
+			}
+			ok = !newer
+		}
+		if ok {
+			applied++
+		} else {
+			stale++
+		}
+	}
+	rr.applied.Add(uint64(applied))
+	rr.stale.Add(uint64(stale))
+	return applied, stale
+}
+
+// later reports whether a change made at in region wins over one made at
+// other in otherRegion.
+func later(at time.Time, region string, other time.Time, otherRegion string) bool {
+	if !at.Equal(other) {
+		return at.After(other)
+	}
+	return region > otherRegion
+}
+
+// regionOf returns the region that made the entry under key stored at
+// storedAt: the one it was applied from, or else this one.
+func (rr *regionReplicator) regionOf(key string, storedAt time.Time) string {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+	if m, ok := rr.marks[key]; ok && !m.deleted && m.at.Equal(storedAt) {
+		return m.region
+	}
+	return rr.name
+}
+
+// outlivesDelete reports whether a set of key made at in region, which
+// has no entry here, is later than any delete of it: later than its
+// tombstone, or, with none, than the tombstones forgotten.
+func (rr *regionReplicator) outlivesDelete(key string, at time.Time, region string) bool {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+	if m, ok := rr.marks[key]; ok {
+		return !m.deleted || later(at, region, m.at, m.region)
+	}
+	return time.Since(at) < rr.tombstoneTTL
+}
+
+// mark records m as the last change to key, unless the one recorded is
+// later.
+func (rr *regionReplicator) mark(key string, m regionMark) {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+	if old, ok := rr.marks[key]; ok && later(old.at, old.region, m.at, m.region) {
+		return
+	}
+	rr.marks[key] = m
+}
+
+// startCleanupRoutine forgets the marks older than TombstoneTTL.
+func (rr *regionReplicator) startCleanupRoutine() {
+	defer rr.done.Done()
+	ticker := time.NewTicker(min(rr.tombstoneTTL, time.Minute))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rr.stop:
+			return
+		case <-ticker.C:
+			horizon := time.Now().Add(-rr.tombstoneTTL)
+			rr.mutex.Lock()
+			for key, m := range rr.marks {
+				if m.at.Before(horizon) {
+					delete(rr.marks, key)
+				}
+			}
+			rr.mutex.Unlock()
+		}
+	}
+}
+
+// Close stops sending; changes still queued are not sent.
+func (rr *regionReplicator) Close() {
+	close(rr.stop)
+	rr.done.Wait()
+}
+
+var (
+	regionLagDesc     = prometheus.NewDesc("cache_region_replication_lag_seconds", "Age of the oldest change being sent to a region or, when none is, how late the last batch arrived.", []string{"region"}, nil)
+	regionQueuedDesc  = prometheus.NewDesc("cache_region_replication_queued", "Changes waiting to be sent to a region.", []string{"region"}, nil)
+	regionSentDesc    = prometheus.NewDesc("cache_region_replication_sent_total", "Changes delivered to a region.", []string{"region"}, nil)
+	regionDroppedDesc = prometheus.NewDesc("cache_region_replication_dropped_total", "Changes not sent to a region because its queue was full.", []string{"region"}, nil)
+	regionAppliedDesc = prometheus.NewDesc("cache_region_changes_applied_total", "Changes from other regions applied here.", nil, nil)
+	regionStaleDesc   = prometheus.NewDesc("cache_region_changes_stale_total", "Changes from other regions ignored as older than the entry or tombstone here.", nil, nil)
+)
+
+func (rr *regionReplicator) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{regionLagDesc, regionQueuedDesc, regionSentDesc, regionDroppedDesc, regionAppliedDesc, regionStaleDesc} {
+		ch <- d
+	}
+}
+
+func (rr *regionReplicator) Collect(ch chan<- prometheus.Metric) {
+	for _, p := range rr.peers {
+		lag := time.Duration(p.lag.Load())
+		if oldest := p.oldest.Load(); oldest != 0 {
+			lag = time.Since(time.Unix(0, oldest))
+		}
+		ch <- prometheus.MustNewConstMetric(regionLagDesc, prometheus.GaugeValue, lag.Seconds(), p.name)
+		ch <- prometheus.MustNewConstMetric(regionQueuedDesc, prometheus.GaugeValue, float64(len(p.queue)), p.name)
+		ch <- prometheus.MustNewConstMetric(regionSentDesc, prometheus.CounterValue, float64(p.sent.Load()), p.name)
+		// dropped is reset by the minute's warning; the counter is not.
+		ch <- prometheus.MustNewConstMetric(regionDroppedDesc, prometheus.CounterValue, float64(p.dropped.Load()), p.name)
+	}
+	ch <- prometheus.MustNewConstMetric(regionAppliedDesc, prometheus.CounterValue, float64(rr.applied.Load()))
+	ch <- prometheus.MustNewConstMetric(regionStaleDesc, prometheus.CounterValue, float64(rr.stale.Load()))
+}
+
+// V1RegionReplicateHandler applies changes sent by another region,
+// passing those for keys other nodes own on to them.
+func (h *CacheHandler) V1RegionReplicateHandler(w http.ResponseWriter, r *http.Request) {
+	if h.regions == nil {
+		writeError(w, http.StatusNotFound, "cross-region replication is off")
+		return
+	}
+	var data RegionChangeList
+	if !h.decodeRequest(w, r, &data) {
+		return
+	}
+	for _, c := range data.Changes {
+		if c.Key == "" {
+			writeError(w, http.StatusBadRequest, "key must not be empty")
+			return
+		}
+		if c.Op != regionOpSet && c.Op != regionOpDelete {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown op %q", c.Op))
+			return
+		}
+	}
+
+	local := data.Changes
+	byOwner := make(map[string][]RegionChange)
+	if h.cluster != nil && r.Header.Get(forwardedHeader) == "" {
+		local = nil
+		ring := h.cluster.ring.Load()
+		for _, c := range data.Changes {
+			owner := ring.Owner(c.Key)
+			if owner == h.cluster.self {
+				local = append(local, c)
+			} else {
+				byOwner[owner] = append(byOwner[owner], c)
+			}
+		}
+	}
+	for owner, changes := range byOwner {
+		// The sender retries the whole batch on failure, and applying a
+		// change twice is harmless.
+		if err := h.regions.send(owner, &RegionChangeList{Region: data.Region, Changes: changes}, h.regions.clusterAPIKey, h.cluster.self); err != nil {
+			writeError(w, http.StatusBadGateway, "node "+owner+": "+err.Error())
+			return
+		}
+	}
+	ctx := r.Context()
+	if id := requestIDFrom(ctx); !strings.HasPrefix(id, regionRequestPrefix) {
+		ctx = withRequestID(ctx, regionRequestPrefix+id)
+	}
+	applied, stale := h.regions.apply(ctx, h.lru, data.Region, local)
+	writeResponse(w, r, &RegionApplyResponse{Applied: applied, Stale: stale})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// reloadable are the settings a reload applies while the server runs.
+// Changes to any other take a restart.
+var reloadable = map[string]bool{
+	"Capacity":              true,
+	"DefaultTTL":            true,
+	"RateLimit.RPS":         true,
+	"RateLimit.Burst":       true,
+	"RateLimit.IdleTimeout": true,
+	"Log.Level":             true,
+	"Log.Subsystems":        true,
+	"Auth.Keys":             true,
+	"Auth.KeysFile":         true,
+}
+
+// reloader reloads the configuration from its sources, as at startup, on
+// SIGHUP or POST /v1/admin/config, and applies the settings in reloadable
+// without losing the cache's contents.
+type reloader struct {
+	load    func() (Config, error)
+	cache   *LRUCache
+	limiter *rateLimiter
+	auth    *authenticator
+
+	mutex sync.Mutex
+	// config is the configuration in effect.
+	config Config
+}
+
+// Reload loads the configuration again and applies what it can. A
+// configuration that fails to load or validate changes nothing.
+func (rl *reloader) Reload() (*ConfigReload, error) {
+	next, err := rl.load()
+	if err != nil {
+		return nil, err
+	}
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	report := &ConfigReload{Applied: []string{}, RestartRequired: []string{}}
+	changed := make(map[string]bool)
+	for _, path := range configChanges(reflect.ValueOf(rl.config), reflect.ValueOf(next), "") {
+		if reloadable[path] {
+			report.Applied = append(report.Applied, path)
+			changed[path] = true
+		} else {
+			report.RestartRequired = append(report.RestartRequired, path)
+		}
+	}
+
+	// Check the log levels before applying anything, then the keys, so
+	// that a reload that fails changes nothing.
+	var levels map[string]slog.Level
+	if changed["Log.Level"] || changed["Log.Subsystems"] {
+		names := make(map[string]string, len(logSubsystems))
+		for _, name := range logSubsystems {
+			names[name] = next.Log.Level
+		}
+		for name, level := range next.Log.Subsystems {
+			names[name] = level
+		}
+		if levels, err = parseLogLevels(names); err != nil {
+			return nil, err
+		}
+	}
+	if (changed["Auth.Keys"] || changed["Auth.KeysFile"]) && rl.auth.apiKeys != nil {
+		if err := rl.auth.apiKeys.update(next.Auth); err != nil {
+			return nil, fmt.Errorf("api keys: %w", err)
+		}
+	}
+	rl.config.Auth.Keys, rl.config.Auth.KeysFile = next.Auth.Keys, next.Auth.KeysFile
+	if levels != nil {
+		applyLogLevels(levels)
+		rl.config.Log.Level, rl.config.Log.Subsystems = next.Log.Level, next.Log.Subsystems
+	}
+	if changed["RateLimit.RPS"] || changed["RateLimit.Burst"] || changed["RateLimit.IdleTimeout"] {
+		rl.limiter.update(next.RateLimit)
+		enabled := rl.config.RateLimit.Enabled
+		rl.config.RateLimit = next.RateLimit
+		rl.config.RateLimit.Enabled = enabled
+	}
+	if changed["DefaultTTL"] {
+		rl.cache.SetDefaultTTL(next.DefaultTTL)
+		rl.config.DefaultTTL = next.DefaultTTL
+	}
+	if changed["Capacity"] {
+		capacity := next.capacity()
+		evicted := rl.cache.Resize(withRequestID(context.Background(), "reload"), capacity)
+		rl.config.Capacity = next.Capacity
+		serverLog.Info("Resized the cache", "capacity", capacity, "evicted", evicted)
+	}
+
+	if len(report.RestartRequired) > 0 {
+		serverLog.Warn("Some changed settings take effect only after a restart", "settings", report.RestartRequired)
+	}
+	serverLog.Info("Reloaded configuration", "applied", report.Applied)
+	return report, nil
+}
+
+// configChanges lists the paths of the settings that differ between old
+// and next, down to the first level that is not a struct.
+func configChanges(old, next reflect.Value, prefix string) []string {
+	if old.Kind() != reflect.Struct || old.Type() == durationType {
+		if reflect.DeepEqual(old.Interface(), next.Interface()) {
+			return nil
+		}
+		return []string{prefix}
+	}
+	var changes []string
+	for i := range old.NumField() {
+		field := old.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		path := field.Name
+		if prefix != "" {
+			path = prefix + "." + field.Name
+		}
+		changes = append(changes, configChanges(old.Field(i), next.Field(i), path)...)
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// reloadOnHangup reloads whenever the process gets SIGHUP, until ctx is
+// done.
+func (rl *reloader) reloadOnHangup(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+			}
+			if _, err := rl.Reload(); err != nil {
+				serverLog.Error("Failed to reload configuration; keeping the current one", "err", err)
+			}
+		}
+	}()
+}
+
+// V1ReloadConfigHandler reloads the configuration, as SIGHUP does.
+func (h *CacheHandler) V1ReloadConfigHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := h.reloader.Reload()
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeResponse(w, r, report)
+}
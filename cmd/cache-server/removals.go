@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// V1EvictionsHandler reports removals by reason and the most recent ones,
+// optionally only those of ?key=.
+func (h *CacheHandler) V1EvictionsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if s := r.URL.Query().Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+	}
+	writeResponse(w, r, h.lru.Removals(r.URL.Query().Get("key"), limit))
+}
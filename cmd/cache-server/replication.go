@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// replSynced follows the full copy that opens a replication stream,
+	// and replPing is sent while the stream is otherwise idle.
+	replSynced = "synced"
+	replPing   = "ping"
+
+	replicationHeartbeat = time.Second
+	// replicationTimeout is how long a replica waits for a line before it
+	// takes the primary for lost.
+	replicationTimeout = 5 * time.Second
+
+	// primaryHeader names the primary on a replica's refusal of a write.
+	primaryHeader = "X-Cache-Primary"
+	// serializerHeader names the serializer of a replication stream's
+	// records, if not JSON.
+	serializerHeader = "X-Cache-Serializer"
+)
+
+// aofSetRecord is the log record that stores item.
+func aofSetRecord(item Item) aofRecord {
+	return aofRecord{Op: aofSet, Key: item.Key, Value: item.Value, ExpiresAt: item.ExpiresAt.UnixMilli(), Flags: item.Flags}
+}
+
+// V1ReplicationStreamHandler streams the cache to a replica, in the
+// append-only log's format: a full copy as set records, a synced record,
+// then every set and delete as it happens. As in the log, evictions and
+// expirations are left to the replica. The response's X-Cache-Session
+// names the point the copy starts from, and each later record carries
+// its place in the primary's writes. A replica that falls more than
+// Replication.Buffer records behind is disconnected, to start again from
+// a fresh copy.
+func (h *CacheHandler) V1ReplicationStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.serveReplicas {
+		writeError(w, http.StatusNotFound, "replication is not turned on; add it to features")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	records := make(chan aofRecord, h.replicationBuffer)
+	var overflowed atomic.Bool
+	send := func(record aofRecord) {
+		select {
+		case records <- record:
+		default:
+			overflowed.Store(true)
+		}
+	}
+	// Writes up to start are all in the copy; later ones are queued.
+	start := h.sessions.now()
+	hooks := h.lru.AddHooks(Hooks{
+		OnSet: func(e HookEvent) {
+			record := aofSetRecord(e.Item)
+			record.Seq = h.sessions.writes.Load()
+			send(record)
+		},
+		OnDelete: func(e HookEvent) { send(aofRecord{Op: aofDelete, Key: e.Key, Seq: h.sessions.writes.Load()}) },
+	}, HookOptions{})
+	defer hooks.Remove()
+	h.replicas.Add(1)
+	defer h.replicas.Add(-1)
+
+	codec := lineCodec{serializer: h.cipher.recordSerializer()}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set(sessionHeader, start.String())
+	if name := codec.serializer.Name(); name != "json" {
+		w.Header().Set(serializerHeader, name)
+	}
+	w.WriteHeader(http.StatusOK)
+	enc := lineEncoder{w, codec}
+	// Changes made during the copy are queued behind it. Some are in the
+	// copy already; applying them again leaves the replica as they left
+	// the primary.
+	copied := 0
+	for item := range h.lru.Scan("") {
+		if err := enc.Encode(aofSetRecord(item)); err != nil {
+			return
+		}
+		copied++
+	}
+	if err := enc.Encode(aofRecord{Op: replSynced, Seq: start.writes}); err != nil {
+		return
+	}
+	flusher.Flush()
+	serverLog.Info("Replica synced", "addr", r.RemoteAddr, "entries", copied)
+
+	heartbeat := time.NewTicker(replicationHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			serverLog.Info("Replica disconnected", "addr", r.RemoteAddr)
+			return
+		case <-h.streamsDone:
+			return
+		case <-heartbeat.C:
+			err = enc.Encode(aofRecord{Op: replPing})
+		case record := <-records:
+			if overflowed.Load() {
+				serverLog.Warn("Replica fell behind; disconnecting it to resync", "addr", r.RemoteAddr, "buffer", cap(records))
+				return
+			}
+			err = enc.Encode(record)
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// lineEncoder writes records to a replication stream, a line each.
+type lineEncoder struct {
+	w     io.Writer
+	codec lineCodec
+}
+
+func (e lineEncoder) Encode(record aofRecord) error {
+	line, err := e.codec.marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(append(line, '\n'))
+	return err
+}
+
+// replica keeps the cache a copy of the primary's over its replication
+// stream, and has the HTTP API refuse writes until the primary has been
+// out of reach for Replication.FailoverAfter, when it takes over.
+type replica struct {
+	cache    *LRUCache
+	config   ReplicationConfig
+	url      string
+	client   *http.Client
+	sessions *sessionClock
+
+	mutex       sync.Mutex
+	connected   bool
+	lastContact time.Time
+	promoted    bool
+	applied     uint64
+	fullSyncs   uint64
+	// position is how far into the primary's writes the replica has
+	// applied; advanced, if not nil, is closed when it moves on.
+	position sessionToken
+	advanced chan struct{}
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+func startReplica(cache *LRUCache, config ReplicationConfig, sessions *sessionClock) *replica {
+	r := &replica{
+		cache:       cache,
+		config:      config,
+		url:         strings.TrimSuffix(config.PrimaryURL, "/") + "/v1/admin/replication/stream",
+		client:      &http.Client{},
+		sessions:    sessions,
+		lastContact: time.Now(),
+		stop:        make(chan struct{}),
+	}
+	r.done.Add(1)
+	go r.run()
+	return r
+}
+
+func (r *replica) run() {
+	defer r.done.Done()
+	for {
+		err := r.follow()
+		r.mutex.Lock()
+		wasConnected := r.connected
+		r.connected = false
+		lost := time.Since(r.lastContact)
+		r.mutex.Unlock()
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+		if wasConnected {
+			serverLog.Warn("Lost the replication stream", "primary", r.config.PrimaryURL, "err", err)
+		} else {
+			serverLog.Debug("Failed to reach the primary", "primary", r.config.PrimaryURL, "err", err)
+		}
+		if r.config.FailoverAfter > 0 && lost >= r.config.FailoverAfter {
+			r.sessions.restart()
+			r.mutex.Lock()
+			r.promoted = true
+			r.wake()
+			r.mutex.Unlock()
+			serverLog.Warn("Primary unreachable; taking over as primary", "primary", r.config.PrimaryURL, "lost_for", lost)
+			return
+		}
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// follow applies the primary's stream until it ends.
+func (r *replica) follow() error {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	go func() {
+		select {
+		case <-r.stop:
+			cancel(errors.New("replica stopped"))
+		case <-ctx.Done():
+		}
+	}()
+	watchdog := time.AfterFunc(replicationTimeout, func() {
+		cancel(fmt.Errorf("nothing from the primary in %v", replicationTimeout))
+	})
+	defer watchdog.Stop()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return err
+	}
+	if r.config.APIKey != "" {
+		req.Header.Set("X-API-Key", r.config.APIKey)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return canceledBy(ctx, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("primary answered %s", resp.Status)
+	}
+
+	// A primary too old to send its position leaves run 0, which no
+	// session token is behind.
+	start, _ := parseSessionToken(resp.Header.Get(sessionHeader))
+	codec := lineCodec{serializer: jsonSerializer{}}
+	if name := resp.Header.Get(serializerHeader); name != "" {
+		s, err := serializerNamed(name)
+		if err != nil || !slices.Contains(recordSerializers, s.Name()) {
+			return fmt.Errorf("primary sends records in an unknown serializer %q", name)
+		}
+		codec.serializer = s
+	}
+
+	applyCtx := withRequestID(context.Background(), "replication")
+	// seen holds the keys of the full copy, until it is complete.
+	seen := make(map[string]struct{})
+	br := bufio.NewReader(resp.Body)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return errors.New("primary closed the stream")
+		}
+		if err != nil {
+			return canceledBy(ctx, err)
+		}
+		watchdog.Reset(replicationTimeout)
+		r.mutex.Lock()
+		r.connected, r.lastContact = true, time.Now()
+		r.mutex.Unlock()
+
+		var record aofRecord
+		if err := codec.unmarshal(bytes.TrimRight(line, "\n"), &record); err != nil {
+			return fmt.Errorf("bad record from primary: %w", err)
+		}
+		switch record.Op {
+		case replPing:
+			continue
+		case replSynced:
+			pruned := r.prune(applyCtx, seen)
+			seen = nil
+			r.mutex.Lock()
+			r.fullSyncs++
+			r.advance(sessionToken{run: start.run, writes: record.Seq})
+			r.mutex.Unlock()
+			serverLog.Info("Synced with primary", "primary", r.config.PrimaryURL, "pruned", pruned)
+			continue
+		}
+		if seen != nil {
+			seen[record.Key] = struct{}{}
+		}
+		if err := applyAOFRecord(applyCtx, r.cache, record); err != nil {
+			return fmt.Errorf("bad record from primary: %w", err)
+		}
+		r.mutex.Lock()
+		r.applied++
+		if seen == nil && record.Seq != 0 {
+			r.advance(sessionToken{run: start.run, writes: record.Seq})
+		}
+		r.mutex.Unlock()
+	}
+}
+
+// canceledBy prefers the reason ctx was canceled to the error it caused.
+func canceledBy(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); cause != nil {
+		return cause
+	}
+	return err
+}
+
+// prune deletes the keys the primary's full copy did not have.
+func (r *replica) prune(ctx context.Context, seen map[string]struct{}) int {
+	var stale []string
+	for item := range r.cache.Scan("") {
+		if _, ok := seen[item.Key]; !ok {
+			stale = append(stale, item.Key)
+		}
+	}
+	for _, key := range stale {
+		r.cache.Delete(ctx, key)
+	}
+	return len(stale)
+}
+
+// ReadOnly refuses writes with 421 and the primary in X-Cache-Primary
+// until the replica takes over. A nil replica allows everything. Writes
+// over the other protocols are not refused.
+func (r *replica) ReadOnly(next http.Handler) http.Handler {
+	if r == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mutex.Lock()
+		promoted := r.promoted
+		r.mutex.Unlock()
+		if promoted {
+			next.ServeHTTP(w, req)
+			return
+		}
+		w.Header().Set(primaryHeader, r.config.PrimaryURL)
+		writeError(w, http.StatusMisdirectedRequest, "read-only replica of "+r.config.PrimaryURL)
+	})
+}
+
+// Close stops replicating.
+func (r *replica) Close() {
+	close(r.stop)
+	r.done.Wait()
+}
+
+// V1ReplicationHandler reports this node's part in replication.
+func (h *CacheHandler) V1ReplicationHandler(w http.ResponseWriter, r *http.Request) {
+	status := &ReplicationStatus{Role: "primary", Replicas: int(h.replicas.Load())}
+	if rep := h.replica; rep != nil {
+		rep.mutex.Lock()
+		if !rep.promoted {
+			status.Role = "replica"
+		}
+		status.Primary = rep.config.PrimaryURL
+		status.Connected = rep.connected
+		status.SecondsSinceContact = time.Since(rep.lastContact).Seconds()
+		status.Applied = rep.applied
+		status.FullSyncs = rep.fullSyncs
+		status.Promoted = rep.promoted
+		rep.mutex.Unlock()
+	}
+	writeResponse(w, r, status)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"myproject/cache"
+)
+
+const (
+	requestIDHeader   = "X-Request-ID"
+	maxRequestIDBytes = 128
+)
+
+// requestIDMiddleware gives every request an ID: the caller's X-Request-ID
+// if it sent a usable one, a random one otherwise. The ID is echoed in the
+// response and carried in the context for logs, events and loader calls.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(withRequestID(r.Context(), id)))
+	})
+}
+
+func withRequestID(ctx context.Context, id string) context.Context {
+	return cache.WithRequestID(ctx, id)
+}
+
+// requestIDFrom returns the request ID carried by ctx, or "".
+func requestIDFrom(ctx context.Context) string {
+	return cache.RequestIDFrom(ctx)
+}
+
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID accepts printable ASCII without spaces, so a propagated
+// ID cannot break log lines or headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDBytes {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
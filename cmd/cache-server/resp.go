@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRESPArgs bounds the number of arguments in one command.
+const maxRESPArgs = 1 << 16
+
+var errRESPProtocol = errors.New("protocol error")
+
+// respServer speaks enough of the Redis protocol (RESP2) for ordinary Redis
+// clients to use the cache: GET, SET, DEL, EXPIRE, TTL, INCR, FLUSHALL and
+// PING, plus AUTH and QUIT, and pub/sub with keyspace notifications (see
+// resp_pubsub.go). Commands go straight to the cache core.
+type respServer struct {
+	connServer
+	cache   *LRUCache
+	pubsub  *pubSub
+	auth    *authenticator
+	maxBulk int
+}
+
+func newRESPServer(cache *LRUCache, pubsub *pubSub, auth *authenticator, maxBulk int64) *respServer {
+	s := &respServer{
+		cache:   cache,
+		pubsub:  pubsub,
+		auth:    auth,
+		maxBulk: int(maxBulk),
+	}
+	s.connServer.serve = s.serveConn
+	return s
+}
+
+type respSession struct {
+	perm permission
+	// mutex guards the connection's writer, which sub's messages are
+	// pushed to as well once the client subscribes.
+	mutex sync.Mutex
+	sub   *subscriber
+}
+
+func (s *respServer) serveConn(conn net.Conn) {
+	// One ID per connection, so events caused over it can be traced back.
+	ctx := withClient(withRequestID(context.Background(), "resp-"+newRequestID()), conn.RemoteAddr().String())
+	session := &respSession{}
+	if !s.auth.enabled() {
+		session.perm = permAdmin
+	}
+	defer func() {
+		if session.sub != nil {
+			s.pubsub.Unsubscribe(session.sub)
+		}
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readRESPCommand(r, s.maxBulk)
+		if err != nil {
+			if errors.Is(err, errRESPProtocol) {
+				writeRESPError(w, "ERR "+err.Error())
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		session.mutex.Lock()
+		quit := s.exec(ctx, session, w, args)
+		// Pipelined commands are answered in one write.
+		if quit || r.Buffered() == 0 {
+			if w.Flush() != nil {
+				quit = true
+			}
+		}
+		session.mutex.Unlock()
+		if quit {
+			return
+		}
+	}
+}
+
+var respPermissions = map[string]permission{
+	"GET":      permRead,
+	"TTL":      permRead,
+	"SET":      permWrite,
+	"DEL":      permWrite,
+	"EXPIRE":   permWrite,
+	"INCR":     permWrite,
+	"FLUSHALL": permAdmin,
+
+	"SUBSCRIBE":    permRead,
+	"PSUBSCRIBE":   permRead,
+	"UNSUBSCRIBE":  permRead,
+	"PUNSUBSCRIBE": permRead,
+	"PUBLISH":      permWrite,
+}
+
+func (s *respServer) exec(ctx context.Context, session *respSession, w *bufio.Writer, args []string) (quit bool) {
+	name := strings.ToUpper(args[0])
+	args = args[1:]
+	arity := func(min, max int) bool {
+		if len(args) < min || (max >= 0 && len(args) > max) {
+			writeRESPError(w, fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+			return false
+		}
+		return true
+	}
+
+	if session.subscribed() && !respSubscribedCommands[name] {
+		writeRESPError(w, fmt.Sprintf("ERR Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context", truncateName(strings.ToLower(name))))
+		return false
+	}
+
+	switch name {
+	case "PING":
+		if !arity(0, 1) {
+			return false
+		}
+		if session.subscribed() {
+			// Replies in this state are arrays, as messages are.
+			writeRESPArrayHeader(w, 2)
+			writeRESPBulk(w, "pong")
+			writeRESPBulk(w, strings.Join(args, ""))
+		} else if len(args) == 1 {
+			writeRESPBulk(w, args[0])
+		} else {
+			writeRESPSimple(w, "PONG")
+		}
+		return false
+	case "QUIT":
+		writeRESPSimple(w, "OK")
+		return true
+	case "AUTH":
+		if !arity(1, 2) {
+			return false
+		}
+		if !s.auth.enabled() {
+			writeRESPError(w, "ERR AUTH called without any password configured")
+			return false
+		}
+		perm, principal, ok := s.auth.credential(args[len(args)-1])
+		if !ok {
+			writeRESPError(w, "WRONGPASS invalid username-password pair or user is disabled.")
+			return false
+		}
+		session.perm = perm
+		setPrincipal(ctx, principal)
+		writeRESPSimple(w, "OK")
+		return false
+	}
+
+	required, ok := respPermissions[name]
+	if !ok {
+		writeRESPError(w, fmt.Sprintf("ERR unknown command '%s'", truncateName(name)))
+		return false
+	}
+	if session.perm == 0 {
+		writeRESPError(w, "NOAUTH Authentication required.")
+		return false
+	}
+	if session.perm < required {
+		writeRESPError(w, fmt.Sprintf("NOPERM this user has no permissions to run the '%s' command", strings.ToLower(name)))
+		return false
+	}
+
+	switch name {
+	case "GET":
+		if !arity(1, 1) {
+			return false
+		}
+		item, found, err := s.cache.GetOrLoad(ctx, args[0])
+		switch {
+		case err != nil:
+			writeRESPError(w, "ERR loading from origin failed: "+err.Error())
+		case !found:
+			writeRESPNil(w)
+		default:
+			writeRESPBulk(w, item.Value)
+		}
+	case "SET":
+		if !arity(2, -1) {
+			return false
+		}
+		s.set(ctx, w, args[0], args[1], args[2:])
+	case "DEL":
+		if !arity(1, -1) {
+			return false
+		}
+		n := 0
+		for _, key := range args {
+			if s.cache.Delete(ctx, key) {
+				n++
+			}
+		}
+		writeRESPInt(w, int64(n))
+	case "EXPIRE":
+		if !arity(2, 2) {
+			return false
+		}
+		seconds, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			writeRESPError(w, "ERR value is not an integer or out of range")
+			return false
+		}
+		var ok bool
+		if seconds <= 0 {
+			ok = s.cache.Delete(ctx, args[0])
+		} else {
+			ttl, valid := respDuration(seconds, time.Second)
+			if !valid {
+				writeRESPError(w, "ERR invalid expire time in 'expire' command")
+				return false
+			}
+			ok = s.cache.Expire(ctx, args[0], ttl)
+		}
+		writeRESPBool(w, ok)
+	case "TTL":
+		if !arity(1, 1) {
+			return false
+		}
+		item, ok := s.cache.Peek(args[0])
+		if !ok {
+			writeRESPInt(w, -2)
+			return false
+		}
+		writeRESPInt(w, remainingSeconds(item.ExpiresAt))
+	case "INCR":
+		if !arity(1, 1) {
+			return false
+		}
+		item, err := s.cache.Incr(ctx, args[0], 1)
+		switch {
+		case errors.Is(err, ErrNotInteger):
+			writeRESPError(w, "ERR value is not an integer or out of range")
+		case errors.Is(err, ErrOverflow):
+			writeRESPError(w, "ERR increment or decrement would overflow")
+		default:
+			n, _ := strconv.ParseInt(item.Value, 10, 64)
+			writeRESPInt(w, n)
+		}
+	case "FLUSHALL":
+		if !arity(0, 1) {
+			return false
+		}
+		s.cache.Flush(ctx)
+		writeRESPSimple(w, "OK")
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		if !arity(1, -1) {
+			return false
+		}
+		s.subscribe(session, w, name, args)
+	case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		s.unsubscribe(session, w, name, args)
+	case "PUBLISH":
+		if !arity(2, 2) {
+			return false
+		}
+		writeRESPInt(w, int64(s.pubsub.Publish(args[0], args[1])))
+	}
+	return false
+}
+
+// set handles SET key value [EX seconds | PX milliseconds] [NX | XX].
+func (s *respServer) set(ctx context.Context, w *bufio.Writer, key, value string, opts []string) {
+	var ttl time.Duration
+	var nx, xx bool
+	for i := 0; i < len(opts); i++ {
+		switch opt := strings.ToUpper(opts[i]); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX":
+			if i+1 == len(opts) {
+				writeRESPError(w, "ERR syntax error")
+				return
+			}
+			i++
+			unit := time.Second
+			if opt == "PX" {
+				unit = time.Millisecond
+			}
+			n, err := strconv.ParseInt(opts[i], 10, 64)
+			valid := err == nil
+			if valid {
+				ttl, valid = respDuration(n, unit)
+			}
+			if !valid {
+				writeRESPError(w, "ERR invalid expire time in 'set' command")
+				return
+			}
+		default:
+			writeRESPError(w, "ERR syntax error")
+			return
+		}
+	}
+	if nx && xx {
+		writeRESPError(w, "ERR syntax error")
+		return
+	}
+	value, err := limitValue(value)
+	if err != nil {
+		writeRESPError(w, "ERR "+err.Error())
+		return
+	}
+
+	if !nx && !xx {
+		s.cache.Set(ctx, key, value, ttl)
+		writeRESPSimple(w, "OK")
+		return
+	}
+	_, stored := s.cache.SetIf(ctx, key, value, ttl, func(_ Item, exists bool) bool {
+		return exists == xx
+	})
+	if stored {
+		writeRESPSimple(w, "OK")
+	} else {
+		writeRESPNil(w)
+	}
+}
+
+// respDuration converts n units to a duration, reporting false unless n
+// is positive and the duration fits.
+func respDuration(n int64, unit time.Duration) (time.Duration, bool) {
+	if n <= 0 || n > int64(math.MaxInt64/unit) {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// truncateName keeps an unknown command name short enough to echo back.
+func truncateName(name string) string {
+	if len(name) > 64 {
+		return name[:64] + "..."
+	}
+	return name
+}
+
+// readRESPCommand reads one command, either as a RESP array of bulk
+// strings (what client libraries send) or as an inline space-separated
+// line (what a human at telnet sends). The bulk strings of one command
+// may hold maxBulk bytes between them, as a request body may over HTTP.
+func readRESPCommand(r *bufio.Reader, maxBulk int) ([]string, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, nil
+	}
+	if line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxRESPArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errRESPProtocol)
+	}
+	// Like Redis, take an empty or null array as an empty command.
+	if n <= 0 {
+		return nil, nil
+	}
+	args := make([]string, 0, min(n, 16))
+	total := 0
+	for range n {
+		line, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if line == "" || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$'", errRESPProtocol)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulk-total {
+			return nil, fmt.Errorf("%w: invalid bulk length", errRESPProtocol)
+		}
+		total += size
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, fmt.Errorf("%w: expected CRLF after bulk string", errRESPProtocol)
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// readRESPLine reads a CRLF- (or LF-) terminated line. Lines longer than
+// the reader's buffer are a protocol error rather than a reason to grow it.
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", fmt.Errorf("%w: too big inline request", errRESPProtocol)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func writeRESPSimple(w *bufio.Writer, s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+func writeRESPError(w *bufio.Writer, msg string) {
+	w.WriteString("-" + strings.NewReplacer("\r", " ", "\n", " ").Replace(msg) + "\r\n")
+}
+
+func writeRESPInt(w *bufio.Writer, n int64) {
+	w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func writeRESPBool(w *bufio.Writer, ok bool) {
+	if ok {
+		writeRESPInt(w, 1)
+	} else {
+		writeRESPInt(w, 0)
+	}
+}
+
+func writeRESPBulk(w *bufio.Writer, s string) {
+	w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n")
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+func writeRESPArrayHeader(w *bufio.Writer, n int) {
+	w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+func writeRESPNil(w *bufio.Writer) {
+	w.WriteString("$-1\r\n")
+}
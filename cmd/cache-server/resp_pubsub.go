@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+)
+
+// respPubSubBuffer is how many messages may wait for a subscribed
+// connection; further ones are lost, as with the other event streams.
+const respPubSubBuffer = 1024
+
+// respSubscribedCommands are those a connection may run while it has
+// subscriptions, as in Redis with RESP2.
+var respSubscribedCommands = map[string]bool{
+	"SUBSCRIBE":    true,
+	"PSUBSCRIBE":   true,
+	"UNSUBSCRIBE":  true,
+	"PUNSUBSCRIBE": true,
+	"PING":         true,
+	"QUIT":         true,
+}
+
+func (session *respSession) subscribed() bool {
+	return session.sub != nil && session.sub.Count() > 0
+}
+
+// subscribe runs SUBSCRIBE or PSUBSCRIBE, confirming each channel or
+// pattern in an array of its own. The first subscription starts pushing
+// the connection's messages to it.
+func (s *respServer) subscribe(session *respSession, w *bufio.Writer, name string, args []string) {
+	pattern := name == "PSUBSCRIBE"
+	if pattern {
+		for _, p := range args {
+			if err := checkPattern(p); err != nil {
+				writeRESPError(w, "ERR "+err.Error())
+				return
+			}
+		}
+	}
+	if session.sub == nil {
+		session.sub = s.pubsub.Subscribe(respPubSubBuffer)
+		go session.push(w)
+	}
+	for _, channel := range args {
+		writeRESPSubscription(w, strings.ToLower(name), channel, session.sub.Add(pattern, channel))
+	}
+}
+
+// unsubscribe runs UNSUBSCRIBE or PUNSUBSCRIBE, which without arguments
+// drop every channel, or pattern.
+func (s *respServer) unsubscribe(session *respSession, w *bufio.Writer, name string, args []string) {
+	pattern := name == "PUNSUBSCRIBE"
+	kind := strings.ToLower(name)
+	if len(args) == 0 && session.sub != nil {
+		args = session.sub.Names(pattern)
+	}
+	if len(args) == 0 {
+		// Nothing to drop, which Redis confirms without a channel.
+		count := 0
+		if session.sub != nil {
+			count = session.sub.Count()
+		}
+		writeRESPArrayHeader(w, 3)
+		writeRESPBulk(w, kind)
+		writeRESPNil(w)
+		writeRESPInt(w, int64(count))
+		return
+	}
+	for _, channel := range args {
+		count := 0
+		if session.sub != nil {
+			count = session.sub.Remove(pattern, channel)
+		}
+		writeRESPSubscription(w, kind, channel, count)
+	}
+}
+
+func writeRESPSubscription(w *bufio.Writer, kind, channel string, count int) {
+	writeRESPArrayHeader(w, 3)
+	writeRESPBulk(w, kind)
+	writeRESPBulk(w, channel)
+	writeRESPInt(w, int64(count))
+}
+
+// push writes the subscriber's messages to the connection until its queue
+// is closed, flushing whenever it runs dry.
+func (session *respSession) push(w *bufio.Writer) {
+	messages := session.sub.messages
+	for m := range messages {
+		session.mutex.Lock()
+		if m.Pattern != "" {
+			writeRESPArrayHeader(w, 4)
+			writeRESPBulk(w, "pmessage")
+			writeRESPBulk(w, m.Pattern)
+		} else {
+			writeRESPArrayHeader(w, 3)
+			writeRESPBulk(w, "message")
+		}
+		writeRESPBulk(w, m.Channel)
+		writeRESPBulk(w, m.Message)
+		if len(messages) == 0 {
+			w.Flush()
+		}
+		session.mutex.Unlock()
+	}
+}
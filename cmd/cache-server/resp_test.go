@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadRESPCommand(t *testing.T) {
+	tests := []struct {
+		name, input string
+		want        []string
+		err         error
+	}{
+		{"array", "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", []string{"GET", "k"}, nil},
+		{"inline", "SET k v\r\n", []string{"SET", "k", "v"}, nil},
+		{"empty array", "*0\r\n", nil, nil},
+		{"null array", "*-1\r\n", nil, nil},
+		{"negative array", "*-5\r\n", nil, nil},
+		{"too many arguments", "*65537\r\n", nil, errRESPProtocol},
+		{"bad array length", "*x\r\n", nil, errRESPProtocol},
+		{"negative bulk", "*1\r\n$-1\r\n", nil, errRESPProtocol},
+		{"oversized bulk", "*1\r\n$17\r\n", nil, errRESPProtocol},
+		{"oversized command", "*2\r\n$10\r\n0123456789\r\n$7\r\n", nil, errRESPProtocol},
+		{"missing bulk header", "*1\r\nGET\r\n", nil, errRESPProtocol},
+		{"no CRLF after bulk", "*1\r\n$3\r\nGETxx", nil, errRESPProtocol},
+		{"truncated array", "*2\r\n$3\r\nGET\r\n", nil, io.EOF},
+		{"truncated bulk", "*1\r\n$3\r\nGE", nil, io.ErrUnexpectedEOF},
+		{"truncated header", "*1", nil, io.EOF},
+	}
+	for _, tt := range tests {
+		r := bufio.NewReader(strings.NewReader(tt.input))
+		got, err := readRESPCommand(r, 16)
+		if !errors.Is(err, tt.err) || !slices.Equal(got, tt.want) {
+			t.Errorf("%s: readRESPCommand(%q) = %q, %v; want %q, %v", tt.name, tt.input, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestRESPDuration(t *testing.T) {
+	tests := []struct {
+		n     int64
+		unit  time.Duration
+		want  time.Duration
+		valid bool
+	}{
+		{10, time.Second, 10 * time.Second, true},
+		{1500, time.Millisecond, 1500 * time.Millisecond, true},
+		{0, time.Second, 0, false},
+		{-1, time.Millisecond, 0, false},
+		{math.MaxInt64 / int64(time.Second), time.Second, time.Duration(math.MaxInt64/int64(time.Second)) * time.Second, true},
+		{math.MaxInt64/int64(time.Second) + 1, time.Second, 0, false},
+		{math.MaxInt64, time.Millisecond, 0, false},
+	}
+	for _, tt := range tests {
+		got, valid := respDuration(tt.n, tt.unit)
+		if got != tt.want || valid != tt.valid {
+			t.Errorf("respDuration(%d, %v) = %v, %v; want %v, %v", tt.n, tt.unit, got, valid, tt.want, tt.valid)
+		}
+	}
+}
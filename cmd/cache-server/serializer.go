@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// A serializer turns values into bytes and back. One, named by the
+// Serializer setting, encodes the records of snapshots, the AOF and the
+// replication stream; JSON is the default, while msgpack and gob are
+// smaller and, unlike JSON, keep values that are not valid UTF-8 intact.
+// Responses whose Accept header names no type this server supports are
+// encoded with the serializer of the key's namespace, if it has one, or
+// else with that one.
+type serializer interface {
+	Name() string
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+	// Binary reports whether the bytes may hold newlines, so that
+	// line-based files need them in base64.
+	Binary() bool
+}
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) Name() string                       { return "json" }
+func (jsonSerializer) ContentType() string                { return "application/json" }
+func (jsonSerializer) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonSerializer) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonSerializer) Binary() bool                       { return false }
+
+// msgpackSerializer names fields by their json tags, so that records
+// have the same field names in either encoding.
+type msgpackSerializer struct{}
+
+func (msgpackSerializer) Name() string        { return "msgpack" }
+func (msgpackSerializer) ContentType() string { return "application/msgpack" }
+func (msgpackSerializer) Binary() bool        { return true }
+
+func (msgpackSerializer) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetOmitEmpty(true)
+	err := enc.Encode(v)
+	return buf.Bytes(), err
+}
+
+func (msgpackSerializer) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// gobSerializer writes each value as a stream of its own, type
+// description included, so that records can be read one at a time.
+type gobSerializer struct{}
+
+func (gobSerializer) Name() string        { return "gob" }
+func (gobSerializer) ContentType() string { return "application/x-gob" }
+func (gobSerializer) Binary() bool        { return true }
+
+func (gobSerializer) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobSerializer) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+var errNoRawEncoding = errors.New("value has no raw encoding")
+
+// rawSerializer is an entry's value alone, as it was stored. It encodes
+// single entries, and decodes the bodies of writes to one key; a write
+// of a raw body keeps the default TTL. It cannot encode records.
+type rawSerializer struct{}
+
+func (rawSerializer) Name() string        { return "raw" }
+func (rawSerializer) ContentType() string { return "application/octet-stream" }
+func (rawSerializer) Binary() bool        { return true }
+
+func (rawSerializer) Marshal(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case *V1Entry:
+		return []byte(v.Value), nil
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	}
+	return nil, errNoRawEncoding
+}
+
+func (rawSerializer) Unmarshal(data []byte, v any) error {
+	switch v := v.(type) {
+	case *V1PutRequest:
+		*v = V1PutRequest{Value: string(data)}
+	case *string:
+		*v = string(data)
+	case *[]byte:
+		*v = slices.Clone(data)
+	default:
+		return errNoRawEncoding
+	}
+	return nil
+}
+
+var serializers = map[string]serializer{
+	"json":    jsonSerializer{},
+	"msgpack": msgpackSerializer{},
+	"gob":     gobSerializer{},
+	"raw":     rawSerializer{},
+}
+
+// recordSerializers are those that can encode records.
+var recordSerializers = []string{"json", "msgpack", "gob"}
+
+// serializerNamed returns the serializer called name; empty is JSON.
+func serializerNamed(name string) (serializer, error) {
+	if name == "" {
+		return jsonSerializer{}, nil
+	}
+	s, ok := serializers[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown serializer %q; want json, msgpack, gob or raw", name)
+	}
+	return s, nil
+}
+
+// serializerCodec serves a serializer as an HTTP codec, for the media
+// types no other codec handles.
+type serializerCodec struct {
+	s serializer
+}
+
+func (c serializerCodec) ContentType() string { return c.s.ContentType() }
+
+func (c serializerCodec) Encode(w io.Writer, v interface{}) error {
+	data, err := c.s.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (c serializerCodec) Decode(r io.Reader, v interface{}) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return c.s.Unmarshal(data, v)
+}
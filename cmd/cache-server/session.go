@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// sessionHeader carries a session token: writes answer with one, and a
+// read that sends one back is answered by a replica only once it has
+// applied that write.
+const sessionHeader = "X-Cache-Session"
+
+// sessionToken names a point in a primary's writes: the time it started
+// taking writes, which tells its runs apart, and how many it had made.
+type sessionToken struct {
+	run    int64
+	writes uint64
+}
+
+func (t sessionToken) String() string {
+	return strconv.FormatInt(t.run, 16) + "." + strconv.FormatUint(t.writes, 16)
+}
+
+func parseSessionToken(s string) (sessionToken, error) {
+	run, writes, ok := strings.Cut(s, ".")
+	if !ok {
+		return sessionToken{}, fmt.Errorf("invalid session token %q", s)
+	}
+	var t sessionToken
+	var err error
+	if t.run, err = strconv.ParseInt(run, 16, 64); err != nil {
+		return sessionToken{}, fmt.Errorf("invalid session token %q", s)
+	}
+	if t.writes, err = strconv.ParseUint(writes, 16, 64); err != nil {
+		return sessionToken{}, fmt.Errorf("invalid session token %q", s)
+	}
+	return t, nil
+}
+
+// covers reports whether a node at t has every write up to other. A later
+// run started from a full copy of everything before it.
+func (t sessionToken) covers(other sessionToken) bool {
+	return t.run > other.run || (t.run == other.run && t.writes >= other.writes)
+}
+
+// sessionClock counts the sets and deletes made here, in the order the
+// replication stream sends them.
+type sessionClock struct {
+	run    atomic.Int64
+	writes atomic.Uint64
+}
+
+func newSessionClock(cache *LRUCache) *sessionClock {
+	s := &sessionClock{}
+	s.run.Store(time.Now().UnixNano())
+	count := func(HookEvent) { s.writes.Add(1) }
+	// Synchronous and added first, so that the count is up to date when
+	// the replication streams' hooks read it.
+	cache.AddHooks(Hooks{OnSet: count, OnDelete: count}, HookOptions{})
+	return s
+}
+
+func (s *sessionClock) now() sessionToken {
+	return sessionToken{run: s.run.Load(), writes: s.writes.Load()}
+}
+
+// restart begins a new run, for a replica taking over from its primary.
+func (s *sessionClock) restart() {
+	s.run.Store(time.Now().UnixNano())
+}
+
+// Issue answers successful writes with a session token covering them.
+func (s *sessionClock) Issue(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&sessionWriter{ResponseWriter: w, clock: s}, r)
+	})
+}
+
+// sessionWriter adds the token as the response starts, when the write has
+// been made.
+type sessionWriter struct {
+	http.ResponseWriter
+	clock   *sessionClock
+	started bool
+}
+
+func (w *sessionWriter) WriteHeader(status int) {
+	if !w.started {
+		w.started = true
+		if status < 300 {
+			w.Header().Set(sessionHeader, w.clock.now().String())
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *sessionWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// Consistent holds reads carrying a session token until the replica has
+// applied the write it names, for up to Replication.SessionWait, and then
+// refuses them with 421 and the primary, as it does writes. A nil replica,
+// or one that has taken over, serves everything at once.
+func (r *replica) Consistent(next http.Handler) http.Handler {
+	if r == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header := req.Header.Get(sessionHeader)
+		if header == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+		token, err := parseSessionToken(header)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !r.waitFor(req.Context(), token) {
+			if requestCanceled(w, req) {
+				return
+			}
+			w.Header().Set(primaryHeader, r.config.PrimaryURL)
+			writeError(w, http.StatusMisdirectedRequest, "replica has not caught up with the session; read from "+r.config.PrimaryURL)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// waitFor reports whether the replica has applied every write up to
+// token, waiting for it to catch up if need be.
+func (r *replica) waitFor(ctx context.Context, token sessionToken) bool {
+	timer := time.NewTimer(r.config.SessionWait)
+	defer timer.Stop()
+	for {
+		r.mutex.Lock()
+		if r.promoted || r.position.covers(token) {
+			r.mutex.Unlock()
+			return true
+		}
+		if r.advanced == nil {
+			r.advanced = make(chan struct{})
+		}
+		advanced := r.advanced
+		r.mutex.Unlock()
+		select {
+		case <-advanced:
+		case <-timer.C:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// advance records that the replica has applied the primary's writes up to
+// position. The caller holds r.mutex.
+func (r *replica) advance(position sessionToken) {
+	r.position = position
+	r.wake()
+}
+
+// wake lets the reads waiting in waitFor look again. The caller holds
+// r.mutex.
+func (r *replica) wake() {
+	if r.advanced != nil {
+		close(r.advanced)
+		r.advanced = nil
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// V1SlowOpsHandler lists the latest slow operations.
+func (h *CacheHandler) V1SlowOpsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if s := r.URL.Query().Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+	}
+	report := h.lru.SlowOps(limit)
+	if report == nil {
+		writeError(w, http.StatusNotFound, "the slow operation log is off")
+		return
+	}
+	writeResponse(w, r, report)
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"iter"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// snapshotEntry is one line of a snapshot, after the header (see
+// format.go). Entries that expired while the server was down are skipped
+// on restore.
+type snapshotEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// ExpiresAt is in Unix milliseconds.
+	ExpiresAt int64  `json:"expires_at"`
+	Flags     uint32 `json:"flags,omitempty"`
+	// TTL is what remained when a version 1 snapshot was written, in
+	// milliseconds, counted from the file's modification time.
+	TTL int64 `json:"ttl_ms,omitempty"`
+}
+
+// snapshotTrailer is the last line of a snapshot from version 4: the
+// number of entries and the CRC-32C of every byte before it. A snapshot
+// without one was cut short.
+type snapshotTrailer struct {
+	End     bool   `json:"end"`
+	Entries int    `json:"entries"`
+	CRC32C  string `json:"crc32c"`
+}
+
+var (
+	snapshotTrailerPrefix = []byte(`{"end":`)
+	crc32c                = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// snapshotPrevPath is where the snapshot that path replaced is kept, to
+// fall back on if path turns out to be damaged. Falling back loses the
+// writes in between, which the AOF rewrite that followed has dropped.
+func snapshotPrevPath(path string) string {
+	if u, err := url.Parse(path); err == nil && isObjectURL(path) {
+		u.Path += ".prev"
+		return u.String()
+	}
+	return path + ".prev"
+}
+
+// writeSnapshot writes items as newline-delimited JSON entries to a
+// temporary file beside path, fsyncs it and renames it into place, so
+// readers only ever see a complete snapshot and a crash leaves either the
+// old or the new one. The old one is kept at snapshotPrevPath. path may
+// also be an object URL. It returns the number of entries written.
+func writeSnapshot(path string, items iter.Seq[Item], c *fileCipher) (int, error) {
+	if isObjectURL(path) {
+		return writeObject(path, items, c)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	n, err := encodeSnapshot(f, items, c)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = keepPrevious(path)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return 0, err
+	}
+	return n, syncDir(filepath.Dir(path))
+}
+
+// keepPrevious hard-links the snapshot at path to snapshotPrevPath, so
+// that path is never missing while it is replaced.
+func keepPrevious(path string) error {
+	prev := snapshotPrevPath(path)
+	if err := os.Remove(prev); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Link(path, prev); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// syncDir fsyncs a directory, making the renames in it durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// encodeSnapshot writes items in the snapshot format, skipping expired
+// ones, sealed with c if it is not nil.
+func encodeSnapshot(dst io.Writer, items iter.Seq[Item], c *fileCipher) (int, error) {
+	h, codec, err := c.newHeader(snapshotFormat, snapshotVersion)
+	if err != nil {
+		return 0, err
+	}
+	buffered := bufio.NewWriter(dst)
+	crc := crc32.New(crc32c)
+	w := io.MultiWriter(buffered, crc)
+	if _, err := writeFormatHeader(w, h); err != nil {
+		return 0, err
+	}
+	n := 0
+	now := time.Now()
+	for item := range items {
+		if !now.Before(item.ExpiresAt) {
+			continue
+		}
+		line, err := codec.marshal(snapshotEntry{Key: item.Key, Value: item.Value, ExpiresAt: item.ExpiresAt.UnixMilli(), Flags: item.Flags})
+		if err != nil {
+			return 0, err
+		}
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	trailer, err := json.Marshal(snapshotTrailer{End: true, Entries: n, CRC32C: fmt.Sprintf("%08x", crc.Sum32())})
+	if err != nil {
+		return 0, err
+	}
+	buffered.Write(trailer)
+	buffered.WriteByte('\n')
+	return n, buffered.Flush()
+}
+
+// openSnapshot opens the file or object at path and returns when it was
+// written, which version 1 snapshots need.
+func openSnapshot(ctx context.Context, path string) (io.ReadCloser, time.Time, error) {
+	if isObjectURL(path) {
+		return openObject(ctx, path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, time.Time{}, err
+	}
+	return f, info.ModTime(), nil
+}
+
+// restoreSnapshot loads the snapshot at path into cache and returns the
+// number of entries restored and skipped as expired. Entries are stored
+// least recently used first, so that the cache's recency order survives
+// and, if the snapshot holds more than fits, the hottest entries are kept.
+//
+// A damaged snapshot is not restored; the previous one is, if it is
+// intact, and otherwise what could be read of the damaged one, with an
+// error. A snapshot in a format this build does not read, or encrypted
+// with a key c cannot unwrap, is an error wrapping errUnsupportedFormat,
+// and nothing is restored.
+func restoreSnapshot(ctx context.Context, path string, cache *LRUCache, c *fileCipher) (restored, expired int, err error) {
+	ctx, span := tracer.Start(ctx, "snapshot.restore")
+	defer func() { endSpan(span, err) }()
+	writes, expired, err := readSnapshot(ctx, path, c)
+	if err != nil && !errors.Is(err, os.ErrNotExist) && !errors.Is(err, errUnsupportedFormat) {
+		prev := snapshotPrevPath(path)
+		prevWrites, prevExpired, prevErr := readSnapshot(ctx, prev, c)
+		if prevErr == nil {
+			persistenceLog.Warn("Snapshot is damaged; restoring the previous one", "path", path, "err", err, "previous", prev)
+			writes, expired, err = prevWrites, prevExpired, nil
+		}
+	}
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, errUnsupportedFormat) {
+		return 0, 0, err
+	}
+
+	loadWrites(ctx, cache, writes)
+	return len(writes), expired, err
+}
+
+// loadWrites stores what readSnapshot returned, in reverse so that the
+// first entry, the most recently used, ends up at the front.
+func loadWrites(ctx context.Context, cache *LRUCache, writes []Write) {
+	slices.Reverse(writes)
+	for batch := range slices.Chunk(writes, importBatchSize) {
+		cache.SetMany(ctx, batch)
+	}
+}
+
+// readSnapshot reads the snapshot at path, returning the entries that have
+// not expired and the number that have. If the snapshot is damaged, it
+// returns the entries before the damage, and an error.
+func readSnapshot(ctx context.Context, path string, c *fileCipher) (writes []Write, expired int, err error) {
+	f, modTime, err := openSnapshot(ctx, path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	first, err := r.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	h, isHeader, err := parseFormatHeader(first, snapshotFormat, snapshotVersion)
+	if err != nil {
+		return nil, 0, err
+	}
+	codec, err := c.codec(h)
+	if err != nil {
+		return nil, 0, err
+	}
+	crc := crc32.New(crc32c)
+	line := first
+	if isHeader {
+		crc.Write(first)
+		line, err = r.ReadBytes('\n')
+	}
+
+	damaged := func(err error) ([]Write, int, error) {
+		return writes, expired, fmt.Errorf("entry %d: %w", len(writes)+expired+1, err)
+	}
+	for ; len(line) > 0; line, err = r.ReadBytes('\n') {
+		if err != nil && err != io.EOF {
+			return damaged(err)
+		}
+		if h.Version >= 4 && bytes.HasPrefix(line, snapshotTrailerPrefix) {
+			var t snapshotTrailer
+			if err := json.Unmarshal(line, &t); err != nil {
+				return damaged(err)
+			}
+			if sum := fmt.Sprintf("%08x", crc.Sum32()); t.CRC32C != sum || t.Entries != len(writes)+expired {
+				return damaged(fmt.Errorf("checksum %s does not match trailer %s", sum, t.CRC32C))
+			}
+			if rest, _ := r.ReadBytes('\n'); len(rest) > 0 {
+				return damaged(errors.New("data after the trailer"))
+			}
+			return writes, expired, nil
+		}
+		crc.Write(line)
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e snapshotEntry
+		if err := codec.unmarshal(bytes.TrimRight(line, "\r\n"), &e); err != nil {
+			return damaged(err)
+		}
+		expiresAt := time.UnixMilli(e.ExpiresAt)
+		if h.Version == 1 {
+			expiresAt = modTime.Add(time.Duration(e.TTL) * time.Millisecond)
+		}
+		ttl := time.Until(expiresAt)
+		if ttl <= 0 {
+			expired++
+			continue
+		}
+		writes = append(writes, Write{Key: e.Key, Value: e.Value, TTL: ttl, Flags: e.Flags})
+	}
+	if err != nil && err != io.EOF {
+		return damaged(err)
+	}
+	if h.Version >= 4 {
+		return damaged(errors.New("snapshot ends without a trailer; it was cut short"))
+	}
+	return writes, expired, nil
+}
+
+// snapshotter writes the snapshot every interval, and whenever the AOF
+// grows past rewriteSize, until closed. Each snapshot also rewrites the
+// AOF, which from then on only needs to hold what came after it.
+type snapshotter struct {
+	path        string
+	cache       *LRUCache
+	aof         *appendOnlyLog
+	rewriteSize int64
+	cipher      *fileCipher
+	// mutex serializes snapshots, so that an older one never replaces a
+	// newer one, nor a rotation of the AOF another's.
+	mutex sync.Mutex
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// startSnapshotter writes snapshots to config.Path; aof and c may be nil.
+func startSnapshotter(cache *LRUCache, config SnapshotConfig, aof *appendOnlyLog, rewriteSize int64, c *fileCipher) *snapshotter {
+	s := &snapshotter{path: config.Path, cache: cache, aof: aof, rewriteSize: rewriteSize, cipher: c, done: make(chan struct{})}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		var periodic, check <-chan time.Time
+		if config.Interval > 0 {
+			ticker := time.NewTicker(config.Interval)
+			defer ticker.Stop()
+			periodic = ticker.C
+		}
+		if aof != nil && rewriteSize > 0 {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			check = ticker.C
+		}
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-periodic:
+				s.logSnapshot()
+			case <-check:
+				if size := aof.Size(); size >= s.rewriteSize {
+					s.logSnapshot("aof_bytes", size)
+				}
+			}
+		}
+	}()
+	return s
+}
+
+// Snapshot writes a snapshot and rewrites the AOF, returning the number of
+// entries written. If it fails, the AOF keeps every record since the last
+// snapshot that succeeded.
+func (s *snapshotter) Snapshot() (n int, err error) {
+	_, span := tracer.Start(context.Background(), "snapshot.write")
+	defer func() {
+		span.SetAttributes(attribute.Int("snapshot.entries", n))
+		endSpan(span, err)
+	}()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.aof != nil {
+		if err := s.aof.rotate(); err != nil {
+			return 0, fmt.Errorf("rotating AOF: %w", err)
+		}
+	}
+	n, err = writeSnapshot(s.path, s.cache.Scan(""), s.cipher)
+	if err != nil {
+		return 0, err
+	}
+	if s.aof != nil {
+		if err := s.aof.finishRotation(); err != nil {
+			return n, fmt.Errorf("rewriting AOF: %w", err)
+		}
+	}
+	return n, nil
+}
+
+func (s *snapshotter) logSnapshot(args ...any) {
+	start := time.Now()
+	n, err := s.Snapshot()
+	if err != nil {
+		persistenceLog.Error("Failed to write snapshot", "err", err)
+		return
+	}
+	persistenceLog.Info("Wrote snapshot", append([]any{"entries", n, "path", s.path, "took", time.Since(start).Round(time.Millisecond)}, args...)...)
+}
+
+// Close stops the background snapshots, waiting for one in progress.
+// Snapshot may still be called afterwards.
+func (s *snapshotter) Close() {
+	close(s.done)
+	s.wg.Wait()
+}
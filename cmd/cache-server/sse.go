@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const sseHeartbeatInterval = 15 * time.Second
+
+func (h *CacheHandler) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	query := r.URL.Query()
+	if channels, patterns := query["channel"], query["pattern"]; len(channels) > 0 || len(patterns) > 0 {
+		h.streamMessages(w, r, flusher, channels, patterns)
+		return
+	}
+
+	events, cancel := h.lru.Subscribe(256)
+	defer cancel()
+
+	startEventStream(w, flusher)
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.streamsDone:
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case e := <-events:
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// streamMessages streams the pub/sub messages on channels, and on the
+// channels matching patterns, as events named message, or pmessage for a
+// pattern's, with the message as JSON data.
+func (h *CacheHandler) streamMessages(w http.ResponseWriter, r *http.Request, flusher http.Flusher, channels, patterns []string) {
+	for _, pattern := range patterns {
+		if err := checkPattern(pattern); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	sub := h.pubsub.Subscribe(256)
+	defer h.pubsub.Unsubscribe(sub)
+	for _, channel := range channels {
+		sub.Add(false, channel)
+	}
+	for _, pattern := range patterns {
+		sub.Add(true, pattern)
+	}
+
+	startEventStream(w, flusher)
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.streamsDone:
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case m := <-sub.messages:
+			data, err := json.Marshal(m)
+			if err != nil {
+				continue
+			}
+			name := "message"
+			if m.Pattern != "" {
+				name = "pmessage"
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+		}
+		flusher.Flush()
+	}
+}
+
+func startEventStream(w http.ResponseWriter, flusher http.Flusher) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+}
+
+// closeStreams ends every open event stream so server shutdown isn't held
+// up by long-lived connections.
+func (h *CacheHandler) closeStreams() {
+	h.closeOnce.Do(func() { close(h.streamsDone) })
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"myproject/cache"
+)
+
+// statsdMaxPacket keeps each datagram within a typical Ethernet MTU once
+// the IP and UDP headers are added.
+const statsdMaxPacket = 1432
+
+// statsdExporter sends the cache counters and gauges to a StatsD server
+// every interval, and request and cache operation timings as they
+// happen. Metrics are batched into datagrams of up to statsdMaxPacket
+// bytes; a lost datagram loses its metrics, as usual for StatsD.
+type statsdExporter struct {
+	conn       net.Conn
+	prefix     string
+	tags       []string
+	plain      bool
+	sampleRate float64
+	cache      *LRUCache
+
+	mutex sync.Mutex
+	buf   []byte
+	// last holds the counters as of the previous interval, which are
+	// sent as the difference from them.
+	last     cache.Counters
+	removals map[string]uint64
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func startStatsD(cache *LRUCache, config StatsDConfig) (*statsdExporter, error) {
+	conn, err := net.Dial("udp", config.Addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &statsdExporter{
+		conn:       conn,
+		prefix:     config.Prefix,
+		plain:      config.Plain,
+		sampleRate: config.TimingSampleRate,
+		cache:      cache,
+		buf:        make([]byte, 0, statsdMaxPacket),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if s.prefix != "" && !strings.HasSuffix(s.prefix, ".") {
+		s.prefix += "."
+	}
+	if !s.plain {
+		for name, value := range config.Tags {
+			s.tags = append(s.tags, name+":"+value)
+		}
+		slices.Sort(s.tags)
+	}
+	// Start from the counters as they are, so that a restored cache does
+	// not report its restore as one interval's traffic.
+	s.last, _, _ = cache.Counters()
+	s.removals = cache.Removals("", 0).Reasons
+	cache.SetTimingObserver(func(op string, d time.Duration) { s.timing("operation", d, "op:"+op) })
+	go s.run(config.Interval)
+	return s, nil
+}
+
+func (s *statsdExporter) run(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			s.report()
+			return
+		case <-ticker.C:
+			s.report()
+		}
+	}
+}
+
+// report sends the counters and gauges and flushes whatever timings are
+// waiting with them.
+func (s *statsdExporter) report() {
+	counters, entries, bytes := s.cache.Counters()
+	reasons := s.cache.Removals("", 0).Reasons
+	memory := s.cache.Memory()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.count("hits", counters.Hits-s.last.Hits)
+	s.count("misses", counters.Misses-s.last.Misses)
+	s.count("evictions", counters.Evictions-s.last.Evictions)
+	s.count("expirations", counters.Expirations-s.last.Expirations)
+	s.last = counters
+	for reason, n := range reasons {
+		s.count("removals", n-s.removals[reason], "reason:"+reason)
+	}
+	s.removals = reasons
+	s.add("entries", strconv.Itoa(entries), "g", 1)
+	s.add("bytes", strconv.FormatInt(bytes, 10), "g", 1)
+	s.add("memory", strconv.FormatInt(memory.Total, 10), "g", 1)
+	s.flush()
+}
+
+func (s *statsdExporter) count(name string, n uint64, tags ...string) {
+	if n > 0 {
+		s.add(name, strconv.FormatUint(n, 10), "c", 1, tags...)
+	}
+}
+
+// timing records that something took d, sampled at the configured rate.
+func (s *statsdExporter) timing(name string, d time.Duration, tags ...string) {
+	if s.sampleRate < 1 && rand.Float64() >= s.sampleRate {
+		return
+	}
+	ms := strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.add(name, ms, "ms", s.sampleRate, tags...)
+}
+
+// add appends one metric to the buffer, sending the buffer first if the
+// metric would not fit. tags are "name:value" pairs; without tag
+// support, their values are appended to the metric name instead.
+func (s *statsdExporter) add(name, value, kind string, rate float64, tags ...string) {
+	var line []byte
+	line = append(line, s.prefix...)
+	line = append(line, name...)
+	if s.plain {
+		for _, tag := range tags {
+			_, v, _ := strings.Cut(tag, ":")
+			line = append(line, '.')
+			line = append(line, statsdName.Replace(v)...)
+		}
+	}
+	line = append(line, ':')
+	line = append(line, value...)
+	line = append(line, '|')
+	line = append(line, kind...)
+	if rate < 1 {
+		line = append(line, "|@"...)
+		line = strconv.AppendFloat(line, rate, 'g', -1, 64)
+	}
+	if !s.plain && len(s.tags)+len(tags) > 0 {
+		line = append(line, "|#"...)
+		for i, tag := range append(slices.Clip(s.tags), tags...) {
+			if i > 0 {
+				line = append(line, ',')
+			}
+			line = append(line, statsdTag.Replace(tag)...)
+		}
+	}
+	if len(s.buf) > 0 && len(s.buf)+1+len(line) > statsdMaxPacket {
+		s.flush()
+	}
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, line...)
+}
+
+// statsdTag and statsdName replace what the line format reserves, and
+// spaces, as in route patterns, with underscores; statsdName also
+// replaces what would split a name into further segments.
+var (
+	statsdTag  = strings.NewReplacer(" ", "_", ",", "_", "|", "_", "#", "_", "\n", "_")
+	statsdName = strings.NewReplacer(" ", "_", ",", "_", "|", "_", "#", "_", "\n", "_", ":", "_", "@", "_", ".", "_", "/", "_", "{", "", "}", "")
+)
+
+func (s *statsdExporter) flush() {
+	if len(s.buf) == 0 {
+		return
+	}
+	if _, err := s.conn.Write(s.buf); err != nil {
+		serverLog.Debug("Failed to send StatsD metrics", "err", err)
+	}
+	s.buf = s.buf[:0]
+}
+
+// Instrument times requests to route, as metrics.Instrument does.
+func (s *statsdExporter) Instrument(route string, next http.Handler) http.Handler {
+	if s == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		s.timing("http.request", time.Since(start), "route:"+route, "code:"+strconv.Itoa(rec.status))
+	})
+}
+
+// Close stops the exporter after a last report.
+func (s *statsdExporter) Close() error {
+	s.cache.SetTimingObserver(nil)
+	close(s.stop)
+	<-s.done
+	return s.conn.Close()
+}
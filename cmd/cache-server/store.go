@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Store is a durability backend that mirrors the cache: every set is a
+// Put, every delete, eviction and expiration a Delete, and the cache is
+// loaded from Iterate on startup. Implementations are in store_*.go.
+type Store interface {
+	Put(item Item) error
+	Get(key string) (Item, bool, error)
+	Delete(key string) error
+	// Iterate calls fn with every entry that has not expired, stopping
+	// early if fn returns false.
+	Iterate(fn func(Item) bool) error
+	// Snapshot atomically replaces the store's contents with items.
+	Snapshot(items iter.Seq[Item]) error
+	Close() error
+}
+
+// storeSyncer is implemented by stores that buffer writes; Sync is called
+// after each batch.
+type storeSyncer interface {
+	Sync() error
+}
+
+const (
+	storeFile   = "file"
+	storeBolt   = "bolt"
+	storeSQLite = "sqlite"
+)
+
+func openStore(config StoreConfig) (Store, error) {
+	switch config.Backend {
+	case storeFile:
+		return openFileStore(config.Path)
+	case storeBolt:
+		return openBoltStore(config.Path)
+	case storeSQLite:
+		return openSQLiteStore(config.Path)
+	}
+	return nil, fmt.Errorf("unknown store backend %q", config.Backend)
+}
+
+// loadStore stores every entry of store in cache and returns the count.
+func loadStore(ctx context.Context, store Store, cache *LRUCache) (n int, err error) {
+	ctx, span := tracer.Start(ctx, "store.load")
+	defer func() { endSpan(span, err) }()
+	batch := make([]Write, 0, importBatchSize)
+	err = store.Iterate(func(item Item) bool {
+		ttl := time.Until(item.ExpiresAt)
+		if ttl <= 0 {
+			return true
+		}
+		batch = append(batch, Write{Key: item.Key, Value: item.Value, TTL: ttl, Flags: item.Flags})
+		if len(batch) == importBatchSize {
+			cache.SetMany(ctx, batch)
+			n += len(batch)
+			batch = batch[:0]
+		}
+		return true
+	})
+	cache.SetMany(ctx, batch)
+	return n + len(batch), err
+}
+
+type storeOp struct {
+	key  string
+	item *Item // nil for a delete
+}
+
+// storeWriter is the MutationSink that feeds a Store. Mutations are
+// queued and applied in order by one goroutine, which also compacts the
+// store from the cache every interval. When the queue is full mutations
+// are dropped rather than stalling the cache, and the next compaction,
+// brought forward, puts the store right.
+type storeWriter struct {
+	store    Store
+	cache    *LRUCache
+	queue    chan storeOp
+	dropped  atomic.Uint64
+	interval time.Duration
+	done     sync.WaitGroup
+}
+
+func startStoreWriter(store Store, cache *LRUCache, interval time.Duration) *storeWriter {
+	s := &storeWriter{store: store, cache: cache, queue: make(chan storeOp, 10000), interval: interval}
+	s.done.Add(1)
+	go s.run()
+	return s
+}
+
+func (s *storeWriter) Record(e CacheEvent, item Item) {
+	op := storeOp{key: e.Key}
+	switch e.Type {
+	case eventSet:
+		op.item = &item
+	case eventDelete, eventEvict, eventExpire:
+	default:
+		return
+	}
+	select {
+	case s.queue <- op:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+func (s *storeWriter) run() {
+	defer s.done.Done()
+	var compact <-chan time.Time
+	if s.interval > 0 {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		compact = ticker.C
+	}
+	check := time.NewTicker(time.Second)
+	defer check.Stop()
+
+	var failed bool
+	for {
+		select {
+		case op, ok := <-s.queue:
+			if !ok {
+				return
+			}
+			err := s.apply(op)
+			// Apply whatever else is queued before syncing once.
+			for drained := false; err == nil && !drained; {
+				select {
+				case op, ok := <-s.queue:
+					if !ok {
+						s.sync()
+						return
+					}
+					err = s.apply(op)
+				default:
+					drained = true
+				}
+			}
+			if err == nil {
+				err = s.sync()
+			}
+			switch {
+			case err != nil && !failed:
+				persistenceLog.Error("Failed to write to store", "err", err)
+				failed = true
+			case err == nil && failed:
+				persistenceLog.Info("Store writes recovered")
+				failed = false
+			}
+		case <-compact:
+			s.compact()
+		case <-check.C:
+			if n := s.dropped.Swap(0); n > 0 {
+				persistenceLog.Warn("Store writer dropped mutations; queue full, compacting", "dropped", n)
+				s.compact()
+			}
+		}
+	}
+}
+
+func (s *storeWriter) apply(op storeOp) error {
+	if op.item == nil {
+		return s.store.Delete(op.key)
+	}
+	return s.store.Put(*op.item)
+}
+
+func (s *storeWriter) sync() error {
+	if syncer, ok := s.store.(storeSyncer); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
+
+// compact rewrites the store from the cache. Mutations still queued are
+// applied afterwards, which is safe: any that are older than the scan are
+// followed in the queue by the later changes to their keys, or, if those
+// were dropped, by another compaction.
+func (s *storeWriter) compact() {
+	_, span := tracer.Start(context.Background(), "store.compact")
+	start := time.Now()
+	err := s.store.Snapshot(s.cache.Scan(""))
+	endSpan(span, err)
+	if err != nil {
+		persistenceLog.Error("Failed to compact store", "err", err)
+		return
+	}
+	persistenceLog.Info("Compacted store", "took", time.Since(start).Round(time.Millisecond))
+}
+
+// Close applies what is queued and closes the store. The cache must no
+// longer be calling Record.
+func (s *storeWriter) Close() error {
+	close(s.queue)
+	s.done.Wait()
+	return s.store.Close()
+}
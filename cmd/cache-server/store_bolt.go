@@ -0,0 +1,101 @@
+package main
+
+import (
+	"iter"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltStore keeps entries in one Bolt bucket, encoded as in the overflow
+// tier. Writes skip fsync; Sync makes a batch durable.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second, NoSync: true})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(overflowBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Put(item Item) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(overflowBucket).Put([]byte(item.Key), encodeOverflowItem(&item))
+	})
+}
+
+func (s *boltStore) Get(key string) (Item, bool, error) {
+	var item Item
+	var ok bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(overflowBucket).Get([]byte(key)); v != nil {
+			item, ok = decodeOverflowItem(key, v)
+		}
+		return nil
+	})
+	if ok && !time.Now().Before(item.ExpiresAt) {
+		return Item{}, false, err
+	}
+	return item, ok, err
+}
+
+func (s *boltStore) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(overflowBucket).Delete([]byte(key))
+	})
+}
+
+func (s *boltStore) Iterate(fn func(Item) bool) error {
+	now := time.Now()
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(overflowBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			item, ok := decodeOverflowItem(string(k), v)
+			if ok && now.Before(item.ExpiresAt) && !fn(item) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Snapshot(items iter.Seq[Item]) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(overflowBucket); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucket(overflowBucket)
+		if err != nil {
+			return err
+		}
+		for item := range items {
+			if err := b.Put([]byte(item.Key), encodeOverflowItem(&item)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.db.Sync()
+}
+
+func (s *boltStore) Sync() error {
+	return s.db.Sync()
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
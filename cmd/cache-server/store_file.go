@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// fileStore is a log of aofRecord lines: Put and Delete append, and
+// Snapshot rewrites the file with one set per entry. Reading folds the
+// whole log, so Get is linear; the cache only reads it on startup.
+type fileStore struct {
+	mutex sync.Mutex
+	path  string
+	f     *os.File
+	w     *bufio.Writer
+}
+
+func openFileStore(path string) (*fileStore, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileStore{path: path, f: f, w: bufio.NewWriterSize(f, 64<<10)}, nil
+}
+
+func (s *fileStore) append(record aofRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.w.Write(line)
+	return s.w.WriteByte('\n')
+}
+
+func (s *fileStore) Put(item Item) error {
+	return s.append(aofRecord{Op: aofSet, Key: item.Key, Value: item.Value, ExpiresAt: item.ExpiresAt.UnixMilli(), Flags: item.Flags})
+}
+
+func (s *fileStore) Delete(key string) error {
+	return s.append(aofRecord{Op: aofDelete, Key: key})
+}
+
+func (s *fileStore) Sync() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+// fold reads the log into the entries it leaves. A torn last line is
+// ignored.
+func (s *fileStore) fold() (map[string]Item, error) {
+	if err := s.Sync(); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make(map[string]Item)
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var record aofRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, err
+		}
+		switch record.Op {
+		case aofSet:
+			entries[record.Key] = Item{Key: record.Key, Value: record.Value, ExpiresAt: time.UnixMilli(record.ExpiresAt), Flags: record.Flags}
+		case aofDelete:
+			delete(entries, record.Key)
+		}
+	}
+}
+
+func (s *fileStore) Get(key string) (Item, bool, error) {
+	entries, err := s.fold()
+	if err != nil {
+		return Item{}, false, err
+	}
+	item, ok := entries[key]
+	if ok && !time.Now().Before(item.ExpiresAt) {
+		return Item{}, false, nil
+	}
+	return item, ok, nil
+}
+
+func (s *fileStore) Iterate(fn func(Item) bool) error {
+	entries, err := s.fold()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, item := range entries {
+		if now.Before(item.ExpiresAt) && !fn(item) {
+			break
+		}
+	}
+	return nil
+}
+
+// Snapshot writes the new log beside the old one and renames it into
+// place.
+func (s *fileStore) Snapshot(items iter.Seq[Item]) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for item := range items {
+		if err = enc.Encode(aofRecord{Op: aofSet, Key: item.Key, Value: item.Value, ExpiresAt: item.ExpiresAt.UnixMilli(), Flags: item.Flags}); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	// Put and Delete come from the same goroutine as Snapshot, so the old
+	// file has nothing unsynced left to lose.
+	s.f.Close()
+	s.f = tmp
+	s.w.Reset(tmp)
+	return nil
+}
+
+func (s *fileStore) Close() error {
+	err := s.Sync()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"database/sql"
+	"iter"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteStore keeps entries in one table. WAL mode with synchronous=NORMAL
+// makes each write durable once the WAL is checkpointed, and never
+// corrupts the database.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS entries (
+	key        TEXT PRIMARY KEY,
+	value      BLOB NOT NULL,
+	stored_at  INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL,
+	version    INTEGER NOT NULL,
+	flags      INTEGER NOT NULL
+)`
+
+const sqlitePut = `
+INSERT INTO entries (key, value, stored_at, expires_at, version, flags)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (key) DO UPDATE SET
+	value = excluded.value, stored_at = excluded.stored_at,
+	expires_at = excluded.expires_at, version = excluded.version,
+	flags = excluded.flags`
+
+func openSQLiteStore(path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	// One connection: writes are serialized by SQLite anyway, and the
+	// store writer is their only source.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+func sqliteArgs(item Item) []any {
+	return []any{item.Key, []byte(item.Value), item.StoredAt.UnixNano(), item.ExpiresAt.UnixNano(), int64(item.Version), int64(item.Flags)}
+}
+
+func (s *sqliteStore) Put(item Item) error {
+	_, err := s.db.Exec(sqlitePut, sqliteArgs(item)...)
+	return err
+}
+
+func (s *sqliteStore) Get(key string) (Item, bool, error) {
+	row := s.db.QueryRow(`SELECT key, value, stored_at, expires_at, version, flags FROM entries WHERE key = ? AND expires_at > ?`, key, time.Now().UnixNano())
+	item, err := scanSQLiteItem(row)
+	if err == sql.ErrNoRows {
+		return Item{}, false, nil
+	}
+	return item, err == nil, err
+}
+
+func (s *sqliteStore) Delete(key string) error {
+	_, err := s.db.Exec(`DELETE FROM entries WHERE key = ?`, key)
+	return err
+}
+
+func (s *sqliteStore) Iterate(fn func(Item) bool) error {
+	rows, err := s.db.Query(`SELECT key, value, stored_at, expires_at, version, flags FROM entries WHERE expires_at > ?`, time.Now().UnixNano())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		item, err := scanSQLiteItem(rows)
+		if err != nil {
+			return err
+		}
+		if !fn(item) {
+			break
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqliteStore) Snapshot(items iter.Seq[Item]) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM entries`); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(sqlitePut)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for item := range items {
+		if _, err := stmt.Exec(sqliteArgs(item)...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+func scanSQLiteItem(row interface{ Scan(...any) error }) (Item, error) {
+	var item Item
+	var value []byte
+	var storedAt, expiresAt, version, flags int64
+	if err := row.Scan(&item.Key, &value, &storedAt, &expiresAt, &version, &flags); err != nil {
+		return Item{}, err
+	}
+	item.Value = string(value)
+	item.StoredAt, item.ExpiresAt = time.Unix(0, storedAt), time.Unix(0, expiresAt)
+	item.Version, item.Flags = uint64(version), uint32(flags)
+	return item, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// V1TuneCacheHandler changes the cache's settings on this node. Every
+// setting is checked before any changes, so a bad one changes nothing.
+func (h *CacheHandler) V1TuneCacheHandler(w http.ResponseWriter, r *http.Request) {
+	var req CacheTuning
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	if req.DefaultTTL != nil && *req.DefaultTTL <= 0 {
+		writeError(w, http.StatusBadRequest, "default_ttl must be positive")
+		return
+	}
+	if req.SweepIntervalMs != nil && *req.SweepIntervalMs <= 0 {
+		writeError(w, http.StatusBadRequest, "sweep_interval_ms must be positive")
+		return
+	}
+	quotas := make(map[string]Quota, len(req.Namespaces))
+	for name, p := range req.Namespaces {
+		quota, err := namespaceQuota(p)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("namespaces.%s: %v", name, err))
+			return
+		}
+		quotas[name] = quota
+	}
+	if req.HighWatermark != nil || req.LowWatermark != nil {
+		current := h.lru.Settings()
+		high, low := current.HighWatermark, current.LowWatermark
+		if req.HighWatermark != nil {
+			high = *req.HighWatermark
+		}
+		if req.LowWatermark != nil {
+			low = *req.LowWatermark
+		}
+		// The last check, so that failing it changes nothing either.
+		if err := h.lru.SetWatermarks(high, low); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if req.DefaultTTL != nil {
+		h.lru.SetDefaultTTL(time.Duration(*req.DefaultTTL) * time.Second)
+	}
+	if req.SweepIntervalMs != nil {
+		h.lru.SetSweepInterval(time.Duration(*req.SweepIntervalMs) * time.Millisecond)
+	}
+	for name, quota := range quotas {
+		h.lru.SetQuota(name, quota)
+	}
+	settings := h.lru.Settings()
+	serverLog.Info("Tuned the cache", "default_ttl", settings.DefaultTTL, "sweep_interval_ms", settings.SweepIntervalMs,
+		"high_watermark", settings.HighWatermark, "low_watermark", settings.LowWatermark, "namespaces", len(quotas))
+	writeResponse(w, r, &settings)
+}
+
+func namespaceQuota(p NamespaceProfile) (Quota, error) {
+	if p.MaxEntries < 0 || p.MaxBytes < 0 || p.TTL < 0 || p.CompressAbove < 0 || p.ReservedBytes < 0 {
+		return Quota{}, errors.New("quotas, ttl, compress_above and reserved_bytes must not be negative")
+	}
+	if p.MaxBytes > 0 && p.ReservedBytes > p.MaxBytes {
+		return Quota{}, errors.New("reserved_bytes must not exceed max_bytes")
+	}
+	eviction, err := parseEvictionPolicy(p.Eviction)
+	if err != nil {
+		return Quota{}, err
+	}
+	compression, err := parseCompression(p.Compression)
+	if err != nil {
+		return Quota{}, err
+	}
+	return Quota{MaxEntries: p.MaxEntries, MaxBytes: p.MaxBytes, TTL: time.Duration(p.TTL) * time.Second, Eviction: eviction,
+		Compression: compression, CompressAbove: p.CompressAbove, ReservedBytes: p.ReservedBytes}, nil
+}
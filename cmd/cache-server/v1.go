@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (h *CacheHandler) V1GetHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	// A raw value is copied out of the cache rather than read whole, which
+	// for a value held in chunks would join them.
+	raw := h.lru != nil && responseCodec(r).ContentType() == rawSerializer{}.ContentType()
+	var item Item
+	var value io.Reader
+	var hit bool
+	var err error
+	if raw {
+		item, value, hit, err = h.lru.OpenOrLoad(r.Context(), key)
+	} else {
+		item, hit, err = h.get(r.Context(), key)
+	}
+	recordLookup(r, key, hit)
+	if err != nil {
+		writeLoadError(w, r, err)
+		return
+	}
+	if !hit {
+		writeError(w, http.StatusNotFound, "key not found")
+		return
+	}
+	h.setFreshness(w, item)
+	if notModified(r, item) {
+		w.Header().Add("Vary", "Accept")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if raw {
+		w.Header().Set("Content-Type", rawSerializer{}.ContentType())
+		w.Header().Add("Vary", "Accept")
+		if _, err := io.Copy(w, value); err != nil {
+			httpLog.Debug("Failed to write value", "key", key, "err", err)
+		}
+		return
+	}
+	writeResponse(w, r, v1Entry(item))
+}
+
+// V1HeadHandler answers existence and freshness probes. It uses Peek, so
+// probing neither counts as a read nor refreshes the entry's LRU position.
+func (h *CacheHandler) V1HeadHandler(w http.ResponseWriter, r *http.Request) {
+	item, ok := h.lru.Peek(r.PathValue("key"))
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	c := responseCodec(r)
+	var body bytes.Buffer
+	if err := c.Encode(&body, v1Entry(item)); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", c.ContentType())
+	w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	h.setFreshness(w, item)
+	w.Header().Set("X-Cache-TTL-Remaining", strconv.FormatInt(remainingSeconds(item.ExpiresAt), 10))
+	w.Header().Set("X-Cache-Version", strconv.FormatUint(item.Version, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *CacheHandler) V1PutHandler(w http.ResponseWriter, r *http.Request) {
+	var data V1PutRequest
+	if !h.decodeRequest(w, r, &data) {
+		return
+	}
+	if data.TTL < 0 {
+		writeError(w, http.StatusBadRequest, "ttl must not be negative")
+		return
+	}
+	value, err := limitValue(data.Value)
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	data.Value = value
+
+	key := r.PathValue("key")
+	ttl := time.Duration(data.TTL) * time.Second
+	var item Item
+	stored := true
+	if h.consensus != nil {
+		var err error
+		item, stored, err = h.consensus.Set(r.Context(), key, data.Value, ttl, r.Header.Get("If-Match"), r.Header.Get("If-None-Match"))
+		if err != nil {
+			writeRaftError(w, err)
+			return
+		}
+	} else if cond, ok := writePrecondition(r); ok {
+		if h.lru == nil {
+			writeError(w, http.StatusNotImplemented, "conditional writes need the lru engine")
+			return
+		}
+		item, stored = h.lru.SetIf(r.Context(), key, data.Value, ttl, cond)
+	} else {
+		item = h.cache.Set(r.Context(), key, data.Value, ttl)
+	}
+	if !stored {
+		writeError(w, http.StatusPreconditionFailed, "precondition failed")
+		return
+	}
+	recordWrite(r, key)
+
+	// If-None-Match on a write is a precondition, which the write has
+	// passed, not revalidation.
+	h.setFreshness(w, item)
+	writeResponse(w, r, v1Entry(item))
+}
+
+func (h *CacheHandler) V1DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	found := false
+	if h.consensus != nil {
+		var err error
+		if found, err = h.consensus.Delete(r.Context(), key); err != nil {
+			writeRaftError(w, err)
+			return
+		}
+	} else {
+		found = h.cache.Delete(r.Context(), key)
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "key not found")
+		return
+	}
+	recordWrite(r, key)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *CacheHandler) V1MGetHandler(w http.ResponseWriter, r *http.Request) {
+	keys := r.URL.Query()["key"]
+	resp := &V1EntryList{Entries: make([]V1Entry, 0, len(keys))}
+	for _, key := range keys {
+		item, hit, err := h.get(r.Context(), key)
+		recordLookup(r, key, hit)
+		if err != nil {
+			writeLoadError(w, r, err)
+			return
+		}
+		if hit {
+			resp.Entries = append(resp.Entries, *v1Entry(item))
+		}
+	}
+
+	writeResponse(w, r, resp)
+}
+
+func (h *CacheHandler) V1MSetHandler(w http.ResponseWriter, r *http.Request) {
+	var data V1EntryList
+	if !h.decodeRequest(w, r, &data) {
+		return
+	}
+	for i, e := range data.Entries {
+		if e.Key == "" {
+			writeError(w, http.StatusBadRequest, "key must not be empty")
+			return
+		}
+		if e.TTL < 0 {
+			writeError(w, http.StatusBadRequest, "ttl must not be negative")
+			return
+		}
+		value, err := limitValue(e.Value)
+		if err != nil {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("%s: %v", e.Key, err))
+			return
+		}
+		data.Entries[i].Value = value
+	}
+
+	resp := &V1EntryList{Entries: make([]V1Entry, 0, len(data.Entries))}
+	for _, e := range data.Entries {
+		if requestCanceled(w, r) {
+			return
+		}
+		item := h.cache.Set(r.Context(), e.Key, e.Value, time.Duration(e.TTL)*time.Second)
+		recordWrite(r, e.Key)
+		resp.Entries = append(resp.Entries, *v1Entry(item))
+	}
+
+	writeResponse(w, r, resp)
+}
+
+func (h *CacheHandler) V1FlushHandler(w http.ResponseWriter, r *http.Request) {
+	h.lru.Flush(r.Context())
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *CacheHandler) V1ResizeHandler(w http.ResponseWriter, r *http.Request) {
+	var req ResizeRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Capacity <= 0 {
+		writeError(w, http.StatusBadRequest, "capacity must be positive")
+		return
+	}
+	evicted := h.lru.Resize(r.Context(), req.Capacity)
+	writeResponse(w, r, &ResizeResponse{Capacity: req.Capacity, Evicted: evicted})
+}
+
+func v1Entry(item Item) *V1Entry {
+	return &V1Entry{
+		Key:       item.Key,
+		Value:     item.Value,
+		TTL:       remainingSeconds(item.ExpiresAt),
+		ExpiresAt: item.ExpiresAt.UTC(),
+		Version:   item.Version,
+	}
+}
+
+// remainingSeconds rounds up so an entry that still exists never reports a
+// TTL of zero.
+func remainingSeconds(t time.Time) int64 {
+	return int64(math.Ceil(time.Until(t).Seconds()))
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Error{Error: msg})
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"myproject/cache"
+)
+
+// newTestServer serves the API routes over a cache made with opts,
+// without the middleware main wraps them in.
+func newTestServer(t *testing.T, opts ...cache.Option) (*httptest.Server, *LRUCache) {
+	t.Helper()
+	c, err := cache.NewCache(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	h := &CacheHandler{cache: c, lru: c, maxBodyBytes: 1 << 20, hotKeys: 10, streamsDone: make(chan struct{})}
+	mux := http.NewServeMux()
+	registerAPIRoutes(h, func(route apiRoute, h http.Handler) {
+		mux.Handle(route.Pattern(), h)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, c
+}
+
+// do sends a request with body, if any, and the headers given as
+// name, value pairs, and returns the response with its body read.
+func do(t *testing.T, method, url, body string, headers ...string) (*http.Response, string) {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+func decodeEntry(t *testing.T, body string) V1Entry {
+	t.Helper()
+	var e V1Entry
+	if err := json.Unmarshal([]byte(body), &e); err != nil {
+		t.Fatalf("decoding %q: %v", body, err)
+	}
+	return e
+}
+
+func TestV1PutGetDelete(t *testing.T) {
+	srv, _ := newTestServer(t)
+	url := srv.URL + "/v1/keys/greeting"
+
+	resp, body := do(t, "PUT", url, `{"value":"hello","ttl":60}`, "Content-Type", "application/json")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT = %d %s", resp.StatusCode, body)
+	}
+	put := decodeEntry(t, body)
+	if put.Key != "greeting" || put.Value != "hello" || put.TTL != 60 || put.Version == 0 {
+		t.Errorf("PUT returned %+v", put)
+	}
+
+	resp, body = do(t, "GET", url, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET = %d %s", resp.StatusCode, body)
+	}
+	if got := decodeEntry(t, body); got.Value != "hello" || got.Version != put.Version {
+		t.Errorf("GET returned %+v", got)
+	}
+	if got, want := resp.Header.Get("ETag"), etag(put.Version); got != want {
+		t.Errorf("ETag = %s, want %s", got, want)
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "max-age=60" {
+		t.Errorf("Cache-Control = %q", got)
+	}
+
+	if resp, _ := do(t, "DELETE", url, ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE = %d", resp.StatusCode)
+	}
+	if resp, _ := do(t, "GET", url, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET after DELETE = %d", resp.StatusCode)
+	}
+	if resp, _ := do(t, "DELETE", url, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("second DELETE = %d", resp.StatusCode)
+	}
+}
+
+func TestV1PutRejectsBadRequests(t *testing.T) {
+	srv, _ := newTestServer(t)
+	url := srv.URL + "/v1/keys/k"
+	for _, tt := range []struct {
+		name, body string
+		want       int
+	}{
+		{"negative ttl", `{"value":"v","ttl":-1}`, http.StatusBadRequest},
+		{"malformed", `{"value":`, http.StatusBadRequest},
+		{"too large", `{"value":"` + strings.Repeat("x", 1<<20) + `"}`, http.StatusRequestEntityTooLarge},
+	} {
+		if resp, body := do(t, "PUT", url, tt.body, "Content-Type", "application/json"); resp.StatusCode != tt.want {
+			t.Errorf("%s: PUT = %d %s, want %d", tt.name, resp.StatusCode, body, tt.want)
+		}
+	}
+}
+
+func TestV1ConditionalRequests(t *testing.T) {
+	srv, _ := newTestServer(t)
+	url := srv.URL + "/v1/keys/k"
+	put := func(value string, headers ...string) (int, V1Entry) {
+		t.Helper()
+		resp, body := do(t, "PUT", url, `{"value":"`+value+`"}`, append(headers, "Content-Type", "application/json")...)
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, V1Entry{}
+		}
+		return resp.StatusCode, decodeEntry(t, body)
+	}
+
+	status, first := put("1", "If-None-Match", "*")
+	if status != http.StatusOK {
+		t.Fatalf("create-only PUT of a new key = %d", status)
+	}
+	if status, _ := put("2", "If-None-Match", "*"); status != http.StatusPreconditionFailed {
+		t.Errorf("create-only PUT of an existing key = %d", status)
+	}
+	if status, _ := put("2", "If-Match", etag(first.Version+1)); status != http.StatusPreconditionFailed {
+		t.Errorf("PUT against a stale ETag = %d", status)
+	}
+	status, second := put("2", "If-Match", etag(first.Version))
+	if status != http.StatusOK || second.Value != "2" {
+		t.Errorf("PUT against the current ETag = %d %+v", status, second)
+	}
+
+	resp, _ := do(t, "GET", url, "", "If-None-Match", etag(second.Version))
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("revalidating GET = %d", resp.StatusCode)
+	}
+	resp, _ = do(t, "GET", url, "", "If-None-Match", etag(first.Version))
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET with an old ETag = %d", resp.StatusCode)
+	}
+}
+
+func TestV1HeadLeavesCountersAlone(t *testing.T) {
+	srv, c := newTestServer(t)
+	c.Set(t.Context(), "k", "v", 0)
+	resp, body := do(t, "HEAD", srv.URL+"/v1/keys/k", "")
+	if resp.StatusCode != http.StatusOK || body != "" {
+		t.Fatalf("HEAD = %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("X-Cache-Version") == "" || resp.Header.Get("ETag") == "" {
+		t.Errorf("HEAD headers = %v", resp.Header)
+	}
+	if resp, _ := do(t, "HEAD", srv.URL+"/v1/keys/none", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("HEAD of a missing key = %d", resp.StatusCode)
+	}
+	if stats := c.Stats(0); stats.Hits != 0 || stats.Misses != 0 {
+		t.Errorf("HEAD counted %d hits and %d misses", stats.Hits, stats.Misses)
+	}
+}
+
+func TestV1RawValues(t *testing.T) {
+	srv, c := newTestServer(t, cache.WithChunkSize(16))
+	url := srv.URL + "/v1/keys/blob"
+	value := strings.Repeat("0123456789", 100)
+
+	resp, body := do(t, "PUT", url, value, "Content-Type", "application/octet-stream")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("raw PUT = %d %s", resp.StatusCode, body)
+	}
+	if got, _ := c.Get("blob"); got != value {
+		t.Fatalf("raw PUT stored %d bytes, want %d", len(got), len(value))
+	}
+
+	resp, body = do(t, "GET", url, "", "Accept", "application/octet-stream")
+	if resp.StatusCode != http.StatusOK || body != value {
+		t.Fatalf("raw GET = %d with %d bytes, want %d", resp.StatusCode, len(body), len(value))
+	}
+	if got := resp.Header.Get("Content-Type"); got != "application/octet-stream" {
+		t.Errorf("raw GET Content-Type = %q", got)
+	}
+	if resp.Header.Get("ETag") == "" {
+		t.Error("raw GET has no ETag")
+	}
+	if resp, _ := do(t, "GET", srv.URL+"/v1/keys/none", "", "Accept", "application/octet-stream"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("raw GET of a missing key = %d", resp.StatusCode)
+	}
+}
+
+func TestV1MultiKey(t *testing.T) {
+	srv, _ := newTestServer(t)
+	resp, body := do(t, "POST", srv.URL+"/v1/keys",
+		`{"entries":[{"key":"a","value":"1"},{"key":"b","value":"2","ttl":30}]}`,
+		"Content-Type", "application/json")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("mset = %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = do(t, "GET", srv.URL+"/v1/keys?key=a&key=missing&key=b", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("mget = %d %s", resp.StatusCode, body)
+	}
+	var list V1EntryList
+	if err := json.Unmarshal([]byte(body), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Entries) != 2 || list.Entries[0].Value != "1" || list.Entries[1].Value != "2" || list.Entries[1].TTL != 30 {
+		t.Errorf("mget returned %+v", list.Entries)
+	}
+
+	if resp, _ := do(t, "POST", srv.URL+"/v1/keys", `{"entries":[{"key":"","value":"1"}]}`, "Content-Type", "application/json"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("mset of an empty key = %d", resp.StatusCode)
+	}
+}
+
+func TestV1Stats(t *testing.T) {
+	srv, c := newTestServer(t, cache.WithCapacity(50))
+	c.Set(t.Context(), "a", "1", 0)
+	c.Get("a")
+	c.Get("missing")
+
+	resp, body := do(t, "GET", srv.URL+"/v1/stats", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("stats = %d %s", resp.StatusCode, body)
+	}
+	var stats Stats
+	if err := json.Unmarshal([]byte(body), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entries != 1 || stats.Capacity != 50 || stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestLegacyCacheAPI(t *testing.T) {
+	srv, _ := newTestServer(t)
+	resp, body := do(t, "POST", srv.URL+"/cache/set", `{"key":7,"value":42}`, "Content-Type", "application/json")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("set = %d %s", resp.StatusCode, body)
+	}
+	resp, body = do(t, "GET", srv.URL+"/cache/get?key=7", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("get = %d %s", resp.StatusCode, body)
+	}
+	var got GetResponse
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Value != 42 {
+		t.Errorf("get returned %+v", got)
+	}
+	if resp, _ := do(t, "GET", srv.URL+"/cache/get?key=seven", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("get of a non-integer key = %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// errValueTooLarge is returned for values over MaxValueBytes, so that one
+// huge payload cannot take the memory budget. HTTP answers it with 413.
+var errValueTooLarge = errors.New("value too large")
+
+// valueLimit is MaxValueBytes and OversizeValues, which every protocol's
+// writes are held to. Values that arrive from other nodes, or are
+// restored from disk, were held to it where they were first written.
+var valueLimit struct {
+	max      int
+	truncate bool
+}
+
+// limitValue returns value as it may be stored: unchanged if within the
+// limit, cut to it if oversize values are truncated, or else an error
+// wrapping errValueTooLarge. A cut never splits a UTF-8 character of a
+// value that is valid UTF-8.
+func limitValue(value string) (string, error) {
+	if valueLimit.max <= 0 || len(value) <= valueLimit.max {
+		return value, nil
+	}
+	if !valueLimit.truncate {
+		return "", fmt.Errorf("%w: %d bytes, over the limit of %d", errValueTooLarge, len(value), valueLimit.max)
+	}
+	n := valueLimit.max
+	if utf8.ValidString(value) {
+		for n > 0 && !utf8.RuneStart(value[n]) {
+			n--
+		}
+	}
+	return value[:n], nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// webhookSink POSTs the expirations and evictions of keys to the
+// webhooks whose namespace and pattern match them, as JSON arrays of
+// events. Each webhook has a queue and a sender of its own, so that a
+// slow endpoint holds up no other; like the audit log, a full queue drops
+// events, with a log line, rather than stall the cache.
+type webhookSink struct {
+	cache  *LRUCache
+	config WebhooksConfig
+	client *http.Client
+
+	mutex   sync.RWMutex
+	targets []*webhookTarget
+	nextID  int
+	// hooks is registered with the first webhook.
+	hooks *HookRegistration
+}
+
+type webhookTarget struct {
+	Webhook
+	queue   chan CacheEvent
+	dropped atomic.Uint64
+	done    sync.WaitGroup
+}
+
+func newWebhookSink(c *LRUCache, config WebhooksConfig) *webhookSink {
+	return &webhookSink{cache: c, config: config, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// newWebhook checks w and returns it as it is registered.
+func newWebhook(w Webhook) (Webhook, error) {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, fmt.Errorf("url %q is not an http or https URL", w.URL)
+	}
+	if _, err := path.Match(w.Pattern, ""); err != nil {
+		return Webhook{}, fmt.Errorf("pattern %q: %v", w.Pattern, err)
+	}
+	for _, event := range w.Events {
+		if event != eventExpire && event != eventEvict {
+			return Webhook{}, fmt.Errorf("unknown event %q; want expire or evict", event)
+		}
+	}
+	w.ID = ""
+	return w, nil
+}
+
+func (t *webhookTarget) matches(e CacheEvent) bool {
+	if len(t.Events) > 0 && !slices.Contains(t.Events, e.Type) {
+		return false
+	}
+	if t.Namespace != "" && namespaceOf(e.Key) != t.Namespace {
+		return false
+	}
+	if t.Pattern != "" {
+		ok, _ := path.Match(t.Pattern, e.Key)
+		return ok
+	}
+	return true
+}
+
+// Add registers w and starts sending it events.
+func (s *webhookSink) Add(w Webhook) (Webhook, error) {
+	w, err := newWebhook(w)
+	if err != nil {
+		return Webhook{}, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.nextID++
+	w.ID = strconv.Itoa(s.nextID)
+	t := &webhookTarget{Webhook: w, queue: make(chan CacheEvent, s.config.QueueSize)}
+	t.done.Add(1)
+	go s.run(t)
+	s.targets = append(s.targets, t)
+	if s.hooks == nil {
+		s.hooks = s.cache.AddHooks(Hooks{OnEvict: s.hook(eventEvict), OnExpire: s.hook(eventExpire)},
+			HookOptions{Async: true, Buffer: s.config.QueueSize})
+	}
+	return w, nil
+}
+
+// Remove unregisters the webhook with id, whose sender goes on to send
+// what is already queued. It reports whether there was one.
+func (s *webhookSink) Remove(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	i := slices.IndexFunc(s.targets, func(t *webhookTarget) bool { return t.ID == id })
+	if i < 0 {
+		return false
+	}
+	close(s.targets[i].queue)
+	s.targets = slices.Delete(s.targets, i, i+1)
+	return true
+}
+
+// List returns the webhooks in the order they were registered.
+func (s *webhookSink) List() []Webhook {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	list := make([]Webhook, len(s.targets))
+	for i, t := range s.targets {
+		list[i] = t.Webhook
+	}
+	return list
+}
+
+func (s *webhookSink) hook(eventType string) func(HookEvent) {
+	return func(e HookEvent) {
+		event := CacheEvent{Type: eventType, Key: e.Key, Reason: e.Reason, RequestID: e.RequestID, Time: e.Time}
+		s.mutex.RLock()
+		defer s.mutex.RUnlock()
+		for _, t := range s.targets {
+			if !t.matches(event) {
+				continue
+			}
+			select {
+			case t.queue <- event:
+			default:
+				t.dropped.Add(1)
+			}
+		}
+	}
+}
+
+func (s *webhookSink) run(t *webhookTarget) {
+	defer t.done.Done()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	batchSize := max(s.config.BatchSize, 1)
+	for {
+		select {
+		case event, ok := <-t.queue:
+			if !ok {
+				return
+			}
+			s.send(t, drainBatch(t.queue, event, batchSize))
+		case <-ticker.C:
+			if n := t.dropped.Swap(0); n > 0 {
+				eventsLog.Warn("Webhook dropped events in the last minute; queue full", "webhook", t.ID, "dropped", n)
+			}
+		}
+	}
+}
+
+func (s *webhookSink) send(t *webhookTarget, batch []CacheEvent) {
+	if err := postJSON(s.client, t.URL, batch); err != nil {
+		eventsLog.Error("Failed to send events to webhook", "webhook", t.ID, "url", t.URL, "events", len(batch), "err", err)
+	}
+}
+
+// Close stops the events and sends what is queued.
+func (s *webhookSink) Close() {
+	s.mutex.Lock()
+	hooks := s.hooks
+	s.mutex.Unlock()
+	// Removing the hooks waits for their calls, which take the lock.
+	if hooks != nil {
+		hooks.Remove()
+	}
+	s.mutex.Lock()
+	targets := s.targets
+	s.targets = nil
+	for _, t := range targets {
+		close(t.queue)
+	}
+	s.mutex.Unlock()
+	for _, t := range targets {
+		t.done.Wait()
+	}
+}
+
+// postJSON POSTs v as JSON to endpoint, trying three times before giving up.
+func postJSON(client *http.Client, endpoint string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		err = postOnce(client, endpoint, body)
+		if err == nil || attempt == 2 {
+			return err
+		}
+		time.Sleep(time.Duration(attempt+1) * time.Second)
+	}
+}
+
+func postOnce(client *http.Client, endpoint string, body []byte) error {
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// V1ListWebhooksHandler lists the webhooks registered on this node.
+func (h *CacheHandler) V1ListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, &WebhookList{Webhooks: h.webhooks.List()})
+}
+
+// V1AddWebhookHandler registers a webhook until restart.
+func (h *CacheHandler) V1AddWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var req Webhook
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	hook, err := h.webhooks.Add(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	eventsLog.Info("Registered webhook", "webhook", hook.ID, "url", hook.URL)
+	writeResponse(w, r, &hook)
+}
+
+// V1DeleteWebhookHandler removes a webhook.
+func (h *CacheHandler) V1DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !h.webhooks.Remove(id) {
+		writeError(w, http.StatusNotFound, "no webhook has this ID")
+		return
+	}
+	eventsLog.Info("Removed webhook", "webhook", id)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsPingInterval = 30 * time.Second
+	wsPongWait     = 60 * time.Second
+	wsWriteWait    = 10 * time.Second
+)
+
+var wsUpgrader = websocket.Upgrader{
+	// CORS already allows any origin; credentials travel in headers, not
+	// cookies, so cross-site upgrades carry no ambient authority.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// wsMessage is sent by clients to manage their subscriptions. Patterns use
+// path.Match syntax against the key, e.g. "user:*" or "4?".
+type wsMessage struct {
+	Op       string   `json:"op"`
+	Patterns []string `json:"patterns"`
+}
+
+type wsReply struct {
+	Type     string   `json:"type"`
+	Patterns []string `json:"patterns,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+type patternSet struct {
+	mutex    sync.RWMutex
+	patterns map[string]struct{}
+}
+
+func (p *patternSet) update(op string, patterns []string) ([]string, error) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, pattern := range patterns {
+		if op == "subscribe" {
+			p.patterns[pattern] = struct{}{}
+		} else {
+			delete(p.patterns, pattern)
+		}
+	}
+	current := make([]string, 0, len(p.patterns))
+	for pattern := range p.patterns {
+		current = append(current, pattern)
+	}
+	return current, nil
+}
+
+func (p *patternSet) matches(key string) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	for pattern := range p.patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *CacheHandler) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	events, cancel := h.lru.Subscribe(256)
+	defer cancel()
+
+	subscriptions := &patternSet{patterns: make(map[string]struct{})}
+	replies := make(chan wsReply, 8)
+	closed := make(chan struct{})
+
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	go func() {
+		defer close(closed)
+		for {
+			var msg wsMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			reply := wsReply{Type: msg.Op + "d"}
+			switch msg.Op {
+			case "subscribe", "unsubscribe":
+				patterns, err := subscriptions.update(msg.Op, msg.Patterns)
+				if err != nil {
+					reply = wsReply{Type: "error", Error: err.Error()}
+				} else {
+					reply.Patterns = patterns
+				}
+			default:
+				reply = wsReply{Type: "error", Error: "unknown op " + strconv.Quote(msg.Op)}
+			}
+			select {
+			case replies <- reply:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		var err error
+		select {
+		case <-closed:
+			return
+		case <-h.streamsDone:
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+				time.Now().Add(wsWriteWait))
+			return
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+		case reply := <-replies:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err = conn.WriteJSON(reply)
+		case e := <-events:
+			if !subscriptions.matches(e.Key) {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err = conn.WriteJSON(e)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
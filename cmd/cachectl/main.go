@@ -0,0 +1,357 @@
+// Command cachectl talks to a cache server from the shell:
+//
+//	cachectl [flags] <command> [args]
+//	cachectl [flags]              # interactive
+//
+// Run "cachectl help" for the commands.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"myproject/client"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, c *client.CacheClient, args []string, out io.Writer) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"get":    {"get <key>", runGet},
+		"mget":   {"mget <key>...", runMGet},
+		"set":    {"set [-ttl duration] <key> <value>", runSet},
+		"del":    {"del <key>...", runDel},
+		"stats":  {"stats", runStats},
+		"export": {"export [-prefix p] [-format ndjson|csv] [-o file]", runExport},
+		"import": {"import [-format ndjson|csv|rdb] [file]  (stdin if no file)", runImport},
+		"help":   {"help", runHelp},
+	}
+}
+
+// errUsage reports bad arguments; the command's usage line is printed.
+var errUsage = errors.New("usage")
+
+func main() {
+	addr := flag.String("addr", envOr("CACHE_ADDR", "http://localhost:8080"), "server base URL; export and import need the admin address if the server has one")
+	apiKey := flag.String("api-key", os.Getenv("CACHE_API_KEY"), "API key, sent as X-API-Key")
+	token := flag.String("token", os.Getenv("CACHE_TOKEN"), "JWT, sent as a bearer token")
+	timeout := flag.Duration("timeout", 10*time.Second, "per-request timeout")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: cachectl [flags] [command [args]]\n\nflags:\n")
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output())
+		runHelp(context.Background(), nil, nil, flag.CommandLine.Output())
+	}
+	flag.Parse()
+
+	c, err := client.New(*addr, client.Options{APIKey: *apiKey, BearerToken: *token, Timeout: *timeout})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if flag.NArg() == 0 {
+		repl(ctx, c, os.Stdin, os.Stdout)
+		return
+	}
+	if err := execute(ctx, c, flag.Args(), os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func execute(ctx context.Context, c *client.CacheClient, args []string, out io.Writer) error {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q; try \"help\"", args[0])
+	}
+	err := cmd.run(ctx, c, args[1:], out)
+	if errors.Is(err, errUsage) {
+		return fmt.Errorf("usage: %s", cmd.usage)
+	}
+	return err
+}
+
+// repl reads commands a line at a time until EOF or "quit". Arguments are
+// split on spaces; use double quotes for values that contain them.
+func repl(ctx context.Context, c *client.CacheClient, in io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for {
+		fmt.Fprint(out, "cache> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+		args, err := splitLine(scanner.Text())
+		switch {
+		case err != nil:
+			fmt.Fprintln(out, "error:", err)
+			continue
+		case len(args) == 0:
+			continue
+		case args[0] == "quit" || args[0] == "exit":
+			return
+		}
+		if err := execute(ctx, c, args, out); err != nil {
+			fmt.Fprintln(out, "error:", err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// splitLine splits on whitespace, keeping double-quoted strings (with JSON
+// escapes) together.
+func splitLine(line string) ([]string, error) {
+	var args []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return args, nil
+		}
+		if line[0] != '"' {
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			args = append(args, line[:end])
+			line = line[end:]
+			continue
+		}
+		end := 1
+		for end < len(line) && line[end] != '"' {
+			if line[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(line) {
+			return nil, errors.New("unterminated quote")
+		}
+		var arg string
+		if err := json.Unmarshal([]byte(line[:end+1]), &arg); err != nil {
+			return nil, fmt.Errorf("bad quoted string %s", line[:end+1])
+		}
+		args = append(args, arg)
+		line = line[end+1:]
+	}
+}
+
+func runGet(ctx context.Context, c *client.CacheClient, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	e, err := c.Get(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, e.Value)
+	return nil
+}
+
+func runMGet(ctx context.Context, c *client.CacheClient, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	entries, err := c.MGet(ctx, args...)
+	if err != nil {
+		return err
+	}
+	for _, key := range args {
+		if e, ok := entries[key]; ok {
+			fmt.Fprintf(out, "%s\t%s\n", key, e.Value)
+		} else {
+			fmt.Fprintf(out, "%s\t(not found)\n", key)
+		}
+	}
+	return nil
+}
+
+func runSet(ctx context.Context, c *client.CacheClient, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("set", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	ttl := flags.Duration("ttl", 0, "")
+	if flags.Parse(args) != nil || flags.NArg() != 2 {
+		return errUsage
+	}
+	e, err := c.Set(ctx, flags.Arg(0), flags.Arg(1), *ttl)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "OK (version %d, expires %s)\n", e.Version, e.ExpiresAt.Local().Format(time.DateTime))
+	return nil
+}
+
+func runDel(ctx context.Context, c *client.CacheClient, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	deleted := 0
+	for _, key := range args {
+		ok, err := c.Delete(ctx, key)
+		if err != nil {
+			return err
+		}
+		if ok {
+			deleted++
+		}
+	}
+	fmt.Fprintf(out, "deleted %d\n", deleted)
+	return nil
+}
+
+func runStats(ctx context.Context, c *client.CacheClient, args []string, out io.Writer) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	s, err := c.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "entries      %d / %d\n", s.Entries, s.Capacity)
+	fmt.Fprintf(out, "hits         %d\n", s.Hits)
+	fmt.Fprintf(out, "misses       %d\n", s.Misses)
+	fmt.Fprintf(out, "hit rate     %.1f%%\n", s.HitRate*100)
+	fmt.Fprintf(out, "evictions    %d\n", s.Evictions)
+	fmt.Fprintf(out, "expirations  %d\n", s.Expirations)
+	fmt.Fprintf(out, "memory       %d bytes (keys %d, values %d, overhead %d)\n", s.Memory.Total, s.Memory.Keys, s.Memory.Values, s.Memory.Overhead)
+	if s.Memory.Deduplicated > 0 {
+		fmt.Fprintf(out, "deduplicated %d bytes\n", s.Memory.Deduplicated)
+	}
+	if len(s.HotKeys) > 0 {
+		fmt.Fprintln(out, "hot keys")
+		for _, k := range s.HotKeys {
+			fmt.Fprintf(out, "  %-30s %d\n", k.Key, k.Hits)
+		}
+	}
+	if len(s.Namespaces) > 0 {
+		namespaces := s.Namespaces
+		sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Name < namespaces[j].Name })
+		fmt.Fprintln(out, "namespaces")
+		for _, ns := range namespaces {
+			name := ns.Name
+			if name == "" {
+				name = `""`
+			}
+			fmt.Fprintf(out, "  %-20s %d entries, %d bytes, %.1f%% hit rate\n", name, ns.Entries, ns.Bytes, ns.HitRate*100)
+		}
+	}
+	return nil
+}
+
+func runExport(ctx context.Context, c *client.CacheClient, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	prefix := flags.String("prefix", "", "")
+	format := flags.String("format", "", "")
+	path := flags.String("o", "", "")
+	if flags.Parse(args) != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+
+	body, err := c.Export(ctx, *prefix, *format)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if *path == "" {
+		_, err = io.Copy(out, body)
+		return err
+	}
+	f, err := os.Create(*path)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d bytes to %s\n", n, *path)
+	return nil
+}
+
+func runImport(ctx context.Context, c *client.CacheClient, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	format := flags.String("format", "", "")
+	if flags.Parse(args) != nil || flags.NArg() > 1 {
+		return errUsage
+	}
+
+	in := io.Reader(os.Stdin)
+	if path := flags.Arg(0); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+		if *format == "" {
+			switch {
+			case strings.HasSuffix(path, ".csv"):
+				*format = "csv"
+			case strings.HasSuffix(path, ".rdb"):
+				*format = "rdb"
+			}
+		}
+	}
+
+	report, err := c.Import(ctx, in, *format)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "imported %d, failed %d\n", report.Imported, report.Failed)
+	for _, e := range report.Errors {
+		fmt.Fprintf(out, "  row %d: %s\n", e.Row, e.Error)
+	}
+	if report.ErrorsTruncated {
+		fmt.Fprintln(out, "  (more errors not shown)")
+	}
+	if report.Aborted != "" {
+		return fmt.Errorf("import stopped early: %s", report.Aborted)
+	}
+	return nil
+}
+
+func runHelp(_ context.Context, _ *client.CacheClient, _ []string, out io.Writer) error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(out, "commands:")
+	for _, name := range names {
+		fmt.Fprintln(out, "  "+commands[name].usage)
+	}
+	return nil
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,74 @@
+// Package hashring assigns keys to nodes by consistent hashing, so that
+// adding or removing a node moves only the keys it gains or loses. The
+// cache server and the client build the same ring from the same node
+// names, whatever order they are listed in.
+package hashring
+
+import (
+	"cmp"
+	"hash/fnv"
+	"slices"
+	"strconv"
+)
+
+// virtualNodes is the number of points each node has on the ring, enough
+// to spread keys within a few percent of evenly.
+const virtualNodes = 160
+
+type Ring struct {
+	nodes  []string
+	points []point
+}
+
+type point struct {
+	hash uint64
+	node string
+}
+
+// New returns the ring of nodes. Duplicates are ignored.
+func New(nodes []string) *Ring {
+	r := &Ring{nodes: slices.Compact(slices.Sorted(slices.Values(nodes)))}
+	r.points = make([]point, 0, len(r.nodes)*virtualNodes)
+	for _, node := range r.nodes {
+		for i := range virtualNodes {
+			r.points = append(r.points, point{hash: hash(node + "#" + strconv.Itoa(i)), node: node})
+		}
+	}
+	slices.SortFunc(r.points, func(a, b point) int {
+		return cmp.Or(cmp.Compare(a.hash, b.hash), cmp.Compare(a.node, b.node))
+	})
+	return r
+}
+
+// Owner returns the node key belongs to: the first point at or after the
+// key's hash, going round. It is "" for an empty ring.
+func (r *Ring) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i, _ := slices.BinarySearchFunc(r.points, h, func(p point, h uint64) int { return cmp.Compare(p.hash, h) })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].node
+}
+
+// Nodes returns the nodes, sorted.
+func (r *Ring) Nodes() []string {
+	return slices.Clone(r.nodes)
+}
+
+// hash is FNV-1a with the SplitMix64 finalizer, which FNV needs to spread
+// short, similar strings such as "node#1" and "node#2" over the ring.
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
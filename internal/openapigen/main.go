@@ -0,0 +1,364 @@
+// Command openapigen generates the request/response types and route table
+// for the cache server from openapi.yaml. It understands the subset of
+// OpenAPI 3 the spec uses: object schemas with scalar, array, map and $ref
+// properties, and operations carrying an x-permission extension. A schema
+// with x-go-type, an import path and type name such as
+// "myproject/cache.Stats", becomes an alias of that type.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type schema struct {
+	Ref                  string    `yaml:"$ref"`
+	Type                 string    `yaml:"type"`
+	Format               string    `yaml:"format"`
+	Description          string    `yaml:"description"`
+	Required             []string  `yaml:"required"`
+	Properties           yaml.Node `yaml:"properties"`
+	Items                *schema   `yaml:"items"`
+	AdditionalProperties *schema   `yaml:"additionalProperties"`
+	GoType               string    `yaml:"x-go-type"`
+	// Nullable makes a property a pointer, so that leaving it out can be
+	// told from giving its zero value.
+	Nullable bool `yaml:"nullable"`
+}
+
+type operation struct {
+	OperationID string `yaml:"operationId"`
+	Summary     string `yaml:"summary"`
+	Permission  string `yaml:"x-permission"`
+	// Handler overrides the handler method name derived from operationId,
+	// letting several routes share one handler.
+	Handler string `yaml:"x-go-handler"`
+	// Streaming marks long-lived responses (SSE, WebSocket) that must not
+	// get the server's request timeout.
+	Streaming bool `yaml:"x-streaming"`
+	// Idempotent routes honour the Idempotency-Key header.
+	Idempotent bool `yaml:"x-idempotent"`
+	// AnyEngine routes need nothing of the cache beyond what every
+	// storage engine has.
+	AnyEngine bool `yaml:"x-any-engine"`
+}
+
+type spec struct {
+	Paths      yaml.Node `yaml:"paths"`
+	Components struct {
+		Schemas yaml.Node `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+var methods = []string{"get", "head", "post", "put", "patch", "delete"}
+
+var permissions = map[string]string{
+	"read":  "permRead",
+	"write": "permWrite",
+	"admin": "permAdmin",
+}
+
+func main() {
+	specPath := flag.String("spec", "openapi.yaml", "OpenAPI document to read")
+	out := flag.String("out", "api_gen.go", "Go file to write")
+	pkg := flag.String("package", "main", "package name of the generated file")
+	flag.Parse()
+
+	data, err := os.ReadFile(*specPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var doc spec
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		log.Fatalf("parse %s: %v", *specPath, err)
+	}
+
+	var body bytes.Buffer
+	imports := map[string]bool{"net/http": true}
+	if err := writeSchemas(&body, &doc.Components.Schemas, imports); err != nil {
+		log.Fatal(err)
+	}
+	if err := writeRoutes(&body, &doc.Paths); err != nil {
+		log.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by openapigen from %s; DO NOT EDIT.\n\n", *specPath)
+	fmt.Fprintf(&buf, "package %s\n\n", *pkg)
+	if bytes.Contains(body.Bytes(), []byte("time.")) {
+		imports["time"] = true
+	}
+	paths := make([]string, 0, len(imports))
+	for path := range imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	buf.WriteString("import (\n")
+	for _, path := range paths {
+		fmt.Fprintf(&buf, "\t%q\n", path)
+	}
+	buf.WriteString(")\n\n")
+	buf.Write(body.Bytes())
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatalf("format generated code: %v\n%s", err, buf.Bytes())
+	}
+	if err := os.WriteFile(*out, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// mapEntries returns the key/value node pairs of a YAML mapping in
+// document order.
+func mapEntries(n *yaml.Node) [][2]*yaml.Node {
+	var entries [][2]*yaml.Node
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		entries = append(entries, [2]*yaml.Node{n.Content[i], n.Content[i+1]})
+	}
+	return entries
+}
+
+func writeSchemas(buf *bytes.Buffer, schemas *yaml.Node, imports map[string]bool) error {
+	entries := mapEntries(schemas)
+	sort.Slice(entries, func(i, j int) bool { return entries[i][0].Value < entries[j][0].Value })
+
+	for _, e := range entries {
+		name := e[0].Value
+		var s schema
+		if err := e[1].Decode(&s); err != nil {
+			return fmt.Errorf("schema %s: %w", name, err)
+		}
+		if s.Description != "" {
+			writeComment(buf, "", name+" "+lowerFirst(s.Description))
+		}
+		if s.GoType != "" {
+			dot := strings.LastIndex(s.GoType, ".")
+			if dot < 0 {
+				return fmt.Errorf("schema %s: x-go-type %q is not a qualified type", name, s.GoType)
+			}
+			path := s.GoType[:dot]
+			imports[path] = true
+			fmt.Fprintf(buf, "type %s = %s.%s\n\n", name, path[strings.LastIndex(path, "/")+1:], s.GoType[dot+1:])
+			continue
+		}
+		if s.Type != "object" {
+			t, err := goType(&s)
+			if err != nil {
+				return fmt.Errorf("schema %s: %w", name, err)
+			}
+			fmt.Fprintf(buf, "type %s %s\n\n", name, t)
+			continue
+		}
+
+		required := map[string]bool{}
+		for _, r := range s.Required {
+			required[r] = true
+		}
+		fmt.Fprintf(buf, "type %s struct {\n", name)
+		for _, p := range mapEntries(&s.Properties) {
+			var prop schema
+			if err := p[1].Decode(&prop); err != nil {
+				return fmt.Errorf("schema %s.%s: %w", name, p[0].Value, err)
+			}
+			t, err := goType(&prop)
+			if err != nil {
+				return fmt.Errorf("schema %s.%s: %w", name, p[0].Value, err)
+			}
+			if prop.Nullable {
+				t = "*" + t
+			}
+			if prop.Description != "" {
+				writeComment(buf, "\t", prop.Description)
+			}
+			tag := p[0].Value
+			if !required[tag] {
+				tag += ",omitempty"
+			}
+			fmt.Fprintf(buf, "\t%s %s `json:%q msgpack:%q`\n", fieldName(p[0].Value), t, tag, tag)
+		}
+		buf.WriteString("}\n\n")
+	}
+	return nil
+}
+
+func goType(s *schema) (string, error) {
+	if s.Ref != "" {
+		return s.Ref[strings.LastIndex(s.Ref, "/")+1:], nil
+	}
+	switch s.Type {
+	case "integer":
+		switch s.Format {
+		case "int64":
+			return "int64", nil
+		case "int32":
+			return "int32", nil
+		case "uint64":
+			return "uint64", nil
+		}
+		return "int", nil
+	case "number":
+		return "float64", nil
+	case "boolean":
+		return "bool", nil
+	case "string":
+		switch s.Format {
+		case "byte", "binary":
+			return "[]byte", nil
+		case "date-time":
+			return "time.Time", nil
+		}
+		return "string", nil
+	case "array":
+		if s.Items == nil {
+			return "", fmt.Errorf("array without items")
+		}
+		t, err := goType(s.Items)
+		if err != nil {
+			return "", err
+		}
+		return "[]" + t, nil
+	case "object":
+		if s.AdditionalProperties != nil {
+			t, err := goType(s.AdditionalProperties)
+			if err != nil {
+				return "", err
+			}
+			return "map[string]" + t, nil
+		}
+		return "map[string]interface{}", nil
+	case "":
+		return "interface{}", nil
+	}
+	return "", fmt.Errorf("unsupported type %q", s.Type)
+}
+
+type route struct {
+	method, path, handler, permission string
+	streaming, idempotent, anyEngine  bool
+}
+
+func writeRoutes(buf *bytes.Buffer, paths *yaml.Node) error {
+	var routes []route
+	for _, p := range mapEntries(paths) {
+		var ops map[string]operation
+		if err := p[1].Decode(&ops); err != nil {
+			return fmt.Errorf("path %s: %w", p[0].Value, err)
+		}
+		for _, m := range methods {
+			op, ok := ops[m]
+			if !ok {
+				continue
+			}
+			if op.OperationID == "" {
+				return fmt.Errorf("%s %s: missing operationId", m, p[0].Value)
+			}
+			perm, ok := permissions[op.Permission]
+			if !ok {
+				return fmt.Errorf("%s %s: unknown x-permission %q", m, p[0].Value, op.Permission)
+			}
+			handler := op.Handler
+			if handler == "" {
+				handler = upperFirst(op.OperationID) + "Handler"
+			}
+			routes = append(routes, route{
+				method:     strings.ToUpper(m),
+				path:       p[0].Value,
+				handler:    handler,
+				permission: perm,
+				streaming:  op.Streaming,
+				idempotent: op.Idempotent,
+				anyEngine:  op.AnyEngine,
+			})
+		}
+	}
+
+	handlers := map[string]bool{}
+	buf.WriteString("// apiServer has one handler per operation in the spec.\n")
+	buf.WriteString("type apiServer interface {\n")
+	for _, r := range routes {
+		if handlers[r.handler] {
+			continue
+		}
+		handlers[r.handler] = true
+		fmt.Fprintf(buf, "\t%s(w http.ResponseWriter, r *http.Request)\n", r.handler)
+	}
+	buf.WriteString("}\n\n")
+
+	buf.WriteString("type apiRoute struct {\n\tMethod string\n\tPath string\n\tPermission permission\n\tStreaming bool\n\tIdempotent bool\n\tAnyEngine bool\n\thandler func(apiServer, http.ResponseWriter, *http.Request)\n}\n\n")
+	buf.WriteString("var apiRoutes = []apiRoute{\n")
+	for _, r := range routes {
+		flags := ""
+		if r.streaming {
+			flags += " Streaming: true,"
+		}
+		if r.idempotent {
+			flags += " Idempotent: true,"
+		}
+		if r.anyEngine {
+			flags += " AnyEngine: true,"
+		}
+		fmt.Fprintf(buf, "\t{Method: %q, Path: %q, Permission: %s,%s handler: apiServer.%s},\n", r.method, r.path, r.permission, flags, r.handler)
+	}
+	buf.WriteString("}\n\n")
+
+	buf.WriteString(`// Pattern is the ServeMux pattern for the route.
+func (r apiRoute) Pattern() string {
+	return r.Method + " " + r.Path
+}
+
+// registerAPIRoutes passes every spec operation to register, which decides
+// where it is mounted and what per-route middleware it gets.
+func registerAPIRoutes(s apiServer, register func(apiRoute, http.Handler)) {
+	for _, route := range apiRoutes {
+		handler := route.handler
+		register(route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { handler(s, w, r) }))
+	}
+}
+`)
+	return nil
+}
+
+func writeComment(buf *bytes.Buffer, indent, text string) {
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		fmt.Fprintf(buf, "%s// %s\n", indent, line)
+	}
+}
+
+func fieldName(s string) string {
+	parts := strings.FieldsFunc(s, func(r rune) bool { return r == '_' || r == '-' })
+	for i, p := range parts {
+		switch strings.ToLower(p) {
+		case "id", "ttl", "url", "ip", "etag":
+			parts[i] = strings.ToUpper(p)
+		default:
+			parts[i] = upperFirst(p)
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}